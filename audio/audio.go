@@ -0,0 +1,67 @@
+// Package audio turns the sound timer into samples and hands them to sinks,
+// e.g. an output device or a WAV file.
+//
+// Only the CHIP-8 buzzer is generated. XO-CHIP audio, a 16 byte bit
+// pattern loaded by F002 and played at the pitch set by FX3A, is left out
+// because the CPU does not emulate those instructions; a pattern Generator
+// would need them first.
+package audio
+
+import "math"
+
+// Every audio backend and recorder works with signed 16-bit mono samples at
+// SAMPLE_RATE. Samples are produced per emulated frame rather than on demand
+// from a device callback, so the same session always yields the same stream.
+const SAMPLE_RATE = 44100
+const FRAME_RATE = 60
+const SAMPLES_PER_FRAME = SAMPLE_RATE / FRAME_RATE
+
+const BUZZER_FREQUENCY = 440
+const BUZZER_VOLUME = 0.25
+
+// Generator produces the samples for a single emulated frame.
+type Generator interface {
+	Frame(active bool) []int16
+}
+
+// Sink consumes the samples produced by a Generator, e.g. an output device
+// or a WAV file.
+type Sink interface {
+	WriteSamples(samples []int16) error
+}
+
+// Buzzer is the classic CHIP-8 tone: a fixed pitch sine wave played while
+// the sound timer is non-zero.
+type Buzzer struct {
+	Frequency float64
+	Volume    float64
+
+	phase  float64
+	buffer [SAMPLES_PER_FRAME]int16
+}
+
+func NewBuzzer() *Buzzer {
+	return &Buzzer{
+		Frequency: BUZZER_FREQUENCY,
+		Volume:    BUZZER_VOLUME,
+	}
+}
+
+// Frame returns the samples for one frame. The phase carries over between
+// frames so a tone spanning several frames does not click at the seams.
+// The returned slice is reused by the next call.
+func (b *Buzzer) Frame(active bool) []int16 {
+	if !active {
+		b.phase = 0
+		b.buffer = [SAMPLES_PER_FRAME]int16{}
+		return b.buffer[:]
+	}
+
+	step := 2 * math.Pi * b.Frequency / SAMPLE_RATE
+	for i := range b.buffer {
+		b.buffer[i] = int16(math.Sin(b.phase) * b.Volume * math.MaxInt16)
+		b.phase = math.Mod(b.phase+step, 2*math.Pi)
+	}
+
+	return b.buffer[:]
+}
@@ -0,0 +1,57 @@
+package audio_test
+
+import (
+	"chip-8-go/audio"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuzzerFrame(t *testing.T) {
+	assert := assert.New(t)
+
+	buzzer := audio.NewBuzzer()
+
+	silent := buzzer.Frame(false)
+	assert.Len(silent, audio.SAMPLES_PER_FRAME, "Frame should always hold one frame of samples")
+	assert.Equal(make([]int16, audio.SAMPLES_PER_FRAME), silent, "Inactive frame should be silent")
+
+	first := append([]int16(nil), buzzer.Frame(true)...)
+	second := append([]int16(nil), buzzer.Frame(true)...)
+	assert.NotEqual(make([]int16, audio.SAMPLES_PER_FRAME), first, "Active frame should contain the tone")
+
+	other := audio.NewBuzzer()
+	assert.Equal(first, other.Frame(true), "Tone should be deterministic")
+	assert.Equal(second, other.Frame(true), "Tone phase should continue across frames")
+}
+
+func TestRecorder(t *testing.T) {
+	assert := assert.New(t)
+
+	fileName := filepath.Join(t.TempDir(), "out.wav")
+	recorder, err := audio.CreateRecorder(fileName)
+	assert.NoError(err)
+
+	buzzer := audio.NewBuzzer()
+	for _, active := range []bool{true, false, true} {
+		assert.NoError(recorder.WriteSamples(buzzer.Frame(active)))
+	}
+	assert.NoError(recorder.Close())
+
+	data, err := os.ReadFile(fileName)
+	assert.NoError(err)
+
+	dataSize := uint32(3 * audio.SAMPLES_PER_FRAME * 2)
+	assert.Len(data, audio.WAV_HEADER_SIZE+int(dataSize))
+	assert.Equal("RIFF", string(data[0:4]))
+	assert.Equal(36+dataSize, binary.LittleEndian.Uint32(data[4:8]), "RIFF size should be patched on close")
+	assert.Equal("WAVE", string(data[8:12]))
+	assert.Equal(uint16(1), binary.LittleEndian.Uint16(data[20:22]), "Format should be PCM")
+	assert.Equal(uint32(audio.SAMPLE_RATE), binary.LittleEndian.Uint32(data[24:28]))
+	assert.Equal(uint16(16), binary.LittleEndian.Uint16(data[34:36]))
+	assert.Equal("data", string(data[36:40]))
+	assert.Equal(dataSize, binary.LittleEndian.Uint32(data[40:44]), "data size should be patched on close")
+}
@@ -0,0 +1,116 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+const WAV_HEADER_SIZE = 44
+
+const bitsPerSample = 16
+const numChannels = 1
+
+// Recorder writes every sample it receives into a 16-bit PCM WAV stream.
+// The RIFF and data chunk sizes are only known at the end, so they are
+// patched in by Close.
+type Recorder struct {
+	w      io.WriteSeeker
+	closer io.Closer
+
+	dataSize uint32
+	buffer   []byte
+}
+
+func NewRecorder(w io.WriteSeeker) (*Recorder, error) {
+	r := &Recorder{w: w}
+	if err := r.writeHeader(); err != nil {
+		return nil, fmt.Errorf("write WAV header: %w", err)
+	}
+
+	return r, nil
+}
+
+// CreateRecorder creates (or truncates) fileName and records into it.
+func CreateRecorder(fileName string) (*Recorder, error) {
+	file, fileErr := os.Create(fileName)
+	if fileErr != nil {
+		return nil, fileErr
+	}
+
+	r, err := NewRecorder(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	r.closer = file
+
+	return r, nil
+}
+
+func (r *Recorder) WriteSamples(samples []int16) error {
+	if cap(r.buffer) < len(samples)*2 {
+		r.buffer = make([]byte, len(samples)*2)
+	}
+	buf := r.buffer[:len(samples)*2]
+
+	for i, sample := range samples {
+		binary.LittleEndian.PutUint16(buf[i*2:], uint16(sample))
+	}
+
+	n, err := r.w.Write(buf)
+	r.dataSize += uint32(n)
+
+	return err
+}
+
+// Close finalises the header and closes the underlying file if the
+// recorder opened it.
+func (r *Recorder) Close() error {
+	err := r.finish()
+
+	if r.closer != nil {
+		if closeErr := r.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+func (r *Recorder) finish() error {
+	if _, err := r.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := r.writeHeader(); err != nil {
+		return err
+	}
+
+	_, err := r.w.Seek(0, io.SeekEnd)
+	return err
+}
+
+func (r *Recorder) writeHeader() error {
+	const blockAlign = numChannels * bitsPerSample / 8
+
+	header := make([]byte, 0, WAV_HEADER_SIZE)
+	header = append(header, "RIFF"...)
+	header = binary.LittleEndian.AppendUint32(header, 36+r.dataSize)
+	header = append(header, "WAVE"...)
+
+	header = append(header, "fmt "...)
+	header = binary.LittleEndian.AppendUint32(header, 16)
+	header = binary.LittleEndian.AppendUint16(header, 1) // PCM
+	header = binary.LittleEndian.AppendUint16(header, numChannels)
+	header = binary.LittleEndian.AppendUint32(header, SAMPLE_RATE)
+	header = binary.LittleEndian.AppendUint32(header, SAMPLE_RATE*blockAlign)
+	header = binary.LittleEndian.AppendUint16(header, blockAlign)
+	header = binary.LittleEndian.AppendUint16(header, bitsPerSample)
+
+	header = append(header, "data"...)
+	header = binary.LittleEndian.AppendUint32(header, r.dataSize)
+
+	_, err := r.w.Write(header)
+	return err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"chip-8-go/batch"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+func batchCommand(args []string) (err error) {
+	fs := newFlagSet("batch")
+	workers := fs.Int("workers", 0, "jobs to run at the same time (default: one per CPU)")
+	output := fs.String("o", "-", "write the results as JSON lines to `file`, - for stdout")
+
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if *workers < 0 {
+		return usageError{fmt.Sprintf("invalid -workers %d: must not be negative", *workers)}
+	}
+
+	jobs, err := batch.LoadManifest(positional[0])
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		file, createErr := os.Create(*output)
+		if createErr != nil {
+			return createErr
+		}
+		defer func() {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		w = file
+	}
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+
+	failed := 0
+	runErr := batch.Run(jobs, batch.Options{Workers: *workers, ReadROM: readROM}, func(result batch.Result) error {
+		if result.Error != "" {
+			failed++
+		}
+		return encoder.Encode(result)
+	})
+	if flushErr := buffered.Flush(); runErr == nil {
+		runErr = flushErr
+	}
+	if runErr != nil {
+		return runErr
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d jobs failed", failed, len(jobs))
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+// Package batch runs many ROMs headless and in parallel, e.g. to evaluate
+// bots or regression suites over combinations of ROMs, quirks, seeds and
+// inputs. Every job gets a cpu.CPU of its own and nothing is shared between
+// them, so results do not depend on the number of workers.
+package batch
+
+import (
+	"chip-8-go/cpu"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+)
+
+// Job is one run of a ROM.
+type Job struct {
+	Name string `json:"name,omitempty"`
+	ROM  string `json:"rom"`
+	// A quirk spec as taken by cpu.ParseQuirks.
+	Quirks string `json:"quirks,omitempty"`
+	Seed   int64  `json:"seed,omitempty"`
+	// Run the job once per seed instead of with Seed.
+	Seeds []int64 `json:"seeds,omitempty"`
+	// An input script file, see ParseInput.
+	Input  string `json:"input,omitempty"`
+	Frames int    `json:"frames,omitempty"`
+	IPF    int    `json:"ipf,omitempty"`
+
+	prepared bool
+	quirks   cpu.Quirks
+	input    Input
+}
+
+// Manifest lists the jobs to run, e.g.
+//
+//	{
+//	  "defaults": {"quirks": "modern", "frames": 600},
+//	  "jobs": [
+//	    {"name": "brix", "rom": "roms/BRIX", "seeds": [1, 2, 3], "input": "brix.keys"}
+//	  ]
+//	}
+type Manifest struct {
+	// Fill in the fields a job leaves empty.
+	Defaults Job   `json:"defaults"`
+	Jobs     []Job `json:"jobs"`
+}
+
+// LoadManifest reads the manifest at path, see ParseManifest.
+func LoadManifest(path string) ([]Job, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseManifest(file, filepath.Dir(path))
+}
+
+// ParseManifest reads a JSON manifest and returns its jobs with the
+// defaults applied, one per seed, and the input scripts read. Relative ROM
+// and input paths are taken from dir.
+func ParseManifest(r io.Reader, dir string) ([]Job, error) {
+	var manifest Manifest
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	inputs := map[string]Input{}
+	var jobs []Job
+	for i, job := range manifest.Jobs {
+		job = withDefaults(job, manifest.Defaults)
+		if job.ROM == "" {
+			return nil, fmt.Errorf("job %d: no rom", i)
+		}
+		job.ROM = relativeTo(dir, job.ROM)
+		if job.Input != "" {
+			job.Input = relativeTo(dir, job.Input)
+		}
+
+		seeds := job.Seeds
+		if len(seeds) == 0 {
+			seeds = []int64{job.Seed}
+		}
+		job.Seeds = nil
+		if err := job.prepare(inputs); err != nil {
+			return nil, fmt.Errorf("job %d: %w", i, err)
+		}
+		for _, seed := range seeds {
+			job.Seed = seed
+			jobs = append(jobs, job)
+		}
+	}
+
+	return jobs, nil
+}
+
+func withDefaults(job, defaults Job) Job {
+	if job.ROM == "" {
+		job.ROM = defaults.ROM
+	}
+	if job.Quirks == "" {
+		job.Quirks = defaults.Quirks
+	}
+	if job.Seed == 0 && len(job.Seeds) == 0 {
+		job.Seed, job.Seeds = defaults.Seed, defaults.Seeds
+	}
+	if job.Input == "" {
+		job.Input = defaults.Input
+	}
+	if job.Frames == 0 {
+		job.Frames = defaults.Frames
+	}
+	if job.IPF == 0 {
+		job.IPF = defaults.IPF
+	}
+	return job
+}
+
+// prepare checks a job, fills in the rest of the defaults and reads its
+// input script, sharing scripts between jobs through inputs.
+func (job *Job) prepare(inputs map[string]Input) error {
+	if job.prepared {
+		return nil
+	}
+	if job.Seeds != nil {
+		return errors.New("seeds are only expanded in manifests")
+	}
+
+	if job.Quirks == "" {
+		job.Quirks = cpu.DEFAULT_QUIRKS
+	}
+	quirks, err := cpu.ParseQuirks(job.Quirks)
+	if err != nil {
+		return err
+	}
+	job.quirks, job.Quirks = quirks, quirks.String()
+
+	if job.Frames < 1 {
+		return fmt.Errorf("invalid frames %d: must be at least 1", job.Frames)
+	}
+	if job.IPF == 0 {
+		job.IPF = cpu.DEFAULT_IPF
+	}
+	if job.IPF < 1 {
+		return fmt.Errorf("invalid ipf %d: must be at least 1", job.IPF)
+	}
+
+	if job.Input == "" {
+		job.prepared = true
+		return nil
+	}
+	input, ok := inputs[job.Input]
+	if !ok {
+		file, openErr := os.Open(job.Input)
+		if openErr != nil {
+			return openErr
+		}
+		input, err = ParseInput(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", job.Input, err)
+		}
+		inputs[job.Input] = input
+	}
+	job.input = input
+	job.prepared = true
+
+	return nil
+}
+
+func relativeTo(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// Result is the outcome of a job.
+type Result struct {
+	// The index of the job.
+	Job    int    `json:"job"`
+	Name   string `json:"name,omitempty"`
+	ROM    string `json:"rom"`
+	Quirks string `json:"quirks"`
+	Seed   int64  `json:"seed"`
+	// Frames run, fewer than asked for after an error.
+	Frames     int           `json:"frames"`
+	Cycles     uint64        `json:"cycles"`
+	ScreenHash string        `json:"screen_hash"`
+	PC         uint16        `json:"pc"`
+	Registers  cpu.Registers `json:"registers"`
+	// Unknown instructions skipped, and the last of them.
+	InvalidOpCodes    uint64 `json:"invalid_opcodes,omitempty"`
+	LastInvalidOpCode string `json:"last_invalid_opcode,omitempty"`
+	Error             string `json:"error,omitempty"`
+}
+
+type Options struct {
+	// Jobs run at the same time, runtime.NumCPU() when 0.
+	Workers int
+	// Reads the ROMs, os.ReadFile when nil. Each ROM is read once.
+	ReadROM func(path string) ([]byte, error)
+}
+
+// Run runs the jobs on a pool of workers and calls emit with the results
+// in job order. It stops early, returning the error, if emit fails.
+func Run(jobs []Job, opts Options, emit func(Result) error) error {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	readROM := opts.ReadROM
+	if readROM == nil {
+		readROM = os.ReadFile
+	}
+
+	// Workers only read the jobs and ROMs, so they are set up first.
+	jobs = slices.Clone(jobs)
+	errs := make([]error, len(jobs))
+	inputs := map[string]Input{}
+	roms := map[string][]byte{}
+	romErrs := map[string]error{}
+	for i := range jobs {
+		if errs[i] = jobs[i].prepare(inputs); errs[i] != nil {
+			continue
+		}
+		rom := jobs[i].ROM
+		if _, ok := roms[rom]; !ok {
+			roms[rom], romErrs[rom] = readROM(rom)
+		}
+		errs[i] = romErrs[rom]
+	}
+
+	indexes := make(chan int)
+	done := make(chan Result, workers)
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				done <- runJob(i, &jobs[i], roms[jobs[i].ROM], errs[i])
+			}
+		}()
+	}
+	go func() {
+		defer close(indexes)
+		for i := range jobs {
+			select {
+			case indexes <- i:
+			case <-quit:
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// Hold results back until the ones before them are in.
+	pending := map[int]Result{}
+	next := 0
+	var emitErr error
+	for result := range done {
+		pending[result.Job] = result
+		for emitErr == nil {
+			r, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			if emitErr = emit(r); emitErr != nil {
+				close(quit)
+			}
+		}
+	}
+
+	return emitErr
+}
+
+// runJob runs a job on a CPU of its own. Panics, e.g. on a stack overflow,
+// are reported as the job's error.
+func runJob(index int, job *Job, rom []byte, jobErr error) (result Result) {
+	result = Result{Job: index, Name: job.Name, ROM: job.ROM, Quirks: job.Quirks, Seed: job.Seed}
+	if jobErr != nil {
+		result.Error = jobErr.Error()
+		return result
+	}
+
+	c := cpu.NewCPU()
+	defer func() {
+		if r := recover(); r != nil {
+			result.Error = fmt.Sprint(r)
+		}
+		result.Cycles = c.Cycles
+		result.ScreenHash = c.Screen.Hash()
+		result.PC = c.ProgramCounter
+		result.Registers = cpu.Registers{V: c.VRegisters, I: c.IndexRegister, SP: c.StackPointer, DT: c.DelayTimer, ST: c.SoundTimer}
+		if c.InvalidOpCodes > 0 {
+			result.InvalidOpCodes, result.LastInvalidOpCode = c.InvalidOpCodes, c.LastInvalidOpCode.String()
+		}
+	}()
+
+	c.Quirks = job.quirks
+	c.Seed(job.Seed)
+	if err := c.LoadROM(rom, cpu.START_ADDR); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	change := 0
+	for frame := 0; frame < job.Frames; frame++ {
+		for change < len(job.input) && job.input[change].Frame <= frame {
+			c.Keys = job.input[change].Keys
+			change++
+		}
+		if _, err := c.RunFrame(job.IPF); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Frames++
+	}
+
+	return result
+}
@@ -0,0 +1,143 @@
+package batch_test
+
+import (
+	"chip-8-go/batch"
+	"chip-8-go/cpu"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseInput(t *testing.T) {
+	assert := assert.New(t)
+
+	input, err := batch.ParseInput(strings.NewReader("# hold 5, then 1 and F\n10 5\n\n20 1,F\n30 -\n"))
+	assert.NoError(err)
+	if assert.Len(input, 3, "changes") {
+		assert.Equal(10, input[0].Frame, "frame")
+		assert.True(input[0].Keys[5], "key 5")
+		assert.True(input[1].Keys[1] && input[1].Keys[0xF] && !input[1].Keys[5], "keys 1 and F")
+		assert.Equal([cpu.NUM_KEYS]bool{}, input[2].Keys, "- should release all keys")
+	}
+
+	_, err = batch.ParseInput(strings.NewReader("10 5\n10 6\n"))
+	assert.Error(err, "frames must increase")
+	_, err = batch.ParseInput(strings.NewReader("10 G\n"))
+	assert.Error(err, "keys must be hex digits")
+}
+
+func TestParseManifest(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "keys"), []byte("0 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := batch.ParseManifest(strings.NewReader(`{
+		"defaults": {"quirks": "modern", "frames": 60, "input": "keys"},
+		"jobs": [
+			{"name": "a", "rom": "a.ch8", "seeds": [1, 2]},
+			{"name": "b", "rom": "/roms/b.ch8", "quirks": "chip8", "frames": 10, "seed": 5}
+		]
+	}`), dir)
+	assert.NoError(err)
+	if assert.Len(jobs, 3, "a job per seed") {
+		assert.Equal(int64(2), jobs[1].Seed, "seed")
+		assert.Equal(filepath.Join(dir, "a.ch8"), jobs[0].ROM, "relative ROM paths should start at the manifest")
+		assert.Equal(filepath.Join(dir, "keys"), jobs[0].Input, "input from the defaults")
+		assert.Equal(60, jobs[0].Frames, "frames from the defaults")
+		assert.Equal(cpu.DEFAULT_IPF, jobs[0].IPF, "ipf")
+		assert.Equal("/roms/b.ch8", jobs[2].ROM, "absolute ROM path")
+		assert.Equal("chip8", jobs[2].Quirks, "quirks")
+		assert.Equal(10, jobs[2].Frames, "frames")
+	}
+
+	_, err = batch.ParseManifest(strings.NewReader(`{"jobs": [{"rom": "a.ch8"}]}`), dir)
+	assert.Error(err, "a job needs frames")
+	_, err = batch.ParseManifest(strings.NewReader(`{"jobs": [{"rom": "a.ch8", "frames": 1, "quirks": "nope"}]}`), dir)
+	assert.Error(err, "unknown quirks")
+}
+
+func TestRun(t *testing.T) {
+	assert := assert.New(t)
+
+	roms := map[string][]byte{
+		// Draws the last digit of a random number and waits for key 1.
+		"random": {
+			0xC0, 0xFF, // RND V0, 0xFF
+			0xA3, 0x00, // LD I, 0x300
+			0xF0, 0x33, // LD B, V0
+			0xF2, 0x65, // LD V2, [I]
+			0xF2, 0x29, // LD F, V2
+			0xD3, 0x45, // DRW V3, V4, 5
+			0x61, 0x01, // LD V1, 0x01
+			0xE1, 0x9E, // SKP V1
+			0x12, 0x0E, // JP 0x20E
+			0x12, 0x12, // JP 0x212
+		},
+		"overflow": {0x22, 0x00},
+		"ended":    {0x1F, 0xFE},
+		"invalid":  {0x00, 0xE1, 0x12, 0x00},
+	}
+	readROM := func(path string) ([]byte, error) {
+		if rom, ok := roms[path]; ok {
+			return rom, nil
+		}
+		return nil, errors.New("no such ROM")
+	}
+
+	var jobs []batch.Job
+	for seed := range int64(8) {
+		jobs = append(jobs, batch.Job{ROM: "random", Quirks: "modern", Seed: seed, Frames: 10})
+	}
+	jobs = append(jobs,
+		batch.Job{ROM: "overflow", Frames: 10},
+		batch.Job{ROM: "ended", Frames: 10},
+		batch.Job{ROM: "missing", Frames: 10},
+		batch.Job{ROM: "invalid", Frames: 1},
+	)
+
+	run := func(workers int) []batch.Result {
+		var results []batch.Result
+		assert.NoError(batch.Run(jobs, batch.Options{Workers: workers, ReadROM: readROM}, func(r batch.Result) error {
+			results = append(results, r)
+			return nil
+		}))
+		return results
+	}
+
+	results := run(4)
+	if !assert.Len(results, len(jobs), "a result per job") {
+		return
+	}
+	for i, result := range results {
+		assert.Equal(i, result.Job, "results should come in job order")
+	}
+	assert.Equal(results, run(1), "results should not depend on the number of workers")
+
+	assert.Empty(results[0].Error, "error")
+	assert.Equal(10, results[0].Frames, "frames")
+	assert.Equal(uint64(10*cpu.DEFAULT_IPF), results[0].Cycles, "cycles")
+	assert.Equal(cpu.DEFAULT_QUIRKS, results[8].Quirks, "default quirks")
+	assert.NotEqual(results[0].ScreenHash, results[1].ScreenHash, "different seeds should draw differently")
+	assert.Contains(results[8].Error, "overflow", "a panic should fail only its job")
+	assert.Equal(cpu.ErrProgramEnded.Error(), results[9].Error, "running off the end of memory")
+	assert.Equal("no such ROM", results[10].Error, "missing ROM")
+	assert.Empty(results[11].Error, "unknown instructions should be skipped")
+	assert.Equal(uint64(cpu.DEFAULT_IPF/2), results[11].InvalidOpCodes, "unknown instructions should be counted")
+	assert.Equal("invalid opcode 00E1 at 0x200", results[11].LastInvalidOpCode, "the last unknown instruction")
+
+	stop := errors.New("stop")
+	emitted := 0
+	err := batch.Run(jobs, batch.Options{Workers: 2, ReadROM: readROM}, func(batch.Result) error {
+		emitted++
+		return stop
+	})
+	assert.Equal(stop, err, "Run should return the emit error")
+	assert.Equal(1, emitted, "Run should stop emitting after an error")
+}
@@ -0,0 +1,64 @@
+package batch
+
+import (
+	"bufio"
+	"chip-8-go/cpu"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// InputChange holds Keys down from Frame on, until the next change.
+type InputChange struct {
+	Frame int
+	Keys  [cpu.NUM_KEYS]bool
+}
+
+// Input is a script of key presses, in frame order.
+type Input []InputChange
+
+// ParseInput reads an input script: a line per change with the frame,
+// counted from 0, and the keys held from then on as comma separated hex
+// digits, or "-" for none. Blank lines and lines starting with # are
+// skipped. For example, holding 5 for frames 120 to 129:
+//
+//	120 5
+//	130 -
+func ParseInput(r io.Reader) (Input, error) {
+	var input Input
+
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: want a frame and keys, got %q", lineNumber, line)
+		}
+		frame, err := strconv.Atoi(fields[0])
+		if err != nil || frame < 0 {
+			return nil, fmt.Errorf("line %d: invalid frame %q", lineNumber, fields[0])
+		}
+		if len(input) > 0 && frame <= input[len(input)-1].Frame {
+			return nil, fmt.Errorf("line %d: frame %d is not after frame %d", lineNumber, frame, input[len(input)-1].Frame)
+		}
+
+		change := InputChange{Frame: frame}
+		if fields[1] != "-" {
+			for _, key := range strings.Split(fields[1], ",") {
+				n, keyErr := strconv.ParseUint(key, 16, 4)
+				if keyErr != nil {
+					return nil, fmt.Errorf("line %d: invalid key %q, want 0-F", lineNumber, key)
+				}
+				change.Keys[n] = true
+			}
+		}
+		input = append(input, change)
+	}
+
+	return input, scanner.Err()
+}
@@ -0,0 +1,303 @@
+// Package browser is the model of the ROM browser menu: the ROMs of a
+// directory with what the ROM database knows about them, the recently
+// played ones, and a quirk preset and speed to start them with. Drawing
+// the menu and reading the keys is up to a frontend.
+package browser
+
+import (
+	"chip-8-go/config"
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"chip-8-go/romdb"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// The recently played list keeps this many ROMs.
+const MAX_RECENT = 5
+
+// The recently played list is kept in this file of the config directory.
+const RECENT_FILE_NAME = "recent.json"
+
+// AUTO leaves a setting to the config and the ROM database.
+const AUTO = "auto"
+
+// Speeds offered in instructions per frame, besides AUTO.
+var Speeds = []int{5, 7, 10, 15, 20, 30, 50, 100, 200, 500, 1000}
+
+// ROM is a file the menu lists.
+type ROM struct {
+	Path string
+	Name string
+	// Nil when the ROM database does not know the ROM.
+	Entry *romdb.Entry
+}
+
+// Title is the title from the ROM database, or else the file name.
+func (r ROM) Title() string {
+	if r.Entry != nil && r.Entry.Program.Title != "" {
+		return r.Entry.Program.Title
+	}
+	return r.Name
+}
+
+// Scan lists the ROMs in dir, sorted by name, looking each up with
+// identify. Hidden files and files that do not read as a ROM are left out.
+func Scan(dir string, identify func(rom []byte) *romdb.Entry) ([]ROM, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var roms []ROM
+	for _, file := range files {
+		name := file.Name()
+		if !file.Type().IsRegular() || strings.HasPrefix(name, ".") || !emulator.IsROMName(name) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		data, readErr := emulator.ReadROMFile(path)
+		if readErr != nil || len(data) > cpu.RAM_SIZE {
+			continue
+		}
+		roms = append(roms, ROM{Path: path, Name: name, Entry: identify(data)})
+	}
+
+	return roms, nil
+}
+
+// RecentPath returns where the recently played list is kept, next to the
+// config file.
+func RecentPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, config.APP_DIR, RECENT_FILE_NAME), nil
+}
+
+// LoadRecent reads the paths of the recently played ROMs, the latest
+// first. A missing file is an empty list.
+func LoadRecent(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var recent []string
+	if jsonErr := json.Unmarshal(data, &recent); jsonErr != nil {
+		return nil, fmt.Errorf("%s: %w", path, jsonErr)
+	}
+	return recent, nil
+}
+
+// SaveRecent writes the list, creating its directory.
+func SaveRecent(path string, recent []string) error {
+	data, err := json.MarshalIndent(recent, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// Choice is a ROM to start with the settings picked in the menu.
+type Choice struct {
+	ROM ROM
+	// Quirk preset, or AUTO for the configured quirks.
+	Quirks string
+	// Instructions per frame, or 0 for the configured speed.
+	IPF int
+}
+
+// The kinds of lines in the menu.
+const (
+	lineQuirks = iota
+	lineSpeed
+	lineHeader
+	lineROM
+)
+
+type line struct {
+	kind int
+	text string
+	rom  ROM
+}
+
+// Menu is the state of the ROM browser: the line that has the focus and
+// the settings picked.
+type Menu struct {
+	roms   []ROM
+	recent []string
+	quirks []string
+	// Indexes into quirks and Speeds, where -1 is AUTO.
+	quirk, speed int
+
+	lines []line
+	focus int
+}
+
+// NewMenu lists roms, the recently played ones, given by path, on top.
+func NewMenu(roms []ROM, recent []string) *Menu {
+	m := &Menu{
+		roms:   roms,
+		recent: recent,
+		quirks: append([]string{AUTO}, cpu.QuirkPresetNames()...),
+		speed:  -1,
+	}
+	m.update()
+	// The latest ROM played, or else the first.
+	m.focus = max(0, slices.IndexFunc(m.lines, func(l line) bool { return l.kind == lineROM }))
+	return m
+}
+
+// Recent returns the paths of the recently played ROMs, the latest first.
+func (m *Menu) Recent() []string {
+	return m.recent
+}
+
+// Played puts the ROM at path at the top of the recently played list.
+func (m *Menu) Played(path string) {
+	recent := []string{path}
+	for _, other := range m.recent {
+		if other != path && len(recent) < MAX_RECENT {
+			recent = append(recent, other)
+		}
+	}
+	m.recent = recent
+
+	// Keep the focus on the same line, the list above may have grown.
+	focused := m.lines[m.focus]
+	m.update()
+	if focused.kind == lineROM {
+		m.focus = slices.IndexFunc(m.lines, func(l line) bool {
+			return l.kind == lineROM && l.rom.Path == focused.rom.Path
+		})
+	}
+}
+
+// update builds the lines: the settings, then the recently played ROMs
+// that are still there and all the ROMs.
+func (m *Menu) update() {
+	speed := AUTO
+	if m.speed >= 0 {
+		speed = fmt.Sprintf("%d IPF", Speeds[m.speed])
+	}
+	m.lines = []line{
+		{kind: lineQuirks, text: "Quirks  < " + m.quirks[m.quirk] + " >"},
+		{kind: lineSpeed, text: "Speed   < " + speed + " >"},
+	}
+
+	var recent []line
+	for _, path := range m.recent {
+		if i := slices.IndexFunc(m.roms, func(r ROM) bool { return r.Path == path }); i >= 0 {
+			recent = append(recent, line{kind: lineROM, text: "  " + m.roms[i].Title(), rom: m.roms[i]})
+		}
+	}
+	if len(recent) > 0 {
+		m.lines = append(m.lines, line{kind: lineHeader, text: "Recently played"})
+		m.lines = append(m.lines, recent...)
+	}
+
+	m.lines = append(m.lines, line{kind: lineHeader, text: fmt.Sprintf("ROMs (%d)", len(m.roms))})
+	for _, rom := range m.roms {
+		m.lines = append(m.lines, line{kind: lineROM, text: "  " + rom.Title(), rom: rom})
+	}
+}
+
+// Lines returns the text of the menu and the line that has the focus.
+func (m *Menu) Lines() ([]string, int) {
+	texts := make([]string, len(m.lines))
+	for i, l := range m.lines {
+		texts[i] = l.text
+	}
+	return texts, m.focus
+}
+
+// Info describes the line that has the focus: what the ROM database knows
+// about a ROM, or how to change a setting.
+func (m *Menu) Info() []string {
+	focused := m.lines[m.focus]
+	switch focused.kind {
+	case lineQuirks:
+		return []string{"Left and right pick the quirk preset, auto takes the one from the config or the ROM database"}
+	case lineSpeed:
+		return []string{"Left and right pick the instructions per frame, auto takes them from the config or the ROM database"}
+	case lineROM:
+		if focused.rom.Entry == nil {
+			return []string{focused.rom.Name, "Not in the ROM database"}
+		}
+		info := []string{focused.rom.Entry.Summary()}
+		if description := focused.rom.Entry.Description(); description != "" {
+			info = append(info, description)
+		}
+		return info
+	}
+	return nil
+}
+
+// Do carries out a menu command. It returns the choice when a ROM was
+// picked.
+func (m *Menu) Do(input emulator.MenuInput) (Choice, bool) {
+	focused := m.lines[m.focus]
+	switch input {
+	case emulator.MENU_UP:
+		m.move(-1)
+	case emulator.MENU_DOWN:
+		m.move(1)
+	case emulator.MENU_LEFT, emulator.MENU_RIGHT, emulator.MENU_SELECT:
+		step := 1
+		if input == emulator.MENU_LEFT {
+			step = -1
+		}
+		switch focused.kind {
+		case lineQuirks:
+			m.quirk = (m.quirk + step + len(m.quirks)) % len(m.quirks)
+		case lineSpeed:
+			// -1 is AUTO, so there is one more choice than speeds.
+			m.speed = (m.speed+1+step+len(Speeds)+1)%(len(Speeds)+1) - 1
+		case lineROM:
+			if input == emulator.MENU_SELECT {
+				return m.choice(focused.rom), true
+			}
+		}
+		m.update()
+	}
+
+	return Choice{}, false
+}
+
+func (m *Menu) choice(rom ROM) Choice {
+	choice := Choice{ROM: rom, Quirks: m.quirks[m.quirk]}
+	if m.speed >= 0 {
+		choice.IPF = Speeds[m.speed]
+	}
+	return choice
+}
+
+// move moves the focus by step lines, skipping the headers. It stays put
+// at either end.
+func (m *Menu) move(step int) {
+	for i := m.focus + step; i >= 0 && i < len(m.lines); i += step {
+		if m.lines[i].kind != lineHeader {
+			m.focus = i
+			return
+		}
+	}
+}
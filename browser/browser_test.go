@@ -0,0 +1,94 @@
+package browser_test
+
+import (
+	"chip-8-go/browser"
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"chip-8-go/romdb"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScan(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	for name, size := range map[string]int{"PONG": 2, "brix.ch8": 4, ".hidden": 2, "README.md": 2, "big.ch8": cpu.RAM_SIZE + 1, "empty.ch8": 0} {
+		assert.NoError(os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0o644), name)
+	}
+	assert.NoError(os.Mkdir(filepath.Join(dir, "games"), 0o755), "directory")
+
+	roms, err := browser.Scan(dir, func(rom []byte) *romdb.Entry {
+		if len(rom) != 4 {
+			return nil
+		}
+		return &romdb.Entry{Program: romdb.Program{Title: "Brix"}}
+	})
+	if assert.NoError(err, "scan") && assert.Len(roms, 2, "only the ROMs should be listed") {
+		assert.Equal("PONG", roms[0].Title(), "the file name without an entry")
+		assert.Equal(filepath.Join(dir, "PONG"), roms[0].Path, "path")
+		assert.Equal("Brix", roms[1].Title(), "the title from the entry")
+	}
+}
+
+func TestMenu(t *testing.T) {
+	assert := assert.New(t)
+
+	roms := []browser.ROM{{Path: "/roms/BLITZ", Name: "BLITZ"}, {Path: "/roms/BRIX", Name: "BRIX"}, {Path: "/roms/PONG", Name: "PONG"}}
+	menu := browser.NewMenu(roms, []string{"/roms/PONG", "/roms/gone"})
+	lines, selected := menu.Lines()
+	assert.Equal([]string{"Quirks  < auto >", "Speed   < auto >", "Recently played", "  PONG", "ROMs (3)", "  BLITZ", "  BRIX", "  PONG"}, lines, "lines")
+	assert.Equal(3, selected, "the latest ROM played should have the focus")
+	assert.Equal([]string{"PONG", "Not in the ROM database"}, menu.Info(), "info")
+
+	menu.Do(emulator.MENU_DOWN)
+	_, selected = menu.Lines()
+	assert.Equal(5, selected, "headers should be skipped")
+
+	for range 5 {
+		menu.Do(emulator.MENU_UP)
+	}
+	menu.Do(emulator.MENU_LEFT)
+	menu.Do(emulator.MENU_DOWN)
+	menu.Do(emulator.MENU_SELECT)
+	menu.Do(emulator.MENU_RIGHT)
+	lines, selected = menu.Lines()
+	assert.Equal(1, selected, "the focus should stop at the top")
+	assert.Equal("Quirks  < xochip >", lines[0], "left should wrap around to the last preset")
+	assert.Equal("Speed   < 7 IPF >", lines[1], "speed")
+
+	menu.Do(emulator.MENU_DOWN)
+	menu.Do(emulator.MENU_DOWN)
+	choice, ok := menu.Do(emulator.MENU_SELECT)
+	assert.True(ok, "a ROM should be picked")
+	assert.Equal(browser.Choice{ROM: roms[0], Quirks: "xochip", IPF: 7}, choice, "choice")
+
+	menu.Played("/roms/BLITZ")
+	lines, selected = menu.Lines()
+	assert.Equal([]string{"/roms/BLITZ", "/roms/PONG", "/roms/gone"}, menu.Recent(), "recent")
+	assert.Equal([]string{"  BLITZ", "  PONG", "ROMs (3)"}, lines[3:6], "recently played")
+	assert.Equal("  BLITZ", lines[selected], "the focus should stay on the ROM")
+
+	for _, rom := range roms {
+		menu.Played(rom.Path)
+		menu.Played(rom.Path + "2")
+	}
+	assert.Len(menu.Recent(), browser.MAX_RECENT, "the oldest should drop out")
+}
+
+func TestRecent(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "chip-8-go", browser.RECENT_FILE_NAME)
+	recent, err := browser.LoadRecent(path)
+	assert.NoError(err, "a missing file")
+	assert.Empty(recent, "a missing file")
+
+	assert.NoError(browser.SaveRecent(path, []string{"/roms/PONG", "/roms/BRIX"}), "save")
+	recent, err = browser.LoadRecent(path)
+	assert.NoError(err, "load")
+	assert.Equal([]string{"/roms/PONG", "/roms/BRIX"}, recent, "load")
+}
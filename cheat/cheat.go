@@ -0,0 +1,206 @@
+// Package cheat freezes the values a game keeps in registers or memory,
+// e.g. its lives, and finds where it keeps them with a memory search.
+// Codes are saved in a file per ROM, named after the ROM's SHA-1.
+package cheat
+
+import (
+	"chip-8-go/config"
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Cheat files live in this directory of the config directory.
+const DIR_NAME = "cheats"
+
+// Target is a register or a byte of memory, written "VE" or "0x2F3". The
+// zero value is no target, see Valid.
+type Target struct {
+	// X+1 for VX, -1 for an address and 0 for none.
+	register int
+	address  uint16
+}
+
+// Register returns the target for VX.
+func Register(x int) Target {
+	return Target{register: x + 1}
+}
+
+func Address(address uint16) Target {
+	return Target{register: -1, address: address}
+}
+
+func ParseTarget(text string) (Target, error) {
+	upper := strings.ToUpper(text)
+	if len(upper) == 2 && upper[0] == 'V' {
+		x, err := strconv.ParseUint(upper[1:], 16, 4)
+		if err != nil {
+			return Target{}, fmt.Errorf("invalid register %q", text)
+		}
+		return Register(int(x)), nil
+	}
+
+	address, err := strconv.ParseUint(text, 0, 16)
+	if err != nil || address >= cpu.RAM_SIZE {
+		return Target{}, fmt.Errorf("invalid target %q, want a register (VE) or an address (0x2F3)", text)
+	}
+	return Address(uint16(address)), nil
+}
+
+// Valid reports whether t is a register or an address rather than the
+// zero value, e.g. from a code missing its target.
+func (t Target) Valid() bool {
+	return t.register != 0
+}
+
+func (t Target) String() string {
+	switch {
+	case t.register > 0:
+		return fmt.Sprintf("V%X", t.register-1)
+	case t.register < 0:
+		return fmt.Sprintf("0x%03X", t.address)
+	}
+	return "none"
+}
+
+func (t Target) Read(c *cpu.CPU) uint8 {
+	switch {
+	case t.register > 0:
+		return c.VRegisters[t.register-1]
+	case t.register < 0:
+		return c.Memory[t.address]
+	}
+	return 0
+}
+
+// Write sets the target, through WriteMemory so that running code sees
+// the change.
+func (t Target) Write(c *cpu.CPU, value uint8) {
+	if t.register > 0 {
+		c.VRegisters[t.register-1] = value
+	} else if t.register < 0 && c.Memory[t.address] != value {
+		c.WriteMemory(t.address, []byte{value})
+	}
+}
+
+func (t Target) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *Target) UnmarshalText(text []byte) error {
+	parsed, err := ParseTarget(string(text))
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
+
+// Code freezes Target to Value at the end of every frame while enabled.
+type Code struct {
+	Name    string `json:"name"`
+	Target  Target `json:"target"`
+	Value   uint8  `json:"value"`
+	Enabled bool   `json:"enabled"`
+}
+
+// ParseCode parses "TARGET=VALUE", e.g. "VE=5" or "0x2F3=0x09", into an
+// enabled code.
+func ParseCode(name, text string) (Code, error) {
+	targetText, valueText, found := strings.Cut(text, "=")
+	if !found {
+		return Code{}, fmt.Errorf("invalid code %q, want TARGET=VALUE, e.g. VE=5", text)
+	}
+	target, err := ParseTarget(targetText)
+	if err != nil {
+		return Code{}, err
+	}
+	value, err := strconv.ParseUint(valueText, 0, 8)
+	if err != nil {
+		return Code{}, fmt.Errorf("invalid value %q, want 0 to 255", valueText)
+	}
+	return Code{Name: name, Target: target, Value: uint8(value), Enabled: true}, nil
+}
+
+func (c Code) String() string {
+	state := "off"
+	if c.Enabled {
+		state = "on"
+	}
+	return fmt.Sprintf("%s=%d %s %s", c.Target, c.Value, state, c.Name)
+}
+
+// File holds the codes of one ROM.
+type File struct {
+	// Only there to keep the file readable, it is found by the ROM hash.
+	ROM   string `json:"rom,omitempty"`
+	Codes []Code `json:"codes"`
+}
+
+// Path returns where the cheat file for the ROM with the given hash
+// lives, i.e. $XDG_CONFIG_HOME/chip-8-go/cheats/HASH.json on Linux.
+func Path(romHash string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, config.APP_DIR, DIR_NAME, romHash+".json"), nil
+}
+
+// Load reads a cheat file. A missing file is not an error, it holds no
+// codes, but a code without a target is.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &File{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{}
+	if jsonErr := json.Unmarshal(data, file); jsonErr != nil {
+		return nil, fmt.Errorf("%s: %w", path, jsonErr)
+	}
+	for _, code := range file.Codes {
+		if !code.Target.Valid() {
+			return nil, fmt.Errorf("%s: code %q has no target", path, code.Name)
+		}
+	}
+	return file, nil
+}
+
+// Save writes the file, creating its directory.
+func (f *File) Save(path string) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// Apply writes the enabled codes.
+func (f *File) Apply(c *cpu.CPU) {
+	for _, code := range f.Codes {
+		if code.Enabled {
+			code.Target.Write(c, code.Value)
+		}
+	}
+}
+
+// Attach applies the codes of f to c8 at the end of every frame. Change f
+// only inside c8.Sync from then on.
+func (f *File) Attach(c8 *emulator.Chip8) {
+	c8.AddFrameHook(f.Apply)
+}
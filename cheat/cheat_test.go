@@ -0,0 +1,117 @@
+package cheat_test
+
+import (
+	"chip-8-go/cheat"
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCode(t *testing.T) {
+	assert := assert.New(t)
+
+	for text, want := range map[string]string{"VE=5": "VE=5", "ve=0x10": "VE=16", "0x2F3=9": "0x2F3=9", "755=255": "0x2F3=255"} {
+		code, err := cheat.ParseCode("test", text)
+		if assert.NoError(err, text) {
+			assert.Equal(want+" on test", code.String(), text)
+		}
+	}
+	for _, text := range []string{"VE", "VG=1", "0x1000=1", "VE=256", "lives=1"} {
+		_, err := cheat.ParseCode("test", text)
+		assert.Error(err, text)
+	}
+}
+
+func TestApply(t *testing.T) {
+	assert := assert.New(t)
+
+	c := cpu.NewCPU()
+	file := &cheat.File{Codes: []cheat.Code{
+		{Target: cheat.Register(0xE), Value: 5, Enabled: true},
+		{Target: cheat.Address(0x300), Value: 9, Enabled: true},
+		{Target: cheat.Address(0x301), Value: 7},
+	}}
+	file.Apply(c)
+	assert.Equal(uint8(5), c.VRegisters[0xE], "register code")
+	assert.Equal([]byte{9, 0}, c.Memory[0x300:0x302], "only enabled codes should apply")
+
+	path := filepath.Join(t.TempDir(), "cheats", "rom.json")
+	assert.NoError(file.Save(path))
+	loaded, err := cheat.Load(path)
+	assert.NoError(err)
+	assert.Equal(file, loaded, "the file should load as saved")
+
+	missing, err := cheat.Load(filepath.Join(t.TempDir(), "missing.json"))
+	assert.NoError(err, "a missing file holds no codes")
+	assert.Empty(missing.Codes)
+
+	untargeted := filepath.Join(t.TempDir(), "untargeted.json")
+	assert.NoError(os.WriteFile(untargeted, []byte(`{"codes": [{"name": "lives", "value": 9, "enabled": true}]}`), 0o644))
+	_, err = cheat.Load(untargeted)
+	assert.ErrorContains(err, "has no target", "a code without a target should not freeze V0")
+	assert.False(cheat.Target{}.Valid(), "the zero target")
+	assert.True(cheat.Register(0).Valid(), "V0")
+}
+
+func TestSearch(t *testing.T) {
+	assert := assert.New(t)
+
+	c := cpu.NewCPU()
+	c.Memory[0x300], c.Memory[0x301], c.VRegisters[2] = 3, 3, 3
+	search := cheat.NewSearch(c)
+	assert.Len(search.Candidates(), cpu.NUM_REGS+cpu.RAM_SIZE, "every register and byte")
+
+	c.Memory[0x300], c.Memory[0x301], c.VRegisters[2] = 2, 4, 2
+	assert.Equal(2, search.Filter(c, cheat.DECREASED, 0), "decreased")
+	assert.Equal(2, search.Filter(c, cheat.UNCHANGED, 0), "unchanged")
+	c.Memory[0x300] = 1
+	assert.Equal(1, search.Filter(c, cheat.VALUE, 1), "value")
+	assert.Equal([]cheat.Candidate{{Target: cheat.Address(0x300), Value: 1}}, search.Candidates())
+}
+
+func TestConsole(t *testing.T) {
+	assert := assert.New(t)
+
+	// Counts up in V3 from 100.
+	rom := []byte{0x63, 0x64, 0x73, 0x01, 0x12, 0x02}
+	c8 := emulator.NewChip8(emulator.DefaultOptions(), nil)
+	if err := c8.LoadBytes(rom); err != nil {
+		t.Fatal(err)
+	}
+	file := &cheat.File{}
+	path := filepath.Join(t.TempDir(), "rom.json")
+	console := cheat.NewConsole(file, path, c8)
+
+	var out strings.Builder
+	run := func(commands ...string) {
+		out.Reset()
+		assert.NoError(console.Run(strings.NewReader(strings.Join(commands, "\n")), &out))
+	}
+
+	assert.NoError(c8.Step(1))
+	run("increased", "search")
+	assert.Equal("error: no search, start one with search\n4112 candidates\n", out.String())
+
+	assert.NoError(c8.Step(2))
+	run("increased")
+	assert.Equal("1 candidate\nV3 = 101\n", out.String(), "only V3 should have increased")
+
+	run("freeze V3", "add lives 0x2F3=9", "disable 2", "codes")
+	assert.Equal("1: V3=101 on V3\n2: 0x2F3=9 on lives\n1: V3=101 on V3\n2: 0x2F3=9 off lives\n1: V3=101 on V3\n2: 0x2F3=9 off lives\n", out.String())
+
+	saved, err := cheat.Load(path)
+	assert.NoError(err)
+	assert.Equal(file.Codes, saved.Codes, "changes should be saved at once")
+
+	offline := cheat.NewConsole(saved, path, nil)
+	_, err = offline.Exec("search")
+	assert.Error(err, "searching needs a game")
+	output, err := offline.Exec("remove 1")
+	assert.NoError(err)
+	assert.Equal("1: 0x2F3=9 off lives", output)
+}
@@ -0,0 +1,231 @@
+package cheat
+
+import (
+	"bufio"
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Candidates beyond this many are only counted.
+const MAX_LISTED = 20
+
+const consoleHelp = `search                   start a search with every register and byte of memory
+unchanged, changed,
+increased, decreased     keep the candidates whose value did so since the last search command
+value N                  keep the candidates whose value is N
+candidates               list the candidates left
+freeze TARGET [VALUE]    add a code holding a register or address at VALUE, by default its value now
+add NAME TARGET=VALUE    add a code, e.g. add lives VE=5
+codes                    list the codes
+enable N, disable N,
+remove N                 change the code numbered N by codes`
+
+var errNotRunning = errors.New("no game is running")
+
+// Console manages the codes of a file, saving every change, and searches
+// the memory of a running game, with text commands. Without a game only
+// the commands about codes work.
+type Console struct {
+	file   *File
+	path   string
+	c8     *emulator.Chip8
+	search *Search
+}
+
+// NewConsole manages file, saved at path, and searches c8, which may be
+// nil. The file has to be attached to c8 for the codes to take effect.
+func NewConsole(file *File, path string, c8 *emulator.Chip8) *Console {
+	return &Console{file: file, path: path, c8: c8}
+}
+
+// Run executes the commands read from r, a line each, writing their
+// output and errors to w.
+func (c *Console) Run(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		output, err := c.Exec(scanner.Text())
+		if err != nil {
+			output = "error: " + err.Error()
+		}
+		if _, err := fmt.Fprintln(w, output); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Exec executes one command and returns its output.
+func (c *Console) Exec(line string) (output string, err error) {
+	if c.c8 == nil {
+		return c.exec(nil, strings.Fields(line))
+	}
+	// Between frames, as the codes are applied on the emulation goroutine.
+	c.c8.Sync(func(machine *cpu.CPU) {
+		output, err = c.exec(machine, strings.Fields(line))
+	})
+	return output, err
+}
+
+func (c *Console) exec(machine *cpu.CPU, args []string) (string, error) {
+	if len(args) == 0 {
+		return "", nil
+	}
+	command, args := args[0], args[1:]
+	switch command {
+	case "help":
+		return consoleHelp, nil
+	case "search":
+		if machine == nil {
+			return "", errNotRunning
+		}
+		c.search = NewSearch(machine)
+		return c.count(len(c.search.Candidates())), nil
+	case "unchanged", "changed", "increased", "decreased", "value":
+		return c.filter(machine, command, args)
+	case "candidates":
+		return c.candidates()
+	case "freeze":
+		return c.freeze(machine, args)
+	case "add":
+		if len(args) != 2 {
+			return "", errors.New("usage: add NAME TARGET=VALUE")
+		}
+		code, err := ParseCode(args[0], args[1])
+		if err != nil {
+			return "", err
+		}
+		return c.add(code)
+	case "codes":
+		return c.codes(), nil
+	case "enable", "disable", "remove":
+		return c.change(command, args)
+	}
+	return "", fmt.Errorf("unknown command %q, try help", command)
+}
+
+func (c *Console) count(n int) string {
+	if n == 1 {
+		return "1 candidate"
+	}
+	return fmt.Sprintf("%d candidates", n)
+}
+
+func (c *Console) filter(machine *cpu.CPU, name string, args []string) (string, error) {
+	if machine == nil {
+		return "", errNotRunning
+	}
+	if c.search == nil {
+		return "", errors.New("no search, start one with search")
+	}
+
+	comparison, err := ParseComparison(name)
+	if err != nil {
+		return "", err
+	}
+	var value uint64
+	if comparison == VALUE {
+		if len(args) != 1 {
+			return "", errors.New("usage: value N")
+		}
+		if value, err = strconv.ParseUint(args[0], 0, 8); err != nil {
+			return "", fmt.Errorf("invalid value %q, want 0 to 255", args[0])
+		}
+	}
+
+	n := c.search.Filter(machine, comparison, uint8(value))
+	if n > 0 && n <= MAX_LISTED {
+		return c.candidates()
+	}
+	return c.count(n), nil
+}
+
+func (c *Console) candidates() (string, error) {
+	if c.search == nil {
+		return "", errors.New("no search, start one with search")
+	}
+
+	candidates := c.search.Candidates()
+	if len(candidates) > MAX_LISTED {
+		return c.count(len(candidates)) + ", narrow the search down further to list them", nil
+	}
+	lines := []string{c.count(len(candidates))}
+	for _, candidate := range candidates {
+		lines = append(lines, fmt.Sprintf("%s = %d", candidate.Target, candidate.Value))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func (c *Console) freeze(machine *cpu.CPU, args []string) (string, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return "", errors.New("usage: freeze TARGET [VALUE]")
+	}
+	target, err := ParseTarget(args[0])
+	if err != nil {
+		return "", err
+	}
+
+	code := Code{Name: target.String(), Target: target, Enabled: true}
+	switch {
+	case len(args) == 2:
+		value, err := strconv.ParseUint(args[1], 0, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid value %q, want 0 to 255", args[1])
+		}
+		code.Value = uint8(value)
+	case machine != nil:
+		code.Value = target.Read(machine)
+	default:
+		return "", errors.New("no game is running to take the value from, give it")
+	}
+	return c.add(code)
+}
+
+func (c *Console) add(code Code) (string, error) {
+	c.file.Codes = append(c.file.Codes, code)
+	if err := c.file.Save(c.path); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d: %s", len(c.file.Codes), code), nil
+}
+
+func (c *Console) codes() string {
+	if len(c.file.Codes) == 0 {
+		return "no codes"
+	}
+	lines := make([]string, len(c.file.Codes))
+	for i, code := range c.file.Codes {
+		lines[i] = fmt.Sprintf("%d: %s", i+1, code)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (c *Console) change(command string, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("usage: %s N", command)
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 || n > len(c.file.Codes) {
+		return "", fmt.Errorf("no code %s, see codes", args[0])
+	}
+
+	switch command {
+	case "enable":
+		c.file.Codes[n-1].Enabled = true
+	case "disable":
+		c.file.Codes[n-1].Enabled = false
+	case "remove":
+		c.file.Codes = append(c.file.Codes[:n-1], c.file.Codes[n:]...)
+	}
+	if err := c.file.Save(c.path); err != nil {
+		return "", err
+	}
+	return c.codes(), nil
+}
@@ -0,0 +1,101 @@
+package cheat
+
+import (
+	"chip-8-go/cpu"
+	"fmt"
+	"strings"
+)
+
+// Comparison narrows a search down to the targets whose value compares
+// so with the value at the previous snapshot, or with a given value.
+type Comparison int
+
+const (
+	UNCHANGED Comparison = iota
+	CHANGED
+	INCREASED
+	DECREASED
+	VALUE
+)
+
+var comparisonNames = [...]string{
+	UNCHANGED: "unchanged",
+	CHANGED:   "changed",
+	INCREASED: "increased",
+	DECREASED: "decreased",
+	VALUE:     "value",
+}
+
+func ParseComparison(name string) (Comparison, error) {
+	for comparison, comparisonName := range comparisonNames {
+		if strings.EqualFold(name, comparisonName) {
+			return Comparison(comparison), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown comparison %q, want %s", name, strings.Join(comparisonNames[:], ", "))
+}
+
+func (c Comparison) String() string {
+	return comparisonNames[c]
+}
+
+func (c Comparison) match(previous, current, value uint8) bool {
+	switch c {
+	case CHANGED:
+		return current != previous
+	case INCREASED:
+		return current > previous
+	case DECREASED:
+		return current < previous
+	case VALUE:
+		return current == value
+	}
+	return current == previous
+}
+
+// Candidate is a target still in the search, with its value at the last
+// snapshot.
+type Candidate struct {
+	Target Target
+	Value  uint8
+}
+
+// Search finds where a game keeps a number by narrowing down the registers
+// and bytes of memory across snapshots: start it, play until the number
+// changes, keep the targets that changed the same way and repeat.
+type Search struct {
+	candidates []Candidate
+}
+
+// NewSearch starts a search with every register and byte of memory.
+func NewSearch(c *cpu.CPU) *Search {
+	s := &Search{candidates: make([]Candidate, 0, cpu.NUM_REGS+cpu.RAM_SIZE)}
+	for x := range cpu.NUM_REGS {
+		s.candidates = append(s.candidates, Candidate{Target: Register(x)})
+	}
+	for address := range cpu.RAM_SIZE {
+		s.candidates = append(s.candidates, Candidate{Target: Address(uint16(address))})
+	}
+	for i := range s.candidates {
+		s.candidates[i].Value = s.candidates[i].Target.Read(c)
+	}
+	return s
+}
+
+// Filter keeps the candidates matching comparison, value is only used by
+// VALUE, takes a new snapshot of them and returns how many are left.
+func (s *Search) Filter(c *cpu.CPU, comparison Comparison, value uint8) int {
+	kept := s.candidates[:0]
+	for _, candidate := range s.candidates {
+		current := candidate.Target.Read(c)
+		if comparison.match(candidate.Value, current, value) {
+			kept = append(kept, Candidate{Target: candidate.Target, Value: current})
+		}
+	}
+	s.candidates = kept
+	return len(kept)
+}
+
+func (s *Search) Candidates() []Candidate {
+	return s.candidates
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"chip-8-go/cheat"
+	"chip-8-go/config"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cheatFlags find the cheat file of the ROM.
+type cheatFlags struct {
+	path    string
+	console bool
+}
+
+func addCheatFlags(fs *flag.FlagSet) *cheatFlags {
+	c := &cheatFlags{}
+	fs.StringVar(&c.path, "cheats", "", "cheat `file` to use instead of the one for the ROM in the user config directory")
+	return c
+}
+
+func (c *cheatFlags) addConsoleFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&c.console, "cheat-console", false, "read cheat and memory search commands from stdin while running, help lists them")
+}
+
+// load reads the cheat file of the ROM and returns it with its path. A
+// missing file holds no codes.
+func (c *cheatFlags) load(fileName string, rom []byte) (*cheat.File, string, error) {
+	path := c.path
+	if path == "" {
+		var err error
+		if path, err = cheat.Path(config.HashROM(rom)); err != nil {
+			return nil, "", err
+		}
+	}
+
+	file, err := cheat.Load(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if file.ROM == "" {
+		file.ROM = filepath.Base(fileName)
+	}
+	return file, path, nil
+}
+
+func cheatCommand(args []string) error {
+	fs := newFlagSet("cheat")
+	cheatOpts := addCheatFlags(fs)
+
+	// The ROM and a command of up to three words, e.g. add lives VE=5.
+	positional, err := parseArgsRange(fs, args, 1, 4)
+	if err != nil {
+		return err
+	}
+
+	rom, err := readROM(positional[0])
+	if err != nil {
+		return err
+	}
+	file, path, err := cheatOpts.load(positional[0], rom)
+	if err != nil {
+		return err
+	}
+
+	console := cheat.NewConsole(file, path, nil)
+	if len(positional) == 1 {
+		return console.Run(os.Stdin, os.Stdout)
+	}
+	output, err := console.Exec(strings.Join(positional[1:], " "))
+	if err != nil {
+		return err
+	}
+	fmt.Println(output)
+	return nil
+}
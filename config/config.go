@@ -0,0 +1,288 @@
+package config
+
+import (
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const APP_DIR = "chip-8-go"
+const FILE_NAME = "config.json"
+
+// Config holds the effective settings for a run, after defaults, the
+// config file and command-line flags have been merged.
+type Config struct {
+	Scale       int     `json:"scale"`
+	IPF         int     `json:"ipf"`
+	FrameRate   int     `json:"frame_rate"`
+	FastForward int     `json:"fast_forward"`
+	Quirks      string  `json:"quirks"`
+	Palette     string  `json:"palette"`
+	LoadAddress Address `json:"load_address"`
+	Mute        bool    `json:"mute"`
+	Fullscreen  bool    `json:"fullscreen"`
+	Keys        KeyMap  `json:"keys"`
+}
+
+// Settings is one layer of configuration. Only the fields that are set
+// override the layers below it.
+type Settings struct {
+	Scale       *int     `json:"scale,omitempty"`
+	IPF         *int     `json:"ipf,omitempty"`
+	FrameRate   *int     `json:"frame_rate,omitempty"`
+	FastForward *int     `json:"fast_forward,omitempty"`
+	Quirks      *string  `json:"quirks,omitempty"`
+	Palette     *string  `json:"palette,omitempty"`
+	LoadAddress *Address `json:"load_address,omitempty"`
+	Mute        *bool    `json:"mute,omitempty"`
+	Fullscreen  *bool    `json:"fullscreen,omitempty"`
+	// Bindings from CHIP-8 key ("0"-"F") to host key names. Several names
+	// are separated by commas, and a leading "+" adds to the binding from
+	// the layer below instead of replacing it.
+	Keys KeyMap `json:"keys,omitempty"`
+}
+
+// File is the on-disk configuration: global settings plus overrides for
+// individual ROMs, keyed by the SHA-1 of the ROM image.
+type File struct {
+	Settings
+	ROMs map[string]ROMSettings `json:"roms,omitempty"`
+}
+
+type ROMSettings struct {
+	// Only there to keep the file readable, ROMs are matched by hash.
+	Name string `json:"name,omitempty"`
+	Settings
+}
+
+func Default() Config {
+	keys := make(KeyMap, cpu.NUM_KEYS)
+	for key, name := range emulator.DefaultKeyBindings {
+		keys[fmt.Sprintf("%X", key)] = name
+	}
+
+	return Config{
+		Scale:       10,
+		IPF:         cpu.DEFAULT_IPF,
+		FrameRate:   emulator.DEFAULT_FRAME_RATE,
+		FastForward: emulator.DEFAULT_FAST_FORWARD,
+		Quirks:      cpu.DEFAULT_QUIRKS,
+		Palette:     emulator.DEFAULT_PALETTE,
+		LoadAddress: cpu.START_ADDR,
+		Keys:        keys,
+	}
+}
+
+// DefaultPath returns the config file location inside the user's config
+// directory, i.e. $XDG_CONFIG_HOME/chip-8-go/config.json on Linux.
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, APP_DIR, FILE_NAME), nil
+}
+
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{}
+	if jsonErr := json.Unmarshal(data, file); jsonErr != nil {
+		return nil, fmt.Errorf("%s: %w", path, jsonErr)
+	}
+
+	// Hashes are compared in lower case, whatever tool produced them.
+	roms := make(map[string]ROMSettings, len(file.ROMs))
+	for hash, rom := range file.ROMs {
+		roms[strings.ToLower(hash)] = rom
+	}
+	file.ROMs = roms
+
+	return file, nil
+}
+
+// LoadDefault loads the file at DefaultPath. A missing file is not an
+// error, it simply means nothing is overridden.
+func LoadDefault() (*File, string, error) {
+	path, err := DefaultPath()
+	if err != nil {
+		return &File{}, "", nil
+	}
+
+	file, err := Load(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &File{}, "", nil
+	}
+
+	return file, path, err
+}
+
+// HashROM returns the key used to look up per-ROM settings.
+func HashROM(rom []byte) string {
+	sum := sha1.Sum(rom)
+	return hex.EncodeToString(sum[:])
+}
+
+// Resolve merges, in order of precedence, the defaults, the global
+// settings, whatever was detected about the ROM (e.g. from a ROM database)
+// and the section for the ROM with the given hash, if there is one.
+func (f *File) Resolve(romHash string, detected Settings) Config {
+	config := Default()
+	config.Apply(f.Settings)
+	config.Apply(detected)
+
+	if rom, ok := f.ROMs[strings.ToLower(romHash)]; ok {
+		config.Apply(rom.Settings)
+	}
+
+	return config
+}
+
+func (c *Config) Apply(s Settings) {
+	if s.Scale != nil {
+		c.Scale = *s.Scale
+	}
+	if s.IPF != nil {
+		c.IPF = *s.IPF
+	}
+	if s.FrameRate != nil {
+		c.FrameRate = *s.FrameRate
+	}
+	if s.FastForward != nil {
+		c.FastForward = *s.FastForward
+	}
+	if s.Quirks != nil {
+		c.Quirks = *s.Quirks
+	}
+	if s.Palette != nil {
+		c.Palette = *s.Palette
+	}
+	if s.LoadAddress != nil {
+		c.LoadAddress = *s.LoadAddress
+	}
+	if s.Mute != nil {
+		c.Mute = *s.Mute
+	}
+	if s.Fullscreen != nil {
+		c.Fullscreen = *s.Fullscreen
+	}
+
+	if len(s.Keys) > 0 {
+		keys := make(KeyMap, len(c.Keys))
+		for key, name := range c.Keys {
+			keys[key] = name
+		}
+		for key, name := range s.Keys {
+			key = strings.ToUpper(key)
+			if extra, ok := strings.CutPrefix(name, "+"); ok && keys[key] != "" {
+				name = keys[key] + "," + extra
+			}
+			keys[key] = strings.TrimPrefix(name, "+")
+		}
+		c.Keys = keys
+	}
+}
+
+func (c Config) Validate() error {
+	if c.Scale < 1 {
+		return fmt.Errorf("invalid scale %d: must be at least 1", c.Scale)
+	}
+	if c.IPF < 1 {
+		return fmt.Errorf("invalid ipf %d: must be at least 1", c.IPF)
+	}
+	if c.FrameRate < 1 {
+		return fmt.Errorf("invalid frame_rate %d: must be at least 1", c.FrameRate)
+	}
+	if c.FastForward < 1 {
+		return fmt.Errorf("invalid fast_forward %d: must be at least 1", c.FastForward)
+	}
+	if _, err := cpu.ParseQuirks(c.Quirks); err != nil {
+		return err
+	}
+	if _, err := emulator.ParsePalette(c.Palette); err != nil {
+		return err
+	}
+	if c.LoadAddress < cpu.FONTSET_SIZE || c.LoadAddress >= cpu.RAM_SIZE {
+		return fmt.Errorf("invalid load_address %v: must be between 0x%X and 0x%X", c.LoadAddress, cpu.FONTSET_SIZE, cpu.RAM_SIZE-1)
+	}
+	if _, err := c.Keys.Bindings(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Options converts the settings that affect emulation into emulator
+// options. The config is expected to be valid.
+func (c Config) Options() emulator.Options {
+	opts := emulator.DefaultOptions()
+	opts.IPF = c.IPF
+	opts.FrameRate = c.FrameRate
+	opts.FastForward = c.FastForward
+	opts.Quirks, _ = cpu.ParseQuirks(c.Quirks)
+	opts.LoadAddress = uint16(c.LoadAddress)
+
+	return opts
+}
+
+// Address is a memory address written as a hex string, e.g. "0x600". Plain
+// JSON numbers are accepted as well.
+type Address uint16
+
+func (a Address) String() string {
+	return fmt.Sprintf("0x%03X", uint16(a))
+}
+
+func (a Address) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+func (a *Address) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		text = string(data)
+	}
+
+	return a.Set(text)
+}
+
+// Set parses decimal or 0x-prefixed hex, so Address also works as a flag.
+func (a *Address) Set(text string) error {
+	value, err := strconv.ParseUint(text, 0, 16)
+	if err != nil {
+		return fmt.Errorf("invalid address %q", text)
+	}
+	*a = Address(value)
+
+	return nil
+}
+
+// KeyMap binds CHIP-8 keys, written as a hex digit, to host key names.
+type KeyMap map[string]string
+
+func (k KeyMap) Bindings() (emulator.KeyBindings, error) {
+	var bindings emulator.KeyBindings
+
+	for key, name := range k {
+		index, err := strconv.ParseUint(key, 16, 8)
+		if err != nil || index >= cpu.NUM_KEYS {
+			return bindings, fmt.Errorf("invalid CHIP-8 key %q: expected 0-F", key)
+		}
+		bindings[index] = name
+	}
+
+	return bindings, nil
+}
@@ -0,0 +1,68 @@
+package config_test
+
+import (
+	"chip-8-go/config"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `{
+	"ipf": 12,
+	"palette": "amber",
+	"keys": {"5": "Up"},
+	"roms": {
+		"B232EF880BD6060FB45FA6EFFED7EDF0AE95670E": {"name": "PONG", "quirks": "schip", "load_address": "0x600"}
+	}
+}`
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), config.FILE_NAME)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestResolve(t *testing.T) {
+	assert := assert.New(t)
+
+	file, err := config.Load(writeConfig(t, testConfig))
+	assert.NoError(err)
+
+	global := file.Resolve("", config.Settings{})
+	assert.Equal(12, global.IPF, "Global settings should override defaults")
+	assert.Equal("amber", global.Palette)
+	assert.Equal(config.Default().Quirks, global.Quirks, "Unset settings should keep defaults")
+	assert.Equal("Up", global.Keys["5"], "Key bindings should be merged")
+	assert.Equal("Q", global.Keys["4"], "Unbound keys should keep defaults")
+
+	pong := file.Resolve("b232ef880bd6060fb45fa6effed7edf0ae95670e", config.Settings{})
+	assert.Equal("schip", pong.Quirks, "ROM section should override global settings")
+	assert.Equal(config.Address(0x600), pong.LoadAddress)
+	assert.Equal(12, pong.IPF, "ROM section should inherit global settings")
+	assert.NoError(pong.Validate())
+
+	ipf := 3
+	pong.Apply(config.Settings{IPF: &ipf})
+	assert.Equal(3, pong.IPF, "Flags should override the file")
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(config.Default().Validate())
+
+	cfg := config.Default()
+	cfg.Quirks = "unknown"
+	assert.Error(cfg.Validate())
+
+	cfg = config.Default()
+	cfg.Keys = config.KeyMap{"G": "Space"}
+	assert.Error(cfg.Validate(), "Only keys 0-F exist")
+
+	_, err := config.Load(writeConfig(t, `{"load_address": "0xZZ"}`))
+	assert.Error(err)
+}
@@ -0,0 +1,36 @@
+package cpu
+
+import "sort"
+
+// SetBreakpoint makes RunFrame stop in front of the instruction at address.
+func (c *CPU) SetBreakpoint(address uint16) {
+	if c.breakpoints == nil {
+		c.breakpoints = make(map[uint16]bool)
+	}
+	c.breakpoints[address] = true
+}
+
+func (c *CPU) ClearBreakpoint(address uint16) {
+	delete(c.breakpoints, address)
+}
+
+func (c *CPU) ClearBreakpoints() {
+	c.breakpoints = nil
+}
+
+// Breakpoints returns the breakpoint addresses in ascending order.
+func (c *CPU) Breakpoints() []uint16 {
+	addresses := make([]uint16, 0, len(c.breakpoints))
+	for address := range c.breakpoints {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+
+	return addresses
+}
+
+// SkipBreakpoint lets the next RunFrame execute the instruction at PC even
+// if it has a breakpoint, to continue after stopping there.
+func (c *CPU) SkipBreakpoint() {
+	c.skipBreakpoint = true
+}
@@ -1,8 +1,9 @@
 package cpu
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"time"
 )
 
 const RAM_SIZE = 4096
@@ -17,6 +18,9 @@ const START_ADDR = 0x200
 
 const FONTSET_SIZE = 80
 
+// Instructions executed per frame unless configured otherwise.
+const DEFAULT_IPF = 10
+
 var FONTSET = [FONTSET_SIZE]uint8{
 	0xF0, 0x90, 0x90, 0x90, 0xF0, // 0
 	0x20, 0x60, 0x20, 0x20, 0x70, // 1
@@ -62,6 +66,9 @@ type CPU struct {
 	// | Reserved for  |
 	// |  interpreter  |
 	// +---------------+= 0x000 (0) Start of Chip-8 RAM
+	//
+	// Instructions are decoded once and cached, so change memory holding
+	// code with WriteMemory, or call InvalidateCache after.
 	Memory [RAM_SIZE]uint8
 
 	ProgramCounter uint16
@@ -69,7 +76,7 @@ type CPU struct {
 	StackPointer uint16
 	Stack        [STACK_SIZE]uint16
 
-	Screen [SCREEN_HEIGHT][SCREEN_WIDTH]bool
+	Screen Framebuffer
 
 	// CHIP-8 has 16 8-bit data registers named from V0 to VF. The VF
 	// register doubles as a carry flag.
@@ -83,7 +90,39 @@ type CPU struct {
 	DelayTimer uint8
 	SoundTimer uint8
 
+	Quirks Quirks
+
+	// Number of instructions executed so far.
+	Cycles uint64
+
+	// Unknown instructions are skipped like 0000 and counted here, with
+	// the last of them, for the caller to report.
+	InvalidOpCodes    uint64
+	LastInvalidOpCode InvalidOpCode
+
+	rng random
+
+	// Set by RunFrame when it stopped in front of a breakpoint.
+	BreakpointHit bool
+
+	// Sees every instruction when set, see Tracer.
+	Tracer Tracer
+
+	// How RunFrame executes instructions. Step and tracing always use the
+	// interpreter, which the other engines match exactly.
+	Engine Engine
+
 	shouldDraw bool
+	// Set by DXYN when the DisplayWait quirk ends the current frame early.
+	waitVBlank bool
+
+	breakpoints    map[uint16]bool
+	skipBreakpoint bool
+
+	// Instructions decoded so far, see fetch.
+	decoded *decodeCache
+	// Blocks compiled so far, see runBlock.
+	blocks *blockCache
 }
 
 func NewCPU() *CPU {
@@ -92,38 +131,96 @@ func NewCPU() *CPU {
 		Memory:         [RAM_SIZE]uint8{},
 		StackPointer:   0,
 		Stack:          [STACK_SIZE]uint16{},
-		Screen:         [SCREEN_HEIGHT][SCREEN_WIDTH]bool{},
+		Screen:         Framebuffer{},
 		VRegisters:     [NUM_REGS]uint8{},
 		IndexRegister:  0,
 		Keys:           [NUM_KEYS]bool{false},
 		DelayTimer:     0,
 		SoundTimer:     0,
+		Quirks:         QuirkPresets[DEFAULT_QUIRKS],
+		rng:            newRandom(time.Now().UnixNano()),
 		shouldDraw:     false,
+		decoded:        &decodeCache{},
 	}
 	copy(cpu.Memory[:FONTSET_SIZE], FONTSET[:])
 
 	return cpu
 }
 
+// Seed makes CXNN reproducible by replacing the random source.
+func (c *CPU) Seed(seed int64) {
+	c.rng = newRandom(seed)
+}
+
 func (c *CPU) Tick() (bool, bool, error) {
-	op := c.GetOpCode()
-	count, err := c.execute(op)
+	err := c.Step()
 
 	c.TickTimers()
 
-	if count {
+	return c.shouldDraw, c.shouldBeep(), err
+}
+
+// Step executes a single instruction without touching the timers.
+func (c *CPU) Step() error {
+	if c.Tracer != nil {
+		return c.traceStep()
+	}
+	return c.step()
+}
+
+func (c *CPU) step() error {
+	// fetch, by hand as it is too big to be inlined
+	var d *decoded
+	if pc := c.ProgramCounter; pc < RAM_SIZE-1 && c.decoded.entries[pc].exec != nil {
+		d = &c.decoded.entries[pc]
+	} else if pc >= RAM_SIZE-1 {
+		return ErrProgramEnded
+	} else {
+		d = c.decodeAtPC()
+	}
+	if d.exec(c, d) {
 		c.ProgramCounter += 2
 	}
-	return c.shouldDraw, c.shouldBeep(), err
+	c.Cycles += 1
+
+	return nil
 }
 
-func (c *CPU) execute(op OpCode) (bool, error) {
-	count, err := op.Execute(c)
-	if err != nil {
-		fmt.Printf("Error executing opcode: %v\n", err)
+// RunFrame emulates one 60Hz frame: up to ipf instructions followed by a
+// single timer tick. It reports whether the screen changed during the frame.
+// Hitting a breakpoint ends the frame early and sets BreakpointHit.
+func (c *CPU) RunFrame(ipf int) (bool, error) {
+	c.shouldDraw = false
+	c.waitVBlank = false
+	c.BreakpointHit = false
+
+	for i := 0; i < ipf && !c.waitVBlank; i++ {
+		if len(c.breakpoints) > 0 && c.breakpoints[c.ProgramCounter] && !c.skipBreakpoint {
+			c.BreakpointHit = true
+			break
+		}
+		c.skipBreakpoint = false
+
+		if c.Engine != ENGINE_INTERPRETER && c.Tracer == nil {
+			n, err := c.runBlock(ipf - i)
+			if err != nil {
+				return c.shouldDraw, err
+			}
+			i += n - 1
+			continue
+		}
+
+		if err := c.Step(); err != nil {
+			return c.shouldDraw, err
+		}
+	}
+
+	c.TickTimers()
+	if c.Tracer != nil {
+		c.Tracer.TraceFrame()
 	}
 
-	return count, err
+	return c.shouldDraw, nil
 }
 
 func (c *CPU) TickTimers() {
@@ -155,10 +252,15 @@ func (c *CPU) Pop() uint16 {
 	return c.Stack[c.StackPointer]
 }
 
+// ErrProgramEnded is returned by Step and RunFrame when PC runs past the
+// last instruction in memory.
+var ErrProgramEnded = errors.New("program ended: PC ran past the end of memory")
+
+// GetOpCode returns the instruction at PC, or 0 when PC is past the last
+// one.
 func (c *CPU) GetOpCode() OpCode {
 	if c.ProgramCounter >= RAM_SIZE-1 {
-		fmt.Println("Program ended")
-		os.Exit(0)
+		return 0
 	}
 	high_byte := c.Memory[c.ProgramCounter]
 	low_byte := c.Memory[c.ProgramCounter+1]
@@ -166,11 +268,86 @@ func (c *CPU) GetOpCode() OpCode {
 	return OpCode(code)
 }
 
+// indexAddress returns the address offset bytes past I. Addresses wrap
+// around the end of memory, so no value of I reaches outside it.
+func (c *CPU) indexAddress(offset int) int {
+	return (int(c.IndexRegister) + offset) % RAM_SIZE
+}
+
+// readAtIndex fills data from memory at I on.
+func (c *CPU) readAtIndex(data []byte) {
+	start := c.indexAddress(0)
+	if n := copy(data, c.Memory[start:]); n < len(data) {
+		copy(data[n:], c.Memory[:])
+	}
+}
+
+// writeAtIndex writes data to memory at I on, dropping the decoded
+// instructions it overwrites.
+func (c *CPU) writeAtIndex(data []byte) {
+	start := c.indexAddress(0)
+	n := copy(c.Memory[start:], data)
+	c.invalidate(start, start+n)
+	if n < len(data) {
+		copy(c.Memory[:], data[n:])
+		c.invalidate(0, len(data)-n)
+	}
+}
+
+// InvalidOpCode is an unknown instruction and its address.
+type InvalidOpCode struct {
+	Address uint16
+	OpCode  OpCode
+}
+
+func (i InvalidOpCode) String() string {
+	return fmt.Sprintf("invalid opcode %04X at 0x%03X", uint16(i.OpCode), i.Address)
+}
+
+// recordInvalid counts the unknown instruction op at address.
+func (c *CPU) recordInvalid(address uint16, op OpCode) {
+	c.InvalidOpCodes++
+	c.LastInvalidOpCode = InvalidOpCode{Address: address, OpCode: op}
+}
+
+var ErrEmptyROM = errors.New("ROM is empty")
+
+// ROMSizeError reports a ROM that does not fit between its load address
+// and the end of memory.
+type ROMSizeError struct {
+	Size    int
+	Address uint16
+}
+
+func (e *ROMSizeError) Error() string {
+	return fmt.Sprintf("ROM is %d bytes, only %d fit between 0x%03X and 0x%03X", e.Size, RAM_SIZE-int(e.Address), e.Address, RAM_SIZE-1)
+}
+
+// LoadROM copies rom into memory at address and starts execution there.
+// Most programs load at START_ADDR, ETI 660 ones at 0x600.
+func (c *CPU) LoadROM(rom []byte, address uint16) error {
+	if address < FONTSET_SIZE || address >= RAM_SIZE {
+		return fmt.Errorf("invalid load address 0x%03X: must be between 0x%03X and 0x%03X", address, FONTSET_SIZE, RAM_SIZE-1)
+	}
+	if len(rom) == 0 {
+		return ErrEmptyROM
+	}
+	if len(rom) > RAM_SIZE-int(address) {
+		return &ROMSizeError{Size: len(rom), Address: address}
+	}
+
+	copy(c.Memory[address:], rom)
+	c.InvalidateCache()
+	c.ProgramCounter = address
+
+	return nil
+}
+
 func (c *CPU) SetKey(num uint8, isPressed bool) {
 	c.Keys[num] = isPressed
 }
 
 func (cpu *CPU) ClearScreen() {
-	cpu.Screen = [SCREEN_HEIGHT][SCREEN_WIDTH]bool{}
+	cpu.Screen.Clear()
 	cpu.shouldDraw = true
 }
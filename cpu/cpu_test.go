@@ -15,7 +15,7 @@ func TestNewChip(t *testing.T) {
 	assert.Equal(uint16(CPU.START_ADDR), cpu.ProgramCounter, "ProgramCounter should be set to START_ADDR")
 	assert.Equal(uint8(0x00), cpu.Memory[CPU.START_ADDR], "Initial RAM value at START_ADDR should be 0")
 	assert.Equal(uint16(0), cpu.StackPointer, "StackPointer should be initialized to 0")
-	assert.Equal([CPU.SCREEN_HEIGHT][CPU.SCREEN_WIDTH]bool{}, cpu.Screen, "Screen should be cleared")
+	assert.Equal(CPU.Framebuffer{}, cpu.Screen, "Screen should be cleared")
 
 	for i := 0; i < CPU.FONTSET_SIZE; i++ {
 		assert.Equal(CPU.FONTSET[i], cpu.Memory[i], "FONTSET should be correctly loaded into RAM")
@@ -68,3 +68,190 @@ func TestSetKey(t *testing.T) {
 	cpu.SetKey(0x1, false)
 	assert.False(cpu.Keys[0x1], "Key 0x1 should be set to not pressed")
 }
+
+func TestRunFrame(t *testing.T) {
+	assert := assert.New(t)
+
+	cpu := CPU.NewCPU()
+	cpu.Quirks = CPU.Quirks{}
+	cpu.DelayTimer = 5
+
+	// 0x200: ADD V0, 1; JP 0x200
+	copy(cpu.Memory[CPU.START_ADDR:], []uint8{0x70, 0x01, 0x12, 0x00})
+
+	draw, err := cpu.RunFrame(10)
+	assert.NoError(err)
+	assert.False(draw, "Frame without DXYN should not request a redraw")
+	assert.Equal(uint8(5), cpu.VRegisters[0], "Half of the instructions should be ADD")
+	assert.Equal(uint64(10), cpu.Cycles, "RunFrame should execute ipf instructions")
+	assert.Equal(uint8(4), cpu.DelayTimer, "Timers should tick once per frame")
+}
+
+func TestDisplayWaitQuirk(t *testing.T) {
+	assert := assert.New(t)
+
+	cpu := CPU.NewCPU()
+	cpu.Quirks = CPU.QuirkPresets["chip8"]
+
+	// 0x200: DRW V0, V0, 1; JP 0x200
+	copy(cpu.Memory[CPU.START_ADDR:], []uint8{0xD0, 0x01, 0x12, 0x00})
+
+	draw, err := cpu.RunFrame(10)
+	assert.NoError(err)
+	assert.True(draw, "DXYN should request a redraw")
+	assert.Equal(uint64(1), cpu.Cycles, "DXYN should end the frame with the DisplayWait quirk")
+}
+
+func TestShiftQuirk(t *testing.T) {
+	assert := assert.New(t)
+
+	cpu := CPU.NewCPU()
+	cpu.VRegisters[0x1] = 0x01
+	cpu.VRegisters[0x2] = 0x06
+
+	cpu.Quirks = CPU.Quirks{ShiftUsesVY: true}
+	CPU.OpCode(0x8126).Execute(cpu)
+	assert.Equal(uint8(0x03), cpu.VRegisters[0x1], "8XY6 should shift VY into VX")
+	assert.Equal(uint8(0), cpu.VRegisters[0xF])
+
+	cpu.Quirks = CPU.Quirks{}
+	CPU.OpCode(0x8126).Execute(cpu)
+	assert.Equal(uint8(0x01), cpu.VRegisters[0x1], "8XY6 should shift VX in place")
+	assert.Equal(uint8(1), cpu.VRegisters[0xF], "VF should hold the dropped bit")
+}
+
+func TestLoadStoreQuirk(t *testing.T) {
+	assert := assert.New(t)
+
+	cpu := CPU.NewCPU()
+	cpu.IndexRegister = 0x300
+	cpu.VRegisters[0x0] = 0xAA
+	cpu.VRegisters[0x1] = 0xBB
+
+	cpu.Quirks = CPU.Quirks{IncrementIndex: true}
+	CPU.OpCode(0xF155).Execute(cpu)
+	assert.Equal([]uint8{0xAA, 0xBB}, cpu.Memory[0x300:0x302])
+	assert.Equal(uint16(0x302), cpu.IndexRegister, "FX55 should advance I past the stored registers")
+
+	cpu.Quirks = CPU.Quirks{}
+	CPU.OpCode(0xF165).Execute(cpu)
+	assert.Equal(uint16(0x302), cpu.IndexRegister, "FX65 should leave I unchanged")
+}
+
+func TestSeed(t *testing.T) {
+	assert := assert.New(t)
+
+	first := CPU.NewCPU()
+	second := CPU.NewCPU()
+	first.Seed(42)
+	second.Seed(42)
+
+	for i := 0; i < 8; i++ {
+		CPU.OpCode(0xC0FF).Execute(first)
+		CPU.OpCode(0xC0FF).Execute(second)
+		assert.Equal(first.VRegisters[0], second.VRegisters[0], "Same seed should give the same random numbers")
+	}
+}
+
+func TestMnemonic(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("CLS", CPU.OpCode(0x00E0).Mnemonic())
+	assert.Equal("JP 0x2A0", CPU.OpCode(0x12A0).Mnemonic())
+	assert.Equal("SUBN V3, VA", CPU.OpCode(0x83A7).Mnemonic())
+	assert.Equal("DRW V0, V1, 15", CPU.OpCode(0xD01F).Mnemonic())
+	assert.Equal("LD V5, [I]", CPU.OpCode(0xF565).Mnemonic())
+	assert.Equal("DW 0x5121", CPU.OpCode(0x5121).Mnemonic())
+
+	instructions := CPU.Disassemble([]byte{0x00, 0xE0, 0x12}, CPU.START_ADDR)
+	assert.Len(instructions, 2)
+	assert.Equal("0x202: 1200  JP 0x200", instructions[1].String())
+}
+
+func TestParseQuirks(t *testing.T) {
+	assert := assert.New(t)
+
+	quirks, err := CPU.ParseQuirks("schip")
+	assert.NoError(err)
+	assert.Equal(CPU.QuirkPresets["schip"], quirks)
+
+	quirks, err = CPU.ParseQuirks("chip8-vblank+jumpvx")
+	assert.NoError(err)
+	assert.False(quirks.DisplayWait)
+	assert.True(quirks.JumpUsesVX)
+	assert.True(quirks.ResetVF, "Untouched quirks should come from the preset")
+	assert.Equal("chip8+jumpvx-vblank", quirks.String())
+
+	roundTrip, err := CPU.ParseQuirks(quirks.String())
+	assert.NoError(err)
+	assert.Equal(quirks, roundTrip, "String should give a spec that parses back")
+
+	_, err = CPU.ParseQuirks("vip")
+	assert.Error(err)
+	_, err = CPU.ParseQuirks("chip8+nope")
+	assert.Error(err)
+}
+
+func TestState(t *testing.T) {
+	assert := assert.New(t)
+
+	c := CPU.NewCPU()
+	c.Seed(7)
+	assert.NoError(c.LoadROM([]byte{0xA0, 0x00, 0xD0, 0x05, 0xC1, 0xFF, 0xC2, 0xFF}, CPU.START_ADDR))
+	for i := 0; i < 3; i++ {
+		assert.NoError(c.Step())
+	}
+	state := c.SaveState()
+
+	restored := CPU.NewCPU()
+	assert.NoError(restored.LoadState(state))
+	assert.Equal(c.SaveState(), restored.SaveState(), "a restored CPU should save the same state")
+	assert.True(restored.Screen.Pixel(0, 0), "the screen should be restored")
+
+	c.Step()
+	restored.Step()
+	assert.Equal(c.VRegisters[2], restored.VRegisters[2], "the random generator should be restored")
+
+	bad := state
+	bad.ProgramCounter = CPU.RAM_SIZE - 1
+	assert.Error(restored.LoadState(bad), "PC outside memory should be rejected")
+	bad = state
+	bad.IndexRegister = CPU.RAM_SIZE
+	assert.Error(restored.LoadState(bad), "I outside memory should be rejected")
+	bad = state
+	bad.Stack[3] = 0xFFFF
+	assert.Error(restored.LoadState(bad), "a return address outside memory should be rejected")
+	assert.Equal(c.VRegisters[2], restored.VRegisters[2], "a rejected state should leave the CPU alone")
+
+	state.Memory = state.Memory[:10]
+	assert.Error(restored.LoadState(state), "a truncated state should be rejected")
+}
+
+func TestBreakpoint(t *testing.T) {
+	assert := assert.New(t)
+
+	c := CPU.NewCPU()
+	// LD V0, 1; ADD V0, 1; JP 0x202
+	assert.NoError(c.LoadROM([]byte{0x60, 0x01, 0x70, 0x01, 0x12, 0x02}, CPU.START_ADDR))
+	c.SetBreakpoint(0x202)
+
+	_, err := c.RunFrame(10)
+	assert.NoError(err)
+	assert.True(c.BreakpointHit, "RunFrame should stop at the breakpoint")
+	assert.Equal(uint16(0x202), c.ProgramCounter, "the instruction at the breakpoint should not run")
+
+	_, err = c.RunFrame(10)
+	assert.NoError(err)
+	assert.Equal(uint16(0x202), c.ProgramCounter, "RunFrame should stop again without SkipBreakpoint")
+
+	c.SkipBreakpoint()
+	_, err = c.RunFrame(10)
+	assert.NoError(err)
+	assert.True(c.BreakpointHit, "RunFrame should stop when it gets back to the breakpoint")
+	assert.Equal(uint8(2), c.VRegisters[0], "the instruction at the breakpoint should run once")
+
+	c.ClearBreakpoints()
+	_, err = c.RunFrame(10)
+	assert.NoError(err)
+	assert.False(c.BreakpointHit, "no breakpoints should be hit after clearing them")
+}
@@ -0,0 +1,408 @@
+package cpu
+
+// handler executes a decoded instruction. Like OpCode.Execute, it reports
+// whether the program counter should move on to the next instruction.
+type handler func(c *CPU, d *decoded) bool
+
+// decoded is an instruction split into its operands once, ready to run.
+type decoded struct {
+	exec  handler
+	x, y  uint8
+	n, nn uint8
+	nnn   uint16
+}
+
+// decodeCache holds the instructions decoded so far by address.
+type decodeCache struct {
+	entries [RAM_SIZE]decoded
+	// The addresses decoded lie in [low, high), so only that needs clearing.
+	low, high int
+}
+
+// decoders pick the handler of an instruction by its first nibble.
+var decoders = [16]func(op OpCode) handler{
+	0x0: decodeSystem,
+	0x1: fixed(jump),
+	0x2: fixed(call),
+	0x3: fixed(skipEqualNN),
+	0x4: fixed(skipNotEqualNN),
+	0x5: decodeSkipEqualVY,
+	0x6: fixed(loadNN),
+	0x7: fixed(addNN),
+	0x8: decodeArithmetic,
+	0x9: fixed(skipNotEqualVY),
+	0xA: fixed(loadIndex),
+	0xB: fixed(jumpOffset),
+	0xC: fixed(randomNN),
+	0xD: fixed(draw),
+	0xE: decodeKeys,
+	0xF: decodeMisc,
+}
+
+func fixed(h handler) func(op OpCode) handler {
+	return func(OpCode) handler { return h }
+}
+
+func decode(op OpCode) decoded {
+	return decoded{
+		exec: decoders[op>>12](op),
+		x:    uint8(op>>8) & 0xF,
+		y:    uint8(op>>4) & 0xF,
+		n:    uint8(op) & 0xF,
+		nn:   uint8(op),
+		nnn:  uint16(op) & 0x0FFF,
+	}
+}
+
+// decodeAtPC decodes the instruction at PC, which must be in memory, into
+// the cache.
+func (c *CPU) decodeAtPC() *decoded {
+	pc := int(c.ProgramCounter)
+	if c.decoded.low == c.decoded.high {
+		c.decoded.low, c.decoded.high = pc, pc+1
+	} else {
+		c.decoded.low, c.decoded.high = min(c.decoded.low, pc), max(c.decoded.high, pc+1)
+	}
+
+	d := &c.decoded.entries[pc]
+	*d = decode(c.GetOpCode())
+	return d
+}
+
+// invalidate drops the decoded instructions and compiled blocks
+// overlapping memory from start up to end.
+func (c *CPU) invalidate(start, end int) {
+	for address := max(start-1, c.decoded.low); address < min(end, c.decoded.high); address++ {
+		c.decoded.entries[address].exec = nil
+	}
+	c.invalidateBlocks(start, end)
+}
+
+// WriteMemory copies data into memory at address. Code that changes
+// memory while a program runs has to write through it, or call
+// InvalidateCache after, for the change to be seen by execution.
+func (c *CPU) WriteMemory(address uint16, data []byte) {
+	n := copy(c.Memory[address:], data)
+	c.invalidate(int(address), int(address)+n)
+}
+
+// InvalidateCache makes the CPU decode and compile every instruction again.
+func (c *CPU) InvalidateCache() {
+	clear(c.decoded.entries[c.decoded.low:c.decoded.high])
+	c.decoded.low, c.decoded.high = 0, 0
+	c.clearBlocks()
+}
+
+// invalid skips an unknown instruction like OpCode.Execute does, counting
+// it.
+func invalid(c *CPU, d *decoded) bool {
+	c.recordInvalid(c.ProgramCounter, c.GetOpCode())
+	return true
+}
+
+func noop(c *CPU, d *decoded) bool {
+	return true
+}
+
+func decodeSystem(op OpCode) handler {
+	switch op & 0xFF {
+	case 0x00:
+		return noop
+	case 0xE0:
+		return clearScreen
+	case 0xEE:
+		return ret
+	}
+	return invalid
+}
+
+func decodeSkipEqualVY(op OpCode) handler {
+	if op&0xF == 0 {
+		return skipEqualVY
+	}
+	return invalid
+}
+
+var arithmetic = [16]handler{
+	0x0: loadVY,
+	0x1: or,
+	0x2: and,
+	0x3: xor,
+	0x4: addVY,
+	0x5: subVY,
+	0x6: shiftRight,
+	0x7: subFromVY,
+	0xE: shiftLeft,
+}
+
+func decodeArithmetic(op OpCode) handler {
+	if h := arithmetic[op&0xF]; h != nil {
+		return h
+	}
+	return noop
+}
+
+func decodeKeys(op OpCode) handler {
+	switch op & 0xFF {
+	case 0x9E:
+		return skipPressed
+	case 0xA1:
+		return skipReleased
+	}
+	return invalid
+}
+
+var misc = map[uint8]handler{
+	0x07: loadDelay,
+	0x0A: waitKey,
+	0x15: setDelay,
+	0x18: setSound,
+	0x1E: addIndex,
+	0x29: loadFont,
+	0x33: storeBCD,
+	0x55: storeRegisters,
+	0x65: loadRegisters,
+}
+
+func decodeMisc(op OpCode) handler {
+	if h, ok := misc[uint8(op)]; ok {
+		return h
+	}
+	return invalid
+}
+
+func clearScreen(c *CPU, d *decoded) bool {
+	c.ClearScreen()
+	return true
+}
+
+func ret(c *CPU, d *decoded) bool {
+	c.ProgramCounter = c.Pop()
+	return true
+}
+
+func jump(c *CPU, d *decoded) bool {
+	c.ProgramCounter = d.nnn
+	return false
+}
+
+func call(c *CPU, d *decoded) bool {
+	c.Push(c.ProgramCounter)
+	c.ProgramCounter = d.nnn
+	return false
+}
+
+func skipEqualNN(c *CPU, d *decoded) bool {
+	if c.VRegisters[d.x] == d.nn {
+		c.ProgramCounter += 2
+	}
+	return true
+}
+
+func skipNotEqualNN(c *CPU, d *decoded) bool {
+	if c.VRegisters[d.x] != d.nn {
+		c.ProgramCounter += 2
+	}
+	return true
+}
+
+func skipEqualVY(c *CPU, d *decoded) bool {
+	if c.VRegisters[d.x] == c.VRegisters[d.y] {
+		c.ProgramCounter += 2
+	}
+	return true
+}
+
+func loadNN(c *CPU, d *decoded) bool {
+	c.VRegisters[d.x] = d.nn
+	return true
+}
+
+func addNN(c *CPU, d *decoded) bool {
+	c.VRegisters[d.x] += d.nn
+	return true
+}
+
+func loadVY(c *CPU, d *decoded) bool {
+	c.VRegisters[d.x] = c.VRegisters[d.y]
+	return true
+}
+
+func or(c *CPU, d *decoded) bool {
+	c.VRegisters[d.x] |= c.VRegisters[d.y]
+	if c.Quirks.ResetVF {
+		c.VRegisters[0xF] = 0
+	}
+	return true
+}
+
+func and(c *CPU, d *decoded) bool {
+	c.VRegisters[d.x] &= c.VRegisters[d.y]
+	if c.Quirks.ResetVF {
+		c.VRegisters[0xF] = 0
+	}
+	return true
+}
+
+func xor(c *CPU, d *decoded) bool {
+	c.VRegisters[d.x] ^= c.VRegisters[d.y]
+	if c.Quirks.ResetVF {
+		c.VRegisters[0xF] = 0
+	}
+	return true
+}
+
+func flag(set bool) uint8 {
+	if set {
+		return 1
+	}
+	return 0
+}
+
+func addVY(c *CPU, d *decoded) bool {
+	result, overflowed := OverflowAdd(c.VRegisters[d.x], c.VRegisters[d.y])
+	c.VRegisters[d.x] = result
+	c.VRegisters[0xF] = flag(overflowed)
+	return true
+}
+
+func subVY(c *CPU, d *decoded) bool {
+	result, underflowed := OverflowSub(c.VRegisters[d.x], c.VRegisters[d.y])
+	c.VRegisters[d.x] = result
+	c.VRegisters[0xF] = flag(!underflowed)
+	return true
+}
+
+func subFromVY(c *CPU, d *decoded) bool {
+	result, underflowed := OverflowSub(c.VRegisters[d.y], c.VRegisters[d.x])
+	c.VRegisters[d.x] = result
+	c.VRegisters[0xF] = flag(!underflowed)
+	return true
+}
+
+func shiftRight(c *CPU, d *decoded) bool {
+	if c.Quirks.ShiftUsesVY {
+		c.VRegisters[d.x] = c.VRegisters[d.y]
+	}
+	dropped := c.VRegisters[d.x] & 1
+	c.VRegisters[d.x] >>= 1
+	c.VRegisters[0xF] = dropped
+	return true
+}
+
+func shiftLeft(c *CPU, d *decoded) bool {
+	if c.Quirks.ShiftUsesVY {
+		c.VRegisters[d.x] = c.VRegisters[d.y]
+	}
+	overflowed := c.VRegisters[d.x] >> 7
+	c.VRegisters[d.x] <<= 1
+	c.VRegisters[0xF] = overflowed
+	return true
+}
+
+func skipNotEqualVY(c *CPU, d *decoded) bool {
+	if c.VRegisters[d.x] != c.VRegisters[d.y] {
+		c.ProgramCounter += 2
+	}
+	return true
+}
+
+func loadIndex(c *CPU, d *decoded) bool {
+	c.IndexRegister = d.nnn
+	return true
+}
+
+func jumpOffset(c *CPU, d *decoded) bool {
+	offset := c.VRegisters[0x0]
+	if c.Quirks.JumpUsesVX {
+		offset = c.VRegisters[d.x]
+	}
+	c.ProgramCounter = uint16(offset) + d.nnn
+	return false
+}
+
+func randomNN(c *CPU, d *decoded) bool {
+	c.VRegisters[d.x] = c.rng.next() & d.nn
+	return true
+}
+
+func draw(c *CPU, d *decoded) bool {
+	c.drawSprite(c.VRegisters[d.x], c.VRegisters[d.y], d.n)
+	return true
+}
+
+func skipPressed(c *CPU, d *decoded) bool {
+	if c.Keys[c.VRegisters[d.x]] {
+		c.ProgramCounter += 2
+	}
+	return true
+}
+
+func skipReleased(c *CPU, d *decoded) bool {
+	if !c.Keys[c.VRegisters[d.x]] {
+		c.ProgramCounter += 2
+	}
+	return true
+}
+
+func loadDelay(c *CPU, d *decoded) bool {
+	c.VRegisters[d.x] = c.DelayTimer
+	return true
+}
+
+func waitKey(c *CPU, d *decoded) bool {
+	for i, pressed := range c.Keys {
+		if pressed {
+			c.VRegisters[d.x] = uint8(i)
+			return true
+		}
+	}
+	c.ProgramCounter -= 2
+	return true
+}
+
+func setDelay(c *CPU, d *decoded) bool {
+	c.DelayTimer = c.VRegisters[d.x]
+	return true
+}
+
+func setSound(c *CPU, d *decoded) bool {
+	c.SoundTimer = c.VRegisters[d.x]
+	return true
+}
+
+func addIndex(c *CPU, d *decoded) bool {
+	result, overflow := OverflowAdd(c.IndexRegister, uint16(c.VRegisters[d.x]))
+	c.IndexRegister = result
+	if overflow {
+		c.IndexRegister = 0
+	}
+	return true
+}
+
+func loadFont(c *CPU, d *decoded) bool {
+	c.IndexRegister = uint16(c.VRegisters[d.x]) * 5
+	return true
+}
+
+func storeBCD(c *CPU, d *decoded) bool {
+	vx := c.VRegisters[d.x]
+	c.writeAtIndex([]byte{vx / 100, (vx / 10) % 10, vx % 10})
+	return true
+}
+
+func storeRegisters(c *CPU, d *decoded) bool {
+	c.writeAtIndex(c.VRegisters[:d.x+1])
+	if c.Quirks.IncrementIndex {
+		c.IndexRegister += uint16(d.x) + 1
+	}
+	return true
+}
+
+func loadRegisters(c *CPU, d *decoded) bool {
+	c.readAtIndex(c.VRegisters[:d.x+1])
+	if c.Quirks.IncrementIndex {
+		c.IndexRegister += uint16(d.x) + 1
+	}
+	return true
+}
@@ -0,0 +1,187 @@
+package cpu_test
+
+import (
+	CPU "chip-8-go/cpu"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDecodedMatchesExecute runs every instruction through both the
+// decoded instruction cache and OpCode.Execute, from the same random state.
+func TestDecodedMatchesExecute(t *testing.T) {
+	assert := assert.New(t)
+
+	rng := rand.New(rand.NewSource(1))
+	presets := CPU.QuirkPresetNames()
+	base, decoded, switched := CPU.NewCPU(), CPU.NewCPU(), CPU.NewCPU()
+
+	for code := 0; code <= 0xFFFF; code++ {
+		op := CPU.OpCode(code)
+
+		base.Seed(rng.Int63())
+		base.Quirks = CPU.QuirkPresets[presets[code%len(presets)]]
+		base.Memory[CPU.START_ADDR] = uint8(code >> 8)
+		base.Memory[CPU.START_ADDR+1] = uint8(code)
+		rng.Read(base.VRegisters[:])
+		if code>>12 == 0xE {
+			// Both panic on keys past F.
+			base.VRegisters[(code>>8)&0xF] &= 0xF
+		}
+		base.IndexRegister = uint16(CPU.START_ADDR + 2 + rng.Intn(0xD00))
+		rng.Read(base.Memory[base.IndexRegister : base.IndexRegister+16])
+		base.StackPointer = uint16(1 + rng.Intn(CPU.STACK_SIZE-1))
+		base.Stack[base.StackPointer-1] = uint16(rng.Intn(0xF00))
+		base.Keys = [CPU.NUM_KEYS]bool{}
+		base.Keys[rng.Intn(CPU.NUM_KEYS)] = rng.Intn(2) == 0
+		base.DelayTimer = uint8(rng.Intn(3))
+		state := base.SaveState()
+
+		assert.NoError(decoded.LoadState(state))
+		assert.NoError(switched.LoadState(state))
+
+		assert.NoError(decoded.Step())
+		count, err := op.Execute(switched)
+		assert.NoError(err)
+		if count {
+			switched.ProgramCounter += 2
+		}
+		switched.Cycles++
+
+		if !assert.Equal(switched.SaveState(), decoded.SaveState(), "state after %04X", code) {
+			return
+		}
+	}
+	assert.Equal(switched.InvalidOpCodes, decoded.InvalidOpCodes, "both should count the unknown instructions")
+	assert.Equal(switched.LastInvalidOpCode, decoded.LastInvalidOpCode, "both should record the last unknown instruction")
+}
+
+func TestInvalidOpCode(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, engine := range []CPU.Engine{CPU.ENGINE_INTERPRETER, CPU.ENGINE_RECOMPILER, CPU.ENGINE_LOCKSTEP} {
+		c := CPU.NewCPU()
+		c.Engine = engine
+		// 00E1, then V0 += 1, and a jump back to the start.
+		assert.NoError(c.LoadROM([]byte{0x00, 0xE1, 0x70, 0x01, 0x12, 0x00}, CPU.START_ADDR))
+		_, err := c.RunFrame(9)
+		assert.NoError(err, "engine %d", engine)
+		assert.Equal(uint64(3), c.InvalidOpCodes, "unknown instructions should be counted, engine %d", engine)
+		assert.Equal(CPU.InvalidOpCode{Address: CPU.START_ADDR, OpCode: 0x00E1}, c.LastInvalidOpCode, "engine %d", engine)
+		assert.Equal(uint8(3), c.VRegisters[0], "unknown instructions should be skipped, engine %d", engine)
+	}
+	assert.Equal("invalid opcode 00E1 at 0x200", CPU.InvalidOpCode{Address: 0x200, OpCode: 0x00E1}.String(), "string")
+}
+
+func TestIndexWrapsAroundMemory(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, engine := range []CPU.Engine{CPU.ENGINE_INTERPRETER, CPU.ENGINE_RECOMPILER, CPU.ENGINE_LOCKSTEP} {
+		c := CPU.NewCPU()
+		c.Engine = engine
+		assert.NoError(c.LoadROM([]byte{
+			0x60, 0xFF, // 0x200: LD V0, 255
+			0xF0, 0x33, // 0x202: LD B, V0 at 0xFFF, 0x000 and 0x001
+			0x60, 0x00, // 0x204: LD V0, 0
+			0xD0, 0x15, // 0x206: DRW V0, V1, 5
+			0x12, 0x08, // 0x208: JP 0x208
+		}, CPU.START_ADDR))
+		c.IndexRegister = 0xFFF
+
+		_, err := c.RunFrame(10)
+		assert.NoError(err, "engine %d", engine)
+		assert.Equal([]uint8{2, 5, 5}, []uint8{c.Memory[0xFFF], c.Memory[0], c.Memory[1]}, "FX33 should wrap around, engine %d", engine)
+		assert.True(c.Screen.Pixel(6, 0), "the first sprite row comes from 0xFFF, engine %d", engine)
+		assert.True(c.Screen.Pixel(5, 1) && c.Screen.Pixel(7, 1), "the next rows from 0x000 on, engine %d", engine)
+
+		c.IndexRegister = 0xFFE
+		c.VRegisters[0], c.VRegisters[1], c.VRegisters[2] = 7, 8, 9
+		assert.NoError(c.LoadROM([]byte{0xF2, 0x55, 0x12, 0x02}, CPU.START_ADDR))
+		_, err = c.RunFrame(2)
+		assert.NoError(err, "engine %d", engine)
+		assert.Equal([]uint8{7, 8, 9}, []uint8{c.Memory[0xFFE], c.Memory[0xFFF], c.Memory[0]}, "FX55 should wrap around, engine %d", engine)
+	}
+}
+
+func TestSelfModifyingCode(t *testing.T) {
+	assert := assert.New(t)
+
+	cpu := CPU.NewCPU()
+	cpu.Quirks = CPU.QuirkPresets["modern"]
+	assert.NoError(cpu.LoadROM([]byte{
+		0x12, 0x0A, // 0x200: JP 0x20A
+		0xA2, 0x0A, // 0x202: LD I, 0x20A
+		0x60, 0x60, // 0x204: LD V0, 0x60
+		0x61, 0x2A, // 0x206: LD V1, 0x2A
+		0xF1, 0x55, // 0x208: LD [I], V1, turning 0x20A into LD V0, 0x2A
+		0x63, 0x01, // 0x20A: LD V3, 0x01
+		0x73, 0x01, // 0x20C: ADD V3, 0x01
+		0x43, 0x02, // 0x20E: SNE V3, 0x02
+		0x12, 0x02, // 0x210: JP 0x202
+		0x12, 0x12, // 0x212: JP 0x212
+	}, CPU.START_ADDR))
+
+	for i := 0; i < 20; i++ {
+		assert.NoError(cpu.Step())
+	}
+	assert.Equal(uint8(0x2A), cpu.VRegisters[0], "FX55 should replace the decoded instruction")
+	assert.Equal(uint16(0x212), cpu.ProgramCounter, "PC")
+
+	cpu.WriteMemory(0x212, []byte{0x60, 0x07})
+	assert.NoError(cpu.Step())
+	assert.Equal(uint8(0x07), cpu.VRegisters[0], "WriteMemory should replace the decoded instruction")
+}
+
+// benchmarkROM is a loop of common instructions.
+var benchmarkROM = []byte{
+	0x60, 0x00, // 0x200: LD V0, 0x00
+	0x70, 0x01, // 0x202: ADD V0, 0x01
+	0x81, 0x04, // 0x204: ADD V1, V0
+	0x82, 0x16, // 0x206: SHR V2, V1
+	0xA3, 0x00, // 0x208: LD I, 0x300
+	0xF1, 0x1E, // 0x20A: ADD I, V1
+	0xF3, 0x15, // 0x20C: LD DT, V3
+	0xF4, 0x07, // 0x20E: LD V4, DT
+	0xE5, 0xA1, // 0x210: SKNP V5
+	0x12, 0x00, // 0x212: JP 0x200
+	0x30, 0x00, // 0x214: SE V0, 0x00
+	0x12, 0x02, // 0x216: JP 0x202
+	0x12, 0x00, // 0x218: JP 0x200
+}
+
+func benchmarkCPU(b *testing.B) *CPU.CPU {
+	cpu := CPU.NewCPU()
+	if err := cpu.LoadROM(benchmarkROM, CPU.START_ADDR); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	return cpu
+}
+
+// BenchmarkExecute steps the way the CPU did before instructions were
+// cached: fetch, decode and switch on every instruction.
+func BenchmarkExecute(b *testing.B) {
+	cpu := benchmarkCPU(b)
+	for i := 0; i < b.N; i++ {
+		op := cpu.GetOpCode()
+		if count, _ := op.Execute(cpu); count {
+			cpu.ProgramCounter += 2
+		}
+	}
+}
+
+func BenchmarkStep(b *testing.B) {
+	cpu := benchmarkCPU(b)
+	for i := 0; i < b.N; i++ {
+		cpu.Step()
+	}
+}
+
+func BenchmarkRunFrame(b *testing.B) {
+	cpu := benchmarkCPU(b)
+	cpu.Quirks = CPU.QuirkPresets["modern"]
+	for i := 0; i < b.N; i++ {
+		cpu.RunFrame(1000)
+	}
+}
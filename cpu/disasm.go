@@ -0,0 +1,195 @@
+package cpu
+
+import "fmt"
+
+// Mnemonic renders the instruction in the widespread Cowgod notation,
+// e.g. "LD V1, 0x0A" or "DRW V0, V1, 5". Words that are not valid
+// instructions come out as raw data: "DW 0x1234".
+func (op OpCode) Mnemonic() string {
+	opCode := op.Decode()
+	nnn := uint16(op) & 0x0FFF
+	nn := uint8(op)
+	x, y, n := opCode.n2, opCode.n3, opCode.n4
+
+	switch opCode.n1 {
+	case 0x0:
+		switch nnn {
+		case 0x0E0:
+			return "CLS"
+		case 0x0EE:
+			return "RET"
+		}
+		return fmt.Sprintf("SYS 0x%03X", nnn)
+	case 0x1:
+		return fmt.Sprintf("JP 0x%03X", nnn)
+	case 0x2:
+		return fmt.Sprintf("CALL 0x%03X", nnn)
+	case 0x3:
+		return fmt.Sprintf("SE V%X, 0x%02X", x, nn)
+	case 0x4:
+		return fmt.Sprintf("SNE V%X, 0x%02X", x, nn)
+	case 0x5:
+		if n == 0x0 {
+			return fmt.Sprintf("SE V%X, V%X", x, y)
+		}
+	case 0x6:
+		return fmt.Sprintf("LD V%X, 0x%02X", x, nn)
+	case 0x7:
+		return fmt.Sprintf("ADD V%X, 0x%02X", x, nn)
+	case 0x8:
+		switch n {
+		case 0x0:
+			return fmt.Sprintf("LD V%X, V%X", x, y)
+		case 0x1:
+			return fmt.Sprintf("OR V%X, V%X", x, y)
+		case 0x2:
+			return fmt.Sprintf("AND V%X, V%X", x, y)
+		case 0x3:
+			return fmt.Sprintf("XOR V%X, V%X", x, y)
+		case 0x4:
+			return fmt.Sprintf("ADD V%X, V%X", x, y)
+		case 0x5:
+			return fmt.Sprintf("SUB V%X, V%X", x, y)
+		case 0x6:
+			return fmt.Sprintf("SHR V%X, V%X", x, y)
+		case 0x7:
+			return fmt.Sprintf("SUBN V%X, V%X", x, y)
+		case 0xE:
+			return fmt.Sprintf("SHL V%X, V%X", x, y)
+		}
+	case 0x9:
+		if n == 0x0 {
+			return fmt.Sprintf("SNE V%X, V%X", x, y)
+		}
+	case 0xA:
+		return fmt.Sprintf("LD I, 0x%03X", nnn)
+	case 0xB:
+		return fmt.Sprintf("JP V0, 0x%03X", nnn)
+	case 0xC:
+		return fmt.Sprintf("RND V%X, 0x%02X", x, nn)
+	case 0xD:
+		return fmt.Sprintf("DRW V%X, V%X, %d", x, y, n)
+	case 0xE:
+		switch nn {
+		case 0x9E:
+			return fmt.Sprintf("SKP V%X", x)
+		case 0xA1:
+			return fmt.Sprintf("SKNP V%X", x)
+		}
+	case 0xF:
+		switch nn {
+		case 0x07:
+			return fmt.Sprintf("LD V%X, DT", x)
+		case 0x0A:
+			return fmt.Sprintf("LD V%X, K", x)
+		case 0x15:
+			return fmt.Sprintf("LD DT, V%X", x)
+		case 0x18:
+			return fmt.Sprintf("LD ST, V%X", x)
+		case 0x1E:
+			return fmt.Sprintf("ADD I, V%X", x)
+		case 0x29:
+			return fmt.Sprintf("LD F, V%X", x)
+		case 0x33:
+			return fmt.Sprintf("LD B, V%X", x)
+		case 0x55:
+			return fmt.Sprintf("LD [I], V%X", x)
+		case 0x65:
+			return fmt.Sprintf("LD V%X, [I]", x)
+		}
+	}
+
+	return fmt.Sprintf("DW 0x%04X", uint16(op))
+}
+
+// Pattern names the kind of instruction in the usual hex notation, e.g.
+// "8XY4" or "DXYN", or "DATA" for words that are not instructions.
+func (op OpCode) Pattern() string {
+	n := uint16(op) & 0xF
+	nn := uint8(op)
+
+	switch uint16(op) >> 12 {
+	case 0x0:
+		switch op {
+		case 0x00E0:
+			return "00E0"
+		case 0x00EE:
+			return "00EE"
+		}
+		return "0NNN"
+	case 0x1:
+		return "1NNN"
+	case 0x2:
+		return "2NNN"
+	case 0x3:
+		return "3XNN"
+	case 0x4:
+		return "4XNN"
+	case 0x5:
+		if n == 0x0 {
+			return "5XY0"
+		}
+	case 0x6:
+		return "6XNN"
+	case 0x7:
+		return "7XNN"
+	case 0x8:
+		if n <= 0x7 || n == 0xE {
+			return fmt.Sprintf("8XY%X", n)
+		}
+	case 0x9:
+		if n == 0x0 {
+			return "9XY0"
+		}
+	case 0xA:
+		return "ANNN"
+	case 0xB:
+		return "BNNN"
+	case 0xC:
+		return "CXNN"
+	case 0xD:
+		return "DXYN"
+	case 0xE:
+		if nn == 0x9E || nn == 0xA1 {
+			return fmt.Sprintf("EX%02X", nn)
+		}
+	case 0xF:
+		switch nn {
+		case 0x07, 0x0A, 0x15, 0x18, 0x1E, 0x29, 0x33, 0x55, 0x65:
+			return fmt.Sprintf("FX%02X", nn)
+		}
+	}
+
+	return "DATA"
+}
+
+// Instruction is a single disassembled word of a program.
+type Instruction struct {
+	Address uint16
+	OpCode  OpCode
+}
+
+func (i Instruction) String() string {
+	return fmt.Sprintf("0x%03X: %04X  %s", i.Address, uint16(i.OpCode), i.OpCode.Mnemonic())
+}
+
+// Disassemble decodes program as if it were loaded at start. CHIP-8 mixes
+// code and sprite data freely, so this is a linear sweep: every aligned
+// word is decoded, and a trailing odd byte is padded with zero.
+func Disassemble(program []byte, start uint16) []Instruction {
+	instructions := make([]Instruction, 0, (len(program)+1)/2)
+
+	for i := 0; i < len(program); i += 2 {
+		code := uint16(program[i]) << 8
+		if i+1 < len(program) {
+			code |= uint16(program[i+1])
+		}
+
+		instructions = append(instructions, Instruction{
+			Address: start + uint16(i),
+			OpCode:  OpCode(code),
+		})
+	}
+
+	return instructions
+}
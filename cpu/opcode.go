@@ -1,10 +1,5 @@
 package cpu
 
-import (
-	"fmt"
-	"math/rand"
-)
-
 type OpCode uint16
 
 // OpCode decoding binary example on high nibble (n2)
@@ -49,7 +44,7 @@ func (op OpCode) Execute(cpu *CPU) (bool, error) {
 			// Return from subroutine
 			cpu.ProgramCounter = cpu.Pop()
 		default:
-			fmt.Printf("Invalid opcode n1: 0x%x, n2: 0x%x, n3: 0x%x, n4: 0x%x\n", opCode.n1, opCode.n2, opCode.n3, opCode.n4)
+			cpu.recordInvalid(cpu.ProgramCounter, op)
 		}
 	case opCode.n1 == 0x1:
 		// Jump to NNN
@@ -99,12 +94,21 @@ func (op OpCode) Execute(cpu *CPU) (bool, error) {
 		case 0x1:
 			// Set VX |= VY
 			cpu.VRegisters[opCode.n2] |= cpu.VRegisters[opCode.n3]
+			if cpu.Quirks.ResetVF {
+				cpu.VRegisters[0xF] = 0
+			}
 		case 0x2:
 			// Set VX &= VY
 			cpu.VRegisters[opCode.n2] &= cpu.VRegisters[opCode.n3]
+			if cpu.Quirks.ResetVF {
+				cpu.VRegisters[0xF] = 0
+			}
 		case 0x3:
 			// Set VX ^= VY
 			cpu.VRegisters[opCode.n2] ^= cpu.VRegisters[opCode.n3]
+			if cpu.Quirks.ResetVF {
+				cpu.VRegisters[0xF] = 0
+			}
 		case 0x4:
 			// VX += VY
 			result, overflowed := OverflowAdd(cpu.VRegisters[opCode.n2], cpu.VRegisters[opCode.n3])
@@ -125,6 +129,9 @@ func (op OpCode) Execute(cpu *CPU) (bool, error) {
 			}
 		case 0x6:
 			// VX >>= 1
+			if cpu.Quirks.ShiftUsesVY {
+				cpu.VRegisters[opCode.n2] = cpu.VRegisters[opCode.n3]
+			}
 			dropedBit := cpu.VRegisters[opCode.n2] & 1
 			cpu.VRegisters[opCode.n2] >>= 1
 			cpu.VRegisters[0xF] = dropedBit
@@ -139,6 +146,9 @@ func (op OpCode) Execute(cpu *CPU) (bool, error) {
 			}
 		case 0xE:
 			// VX <<= 1
+			if cpu.Quirks.ShiftUsesVY {
+				cpu.VRegisters[opCode.n2] = cpu.VRegisters[opCode.n3]
+			}
 			overflowedBit := (cpu.VRegisters[opCode.n2] >> 7) & 1
 			cpu.VRegisters[opCode.n2] <<= 1
 			cpu.VRegisters[0xF] = overflowedBit
@@ -153,48 +163,20 @@ func (op OpCode) Execute(cpu *CPU) (bool, error) {
 		cpu.IndexRegister = uint16(op) & 0x0FFF
 	case opCode.n1 == 0xB:
 		// Jump to V0 + NNN
-		cpu.ProgramCounter = uint16(cpu.VRegisters[0x0]) + uint16(op)&0x0FFF
+		offset := cpu.VRegisters[0x0]
+		if cpu.Quirks.JumpUsesVX {
+			offset = cpu.VRegisters[opCode.n2]
+		}
+		cpu.ProgramCounter = uint16(offset) + uint16(op)&0x0FFF
+
+		count = false
 	case opCode.n1 == 0xC:
 		// VX = random & NN
-		NN := opCode.n3<<3 | opCode.n4
-		cpu.VRegisters[opCode.n2] = byte(rand.Uint32()) & NN
+		NN := opCode.n3<<4 | opCode.n4
+		cpu.VRegisters[opCode.n2] = cpu.rng.next() & NN
 	case opCode.n1 == 0xD:
 		// Draw Sprite
-		x := cpu.VRegisters[opCode.n2]
-		y := cpu.VRegisters[opCode.n3]
-		height := opCode.n4
-
-		var xLine uint16
-		var yLine uint16
-		var collision uint8 = 0
-
-		for yLine = 0; yLine < uint16(height); yLine++ {
-			pixels := cpu.Memory[cpu.IndexRegister+uint16(yLine)]
-
-			for xLine = 0; xLine < 8; xLine++ {
-				// Compute the pixel's position
-				px := (x + uint8(xLine)) % SCREEN_WIDTH
-				py := (y + uint8(yLine)) % SCREEN_HEIGHT
-
-				// Fetch the current pixel value
-				currentPixel := &cpu.Screen[py][px]
-
-				// Check if the bit is set in the sprite data
-				if (pixels & (0b1000_0000 >> xLine)) != 0 {
-					// If pixel is already set, set VF to 1
-					if *currentPixel {
-						collision = 1
-					}
-
-					// Flip the pixel
-					*currentPixel = !*currentPixel
-				}
-			}
-		}
-
-		// Set VF to 1 if there was a collision
-		cpu.VRegisters[0xF] = collision
-		cpu.shouldDraw = true
+		cpu.drawSprite(cpu.VRegisters[opCode.n2], cpu.VRegisters[opCode.n3], opCode.n4)
 
 	case opCode.n1 == 0xE:
 		switch {
@@ -209,7 +191,7 @@ func (op OpCode) Execute(cpu *CPU) (bool, error) {
 				cpu.ProgramCounter += 2
 			}
 		default:
-			fmt.Printf("Invalid opcode n1: 0x%x, n2: 0x%x, n3: 0x%x, n4: 0x%x\n", opCode.n1, opCode.n2, opCode.n3, opCode.n4)
+			cpu.recordInvalid(cpu.ProgramCounter, op)
 		}
 	case opCode.n1 == 0xF:
 		switch {
@@ -251,30 +233,40 @@ func (op OpCode) Execute(cpu *CPU) (bool, error) {
 			// Binary-Coded Decimal of VX stored in RAM
 			VX := cpu.VRegisters[opCode.n2]
 
-			cpu.Memory[cpu.IndexRegister] = VX / 100
-			cpu.Memory[cpu.IndexRegister+1] = (VX / 10) % 10
-			cpu.Memory[cpu.IndexRegister+2] = VX % 10
+			cpu.writeAtIndex([]byte{VX / 100, (VX / 10) % 10, VX % 10})
 
 		case opCode.n3 == 0x5 && opCode.n4 == 0x5:
 			// Store V0 - VX
-			for i := 0; i <= int(opCode.n2); i++ {
-				cpu.Memory[int(cpu.IndexRegister)+i] = cpu.VRegisters[i]
-
+			cpu.writeAtIndex(cpu.VRegisters[:opCode.n2+1])
+			if cpu.Quirks.IncrementIndex {
+				cpu.IndexRegister += uint16(opCode.n2) + 1
 			}
-			cpu.IndexRegister = uint16(opCode.n2) + 1
 		case opCode.n3 == 0x6 && opCode.n4 == 0x5:
 			// Load V0 - VX
-			for i := 0; i <= int(opCode.n2); i++ {
-				cpu.VRegisters[i] = cpu.Memory[int(cpu.IndexRegister)+i]
-
+			cpu.readAtIndex(cpu.VRegisters[:opCode.n2+1])
+			if cpu.Quirks.IncrementIndex {
+				cpu.IndexRegister += uint16(opCode.n2) + 1
 			}
-			cpu.IndexRegister = uint16(opCode.n2) + 1
 		default:
-			fmt.Printf("Invalid opcode n1: 0x%x, n2: 0x%x, n3: 0x%x, n4: 0x%x\n", opCode.n1, opCode.n2, opCode.n3, opCode.n4)
+			cpu.recordInvalid(cpu.ProgramCounter, op)
 		}
 
 	default:
-		fmt.Printf("Invalid opcode n1: 0x%x, n2: 0x%x, n3: 0x%x, n4: 0x%x\n", opCode.n1, opCode.n2, opCode.n3, opCode.n4)
+		cpu.recordInvalid(cpu.ProgramCounter, op)
 	}
 	return count, nil
 }
+
+// drawSprite draws the height rows of the sprite at I at (x, y), flipping
+// pixels, and sets VF on a collision. A sprite running past the end of
+// memory wraps around to its start.
+func (cpu *CPU) drawSprite(x, y, height uint8) {
+	var rows [16]byte
+	sprite := rows[:height]
+	cpu.readAtIndex(sprite)
+	collision := cpu.Screen.Draw(int(x%SCREEN_WIDTH), int(y%SCREEN_HEIGHT), sprite, cpu.Quirks.ClipSprites)
+
+	cpu.VRegisters[0xF] = flag(collision)
+	cpu.shouldDraw = true
+	cpu.waitVBlank = cpu.Quirks.DisplayWait
+}
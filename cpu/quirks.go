@@ -0,0 +1,145 @@
+package cpu
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Quirks selects between the behaviours that differ across CHIP-8
+// implementations. ROMs written for one platform frequently break on the
+// others, so the right profile has to be picked per program.
+type Quirks struct {
+	// 8XY1, 8XY2 and 8XY3 reset VF to 0 (COSMAC VIP).
+	ResetVF bool
+	// FX55 and FX65 leave I pointing past the last register copied.
+	IncrementIndex bool
+	// 8XY6 and 8XYE shift VY into VX instead of shifting VX in place.
+	ShiftUsesVY bool
+	// BNNN jumps to VX + NNN, X being the high nibble of NNN (SUPER-CHIP).
+	JumpUsesVX bool
+	// Sprites are clipped at the screen edges instead of wrapping around.
+	ClipSprites bool
+	// DXYN waits for the next frame before execution continues.
+	DisplayWait bool
+}
+
+const DEFAULT_QUIRKS = "chip8"
+
+var QuirkPresets = map[string]Quirks{
+	// The original COSMAC VIP interpreter.
+	"chip8": {
+		ResetVF:        true,
+		IncrementIndex: true,
+		ShiftUsesVY:    true,
+		ClipSprites:    true,
+		DisplayWait:    true,
+	},
+	// What most modern interpreters do: none of the quirks.
+	"modern": {},
+	// SUPER-CHIP 1.1 as found on the HP48.
+	"schip": {
+		JumpUsesVX:  true,
+		ClipSprites: true,
+	},
+	// Octo's XO-CHIP.
+	"xochip": {
+		IncrementIndex: true,
+		ShiftUsesVY:    true,
+	},
+}
+
+// quirkNames are the names used to toggle single quirks in a spec.
+var quirkNames = []string{"resetvf", "increment", "shiftvy", "jumpvx", "clip", "vblank"}
+
+func (q *Quirks) flag(name string) *bool {
+	switch name {
+	case "resetvf":
+		return &q.ResetVF
+	case "increment":
+		return &q.IncrementIndex
+	case "shiftvy":
+		return &q.ShiftUsesVY
+	case "jumpvx":
+		return &q.JumpUsesVX
+	case "clip":
+		return &q.ClipSprites
+	case "vblank":
+		return &q.DisplayWait
+	}
+	return nil
+}
+
+func LookupQuirks(name string) (Quirks, bool) {
+	quirks, ok := QuirkPresets[name]
+	return quirks, ok
+}
+
+// ParseQuirks reads a quirk spec: a preset name optionally followed by
+// quirks to switch on or off, e.g. "chip8-vblank" or "modern+clip+jumpvx".
+func ParseQuirks(spec string) (Quirks, error) {
+	end := strings.IndexAny(spec, "+-")
+	if end < 0 {
+		end = len(spec)
+	}
+
+	quirks, ok := LookupQuirks(spec[:end])
+	if !ok {
+		return quirks, fmt.Errorf("unknown quirk preset %q (available: %s)", spec[:end], strings.Join(QuirkPresetNames(), ", "))
+	}
+
+	for rest := spec[end:]; rest != ""; {
+		enable := rest[0] == '+'
+		rest = rest[1:]
+
+		next := strings.IndexAny(rest, "+-")
+		if next < 0 {
+			next = len(rest)
+		}
+		name := rest[:next]
+		rest = rest[next:]
+
+		flag := quirks.flag(name)
+		if flag == nil {
+			return quirks, fmt.Errorf("unknown quirk %q (available: %s)", name, strings.Join(quirkNames, ", "))
+		}
+		*flag = enable
+	}
+
+	return quirks, nil
+}
+
+// String returns the shortest spec ParseQuirks turns back into q: the
+// closest preset followed by the quirks that differ from it.
+func (q Quirks) String() string {
+	best := ""
+	for _, name := range QuirkPresetNames() {
+		spec := name
+		preset := QuirkPresets[name]
+		for _, quirk := range quirkNames {
+			switch want := *q.flag(quirk); {
+			case want && !*preset.flag(quirk):
+				spec += "+" + quirk
+			case !want && *preset.flag(quirk):
+				spec += "-" + quirk
+			}
+		}
+
+		if best == "" || strings.Count(spec, "+")+strings.Count(spec, "-") < strings.Count(best, "+")+strings.Count(best, "-") {
+			best = spec
+		}
+	}
+
+	return best
+}
+
+// QuirkPresetNames returns the preset names in a stable order.
+func QuirkPresetNames() []string {
+	names := make([]string, 0, len(QuirkPresets))
+	for name := range QuirkPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,29 @@
+package cpu
+
+// random is a xorshift64* generator for CXNN. Unlike math/rand its whole
+// state is a single word, so it is saved and restored with the CPU.
+type random struct {
+	state uint64
+}
+
+func newRandom(seed int64) random {
+	// splitmix64 spreads small seeds over the state and never yields the
+	// all-zero state xorshift gets stuck in.
+	z := uint64(seed) + 0x9E3779B97F4A7C15
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	z ^= z >> 31
+	if z == 0 {
+		z = 1
+	}
+
+	return random{state: z}
+}
+
+func (r *random) next() uint8 {
+	r.state ^= r.state >> 12
+	r.state ^= r.state << 25
+	r.state ^= r.state >> 27
+
+	return uint8((r.state * 0x2545F4914F6CDD1D) >> 56)
+}
@@ -0,0 +1,411 @@
+package cpu
+
+import "fmt"
+
+// Engine selects how RunFrame executes instructions.
+type Engine int
+
+const (
+	// Decode each instruction once and run it through its handler.
+	ENGINE_INTERPRETER Engine = iota
+	// Compile basic blocks into chains of closures, see block.
+	ENGINE_RECOMPILER
+	// Run the recompiler and check every instruction against
+	// OpCode.Execute, failing RunFrame on the first difference. Slow, for
+	// testing.
+	ENGINE_LOCKSTEP
+)
+
+var engineNames = []string{"interpreter", "recompiler", "lockstep"}
+
+func (e Engine) String() string {
+	if int(e) < len(engineNames) {
+		return engineNames[e]
+	}
+	return fmt.Sprintf("Engine(%d)", int(e))
+}
+
+func ParseEngine(name string) (Engine, error) {
+	for i, engineName := range engineNames {
+		if name == engineName {
+			return Engine(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown engine %q, want interpreter, recompiler or lockstep", name)
+}
+
+// Blocks are cut after this many instructions.
+const MAX_BLOCK_LENGTH = 64
+
+// block is a run of instructions compiled into closures with their
+// operands and the quirks folded in. Only the last instruction of a block
+// can jump, skip, draw, read the keys or the delay timer or write memory;
+// the others leave the program counter to the block.
+type block struct {
+	start uint16
+	// One past the last byte of the block.
+	end uint16
+	ops []func(c *CPU)
+	// Whether the last instruction sets the program counter.
+	terminated bool
+	quirks     Quirks
+}
+
+type blockCache struct {
+	entries [RAM_SIZE]*block
+	// The blocks start in [low, high), so only that needs clearing.
+	low, high int
+}
+
+// runBlock executes the block at PC, or as much of it as budget allows,
+// stopping in front of a breakpoint after the first instruction. It returns
+// the number of instructions executed.
+func (c *CPU) runBlock(budget int) (int, error) {
+	if c.ProgramCounter >= RAM_SIZE-1 {
+		return 0, ErrProgramEnded
+	}
+
+	b := c.blockAtPC()
+	n := min(len(b.ops), budget)
+	cycles := c.Cycles
+
+	if n == len(b.ops) && len(c.breakpoints) == 0 && c.Engine == ENGINE_RECOMPILER {
+		for _, op := range b.ops {
+			op(c)
+		}
+		if !b.terminated {
+			c.ProgramCounter = b.end
+		}
+		c.Cycles = cycles + uint64(n)
+		return n, nil
+	}
+
+	for k := 0; k < n; k++ {
+		address := b.start + uint16(2*k)
+		if k > 0 && len(c.breakpoints) > 0 && c.breakpoints[address] {
+			n = k
+			break
+		}
+
+		if c.Engine == ENGINE_LOCKSTEP {
+			if err := c.lockstepOp(b, k, cycles); err != nil {
+				return k, err
+			}
+			continue
+		}
+		b.ops[k](c)
+	}
+
+	if n < len(b.ops) || !b.terminated {
+		c.ProgramCounter = b.start + uint16(2*n)
+	}
+	c.Cycles = cycles + uint64(n)
+
+	return n, nil
+}
+
+// lockstepOp runs the instruction k of a block on a copy of the CPU with
+// OpCode.Execute, then with the block, and compares the results.
+func (c *CPU) lockstepOp(b *block, k int, cycles uint64) error {
+	address := b.start + uint16(2*k)
+	c.ProgramCounter, c.Cycles = address, cycles+uint64(k)
+
+	reference := *c
+	op := reference.GetOpCode()
+	if count, _ := op.Execute(&reference); count {
+		reference.ProgramCounter += 2
+	}
+	reference.Cycles++
+
+	b.ops[k](c)
+	if k < len(b.ops)-1 || !b.terminated {
+		c.ProgramCounter = address + 2
+	}
+	c.Cycles = cycles + uint64(k+1)
+
+	if difference := c.difference(&reference); difference != "" {
+		return fmt.Errorf("lockstep: the recompiler differs from the interpreter after 0x%03X %s: %s", address, op.Mnemonic(), difference)
+	}
+	return nil
+}
+
+// difference describes the first difference in the machine state of two
+// CPUs, or returns "".
+func (c *CPU) difference(o *CPU) string {
+	switch {
+	case c.ProgramCounter != o.ProgramCounter:
+		return fmt.Sprintf("PC 0x%03X, want 0x%03X", c.ProgramCounter, o.ProgramCounter)
+	case c.VRegisters != o.VRegisters:
+		return fmt.Sprintf("V %X, want %X", c.VRegisters[:], o.VRegisters[:])
+	case c.IndexRegister != o.IndexRegister:
+		return fmt.Sprintf("I 0x%03X, want 0x%03X", c.IndexRegister, o.IndexRegister)
+	case c.StackPointer != o.StackPointer || c.Stack != o.Stack:
+		return fmt.Sprintf("stack %X, want %X", c.Stack[:c.StackPointer], o.Stack[:o.StackPointer])
+	case c.DelayTimer != o.DelayTimer || c.SoundTimer != o.SoundTimer:
+		return fmt.Sprintf("timers %d/%d, want %d/%d", c.DelayTimer, c.SoundTimer, o.DelayTimer, o.SoundTimer)
+	case c.Memory != o.Memory:
+		return "memory"
+	case c.Screen != o.Screen:
+		return "screen"
+	case c.rng != o.rng:
+		return "random state"
+	case c.waitVBlank != o.waitVBlank || c.shouldDraw != o.shouldDraw:
+		return "frame state"
+	case c.Cycles != o.Cycles:
+		return fmt.Sprintf("cycles %d, want %d", c.Cycles, o.Cycles)
+	}
+	return ""
+}
+
+// blockAtPC returns the block starting at PC, compiling it on the first
+// visit or when the quirks changed.
+func (c *CPU) blockAtPC() *block {
+	pc := int(c.ProgramCounter)
+	if c.blocks == nil {
+		c.blocks = &blockCache{}
+	}
+	if b := c.blocks.entries[pc]; b != nil && b.quirks == c.Quirks {
+		return b
+	}
+
+	b := c.compileBlock(c.ProgramCounter)
+	c.blocks.entries[pc] = b
+	if c.blocks.low == c.blocks.high {
+		c.blocks.low, c.blocks.high = pc, pc+1
+	} else {
+		c.blocks.low, c.blocks.high = min(c.blocks.low, pc), max(c.blocks.high, pc+1)
+	}
+	return b
+}
+
+func (c *CPU) compileBlock(start uint16) *block {
+	b := &block{start: start, quirks: c.Quirks}
+
+	address := start
+	for len(b.ops) < MAX_BLOCK_LENGTH && address < RAM_SIZE-1 {
+		op := OpCode(uint16(c.Memory[address])<<8 | uint16(c.Memory[address+1]))
+		fn, ends := compile(op, address, b.quirks)
+		b.ops = append(b.ops, fn)
+		address += 2
+		if ends {
+			b.terminated = true
+			break
+		}
+	}
+	b.end = address
+
+	return b
+}
+
+// invalidateBlocks drops the blocks overlapping memory from start up to
+// end.
+func (c *CPU) invalidateBlocks(start, end int) {
+	if c.blocks == nil {
+		return
+	}
+	for address := max(start-2*MAX_BLOCK_LENGTH, c.blocks.low); address < min(end, c.blocks.high); address++ {
+		if b := c.blocks.entries[address]; b != nil && int(b.end) > start {
+			c.blocks.entries[address] = nil
+		}
+	}
+}
+
+func (c *CPU) clearBlocks() {
+	if c.blocks == nil {
+		return
+	}
+	clear(c.blocks.entries[c.blocks.low:c.blocks.high])
+	c.blocks.low, c.blocks.high = 0, 0
+}
+
+// compile turns the instruction at address into a closure. ends reports
+// whether the instruction ends a block, in which case the closure sets the
+// program counter.
+func compile(op OpCode, address uint16, q Quirks) (fn func(c *CPU), ends bool) {
+	x, y := uint8(op>>8)&0xF, uint8(op>>4)&0xF
+	n, nn, nnn := uint8(op)&0xF, uint8(op), uint16(op)&0x0FFF
+	next, skip := address+2, address+4
+
+	// skipIf makes a conditional skip.
+	skipIf := func(cond func(c *CPU) bool) (func(c *CPU), bool) {
+		return func(c *CPU) {
+			if cond(c) {
+				c.ProgramCounter = skip
+			} else {
+				c.ProgramCounter = next
+			}
+		}, true
+	}
+
+	switch op >> 12 {
+	case 0x0:
+		switch nn {
+		case 0x00:
+			return func(c *CPU) {}, false
+		case 0xE0:
+			return func(c *CPU) { c.ClearScreen() }, false
+		case 0xEE:
+			return func(c *CPU) { c.ProgramCounter = c.Pop() + 2 }, true
+		}
+	case 0x1:
+		return func(c *CPU) { c.ProgramCounter = nnn }, true
+	case 0x2:
+		return func(c *CPU) {
+			c.Push(address)
+			c.ProgramCounter = nnn
+		}, true
+	case 0x3:
+		return skipIf(func(c *CPU) bool { return c.VRegisters[x] == nn })
+	case 0x4:
+		return skipIf(func(c *CPU) bool { return c.VRegisters[x] != nn })
+	case 0x5:
+		if n == 0 {
+			return skipIf(func(c *CPU) bool { return c.VRegisters[x] == c.VRegisters[y] })
+		}
+	case 0x6:
+		return func(c *CPU) { c.VRegisters[x] = nn }, false
+	case 0x7:
+		return func(c *CPU) { c.VRegisters[x] += nn }, false
+	case 0x8:
+		return compileArithmetic(x, y, n, q), false
+	case 0x9:
+		return skipIf(func(c *CPU) bool { return c.VRegisters[x] != c.VRegisters[y] })
+	case 0xA:
+		return func(c *CPU) { c.IndexRegister = nnn }, false
+	case 0xB:
+		offset := uint8(0)
+		if q.JumpUsesVX {
+			offset = x
+		}
+		return func(c *CPU) { c.ProgramCounter = uint16(c.VRegisters[offset]) + nnn }, true
+	case 0xC:
+		return func(c *CPU) { c.VRegisters[x] = c.rng.next() & nn }, false
+	case 0xD:
+		return func(c *CPU) {
+			c.drawSprite(c.VRegisters[x], c.VRegisters[y], n)
+			c.ProgramCounter = next
+		}, true
+	case 0xE:
+		switch nn {
+		case 0x9E:
+			return skipIf(func(c *CPU) bool { return c.Keys[c.VRegisters[x]] })
+		case 0xA1:
+			return skipIf(func(c *CPU) bool { return !c.Keys[c.VRegisters[x]] })
+		}
+	case 0xF:
+		if fn, ends := compileMisc(x, nn, next, q); fn != nil {
+			return fn, ends
+		}
+	}
+
+	// Count and skip it, like the handler.
+	return func(c *CPU) { c.recordInvalid(address, op) }, false
+}
+
+func compileArithmetic(x, y, n uint8, q Quirks) func(c *CPU) {
+	switch n {
+	case 0x0:
+		return func(c *CPU) { c.VRegisters[x] = c.VRegisters[y] }
+	case 0x1, 0x2, 0x3:
+		var logic func(a, b uint8) uint8
+		switch n {
+		case 0x1:
+			logic = func(a, b uint8) uint8 { return a | b }
+		case 0x2:
+			logic = func(a, b uint8) uint8 { return a & b }
+		default:
+			logic = func(a, b uint8) uint8 { return a ^ b }
+		}
+		if q.ResetVF {
+			return func(c *CPU) {
+				c.VRegisters[x] = logic(c.VRegisters[x], c.VRegisters[y])
+				c.VRegisters[0xF] = 0
+			}
+		}
+		return func(c *CPU) { c.VRegisters[x] = logic(c.VRegisters[x], c.VRegisters[y]) }
+	case 0x4:
+		return func(c *CPU) {
+			result, overflowed := OverflowAdd(c.VRegisters[x], c.VRegisters[y])
+			c.VRegisters[x] = result
+			c.VRegisters[0xF] = flag(overflowed)
+		}
+	case 0x5:
+		return func(c *CPU) {
+			result, underflowed := OverflowSub(c.VRegisters[x], c.VRegisters[y])
+			c.VRegisters[x] = result
+			c.VRegisters[0xF] = flag(!underflowed)
+		}
+	case 0x7:
+		return func(c *CPU) {
+			result, underflowed := OverflowSub(c.VRegisters[y], c.VRegisters[x])
+			c.VRegisters[x] = result
+			c.VRegisters[0xF] = flag(!underflowed)
+		}
+	case 0x6, 0xE:
+		source := x
+		if q.ShiftUsesVY {
+			source = y
+		}
+		if n == 0x6 {
+			return func(c *CPU) {
+				value := c.VRegisters[source]
+				c.VRegisters[x] = value >> 1
+				c.VRegisters[0xF] = value & 1
+			}
+		}
+		return func(c *CPU) {
+			value := c.VRegisters[source]
+			c.VRegisters[x] = value << 1
+			c.VRegisters[0xF] = value >> 7
+		}
+	}
+	return func(c *CPU) {}
+}
+
+func compileMisc(x, nn uint8, next uint16, q Quirks) (fn func(c *CPU), ends bool) {
+	increment := uint16(0)
+	if q.IncrementIndex {
+		increment = uint16(x) + 1
+	}
+
+	switch nn {
+	case 0x07:
+		return func(c *CPU) {
+			c.VRegisters[x] = c.DelayTimer
+			c.ProgramCounter = next
+		}, true
+	case 0x0A:
+		d := decoded{x: x}
+		return func(c *CPU) {
+			c.ProgramCounter = next
+			waitKey(c, &d)
+		}, true
+	case 0x15:
+		return func(c *CPU) { c.DelayTimer = c.VRegisters[x] }, false
+	case 0x18:
+		return func(c *CPU) { c.SoundTimer = c.VRegisters[x] }, false
+	case 0x1E:
+		d := decoded{x: x}
+		return func(c *CPU) { addIndex(c, &d) }, false
+	case 0x29:
+		return func(c *CPU) { c.IndexRegister = uint16(c.VRegisters[x]) * 5 }, false
+	case 0x33:
+		d := decoded{x: x}
+		return func(c *CPU) {
+			storeBCD(c, &d)
+			c.ProgramCounter = next
+		}, true
+	case 0x55:
+		return func(c *CPU) {
+			c.writeAtIndex(c.VRegisters[:x+1])
+			c.IndexRegister += increment
+			c.ProgramCounter = next
+		}, true
+	case 0x65:
+		return func(c *CPU) {
+			c.readAtIndex(c.VRegisters[:x+1])
+			c.IndexRegister += increment
+		}, false
+	}
+	return nil, false
+}
@@ -0,0 +1,97 @@
+package cpu_test
+
+import (
+	CPU "chip-8-go/cpu"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLockstep runs the test ROMs on the recompiler, checking every
+// instruction against the interpreter.
+func TestLockstep(t *testing.T) {
+	assert := assert.New(t)
+
+	roms := []string{"1-chip8-logo", "2-ibm-logo", "3-corax+", "4-flags", "5-quirks", "test_opcode", "chip8-test-rom"}
+	for _, name := range roms {
+		rom, err := os.ReadFile("../bin/tests/" + name + ".ch8")
+		if !assert.NoError(err, name) {
+			continue
+		}
+
+		for _, preset := range CPU.QuirkPresetNames() {
+			cpu := CPU.NewCPU()
+			cpu.Engine = CPU.ENGINE_LOCKSTEP
+			cpu.Quirks = CPU.QuirkPresets[preset]
+			assert.NoError(cpu.LoadROM(rom, CPU.START_ADDR), name)
+
+			for frame := 0; frame < 120; frame++ {
+				// Press a key now and then for the ROMs waiting on one.
+				cpu.Keys[1] = frame%20 < 10
+				if _, err := cpu.RunFrame(100); err != nil {
+					assert.NoError(err, "%s with %s quirks, frame %d", name, preset, frame)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestRecompilerMatchesInterpreter(t *testing.T) {
+	assert := assert.New(t)
+
+	rom, err := os.ReadFile("../bin/tests/3-corax+.ch8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(engine CPU.Engine) CPU.State {
+		cpu := CPU.NewCPU()
+		cpu.Engine = engine
+		cpu.Seed(1)
+		assert.NoError(cpu.LoadROM(rom, CPU.START_ADDR))
+		for frame := 0; frame < 60; frame++ {
+			_, err := cpu.RunFrame(37)
+			assert.NoError(err, "frame %d", frame)
+		}
+		return cpu.SaveState()
+	}
+
+	assert.Equal(run(CPU.ENGINE_INTERPRETER), run(CPU.ENGINE_RECOMPILER), "state after 60 frames")
+}
+
+func TestRecompilerSelfModifyingCode(t *testing.T) {
+	assert := assert.New(t)
+
+	cpu := CPU.NewCPU()
+	cpu.Engine = CPU.ENGINE_LOCKSTEP
+	cpu.Quirks = CPU.QuirkPresets["modern"]
+	assert.NoError(cpu.LoadROM([]byte{
+		0xA2, 0x08, // 0x200: LD I, 0x208
+		0x60, 0x61, // 0x202: LD V0, 0x61
+		0x61, 0x07, // 0x204: LD V1, 0x07
+		0xF1, 0x55, // 0x206: LD [I], V1, turning 0x208 into LD V1, 0x07
+		0x60, 0x01, // 0x208: LD V0, 0x01
+		0x12, 0x0A, // 0x20A: JP 0x20A
+	}, CPU.START_ADDR))
+
+	_, err := cpu.RunFrame(10)
+	assert.NoError(err)
+	assert.Equal(uint8(0x07), cpu.VRegisters[1], "FX55 should end the block before the code it rewrites")
+	assert.Equal(uint8(0x61), cpu.VRegisters[0], "the rewritten instruction should not have run")
+
+	cpu.WriteMemory(0x20A, []byte{0x60, 0x05, 0x12, 0x0C})
+	_, err = cpu.RunFrame(10)
+	assert.NoError(err)
+	assert.Equal(uint8(0x05), cpu.VRegisters[0], "WriteMemory should drop the compiled block")
+}
+
+func BenchmarkRecompiler(b *testing.B) {
+	cpu := benchmarkCPU(b)
+	cpu.Quirks = CPU.QuirkPresets["modern"]
+	cpu.Engine = CPU.ENGINE_RECOMPILER
+	for i := 0; i < b.N; i++ {
+		cpu.RunFrame(1000)
+	}
+}
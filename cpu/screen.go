@@ -0,0 +1,104 @@
+package cpu
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"math/bits"
+)
+
+// Framebuffer is the display packed one bit per pixel, a uint64 per row
+// with the leftmost pixel in the most significant bit. Sprites are drawn a
+// row at a time: collisions are an AND with the row, drawing an XOR.
+//
+// Only the 64x32 CHIP-8 display fits this layout. SUPER-CHIP's 128x64
+// hires mode (00FF) is not emulated and is out of scope here; it would
+// need two uint64 per row.
+type Framebuffer [SCREEN_HEIGHT]uint64
+
+// Pixel reports whether the pixel at (x, y) is on.
+func (f *Framebuffer) Pixel(x, y int) bool {
+	return f[y]&(1<<(SCREEN_WIDTH-1-x)) != 0
+}
+
+// Set turns the pixel at (x, y) on or off.
+func (f *Framebuffer) Set(x, y int, on bool) {
+	mask := uint64(1) << (SCREEN_WIDTH - 1 - x)
+	if on {
+		f[y] |= mask
+	} else {
+		f[y] &^= mask
+	}
+}
+
+// Row returns row y unpacked, e.g. for renderers.
+func (f *Framebuffer) Row(y int) [SCREEN_WIDTH]bool {
+	var row [SCREEN_WIDTH]bool
+	for x := range row {
+		row[x] = f.Pixel(x, y)
+	}
+	return row
+}
+
+func (f *Framebuffer) Clear() {
+	*f = Framebuffer{}
+}
+
+// Count returns the number of pixels that are on.
+func (f *Framebuffer) Count() int {
+	count := 0
+	for _, row := range f {
+		count += bits.OnesCount64(row)
+	}
+	return count
+}
+
+// Pack returns the framebuffer as bytes, row by row, most significant bit
+// first.
+func (f *Framebuffer) Pack() [SCREEN_BYTES]byte {
+	var packed [SCREEN_BYTES]byte
+	for y, row := range f {
+		binary.BigEndian.PutUint64(packed[y*8:], row)
+	}
+	return packed
+}
+
+// Hash returns the hex SHA-1 of the framebuffer as packed by Pack.
+func (f *Framebuffer) Hash() string {
+	packed := f.Pack()
+	sum := sha1.Sum(packed[:])
+	return hex.EncodeToString(sum[:])
+}
+
+// Unpack sets the framebuffer from bytes in the format of Pack.
+func (f *Framebuffer) Unpack(packed []byte) {
+	for y := range f {
+		f[y] = binary.BigEndian.Uint64(packed[y*8:])
+	}
+}
+
+// Draw XORs the sprite rows onto the framebuffer at (x, y), which must be
+// on the screen. Pixels past the edges wrap around, or are dropped when
+// clip is set. It reports whether any pixel was turned off.
+func (f *Framebuffer) Draw(x, y int, sprite []byte, clip bool) bool {
+	var collision uint64
+	for i, line := range sprite {
+		py := y + i
+		if py >= SCREEN_HEIGHT {
+			if clip {
+				break
+			}
+			py -= SCREEN_HEIGHT
+		}
+
+		var row uint64
+		if clip {
+			row = uint64(line) << (SCREEN_WIDTH - 8) >> x
+		} else {
+			row = bits.RotateLeft64(uint64(line)<<(SCREEN_WIDTH-8), -x)
+		}
+		collision |= f[py] & row
+		f[py] ^= row
+	}
+	return collision != 0
+}
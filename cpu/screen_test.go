@@ -0,0 +1,165 @@
+package cpu_test
+
+import (
+	CPU "chip-8-go/cpu"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFramebufferDraw(t *testing.T) {
+	assert := assert.New(t)
+
+	var screen CPU.Framebuffer
+	assert.False(screen.Draw(60, 30, []byte{0xFF, 0x81, 0x81}, false), "drawing on a blank screen")
+	assert.True(screen.Pixel(63, 30), "the sprite should start at x")
+	assert.True(screen.Pixel(3, 30), "pixels past the right edge should wrap")
+	assert.True(screen.Pixel(60, 0), "rows past the bottom edge should wrap")
+	assert.False(screen.Pixel(61, 31), "unset sprite bits should leave pixels alone")
+	assert.Equal(12, screen.Count(), "pixels on")
+
+	assert.True(screen.Draw(3, 0, []byte{0x80}, false), "turning a pixel off should collide")
+	assert.False(screen.Pixel(3, 0), "drawing should flip pixels")
+
+	screen.Clear()
+	screen.Draw(60, 30, []byte{0xFF, 0x81, 0x81}, true)
+	assert.Equal(5, screen.Count(), "clipping should drop the pixels past the edges")
+
+	screen.Set(5, 7, true)
+	packed := screen.Pack()
+	var unpacked CPU.Framebuffer
+	unpacked.Unpack(packed[:])
+	assert.Equal(screen, unpacked, "Unpack should reverse Pack")
+	assert.Equal(byte(0x04), packed[7*8], "pixels should be packed most significant bit first")
+}
+
+// drawROM draws a 15 row sprite at moving positions, wrapping around the
+// edges.
+var drawROM = []byte{
+	0xA2, 0x10, // 0x200: LD I, 0x210
+	0xD0, 0x1F, // 0x202: DRW V0, V1, 15
+	0x70, 0x03, // 0x204: ADD V0, 0x03
+	0x71, 0x05, // 0x206: ADD V1, 0x05
+	0x12, 0x02, // 0x208: JP 0x202
+	0x00, 0x00,
+	0x00, 0x00,
+	0x00, 0x00,
+	0xF0, 0x90, 0x90, 0x90, 0xF0, 0x3C, 0x42, 0x81, 0x81, 0x42, 0x3C, 0xFF, 0x00, 0xFF, 0x00, // 0x210
+}
+
+func BenchmarkDraw(b *testing.B) {
+	cpu := CPU.NewCPU()
+	cpu.Quirks = CPU.QuirkPresets["modern"]
+	if err := cpu.LoadROM(drawROM, CPU.START_ADDR); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cpu.Step()
+	}
+}
+
+func BenchmarkPackedScreen(b *testing.B) {
+	cpu := CPU.NewCPU()
+	cpu.Quirks = CPU.QuirkPresets["modern"]
+	if err := cpu.LoadROM(drawROM, CPU.START_ADDR); err != nil {
+		b.Fatal(err)
+	}
+	cpu.RunFrame(100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cpu.PackedScreen()
+	}
+}
+
+// boolScreen is the screen as it was before the Framebuffer, a bool per
+// pixel, kept to compare against.
+type boolScreen [CPU.SCREEN_HEIGHT][CPU.SCREEN_WIDTH]bool
+
+// draw flips the sprite's pixels one at a time, the way DXYN did.
+func (s *boolScreen) draw(x, y int, sprite []byte, clip bool) bool {
+	collision := false
+	for yLine, pixels := range sprite {
+		for xLine := 0; xLine < 8; xLine++ {
+			px := (x + xLine) % CPU.SCREEN_WIDTH
+			py := (y + yLine) % CPU.SCREEN_HEIGHT
+			if clip && (px < x || py < y) {
+				continue
+			}
+			if pixels&(0b1000_0000>>xLine) != 0 {
+				if s[py][px] {
+					collision = true
+				}
+				s[py][px] = !s[py][px]
+			}
+		}
+	}
+	return collision
+}
+
+func (s *boolScreen) pack() [CPU.SCREEN_BYTES]byte {
+	var packed [CPU.SCREEN_BYTES]byte
+	for y, row := range s {
+		for x, pixel := range row {
+			if pixel {
+				i := y*CPU.SCREEN_WIDTH + x
+				packed[i/8] |= 0x80 >> (i % 8)
+			}
+		}
+	}
+	return packed
+}
+
+var benchSprite = drawROM[0x10 : 0x10+15]
+
+func TestFramebufferMatchesBoolScreen(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, clip := range []bool{false, true} {
+		var screen CPU.Framebuffer
+		var reference boolScreen
+		for i := 0; i < 200; i++ {
+			x, y := i*3%CPU.SCREEN_WIDTH, i*5%CPU.SCREEN_HEIGHT
+			assert.Equal(reference.draw(x, y, benchSprite, clip), screen.Draw(x, y, benchSprite, clip), "collision at (%d, %d), clip %v", x, y, clip)
+		}
+		assert.Equal(reference.pack(), screen.Pack(), "screens after drawing, clip %v", clip)
+	}
+}
+
+// BenchmarkSprite compares drawing a 15 row sprite on the packed
+// framebuffer with the bool per pixel screen it replaced.
+func BenchmarkSprite(b *testing.B) {
+	b.Run("packed", func(b *testing.B) {
+		var screen CPU.Framebuffer
+		for i := 0; i < b.N; i++ {
+			screen.Draw(i*3%CPU.SCREEN_WIDTH, i*5%CPU.SCREEN_HEIGHT, benchSprite, false)
+		}
+	})
+	b.Run("bool", func(b *testing.B) {
+		var screen boolScreen
+		for i := 0; i < b.N; i++ {
+			screen.draw(i*3%CPU.SCREEN_WIDTH, i*5%CPU.SCREEN_HEIGHT, benchSprite, false)
+		}
+	})
+}
+
+// BenchmarkPack compares packing the screen for a state or a hash.
+func BenchmarkPack(b *testing.B) {
+	var screen CPU.Framebuffer
+	var reference boolScreen
+	for i := 0; i < 100; i++ {
+		screen.Draw(i*3%CPU.SCREEN_WIDTH, i*5%CPU.SCREEN_HEIGHT, benchSprite, false)
+		reference.draw(i*3%CPU.SCREEN_WIDTH, i*5%CPU.SCREEN_HEIGHT, benchSprite, false)
+	}
+
+	b.Run("packed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			screen.Pack()
+		}
+	})
+	b.Run("bool", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			reference.pack()
+		}
+	})
+}
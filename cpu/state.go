@@ -0,0 +1,112 @@
+package cpu
+
+import (
+	"errors"
+	"fmt"
+)
+
+// State is a snapshot of everything a CPU needs to carry on exactly where
+// it was taken, including the random number generator. It marshals to
+// JSON for save files and the network.
+type State struct {
+	Memory         []byte             `json:"memory"`
+	ProgramCounter uint16             `json:"pc"`
+	StackPointer   uint16             `json:"sp"`
+	Stack          [STACK_SIZE]uint16 `json:"stack"`
+	// The framebuffer as returned by PackedScreen.
+	Screen        []byte          `json:"screen"`
+	VRegisters    [NUM_REGS]uint8 `json:"v"`
+	IndexRegister uint16          `json:"i"`
+	Keys          [NUM_KEYS]bool  `json:"keys"`
+	DelayTimer    uint8           `json:"dt"`
+	SoundTimer    uint8           `json:"st"`
+	Quirks        string          `json:"quirks"`
+	Cycles        uint64          `json:"cycles"`
+	Random        uint64          `json:"random"`
+	WaitVBlank    bool            `json:"wait_vblank,omitempty"`
+}
+
+const SCREEN_BYTES = SCREEN_WIDTH * SCREEN_HEIGHT / 8
+
+func (c *CPU) SaveState() State {
+	state := State{
+		Memory:         make([]byte, RAM_SIZE),
+		ProgramCounter: c.ProgramCounter,
+		StackPointer:   c.StackPointer,
+		Stack:          c.Stack,
+		Screen:         make([]byte, SCREEN_BYTES),
+		VRegisters:     c.VRegisters,
+		IndexRegister:  c.IndexRegister,
+		Keys:           c.Keys,
+		DelayTimer:     c.DelayTimer,
+		SoundTimer:     c.SoundTimer,
+		Quirks:         c.Quirks.String(),
+		Cycles:         c.Cycles,
+		Random:         c.rng.state,
+		WaitVBlank:     c.waitVBlank,
+	}
+	copy(state.Memory, c.Memory[:])
+	screen := c.PackedScreen()
+	copy(state.Screen, screen[:])
+
+	return state
+}
+
+// PackedScreen returns the framebuffer one bit per pixel, row by row, most
+// significant bit first.
+func (c *CPU) PackedScreen() [SCREEN_BYTES]byte {
+	return c.Screen.Pack()
+}
+
+// LoadState restores a snapshot taken with SaveState. The CPU is left
+// untouched if the snapshot is malformed.
+func (c *CPU) LoadState(state State) error {
+	if len(state.Memory) != RAM_SIZE {
+		return fmt.Errorf("invalid state: %d bytes of memory, want %d", len(state.Memory), RAM_SIZE)
+	}
+	if len(state.Screen) != SCREEN_BYTES {
+		return fmt.Errorf("invalid state: %d bytes of screen, want %d", len(state.Screen), SCREEN_BYTES)
+	}
+	if state.StackPointer > STACK_SIZE {
+		return fmt.Errorf("invalid state: stack pointer %d", state.StackPointer)
+	}
+	// An instruction is two bytes, both must be in memory.
+	if state.ProgramCounter >= RAM_SIZE-1 {
+		return fmt.Errorf("invalid state: pc 0x%X outside memory", state.ProgramCounter)
+	}
+	if state.IndexRegister >= RAM_SIZE {
+		return fmt.Errorf("invalid state: i 0x%X outside memory", state.IndexRegister)
+	}
+	for _, address := range state.Stack {
+		if address >= RAM_SIZE {
+			return fmt.Errorf("invalid state: return address 0x%X outside memory", address)
+		}
+	}
+	if state.Random == 0 {
+		return errors.New("invalid state: random state is zero")
+	}
+	quirks, err := ParseQuirks(state.Quirks)
+	if err != nil {
+		return fmt.Errorf("invalid state: %w", err)
+	}
+
+	copy(c.Memory[:], state.Memory)
+	c.InvalidateCache()
+	c.ProgramCounter = state.ProgramCounter
+	c.StackPointer = state.StackPointer
+	c.Stack = state.Stack
+	c.VRegisters = state.VRegisters
+	c.IndexRegister = state.IndexRegister
+	c.Keys = state.Keys
+	c.DelayTimer = state.DelayTimer
+	c.SoundTimer = state.SoundTimer
+	c.Quirks = quirks
+	c.Cycles = state.Cycles
+	c.rng.state = state.Random
+	c.waitVBlank = state.WaitVBlank
+
+	c.Screen.Unpack(state.Screen)
+	c.shouldDraw = true
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package cpu
+
+// Registers is a snapshot of the registers, for tracing.
+type Registers struct {
+	V  [NUM_REGS]uint8 `json:"v"`
+	I  uint16          `json:"i"`
+	SP uint16          `json:"sp"`
+	DT uint8           `json:"dt"`
+	ST uint8           `json:"st"`
+}
+
+// TraceEntry describes one executed instruction.
+type TraceEntry struct {
+	Cycle  uint64
+	PC     uint16
+	OpCode OpCode
+	Before Registers
+	After  Registers
+}
+
+// A Tracer sees every instruction the CPU executes, whether through Step,
+// Tick or RunFrame. Without one the CPU does no tracing work at all.
+type Tracer interface {
+	// TraceInstruction is called after each instruction. The entry is
+	// only valid during the call.
+	TraceInstruction(entry *TraceEntry)
+	// TraceFrame is called at the end of every RunFrame.
+	TraceFrame()
+}
+
+// Written returns the memory the instruction wrote, from start up to end,
+// which is empty unless it was an FX33 or FX55.
+func (e *TraceEntry) Written() (start, end int) {
+	start = int(e.Before.I)
+	switch e.OpCode & 0xF0FF {
+	case 0xF033:
+		end = start + 3
+	case 0xF055:
+		end = start + int(e.OpCode>>8&0xF) + 1
+	default:
+		return 0, 0
+	}
+	return start, min(end, RAM_SIZE)
+}
+
+type multiTracer []Tracer
+
+// MultiTracer makes a Tracer passing everything on to all the tracers.
+func MultiTracer(tracers ...Tracer) Tracer {
+	return multiTracer(tracers)
+}
+
+func (m multiTracer) TraceInstruction(entry *TraceEntry) {
+	for _, t := range m {
+		t.TraceInstruction(entry)
+	}
+}
+
+func (m multiTracer) TraceFrame() {
+	for _, t := range m {
+		t.TraceFrame()
+	}
+}
+
+func (c *CPU) registers() Registers {
+	return Registers{
+		V:  c.VRegisters,
+		I:  c.IndexRegister,
+		SP: c.StackPointer,
+		DT: c.DelayTimer,
+		ST: c.SoundTimer,
+	}
+}
+
+func (c *CPU) traceStep() error {
+	if c.ProgramCounter >= RAM_SIZE-1 {
+		return ErrProgramEnded
+	}
+
+	entry := TraceEntry{
+		Cycle:  c.Cycles,
+		PC:     c.ProgramCounter,
+		OpCode: c.GetOpCode(),
+		Before: c.registers(),
+	}
+	err := c.step()
+	entry.After = c.registers()
+	c.Tracer.TraceInstruction(&entry)
+
+	return err
+}
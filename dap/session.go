@@ -0,0 +1,908 @@
+// Package dap implements the Debug Adapter Protocol
+// (https://microsoft.github.io/debug-adapter-protocol/) so IDEs like VS
+// Code can debug ROMs, and Octo sources assembled on launch, running in
+// the emulator. The machine shows up as a single thread whose stack frames
+// come from the CPU's call stack.
+package dap
+
+import (
+	"bufio"
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"chip-8-go/octo"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The one thread.
+const THREAD_ID = 1
+
+// MAX_STEP_INSTRUCTIONS bounds a line step, which would otherwise never
+// end on a line that waits, e.g. for a key.
+const MAX_STEP_INSTRUCTIONS = 10000
+
+// Variable references of the scopes.
+const (
+	registersReference = 1 + iota
+	stackReference
+	memoryReference
+)
+
+const MEMORY_ROW_SIZE = 16
+
+// A Launcher starts the emulator for a launch request with rom loaded. The
+// emulator must be paused when it is returned; done receives Run's result.
+type Launcher func(args LaunchArguments, rom []byte) (c8 *emulator.Chip8, done <-chan error, err error)
+
+// step is a line step or step out running until the CPU returns to a
+// call site, where a temporary breakpoint stops it.
+type step struct {
+	target uint16
+	// The stack depth after returning.
+	depth uint16
+	// Continue stepping the line once back, for step over.
+	line   int
+	byLine bool
+}
+
+type Session struct {
+	r      *bufio.Reader
+	w      writer
+	launch Launcher
+
+	c8   *emulator.Chip8
+	done <-chan error
+	// The assembled source, nil when debugging a ROM.
+	program    *octo.Program
+	sourcePath string
+	noDebug    bool
+	entry      bool
+
+	linesStartAt1 bool
+	// Breakpoints by source line, kept until the program is launched.
+	lineBreakpoints        []int
+	sourceBreakpoints      map[uint16]bool
+	instructionBreakpoints map[uint16]bool
+	step                   *step
+	// Unknown instructions already told to the client.
+	invalidOpCodes uint64
+
+	stops chan struct{}
+}
+
+func NewSession(r io.Reader, w io.Writer, launch Launcher) *Session {
+	return &Session{
+		r:                      bufio.NewReader(r),
+		w:                      writer{w: w},
+		launch:                 launch,
+		linesStartAt1:          true,
+		sourceBreakpoints:      make(map[uint16]bool),
+		instructionBreakpoints: make(map[uint16]bool),
+		stops:                  make(chan struct{}, 1),
+	}
+}
+
+var errDisconnect = errors.New("disconnect")
+
+// Serve handles requests until the client disconnects or the input ends.
+// The emulator is stopped before it returns.
+func (s *Session) Serve() error {
+	requests := make(chan request)
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			body, err := readMessage(s.r)
+			if err != nil {
+				readErr <- err
+				return
+			}
+			var req request
+			if jsonErr := json.Unmarshal(body, &req); jsonErr != nil {
+				readErr <- jsonErr
+				return
+			}
+			requests <- req
+		}
+	}()
+
+	defer s.terminate()
+
+	for {
+		select {
+		case req := <-requests:
+			err := s.handle(req)
+			if errors.Is(err, errDisconnect) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+		case err := <-readErr:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+
+		case <-s.stops:
+			if err := s.stopped(); err != nil {
+				return err
+			}
+
+		case runErr := <-s.done:
+			s.done = nil
+			if err := s.exited(runErr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// terminate stops the emulator and waits for it to finish.
+func (s *Session) terminate() {
+	if s.c8 == nil || s.done == nil {
+		return
+	}
+	s.c8.Stop()
+	<-s.done
+	s.done = nil
+}
+
+func (s *Session) exited(runErr error) error {
+	if err := s.reportInvalid(); err != nil {
+		return err
+	}
+	code := 0
+	if runErr != nil {
+		code = 1
+		if err := s.w.event("output", outputEvent{Category: "stderr", Output: runErr.Error() + "\n"}); err != nil {
+			return err
+		}
+	}
+	if err := s.w.event("exited", map[string]int{"exitCode": code}); err != nil {
+		return err
+	}
+	return s.w.event("terminated", nil)
+}
+
+func (s *Session) handle(req request) error {
+	if s.c8 == nil {
+		switch req.Command {
+		case "initialize", "launch", "setBreakpoints", "setExceptionBreakpoints", "disconnect":
+		default:
+			return s.w.fail(req, "not launched")
+		}
+	}
+
+	switch req.Command {
+	case "initialize":
+		return s.initialize(req)
+	case "launch":
+		return s.launchProgram(req)
+	case "setBreakpoints":
+		return s.setBreakpoints(req)
+	case "setInstructionBreakpoints":
+		return s.setInstructionBreakpoints(req)
+	case "setExceptionBreakpoints":
+		return s.w.respond(req, map[string]any{"breakpoints": []breakpoint{}})
+	case "configurationDone":
+		return s.configurationDone(req)
+	case "threads":
+		return s.w.respond(req, map[string]any{"threads": []thread{{ID: THREAD_ID, Name: "CHIP-8"}}})
+	case "stackTrace":
+		return s.stackTrace(req)
+	case "scopes":
+		return s.w.respond(req, map[string]any{"scopes": []scope{
+			{Name: "Registers", VariablesReference: registersReference},
+			{Name: "Stack", VariablesReference: stackReference},
+			{Name: "Memory", VariablesReference: memoryReference, Expensive: true},
+		}})
+	case "variables":
+		return s.variables(req)
+	case "setVariable":
+		return s.setVariable(req)
+	case "evaluate":
+		return s.evaluate(req)
+	case "readMemory":
+		return s.readMemory(req)
+	case "writeMemory":
+		return s.writeMemory(req)
+	case "continue":
+		s.step = nil
+		s.syncBreakpoints()
+		s.resume()
+		return s.w.respond(req, map[string]bool{"allThreadsContinued": true})
+	case "pause":
+		s.c8.Pause()
+		s.step = nil
+		s.syncBreakpoints()
+		if err := s.w.respond(req, nil); err != nil {
+			return err
+		}
+		return s.stop("pause")
+	case "next", "stepIn", "stepOut":
+		return s.stepRequest(req)
+	case "terminate":
+		if err := s.w.respond(req, nil); err != nil {
+			return err
+		}
+		s.terminate()
+		return s.w.event("terminated", nil)
+	case "disconnect":
+		s.terminate()
+		if err := s.w.respond(req, nil); err != nil {
+			return err
+		}
+		return errDisconnect
+	}
+
+	return s.w.fail(req, fmt.Sprintf("unsupported request %q", req.Command))
+}
+
+func (s *Session) initialize(req request) error {
+	var args initializeArguments
+	if err := json.Unmarshal(req.Arguments, &args); err == nil && args.LinesStartAt1 != nil {
+		s.linesStartAt1 = *args.LinesStartAt1
+	}
+
+	return s.w.respond(req, capabilities{
+		SupportsConfigurationDoneRequest: true,
+		SupportsSetVariable:              true,
+		SupportsReadMemoryRequest:        true,
+		SupportsWriteMemoryRequest:       true,
+		SupportsInstructionBreakpoints:   true,
+		SupportsSteppingGranularity:      true,
+		SupportsEvaluateForHovers:        true,
+		SupportsTerminateRequest:         true,
+	})
+}
+
+func (s *Session) launchProgram(req request) error {
+	if s.c8 != nil {
+		return s.w.fail(req, "already launched")
+	}
+
+	var args LaunchArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return s.w.fail(req, err.Error())
+	}
+	if args.Program == "" {
+		return s.w.fail(req, "no program given")
+	}
+
+	rom, err := s.load(args.Program)
+	if err != nil {
+		return s.w.fail(req, err.Error())
+	}
+
+	c8, done, err := s.launch(args, rom)
+	if err != nil {
+		return s.w.fail(req, err.Error())
+	}
+	s.c8, s.done = c8, done
+	s.noDebug, s.entry = args.NoDebug, args.StopOnEntry
+	c8.AddBreakpointHook(func(c *cpu.CPU) {
+		select {
+		case s.stops <- struct{}{}:
+		default:
+		}
+	})
+	s.resolveLineBreakpoints()
+
+	if err := s.w.respond(req, nil); err != nil {
+		return err
+	}
+	return s.w.event("initialized", nil)
+}
+
+// load assembles an Octo source, or reads a ROM.
+func (s *Session) load(path string) ([]byte, error) {
+	if !strings.EqualFold(filepath.Ext(path), ".8o") {
+		return emulator.ReadROMFile(path)
+	}
+
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	program, err := octo.Assemble(path, text)
+	if err != nil {
+		return nil, err
+	}
+
+	s.program, s.sourcePath = program, path
+	return program.ROM, nil
+}
+
+func (s *Session) configurationDone(req request) error {
+	if err := s.w.respond(req, nil); err != nil {
+		return err
+	}
+
+	s.syncBreakpoints()
+	if s.entry && !s.noDebug {
+		return s.stop("entry")
+	}
+	s.resume()
+	return nil
+}
+
+func (s *Session) resume() {
+	select {
+	case <-s.stops:
+	default:
+	}
+	s.c8.Resume()
+}
+
+func (s *Session) stop(reason string, hit ...int) error {
+	if err := s.reportInvalid(); err != nil {
+		return err
+	}
+	return s.w.event("stopped", stoppedEvent{Reason: reason, ThreadID: THREAD_ID, AllThreadsStopped: true, HitBreakpointIDs: hit})
+}
+
+// reportInvalid tells the client, in an output event, about the unknown
+// instructions the CPU skipped since the last report. The client owns
+// stdout, so the emulator must not print them.
+func (s *Session) reportInvalid() error {
+	if s.c8 == nil {
+		return nil
+	}
+	var count uint64
+	var last cpu.InvalidOpCode
+	s.c8.Sync(func(c *cpu.CPU) {
+		count, last = c.InvalidOpCodes, c.LastInvalidOpCode
+	})
+	if count < s.invalidOpCodes {
+		// The CPU was reset.
+		s.invalidOpCodes = 0
+	}
+	if count == s.invalidOpCodes {
+		return nil
+	}
+
+	skipped := count - s.invalidOpCodes
+	s.invalidOpCodes = count
+	return s.w.event("output", outputEvent{Category: "stderr", Output: fmt.Sprintf("skipped %d unknown instructions, the last %s\n", skipped, last)})
+}
+
+// stopped handles the CPU stopping at a breakpoint.
+func (s *Session) stopped() error {
+	var pc, sp uint16
+	s.c8.Sync(func(c *cpu.CPU) {
+		pc, sp = c.ProgramCounter, c.StackPointer
+	})
+	user := s.sourceBreakpoints[pc] || s.instructionBreakpoints[pc]
+
+	if st := s.step; st != nil {
+		if pc == st.target && sp <= st.depth {
+			s.step = nil
+			s.syncBreakpoints()
+			if st.byLine {
+				return s.stepLine(st.line, true, true)
+			}
+			return s.stop("step")
+		}
+		if !user {
+			// The return address of a deeper, recursive call.
+			s.resume()
+			return nil
+		}
+		s.step = nil
+		s.syncBreakpoints()
+	}
+
+	return s.stop("breakpoint", int(pc))
+}
+
+func (s *Session) sameSource(path string) bool {
+	if s.sourcePath == "" {
+		return false
+	}
+	a, errA := filepath.Abs(path)
+	b, errB := filepath.Abs(s.sourcePath)
+	return errA == nil && errB == nil && filepath.Clean(a) == filepath.Clean(b)
+}
+
+func (s *Session) clientLine(line int) int {
+	if s.linesStartAt1 {
+		return line
+	}
+	return line - 1
+}
+
+func (s *Session) sourceLine(line int) int {
+	if s.linesStartAt1 {
+		return line
+	}
+	return line + 1
+}
+
+func (s *Session) setBreakpoints(req request) error {
+	var args setBreakpointsArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return s.w.fail(req, err.Error())
+	}
+
+	lines := make([]int, len(args.Breakpoints))
+	for i, bp := range args.Breakpoints {
+		lines[i] = s.sourceLine(bp.Line)
+	}
+
+	// Before launch the source is not assembled yet: keep the lines.
+	if s.c8 == nil {
+		s.lineBreakpoints = lines
+		s.sourcePath = args.Source.Path
+		breakpoints := make([]breakpoint, len(lines))
+		for i, line := range lines {
+			breakpoints[i] = breakpoint{Line: s.clientLine(line), Message: "not launched yet"}
+		}
+		return s.w.respond(req, map[string]any{"breakpoints": breakpoints})
+	}
+
+	if !s.sameSource(args.Source.Path) || s.program == nil {
+		breakpoints := make([]breakpoint, len(lines))
+		for i, line := range lines {
+			breakpoints[i] = breakpoint{Line: s.clientLine(line), Message: "not part of the program being debugged"}
+		}
+		return s.w.respond(req, map[string]any{"breakpoints": breakpoints})
+	}
+
+	s.lineBreakpoints = lines
+	breakpoints := s.resolveLineBreakpoints()
+	s.syncBreakpoints()
+	return s.w.respond(req, map[string]any{"breakpoints": breakpoints})
+}
+
+// resolveLineBreakpoints moves the line breakpoints to the instructions
+// they stand for.
+func (s *Session) resolveLineBreakpoints() []breakpoint {
+	s.sourceBreakpoints = make(map[uint16]bool)
+	if s.program == nil {
+		return nil
+	}
+
+	src := &source{Name: filepath.Base(s.sourcePath), Path: s.sourcePath}
+	var breakpoints []breakpoint
+	for _, line := range s.lineBreakpoints {
+		address, actual, ok := s.program.Address(line)
+		if !ok {
+			breakpoints = append(breakpoints, breakpoint{Line: s.clientLine(line), Message: "no code on or after this line"})
+			continue
+		}
+		s.sourceBreakpoints[address] = true
+		breakpoints = append(breakpoints, breakpoint{
+			ID:                   int(address),
+			Verified:             true,
+			Source:               src,
+			Line:                 s.clientLine(actual),
+			InstructionReference: formatAddress(address),
+		})
+	}
+
+	return breakpoints
+}
+
+func (s *Session) setInstructionBreakpoints(req request) error {
+	var args setInstructionBreakpointsArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return s.w.fail(req, err.Error())
+	}
+
+	s.instructionBreakpoints = make(map[uint16]bool)
+	breakpoints := make([]breakpoint, len(args.Breakpoints))
+	for i, bp := range args.Breakpoints {
+		address, err := parseAddress(bp.InstructionReference)
+		address += bp.Offset
+		if err != nil || address < 0 || address >= cpu.RAM_SIZE {
+			breakpoints[i] = breakpoint{Message: "invalid address"}
+			continue
+		}
+		s.instructionBreakpoints[uint16(address)] = true
+		breakpoints[i] = breakpoint{ID: address, Verified: true, InstructionReference: formatAddress(uint16(address))}
+	}
+
+	s.syncBreakpoints()
+	return s.w.respond(req, map[string]any{"breakpoints": breakpoints})
+}
+
+// syncBreakpoints sets the CPU's breakpoints to the user's plus the one a
+// running step waits for.
+func (s *Session) syncBreakpoints() {
+	if s.c8 == nil {
+		return
+	}
+
+	s.c8.Sync(func(c *cpu.CPU) {
+		c.ClearBreakpoints()
+		if !s.noDebug {
+			for address := range s.sourceBreakpoints {
+				c.SetBreakpoint(address)
+			}
+			for address := range s.instructionBreakpoints {
+				c.SetBreakpoint(address)
+			}
+		}
+		if s.step != nil {
+			c.SetBreakpoint(s.step.target)
+		}
+	})
+}
+
+func formatAddress(address uint16) string {
+	return fmt.Sprintf("0x%03X", address)
+}
+
+func parseAddress(text string) (int, error) {
+	n, err := strconv.ParseUint(text, 0, 16)
+	return int(n), err
+}
+
+// line is the source line of the instruction at address, or 0.
+func (s *Session) line(address uint16) int {
+	if s.program == nil {
+		return 0
+	}
+	line, _ := s.program.Line(address)
+	return line
+}
+
+// frameName names a frame after the nearest label before address.
+func (s *Session) frameName(address uint16) string {
+	if s.program == nil {
+		return formatAddress(address)
+	}
+
+	best, found := "", false
+	var bestAddress uint16
+	for name, labelAddress := range s.program.Labels {
+		if labelAddress > address || (found && labelAddress < bestAddress) {
+			continue
+		}
+		if found && labelAddress == bestAddress && name > best {
+			continue
+		}
+		best, bestAddress, found = name, labelAddress, true
+	}
+	if !found {
+		return formatAddress(address)
+	}
+	if bestAddress == address {
+		return best
+	}
+	return fmt.Sprintf("%s+%d", best, address-bestAddress)
+}
+
+func (s *Session) stackTrace(req request) error {
+	var pc uint16
+	var stack []uint16
+	s.c8.Sync(func(c *cpu.CPU) {
+		pc = c.ProgramCounter
+		stack = append(stack, c.Stack[:min(c.StackPointer, cpu.STACK_SIZE)]...)
+	})
+
+	// The current instruction, then the call sites from the innermost.
+	addresses := []uint16{pc}
+	for i := len(stack) - 1; i >= 0; i-- {
+		addresses = append(addresses, stack[i])
+	}
+
+	frames := make([]stackFrame, len(addresses))
+	for i, address := range addresses {
+		frames[i] = stackFrame{
+			ID:                          i + 1,
+			Name:                        s.frameName(address),
+			Column:                      1,
+			InstructionPointerReference: formatAddress(address),
+		}
+		if line := s.line(address); line > 0 {
+			frames[i].Source = &source{Name: filepath.Base(s.sourcePath), Path: s.sourcePath}
+			frames[i].Line = s.clientLine(line)
+		}
+	}
+
+	return s.w.respond(req, map[string]any{"stackFrames": frames, "totalFrames": len(frames)})
+}
+
+func (s *Session) variables(req request) error {
+	var args variablesArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return s.w.fail(req, err.Error())
+	}
+
+	var variables []variable
+	s.c8.Sync(func(c *cpu.CPU) {
+		switch args.VariablesReference {
+		case registersReference:
+			variables = registerVariables(c)
+		case stackReference:
+			for i := 0; i < int(c.StackPointer) && i < cpu.STACK_SIZE; i++ {
+				variables = append(variables, variable{
+					Name:  strconv.Itoa(i),
+					Value: s.frameName(c.Stack[i]),
+				})
+			}
+		case memoryReference:
+			for address := 0; address < cpu.RAM_SIZE; address += MEMORY_ROW_SIZE {
+				row := c.Memory[address : address+MEMORY_ROW_SIZE]
+				variables = append(variables, variable{
+					Name:            formatAddress(uint16(address)),
+					Value:           fmt.Sprintf("% x", row),
+					MemoryReference: formatAddress(uint16(address)),
+				})
+			}
+		}
+	})
+	if variables == nil {
+		variables = []variable{}
+	}
+
+	return s.w.respond(req, map[string]any{"variables": variables})
+}
+
+func registerVariables(c *cpu.CPU) []variable {
+	var variables []variable
+	for i, value := range c.VRegisters {
+		variables = append(variables, variable{Name: fmt.Sprintf("V%X", i), Value: formatByte(value)})
+	}
+
+	return append(variables,
+		variable{Name: "I", Value: formatAddress(c.IndexRegister), MemoryReference: formatAddress(c.IndexRegister)},
+		variable{Name: "PC", Value: formatAddress(c.ProgramCounter), MemoryReference: formatAddress(c.ProgramCounter)},
+		variable{Name: "SP", Value: strconv.Itoa(int(c.StackPointer))},
+		variable{Name: "DT", Value: formatByte(c.DelayTimer)},
+		variable{Name: "ST", Value: formatByte(c.SoundTimer)},
+	)
+}
+
+func formatByte(value uint8) string {
+	return fmt.Sprintf("0x%02X (%d)", value, value)
+}
+
+// register returns a pointer to the register called name, and its limit.
+func register(c *cpu.CPU, name string) (*uint8, *uint16, int) {
+	name = strings.ToUpper(name)
+	switch name {
+	case "I":
+		return nil, &c.IndexRegister, 0xFFFF
+	case "PC":
+		return nil, &c.ProgramCounter, cpu.RAM_SIZE - 2
+	case "SP":
+		return nil, &c.StackPointer, cpu.STACK_SIZE
+	case "DT":
+		return &c.DelayTimer, nil, 0xFF
+	case "ST":
+		return &c.SoundTimer, nil, 0xFF
+	}
+	if len(name) == 2 && name[0] == 'V' {
+		if n, err := strconv.ParseUint(name[1:], 16, 8); err == nil {
+			return &c.VRegisters[n], nil, 0xFF
+		}
+	}
+
+	return nil, nil, 0
+}
+
+func (s *Session) setVariable(req request) error {
+	var args setVariableArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return s.w.fail(req, err.Error())
+	}
+	if args.VariablesReference != registersReference {
+		return s.w.fail(req, "only registers can be changed")
+	}
+
+	value, err := strconv.ParseInt(strings.Fields(args.Value + " ")[0], 0, 32)
+	if err != nil {
+		return s.w.fail(req, fmt.Sprintf("invalid value %q", args.Value))
+	}
+
+	var result variable
+	var setErr string
+	s.c8.Sync(func(c *cpu.CPU) {
+		byteRegister, wordRegister, limit := register(c, args.Name)
+		switch {
+		case byteRegister == nil && wordRegister == nil:
+			setErr = fmt.Sprintf("unknown register %q", args.Name)
+			return
+		case value < 0 || int(value) > limit:
+			setErr = fmt.Sprintf("%d out of range for %s", value, args.Name)
+			return
+		case byteRegister != nil:
+			*byteRegister = uint8(value)
+		default:
+			*wordRegister = uint16(value)
+		}
+		for _, v := range registerVariables(c) {
+			if strings.EqualFold(v.Name, args.Name) {
+				result = v
+			}
+		}
+	})
+	if setErr != "" {
+		return s.w.fail(req, setErr)
+	}
+
+	return s.w.respond(req, map[string]any{"value": result.Value, "memoryReference": result.MemoryReference})
+}
+
+// evaluate knows registers and labels, as in hovers and the watch list.
+func (s *Session) evaluate(req request) error {
+	var args evaluateArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return s.w.fail(req, err.Error())
+	}
+	expression := strings.TrimSpace(args.Expression)
+
+	var result *variable
+	s.c8.Sync(func(c *cpu.CPU) {
+		for _, v := range registerVariables(c) {
+			if strings.EqualFold(v.Name, expression) {
+				result = &v
+				return
+			}
+		}
+	})
+	if result == nil && s.program != nil {
+		if address, ok := s.program.Labels[expression]; ok {
+			result = &variable{Value: formatAddress(address), MemoryReference: formatAddress(address)}
+		}
+	}
+	if result == nil {
+		return s.w.fail(req, fmt.Sprintf("unknown register or label %q", expression))
+	}
+
+	return s.w.respond(req, map[string]any{"result": result.Value, "variablesReference": 0, "memoryReference": result.MemoryReference})
+}
+
+// memoryRange checks a memory request and returns its start address.
+func memoryRange(reference string, offset int) (int, error) {
+	base, err := parseAddress(reference)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory reference %q", reference)
+	}
+	return base + offset, nil
+}
+
+func (s *Session) readMemory(req request) error {
+	var args readMemoryArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return s.w.fail(req, err.Error())
+	}
+	start, err := memoryRange(args.MemoryReference, args.Offset)
+	if err != nil {
+		return s.w.fail(req, err.Error())
+	}
+
+	body := map[string]any{"address": formatAddress(uint16(max(start, 0)))}
+	if start < 0 || start >= cpu.RAM_SIZE || args.Count <= 0 {
+		body["unreadableBytes"] = max(args.Count, 0)
+		return s.w.respond(req, body)
+	}
+
+	end := min(start+args.Count, cpu.RAM_SIZE)
+	var data []byte
+	s.c8.Sync(func(c *cpu.CPU) {
+		data = append(data, c.Memory[start:end]...)
+	})
+	body["data"] = base64.StdEncoding.EncodeToString(data)
+	body["unreadableBytes"] = args.Count - len(data)
+
+	return s.w.respond(req, body)
+}
+
+func (s *Session) writeMemory(req request) error {
+	var args writeMemoryArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return s.w.fail(req, err.Error())
+	}
+	start, err := memoryRange(args.MemoryReference, args.Offset)
+	if err != nil {
+		return s.w.fail(req, err.Error())
+	}
+	data, err := base64.StdEncoding.DecodeString(args.Data)
+	if err != nil {
+		return s.w.fail(req, "invalid data")
+	}
+	if start < 0 || start+len(data) > cpu.RAM_SIZE {
+		return s.w.fail(req, "out of memory range")
+	}
+
+	s.c8.Sync(func(c *cpu.CPU) {
+		c.WriteMemory(uint16(start), data)
+	})
+	return s.w.respond(req, map[string]int{"offset": 0, "bytesWritten": len(data)})
+}
+
+func (s *Session) stepRequest(req request) error {
+	var args steppingArguments
+	json.Unmarshal(req.Arguments, &args)
+
+	if err := s.w.respond(req, nil); err != nil {
+		return err
+	}
+
+	s.c8.Pause()
+	s.step = nil
+	var pc, sp uint16
+	var op cpu.OpCode
+	s.c8.Sync(func(c *cpu.CPU) {
+		pc, sp = c.ProgramCounter, c.StackPointer
+		op = c.GetOpCode()
+	})
+
+	byInstruction := args.Granularity == "instruction" || s.line(pc) == 0
+	switch {
+	case req.Command == "stepOut":
+		if sp == 0 {
+			return s.stepLine(0, false, false)
+		}
+		var callSite uint16
+		s.c8.Sync(func(c *cpu.CPU) {
+			callSite = c.Stack[sp-1]
+		})
+		return s.runTo(&step{target: callSite + 2, depth: sp - 1})
+
+	case req.Command == "next" && byInstruction:
+		if op>>12 == 0x2 {
+			return s.runTo(&step{target: pc + 2, depth: sp})
+		}
+		return s.stepLine(0, false, false)
+
+	case byInstruction:
+		return s.stepLine(0, false, false)
+	}
+
+	return s.stepLine(s.line(pc), req.Command == "next", false)
+}
+
+// runTo lets the CPU run until it returns to a call site.
+func (s *Session) runTo(st *step) error {
+	s.step = st
+	s.syncBreakpoints()
+	s.resume()
+	return nil
+}
+
+// stepLine steps instructions until the CPU reaches another line, or
+// steps one instruction when line is 0. Stepping over runs calls at full
+// speed instead; returned says the CPU just came back from one.
+func (s *Session) stepLine(line int, over bool, returned bool) error {
+	for i := 0; i < MAX_STEP_INSTRUCTIONS; i++ {
+		var pc, sp uint16
+		var op cpu.OpCode
+		s.c8.Sync(func(c *cpu.CPU) {
+			pc, sp = c.ProgramCounter, c.StackPointer
+			op = c.GetOpCode()
+		})
+
+		if i > 0 || returned {
+			if current := s.line(pc); current != 0 && current != line {
+				break
+			}
+			if s.sourceBreakpoints[pc] || s.instructionBreakpoints[pc] {
+				return s.stop("breakpoint", int(pc))
+			}
+		}
+		if over && op>>12 == 0x2 {
+			return s.runTo(&step{target: pc + 2, depth: sp, line: line, byLine: true})
+		}
+
+		if err := s.c8.Step(1); err != nil {
+			return s.w.event("output", outputEvent{Category: "stderr", Output: err.Error() + "\n"})
+		}
+		if line == 0 {
+			break
+		}
+	}
+
+	return s.stop("step")
+}
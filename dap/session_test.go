@@ -0,0 +1,265 @@
+package dap_test
+
+import (
+	"bufio"
+	"chip-8-go/dap"
+	"chip-8-go/emulator"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testSource = `: main
+  v0 := 0
+  loop
+    add-one
+    v1 := v0
+  again
+
+: add-one
+  v0 += 1
+  return
+`
+
+type message struct {
+	Type       string          `json:"type"`
+	Command    string          `json:"command"`
+	Event      string          `json:"event"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Body       json.RawMessage `json:"body"`
+}
+
+type client struct {
+	t        *testing.T
+	w        io.Writer
+	messages chan message
+	seq      int
+}
+
+func headless(args dap.LaunchArguments, rom []byte) (*emulator.Chip8, <-chan error, error) {
+	c8 := emulator.NewChip8(emulator.DefaultOptions(), nil)
+	if err := c8.LoadBytes(rom); err != nil {
+		return nil, nil, err
+	}
+	c8.Pause()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c8.Run()
+	}()
+	return c8, done, nil
+}
+
+func newClient(t *testing.T) *client {
+	requests, requestWriter := io.Pipe()
+	responseReader, responses := io.Pipe()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- dap.NewSession(requests, responses, headless).Serve()
+		responses.Close()
+	}()
+	t.Cleanup(func() {
+		requestWriter.Close()
+		<-served
+	})
+
+	c := &client{t: t, w: requestWriter, messages: make(chan message, 100)}
+	go func() {
+		defer close(c.messages)
+		r := bufio.NewReader(responseReader)
+		for {
+			header, err := textproto.NewReader(r).ReadMIMEHeader()
+			if err != nil {
+				return
+			}
+			length, _ := strconv.Atoi(header.Get("Content-Length"))
+			body := make([]byte, length)
+			if _, readErr := io.ReadFull(r, body); readErr != nil {
+				return
+			}
+			var msg message
+			json.Unmarshal(body, &msg)
+			c.messages <- msg
+		}
+	}()
+
+	return c
+}
+
+func (c *client) send(command string, args any) int {
+	c.seq++
+	body, _ := json.Marshal(map[string]any{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return c.seq
+}
+
+// wait reads messages until the response to seq, or the event named
+// event when seq is 0, and decodes its body into v.
+func (c *client) wait(seq int, event string, v any) message {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg, ok := <-c.messages:
+			if !ok {
+				c.t.Fatal("connection closed")
+			}
+			if (seq != 0 && msg.Type == "response" && msg.RequestSeq == seq) || (seq == 0 && msg.Type == "event" && msg.Event == event) {
+				if v != nil && msg.Body != nil {
+					json.Unmarshal(msg.Body, v)
+				}
+				return msg
+			}
+		case <-timeout:
+			c.t.Fatalf("timed out waiting for %d %s", seq, event)
+		}
+	}
+}
+
+func (c *client) request(command string, args any, v any) message {
+	return c.wait(c.send(command, args), "", v)
+}
+
+type stackTrace struct {
+	StackFrames []struct {
+		Name string `json:"name"`
+		Line int    `json:"line"`
+	} `json:"stackFrames"`
+}
+
+func (c *client) stack() stackTrace {
+	var trace stackTrace
+	c.request("stackTrace", map[string]int{"threadId": dap.THREAD_ID}, &trace)
+	return trace
+}
+
+func (c *client) stopped(reason string) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	c.wait(0, "stopped", &body)
+	assert.Equal(c.t, reason, body.Reason, "stop reason")
+}
+
+func TestSession(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "test.8o")
+	if err := os.WriteFile(path, []byte(testSource), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newClient(t)
+	assert.True(c.request("initialize", map[string]any{"adapterID": "chip-8"}, nil).Success, "initialize")
+	assert.True(c.request("launch", map[string]any{"program": path, "headless": true}, nil).Success, "launch")
+	c.wait(0, "initialized", nil)
+
+	var breakpoints struct {
+		Breakpoints []struct {
+			Verified bool `json:"verified"`
+			Line     int  `json:"line"`
+		} `json:"breakpoints"`
+	}
+	c.request("setBreakpoints", map[string]any{
+		"source":      map[string]string{"path": path},
+		"breakpoints": []map[string]int{{"line": 9}, {"line": 7}},
+	}, &breakpoints)
+	if assert.Len(breakpoints.Breakpoints, 2, "breakpoints") {
+		assert.True(breakpoints.Breakpoints[0].Verified, "breakpoint on an instruction")
+		assert.Equal(9, breakpoints.Breakpoints[0].Line, "breakpoint on an instruction")
+		assert.Equal(9, breakpoints.Breakpoints[1].Line, "a breakpoint on an empty line should move to the next instruction")
+	}
+
+	c.request("configurationDone", nil, nil)
+	c.stopped("breakpoint")
+	trace := c.stack()
+	if assert.Len(trace.StackFrames, 2, "stack frames") {
+		assert.Equal("add-one", trace.StackFrames[0].Name, "current frame")
+		assert.Equal(9, trace.StackFrames[0].Line, "current line")
+		assert.Equal("main+2", trace.StackFrames[1].Name, "caller")
+		assert.Equal(4, trace.StackFrames[1].Line, "line of the call")
+	}
+
+	var variables struct {
+		Variables []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"variables"`
+	}
+	c.request("variables", map[string]int{"variablesReference": 1}, &variables)
+	if assert.NotEmpty(variables.Variables, "registers") {
+		assert.Equal("V0", variables.Variables[0].Name, "first register")
+		assert.Equal("0x00 (0)", variables.Variables[0].Value, "V0 before add-one")
+	}
+
+	c.request("next", map[string]int{"threadId": dap.THREAD_ID}, nil)
+	c.stopped("step")
+	assert.Equal(10, c.stack().StackFrames[0].Line, "line after next")
+
+	c.request("stepOut", map[string]int{"threadId": dap.THREAD_ID}, nil)
+	c.stopped("step")
+	trace = c.stack()
+	assert.Len(trace.StackFrames, 1, "stack frames after step out")
+	assert.Equal(5, trace.StackFrames[0].Line, "line after step out")
+
+	var result struct {
+		Result string `json:"result"`
+	}
+	c.request("evaluate", map[string]string{"expression": "v0"}, &result)
+	assert.Equal("0x01 (1)", result.Result, "V0 after add-one")
+
+	c.request("next", map[string]int{"threadId": dap.THREAD_ID}, nil)
+	c.stopped("step")
+	c.request("next", map[string]int{"threadId": dap.THREAD_ID}, nil)
+	c.stopped("step")
+	assert.Equal(4, c.stack().StackFrames[0].Line, "next should step over the call back in the loop")
+	c.request("next", map[string]int{"threadId": dap.THREAD_ID}, nil)
+	c.stopped("breakpoint")
+
+	c.request("setBreakpoints", map[string]any{"source": map[string]string{"path": path}, "breakpoints": []any{}}, nil)
+	c.request("continue", map[string]int{"threadId": dap.THREAD_ID}, nil)
+	c.request("pause", map[string]int{"threadId": dap.THREAD_ID}, nil)
+	c.stopped("pause")
+
+	assert.True(c.request("disconnect", nil, nil).Success, "disconnect")
+}
+
+func TestInvalidOpCodeOutput(t *testing.T) {
+	assert := assert.New(t)
+
+	// 00E1 is not an instruction, then V0 += 1 and jump back.
+	path := filepath.Join(t.TempDir(), "invalid.ch8")
+	if err := os.WriteFile(path, []byte{0x00, 0xE1, 0x70, 0x01, 0x12, 0x00}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newClient(t)
+	c.request("initialize", map[string]any{"adapterID": "chip-8"}, nil)
+	assert.True(c.request("launch", map[string]any{"program": path, "headless": true}, nil).Success, "launch")
+	c.wait(0, "initialized", nil)
+	c.request("setInstructionBreakpoints", map[string]any{
+		"breakpoints": []map[string]string{{"instructionReference": "0x204"}},
+	}, nil)
+	c.request("configurationDone", nil, nil)
+
+	var output struct {
+		Category string `json:"category"`
+		Output   string `json:"output"`
+	}
+	c.wait(0, "output", &output)
+	assert.Equal("stderr", output.Category, "category")
+	assert.Equal("skipped 1 unknown instructions, the last invalid opcode 00E1 at 0x200\n", output.Output, "output")
+	c.stopped("breakpoint")
+
+	assert.True(c.request("disconnect", nil, nil).Success, "disconnect")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"chip-8-go/dap"
+	"chip-8-go/emulator"
+	"errors"
+	"os"
+)
+
+func dapCommand(args []string) error {
+	fs := newFlagSet("dap")
+	configFlags := addConfigFlags(fs)
+	configFlags.addMachineFlags(fs)
+	configFlags.addDisplayFlags(fs)
+
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	// SDL wants the main thread, so the emulator runs here and the
+	// session is served from another goroutine.
+	launches := make(chan func())
+	launch := func(launchArgs dap.LaunchArguments, rom []byte) (*emulator.Chip8, <-chan error, error) {
+		cfg, _, err := configFlags.resolve(rom)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts := cfg.Options()
+
+		started := make(chan *emulator.Chip8, 1)
+		done := make(chan error, 1)
+		extras := runExtras{debug: func(c8 *emulator.Chip8) {
+			c8.Pause()
+			started <- c8
+		}}
+		launches <- func() {
+			if launchArgs.Headless {
+				done <- runHeadless(rom, cfg, opts, extras)
+			} else {
+				done <- runWindowed(launchArgs.Program, rom, cfg, opts, extras)
+			}
+		}
+
+		select {
+		case c8 := <-started:
+			return c8, done, nil
+		case runErr := <-done:
+			if runErr == nil {
+				runErr = errors.New("the emulator exited before starting")
+			}
+			return nil, nil, runErr
+		}
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- dap.NewSession(os.Stdin, os.Stdout, launch).Serve()
+	}()
+
+	for {
+		select {
+		case run := <-launches:
+			run()
+		case err := <-served:
+			return err
+		}
+	}
+}
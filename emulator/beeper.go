@@ -1,30 +1,29 @@
-package emulator
+//go:build !js
 
-// typedef unsigned char Uint8;
-// void AudioCallback(void *userdata, Uint8 *stream, int len);
-import "C"
+package emulator
 
 import (
-	"math"
-	"time"
-	"unsafe"
+	"chip-8-go/audio"
+	"encoding/binary"
 
 	sdl "github.com/veandco/go-sdl2/sdl"
 )
 
 type Beeper struct {
 	deviceId sdl.AudioDeviceID
+	buffer   []byte
 }
 
 func NewBeeper() (*Beeper, error) {
-	instance := &Beeper{}
+	instance := &Beeper{
+		buffer: make([]byte, audio.SAMPLES_PER_FRAME*2),
+	}
 
 	desiredSpec := sdl.AudioSpec{
-		Freq:     44100,
-		Format:   sdl.AUDIO_S16SYS,
+		Freq:     audio.SAMPLE_RATE,
+		Format:   sdl.AUDIO_S16LSB,
 		Channels: 1,
 		Samples:  2048,
-		Callback: sdl.AudioCallback(C.AudioCallback),
 	}
 	obtainedSpec := sdl.AudioSpec{}
 
@@ -34,28 +33,27 @@ func NewBeeper() (*Beeper, error) {
 	}
 
 	instance.deviceId = deviceId
+	sdl.PauseAudioDevice(deviceId, false)
 
 	return instance, nil
 }
 
-//export AudioCallback
-func AudioCallback(userdata unsafe.Pointer, stream *C.Uint8, length C.int) {
-	n := int(length)
-	buf := unsafe.Slice(stream, n)
-
-	var phase float64
-	for i := 0; i < n; i += 2 {
-		phase += 2 * math.Pi * 440 / 44100
-		sample := C.Uint8((math.Sin(phase) + 0.999999) * 128)
-		buf[i] = sample
-		buf[i+1] = sample
+// WriteSamples queues one frame of samples on the audio device.
+func (b *Beeper) WriteSamples(samples []int16) error {
+	if sdl.GetQueuedAudioSize(b.deviceId) > uint32(len(b.buffer)*maxQueuedFrames) {
+		return nil
 	}
-}
 
-func (b *Beeper) Play() {
-	sdl.PauseAudioDevice(b.deviceId, false)
+	if cap(b.buffer) < len(samples)*2 {
+		b.buffer = make([]byte, len(samples)*2)
+	}
+	buf := b.buffer[:len(samples)*2]
+
+	for i, sample := range samples {
+		binary.LittleEndian.PutUint16(buf[i*2:], uint16(sample))
+	}
 
-	time.AfterFunc(time.Second/10, func() { sdl.PauseAudioDevice(b.deviceId, true) })
+	return sdl.QueueAudio(b.deviceId, buf)
 }
 
 func (b *Beeper) Close() {
@@ -0,0 +1,179 @@
+//go:build js && wasm
+
+package emulator
+
+import (
+	"chip-8-go/cpu"
+	"fmt"
+	"strings"
+	"sync"
+	"syscall/js"
+)
+
+// CanvasFrontend draws into an HTML canvas and reads the keypad from DOM
+// keyboard events. The canvas is drawn at the CHIP-8 resolution, scale it
+// with CSS ("image-rendering: pixelated" keeps the pixels sharp).
+type CanvasFrontend struct {
+	context   js.Value
+	imageData js.Value
+	pixels    []byte
+	palette   Palette
+	keyMap    map[string]uint8
+
+	target    js.Value
+	listeners map[string]js.Func
+
+	mu     sync.Mutex
+	events []canvasKeyEvent
+}
+
+type canvasKeyEvent struct {
+	key     uint8
+	pressed bool
+}
+
+// NewCanvasFrontend draws into canvas and listens for keys on the
+// document. Key bindings use the same SDL names as the desktop frontend
+// and are matched against KeyboardEvent.code, so they follow the key's
+// position rather than the keyboard layout.
+func NewCanvasFrontend(canvas js.Value, palette Palette, keys KeyBindings) (*CanvasFrontend, error) {
+	keyMap := make(map[string]uint8, len(keys))
+	for key := range keys {
+		for _, name := range keys.Names(uint8(key)) {
+			code, ok := domCode(name)
+			if !ok {
+				return nil, fmt.Errorf("unknown key name %q for CHIP-8 key %X", name, key)
+			}
+			keyMap[code] = uint8(key)
+		}
+	}
+
+	canvas.Set("width", cpu.SCREEN_WIDTH)
+	canvas.Set("height", cpu.SCREEN_HEIGHT)
+	context := canvas.Call("getContext", "2d")
+
+	f := &CanvasFrontend{
+		context:   context,
+		imageData: context.Call("createImageData", cpu.SCREEN_WIDTH, cpu.SCREEN_HEIGHT),
+		pixels:    make([]byte, cpu.SCREEN_WIDTH*cpu.SCREEN_HEIGHT*4),
+		palette:   palette,
+		keyMap:    keyMap,
+		target:    js.Global().Get("document"),
+		listeners: make(map[string]js.Func),
+	}
+
+	f.listen("keydown", true)
+	f.listen("keyup", false)
+
+	return f, nil
+}
+
+func (f *CanvasFrontend) listen(eventType string, pressed bool) {
+	listener := js.FuncOf(func(this js.Value, args []js.Value) any {
+		event := args[0]
+		key, ok := f.keyMap[event.Get("code").String()]
+		if !ok {
+			return nil
+		}
+		// Keep the arrows and space from scrolling the page.
+		event.Call("preventDefault")
+		if event.Get("repeat").Bool() {
+			return nil
+		}
+
+		f.mu.Lock()
+		f.events = append(f.events, canvasKeyEvent{key: key, pressed: pressed})
+		f.mu.Unlock()
+
+		return nil
+	})
+
+	f.target.Call("addEventListener", eventType, listener)
+	f.listeners[eventType] = listener
+}
+
+// Close removes the keyboard listeners.
+func (f *CanvasFrontend) Close() {
+	for eventType, listener := range f.listeners {
+		f.target.Call("removeEventListener", eventType, listener)
+		listener.Release()
+	}
+	f.listeners = nil
+}
+
+// PollInput never asks to quit, the page stops the emulator with
+// Chip8.Stop instead.
+func (f *CanvasFrontend) PollInput(c *cpu.CPU) bool {
+	f.mu.Lock()
+	events := f.events
+	f.events = nil
+	f.mu.Unlock()
+
+	for _, event := range events {
+		c.SetKey(event.key, event.pressed)
+	}
+
+	return false
+}
+
+func (f *CanvasFrontend) Draw(c *cpu.CPU) error {
+	bg, fg := f.palette.Background, f.palette.Foreground
+
+	for j := 0; j < len(c.Screen); j++ {
+		for i := 0; i < cpu.SCREEN_WIDTH; i++ {
+			color := bg
+			if c.Screen.Pixel(i, j) {
+				color = fg
+			}
+			offset := (j*cpu.SCREEN_WIDTH + i) * 4
+			f.pixels[offset] = color.R
+			f.pixels[offset+1] = color.G
+			f.pixels[offset+2] = color.B
+			f.pixels[offset+3] = 0xFF
+		}
+	}
+
+	js.CopyBytesToJS(f.imageData.Get("data"), f.pixels)
+	f.context.Call("putImageData", f.imageData, 0, 0)
+
+	return nil
+}
+
+var domCodes = map[string]string{
+	"up":          "ArrowUp",
+	"down":        "ArrowDown",
+	"left":        "ArrowLeft",
+	"right":       "ArrowRight",
+	"space":       "Space",
+	"return":      "Enter",
+	"backspace":   "Backspace",
+	"tab":         "Tab",
+	"left shift":  "ShiftLeft",
+	"right shift": "ShiftRight",
+	"left ctrl":   "ControlLeft",
+	"right ctrl":  "ControlRight",
+}
+
+// domCode translates an SDL key name ("Q", "1", "Up", "Keypad 8") into a
+// KeyboardEvent.code ("KeyQ", "Digit1", "ArrowUp", "Numpad8").
+func domCode(name string) (string, bool) {
+	lower := strings.ToLower(name)
+	if code, ok := domCodes[lower]; ok {
+		return code, true
+	}
+
+	if digit, ok := strings.CutPrefix(lower, "keypad "); ok && len(digit) == 1 && digit[0] >= '0' && digit[0] <= '9' {
+		return "Numpad" + digit, true
+	}
+
+	if len(lower) == 1 {
+		switch c := lower[0]; {
+		case c >= 'a' && c <= 'z':
+			return "Key" + strings.ToUpper(lower), true
+		case c >= '0' && c <= '9':
+			return "Digit" + lower, true
+		}
+	}
+
+	return "", false
+}
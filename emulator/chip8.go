@@ -1,37 +1,133 @@
 package emulator
 
 import (
+	"chip-8-go/audio"
 	"chip-8-go/cpu"
+	"errors"
 	"fmt"
-	"os"
-
-	sdl "github.com/veandco/go-sdl2/sdl"
+	"io"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const DEFAULT_FRAME_RATE = audio.FRAME_RATE
+
+// Fast forward runs this many times the frame rate by default.
+const DEFAULT_FAST_FORWARD = 4
+
+// CONTROL_SLOW_MOTION steps through these speeds and back to normal.
+var SLOW_MOTION_SPEEDS = []float64{0.5, 0.25}
+
+// Audio outputs keep at most this many frames queued so a slow host does
+// not make the sound lag further and further behind the picture.
+const maxQueuedFrames = 4
+
+// Options configures how a Chip8 runs a program.
+type Options struct {
+	// Instructions executed per frame.
+	IPF int
+	// Frames shown per second when running with a frontend. The timers
+	// tick once per frame, so anything but 60 speeds the game up or down.
+	FrameRate int
+	Quirks    cpu.Quirks
+	// Seed for CXNN, so that runs can be reproduced.
+	Seed int64
+	// Address the ROM is loaded at and execution starts from.
+	LoadAddress uint16
+	// Stop after this many frames; 0 runs until the user quits.
+	MaxFrames int
+	// Sees every instruction when set, e.g. a trace.Tracer.
+	Tracer cpu.Tracer
+	Engine cpu.Engine
+	// Times the frame rate to run at while fast forwarding.
+	FastForward int
+}
+
+func DefaultOptions() Options {
+	return Options{
+		IPF:         cpu.DEFAULT_IPF,
+		FrameRate:   DEFAULT_FRAME_RATE,
+		Quirks:      cpu.QuirkPresets[cpu.DEFAULT_QUIRKS],
+		Seed:        time.Now().UnixNano(),
+		LoadAddress: cpu.START_ADDR,
+		FastForward: DEFAULT_FAST_FORWARD,
+	}
+}
+
 type Chip8 struct {
-	beeper *Beeper
-	cpu    *cpu.CPU
-	fqHz   uint32
+	buzzer   audio.Generator
+	sinks    []audio.Sink
+	recorder *audio.Recorder
+	cpu      *cpu.CPU
+
+	frontend      Frontend
+	frameDuration time.Duration
+
+	opts        Options
+	ipf         int
+	maxFrames   int
+	frames      int
+	loadAddress uint16
+	rom         []byte
+	// The file the ROM was loaded from, read again by HardReset.
+	fileName string
 
-	renderer      *sdl.Renderer
-	scaleModifier int32
+	// Held while a frame runs, so that other goroutines can safely
+	// inspect and change the machine between frames, see Sync.
+	mu         sync.Mutex
+	frameHooks []func(c *cpu.CPU)
+	breakHooks []func(c *cpu.CPU)
+	paused     bool
+	redraw     bool
+	stopped    atomic.Bool
+
+	// Speed without fast forward, and the frames left to run while paused.
+	speed       float64
+	fastForward bool
+	advance     int
 }
 
-func InitChip8(fileName string, scaleModifier int32, renderer *sdl.Renderer) (*Chip8, error) {
-	beeper, err := NewBeeper()
-	if err != nil {
-		return nil, err
+// NewChip8 creates an emulator with an empty program memory, load a ROM
+// with one of the Load methods before running it. A nil frontend runs the
+// emulator headless.
+func NewChip8(opts Options, frontend Frontend) *Chip8 {
+	if opts.FrameRate <= 0 {
+		opts.FrameRate = DEFAULT_FRAME_RATE
+	}
+	if opts.FastForward <= 0 {
+		opts.FastForward = DEFAULT_FAST_FORWARD
 	}
 
+	return &Chip8{
+		buzzer:        audio.NewBuzzer(),
+		cpu:           newCPU(opts),
+		frontend:      frontend,
+		frameDuration: time.Second / time.Duration(opts.FrameRate),
+		opts:          opts,
+		ipf:           opts.IPF,
+		maxFrames:     opts.MaxFrames,
+		loadAddress:   opts.LoadAddress,
+		speed:         1,
+	}
+}
+
+func newCPU(opts Options) *cpu.CPU {
 	cpu := cpu.NewCPU()
+	cpu.Quirks = opts.Quirks
+	cpu.Seed(opts.Seed)
+	cpu.ProgramCounter = opts.LoadAddress
+	cpu.Tracer = opts.Tracer
+	cpu.Engine = opts.Engine
+
+	return cpu
+}
+
+// InitChip8 creates an emulator and loads fileName into it.
+func InitChip8(fileName string, opts Options, frontend Frontend) (*Chip8, error) {
+	c8 := NewChip8(opts, frontend)
 
-	c8 := &Chip8{
-		beeper:        beeper,
-		cpu:           cpu,
-		fqHz:          1000 / 60, // 60Hz
-		scaleModifier: scaleModifier,
-		renderer:      renderer,
-	}
 	loadErr := c8.LoadProgram(fileName)
 	if loadErr != nil {
 		return nil, loadErr
@@ -41,132 +137,482 @@ func InitChip8(fileName string, scaleModifier int32, renderer *sdl.Renderer) (*C
 
 }
 
-func (c *Chip8) Run() error {
-	for {
-		draw, beep, err := c.cpu.Tick()
-		if err != nil {
+// CPU returns the machine's CPU. Use Sync to access it while Run is going,
+// the CPU is replaced by Reset.
+func (c *Chip8) CPU() *cpu.CPU {
+	return c.cpu
+}
+
+// Frames returns the number of frames emulated so far.
+func (c *Chip8) Frames() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.frames
+}
+
+// AddAudioSink registers an output for the samples generated each frame,
+// typically a Beeper.
+func (c *Chip8) AddAudioSink(sink audio.Sink) {
+	c.sinks = append(c.sinks, sink)
+}
+
+// AddFrameHook registers fn to be called with the CPU at the end of every
+// frame, also while paused. Hooks run on the emulation goroutine with the
+// machine locked, so they have to be quick.
+func (c *Chip8) AddFrameHook(fn func(c *cpu.CPU)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.frameHooks = append(c.frameHooks, fn)
+}
+
+// AddBreakpointHook registers fn to be called, like a frame hook, when Run
+// pauses because the CPU hit a breakpoint.
+func (c *Chip8) AddBreakpointHook(fn func(c *cpu.CPU)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.breakHooks = append(c.breakHooks, fn)
+}
+
+// Stop makes Run return after the current frame. It is safe to call from
+// another goroutine, e.g. a signal handler.
+func (c *Chip8) Stop() {
+	c.stopped.Store(true)
+}
+
+// Pause stops emulation until Resume. Run keeps drawing and polling input
+// while paused, and Step still executes instructions. Run also pauses by
+// itself when the CPU hits a breakpoint.
+func (c *Chip8) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.paused = true
+}
+
+// Resume continues emulation, starting with the instruction at PC even if
+// Run paused on a breakpoint there.
+func (c *Chip8) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.paused = false
+	c.cpu.SkipBreakpoint()
+}
+
+func (c *Chip8) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.paused
+}
+
+// Advance runs frames frames, timers and sound included, and pauses.
+func (c *Chip8) Advance(frames int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.paused = true
+	c.advance += frames
+}
+
+// SetSpeed runs frames at speed times the frame rate, e.g. 0.5 for slow
+// motion. Headless emulation is not paced, so it is not slowed down.
+func (c *Chip8) SetSpeed(speed float64) error {
+	if speed <= 0 {
+		return fmt.Errorf("invalid speed %g: must be above 0", speed)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.speed = speed
+	return nil
+}
+
+// SetFastForward switches fast forward on or off. While on, frames run at
+// Options.FastForward times the frame rate, whatever the speed.
+func (c *Chip8) SetFastForward(on bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.fastForward = on
+}
+
+func (c *Chip8) State() RunState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state()
+}
+
+func (c *Chip8) state() RunState {
+	state := RunState{Paused: c.paused, Speed: c.speed, FastForward: c.fastForward}
+	if c.fastForward {
+		state.Speed = float64(c.opts.FastForward)
+	}
+	return state
+}
+
+// Step executes count instructions without ticking the timers, typically
+// while paused.
+func (c *Chip8) Step(count int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.redraw = true
+	for i := 0; i < count; i++ {
+		if err := c.cpu.Step(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reset starts the loaded ROM over on a fresh CPU, like the reset switch.
+// Breakpoints, pause and speed stay as they are.
+func (c *Chip8) Reset() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.reset()
+}
+
+func (c *Chip8) reset() error {
+	opts := c.opts
+	opts.LoadAddress = c.loadAddress
+
+	breakpoints := c.cpu.Breakpoints()
+	c.cpu = newCPU(opts)
+	for _, address := range breakpoints {
+		c.cpu.SetBreakpoint(address)
+	}
+	c.redraw = true
+	if c.rom == nil {
+		return nil
+	}
+
+	return c.cpu.LoadROM(c.rom, c.loadAddress)
+}
+
+// HardReset starts over as if the machine was switched off and on again:
+// the ROM is read again if it was loaded from a file, e.g. after building
+// it anew, on a fresh CPU without breakpoints, running at normal speed.
+func (c *Chip8) HardReset() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.hardReset()
+}
+
+func (c *Chip8) hardReset() error {
+	rom := c.rom
+	if c.fileName != "" {
+		var err error
+		if rom, err = ReadROMFile(c.fileName); err != nil {
+			return err
+		}
+	}
+
+	opts := c.opts
+	opts.LoadAddress = c.loadAddress
+	machine := newCPU(opts)
+	if rom != nil {
+		if err := machine.LoadROM(rom, c.loadAddress); err != nil {
 			return err
 		}
+	}
+
+	c.cpu, c.rom = machine, rom
+	c.buzzer = audio.NewBuzzer()
+	c.paused, c.advance, c.speed, c.fastForward = false, 0, 1, false
+	c.redraw = true
+	return nil
+}
 
-		if beep {
-			c.Beep()
-		}
-
-		if draw {
-			c.Draw()
-		}
-
-		c.pollKeyPad()
-		sdl.Delay(c.fqHz)
-	}
-}
-
-func (c *Chip8) pollKeyPad() {
-	for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
-		isPressed := isKeyPressed(event)
-		switch et := event.(type) {
-		case *sdl.QuitEvent:
-			os.Exit(0)
-		case *sdl.KeyboardEvent:
-			switch et.Keysym.Sym {
-			case sdl.K_1:
-				c.cpu.SetKey(0x1, isPressed)
-			case sdl.K_2:
-				c.cpu.SetKey(0x2, isPressed)
-			case sdl.K_3:
-				c.cpu.SetKey(0x3, isPressed)
-			case sdl.K_4:
-				c.cpu.SetKey(0xC, isPressed)
-			case sdl.K_q:
-				c.cpu.SetKey(0x4, isPressed)
-			case sdl.K_w:
-				c.cpu.SetKey(0x5, isPressed)
-			case sdl.K_e:
-				c.cpu.SetKey(0x6, isPressed)
-			case sdl.K_r:
-				c.cpu.SetKey(0xD, isPressed)
-			case sdl.K_a:
-				c.cpu.SetKey(0x7, isPressed)
-			case sdl.K_s:
-				c.cpu.SetKey(0x8, isPressed)
-			case sdl.K_d:
-				c.cpu.SetKey(0x9, isPressed)
-			case sdl.K_f:
-				c.cpu.SetKey(0xE, isPressed)
-			case sdl.K_z:
-				c.cpu.SetKey(0xA, isPressed)
-			case sdl.K_x:
-				c.cpu.SetKey(0x0, isPressed)
-			case sdl.K_c:
-				c.cpu.SetKey(0xB, isPressed)
-			case sdl.K_v:
-				c.cpu.SetKey(0xF, isPressed)
+// control applies a control from the frontend.
+func (c *Chip8) control(control Control) error {
+	switch control {
+	case CONTROL_PAUSE:
+		c.paused = !c.paused
+		if !c.paused {
+			c.cpu.SkipBreakpoint()
+		}
+	case CONTROL_ADVANCE:
+		c.paused = true
+		c.advance++
+	case CONTROL_FAST_FORWARD:
+		c.fastForward = true
+	case CONTROL_FAST_FORWARD_END:
+		c.fastForward = false
+	case CONTROL_SLOW_MOTION:
+		next := 1.0
+		for i, speed := range SLOW_MOTION_SPEEDS {
+			if c.speed > speed {
+				next = speed
+				break
+			}
+			if c.speed == speed && i+1 < len(SLOW_MOTION_SPEEDS) {
+				next = SLOW_MOTION_SPEEDS[i+1]
+				break
 			}
 		}
+		c.speed = next
+	case CONTROL_RESET:
+		return c.reset()
+	case CONTROL_HARD_RESET:
+		return c.hardReset()
 	}
+
+	return nil
+}
+
+// Sync calls fn with the CPU between two frames. It is the way for other
+// goroutines to inspect or change the machine while Run is going.
+func (c *Chip8) Sync(fn func(c *cpu.CPU)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fn(c.cpu)
+	c.redraw = true
 }
 
-func (c *Chip8) Draw() {
-	c.renderer.SetDrawColor(0, 0, 0, 255)
-	c.renderer.Clear()
+func (c *Chip8) SaveState() cpu.State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.cpu.SaveState()
+}
+
+func (c *Chip8) LoadState(state cpu.State) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.redraw = true
+	return c.cpu.LoadState(state)
+}
+
+// Run emulates frames until the user quits, Stop is called, the program
+// runs off the end of memory or the frame limit is reached. With a
+// frontend, or while paused, frames are paced to the frame rate times the
+// speed, see RunState.
+func (c *Chip8) Run() error {
+	defer c.StopRecording()
+
+	nextFrame := time.Now()
+	for !c.stopped.Load() && (c.maxFrames == 0 || c.frames < c.maxFrames) {
+		quit, state, err := c.frame()
+		if err != nil || quit {
+			return err
+		}
+
+		if c.frontend == nil && !state.Paused {
+			continue
+		}
+
+		// Input is still polled at the frame rate while paused.
+		duration := c.frameDuration
+		if !state.Paused {
+			duration = time.Duration(float64(duration) / state.Speed)
+		}
+		nextFrame = nextFrame.Add(duration)
+		if wait := time.Until(nextFrame); wait > 0 {
+			time.Sleep(wait)
+		} else {
+			nextFrame = time.Now()
+		}
+	}
+
+	return nil
+}
+
+// frame runs one frame, unless paused, and presents it. The state is the
+// one after the controls of the frontend, if it is a Controller.
+func (c *Chip8) frame() (quit bool, state RunState, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	draw := c.redraw
+	c.redraw = false
+
+	if !c.paused || c.advance > 0 {
+		if c.paused {
+			c.advance--
+		}
+		drawn, err := c.cpu.RunFrame(c.ipf)
+		if errors.Is(err, cpu.ErrProgramEnded) {
+			return true, c.state(), nil
+		}
+		if err != nil {
+			return false, c.state(), err
+		}
+		draw = draw || drawn
+		c.frames++
+		if c.cpu.BreakpointHit {
+			c.paused, c.advance = true, 0
+			for _, hook := range c.breakHooks {
+				hook(c.cpu)
+			}
+		}
+
+		if audioErr := c.playAudio(); audioErr != nil {
+			return false, c.state(), audioErr
+		}
+	}
+
+	for _, hook := range c.frameHooks {
+		hook(c.cpu)
+	}
+
+	if c.frontend == nil {
+		return false, c.state(), nil
+	}
+
+	if draw {
+		if drawErr := c.frontend.Draw(c.cpu); drawErr != nil {
+			return false, c.state(), drawErr
+		}
+	}
 
-	for j := 0; j < len(c.cpu.Screen); j++ {
-		for i := 0; i < len(c.cpu.Screen[j]); i++ {
-			if c.cpu.Screen[j][i] {
-				c.renderer.SetDrawColor(0, 255, 0, 255)
-			} else {
-				c.renderer.SetDrawColor(0, 0, 0, 255)
+	if c.frontend.PollInput(c.cpu) {
+		return true, c.state(), nil
+	}
+	if controller, ok := c.frontend.(Controller); ok {
+		for _, control := range controller.Controls(c.state()) {
+			if controlErr := c.control(control); controlErr != nil {
+				return false, c.state(), controlErr
 			}
-			c.renderer.FillRect(
-				&sdl.Rect{
-					Y: int32(j) * c.scaleModifier,
-					X: int32(i) * c.scaleModifier,
-					W: c.scaleModifier,
-					H: c.scaleModifier,
-				},
-			)
 		}
 	}
 
-	c.renderer.Present()
+	return false, c.state(), nil
 }
 
-func (c *Chip8) Beep() {
-	c.beeper.Play()
+// playAudio generates the samples for the frame that just ran and hands
+// them to the audio sinks and, if one is active, the WAV recorder.
+func (c *Chip8) playAudio() error {
+	samples := c.buzzer.Frame(c.cpu.SoundTimer > 0)
+
+	for _, sink := range c.sinks {
+		if err := sink.WriteSamples(samples); err != nil {
+			return err
+		}
+	}
+
+	if c.recorder != nil {
+		if err := c.recorder.WriteSamples(samples); err != nil {
+			return fmt.Errorf("record audio: %w", err)
+		}
+	}
+
+	return nil
 }
 
-func isKeyPressed(event sdl.Event) bool {
-	var isPressed bool
+// RecordAudio starts mirroring everything the emulator plays into a WAV
+// file. Any recording already in progress is finished first.
+func (c *Chip8) RecordAudio(fileName string) error {
+	if err := c.StopRecording(); err != nil {
+		return err
+	}
 
-	if event.GetType() == sdl.KEYDOWN {
-		isPressed = true
-	} else {
-		isPressed = false
+	recorder, err := audio.CreateRecorder(fileName)
+	if err != nil {
+		return err
 	}
+	c.recorder = recorder
 
-	return isPressed
+	return nil
 }
 
+func (c *Chip8) StopRecording() error {
+	if c.recorder == nil {
+		return nil
+	}
+
+	err := c.recorder.Close()
+	c.recorder = nil
+
+	return err
+}
+
+// LoadProgram loads a ROM file, or a ROM inside a zip archive, at the
+// configured load address. HardReset reads it again.
 func (c *Chip8) LoadProgram(fileName string) error {
-	file, fileErr := os.OpenFile(fileName, os.O_RDONLY, 0777)
-	if fileErr != nil {
-		return fileErr
+	rom, err := ReadROMFile(fileName)
+	if err != nil {
+		return err
 	}
-	defer file.Close()
 
-	stat, statErr := file.Stat()
-	if statErr != nil {
-		return statErr
+	if err := c.LoadBytes(rom); err != nil {
+		return err
 	}
-	if int64(len(c.cpu.Memory)-cpu.START_ADDR) < stat.Size() {
-		return fmt.Errorf("ROM file size is too big")
+	c.SetROMFile(fileName)
+	return nil
+}
+
+// SetROMFile has HardReset read the ROM again from fileName, the file the
+// loaded ROM came from. Loading another ROM forgets it.
+func (c *Chip8) SetROMFile(fileName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.fileName = fileName
+}
+
+func (c *Chip8) LoadReader(r io.Reader) error {
+	rom, err := ReadROM(r)
+	if err != nil {
+		return err
+	}
+
+	return c.LoadBytes(rom)
+}
+
+// LoadFS loads a ROM from a file system, e.g. one embedded with go:embed.
+func (c *Chip8) LoadFS(fsys fs.FS, name string) error {
+	rom, err := ReadROMFS(fsys, name)
+	if err != nil {
+		return err
 	}
 
-	buffer := make([]byte, stat.Size())
-	if _, readErr := file.Read(buffer); readErr != nil {
-		return readErr
+	return c.LoadBytes(rom)
+}
+
+func (c *Chip8) LoadBytes(rom []byte) error {
+	return c.LoadBytesAt(rom, c.loadAddress)
+}
+
+// LoadBytesAt loads rom at address, e.g. 0x600 for ETI 660 programs, and
+// starts execution there.
+func (c *Chip8) LoadBytesAt(rom []byte, address uint16) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.cpu.LoadROM(rom, address); err != nil {
+		return err
 	}
+	c.rom = append([]byte(nil), rom...)
+	c.fileName = ""
+	c.loadAddress = address
+	c.redraw = true
 
-	copy(c.cpu.Memory[cpu.START_ADDR:], buffer)
 	return nil
 }
+
+// Load replaces the running program with rom on a fresh CPU, at the
+// configured load address.
+func (c *Chip8) Load(rom []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := newCPU(c.opts).LoadROM(rom, c.loadAddress); err != nil {
+		return err
+	}
+	c.rom = append([]byte(nil), rom...)
+	c.fileName = ""
+
+	return c.reset()
+}
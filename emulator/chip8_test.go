@@ -0,0 +1,106 @@
+package emulator_test
+
+import (
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Adds 1 to V0 and jumps back, so a frame at 2 IPF adds 1.
+var countROM = []byte{0x70, 0x01, 0x12, 0x00}
+
+// controller plays a control script, a poll at a time, and records V0 and
+// the state at every poll.
+type controller struct {
+	script [][]emulator.Control
+	v0     uint8
+	seen   []uint8
+	states []emulator.RunState
+}
+
+func (f *controller) Draw(c *cpu.CPU) error {
+	return nil
+}
+
+func (f *controller) PollInput(c *cpu.CPU) bool {
+	f.v0 = c.VRegisters[0]
+	return len(f.seen) == len(f.script)
+}
+
+func (f *controller) Controls(state emulator.RunState) []emulator.Control {
+	f.seen = append(f.seen, f.v0)
+	f.states = append(f.states, state)
+	return f.script[len(f.seen)-1]
+}
+
+func TestControls(t *testing.T) {
+	assert := assert.New(t)
+
+	f := &controller{script: [][]emulator.Control{
+		{emulator.CONTROL_PAUSE},
+		{emulator.CONTROL_ADVANCE},
+		{emulator.CONTROL_SLOW_MOTION},
+		{emulator.CONTROL_PAUSE},
+		{emulator.CONTROL_FAST_FORWARD},
+		{emulator.CONTROL_FAST_FORWARD_END, emulator.CONTROL_RESET},
+		nil,
+	}}
+	opts := emulator.DefaultOptions()
+	opts.IPF, opts.FrameRate = 2, 1000
+	c8 := emulator.NewChip8(opts, f)
+	assert.NoError(c8.LoadBytes(countROM), "load")
+	assert.NoError(c8.Run(), "run")
+
+	assert.Equal([]uint8{1, 1, 2, 2, 3, 4, 1}, f.seen, "a frame should run on advance, none while paused and the reset should start over")
+	running := emulator.RunState{Speed: 1}
+	assert.Equal([]emulator.RunState{
+		running,
+		{Paused: true, Speed: 1},
+		{Paused: true, Speed: 1},
+		{Paused: true, Speed: 0.5},
+		{Speed: 0.5},
+		{Speed: emulator.DEFAULT_FAST_FORWARD, FastForward: true},
+		{Speed: 0.5},
+	}, f.states, "states")
+
+	assert.Equal("Paused", f.states[1].String(), "paused")
+	assert.Equal("Speed 50%", f.states[4].String(), "slow motion")
+	assert.Equal("Fast forward 4x", f.states[5].String(), "fast forward")
+	assert.Equal("", running.String(), "nothing to tell")
+}
+
+func TestHardReset(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "count.ch8")
+	assert.NoError(os.WriteFile(path, countROM, 0o644), "write")
+	c8 := emulator.NewChip8(emulator.DefaultOptions(), nil)
+	assert.NoError(c8.LoadProgram(path), "load")
+	c8.CPU().SetBreakpoint(0x202)
+	assert.Error(c8.SetSpeed(0), "speed")
+	assert.NoError(c8.SetSpeed(0.25), "speed")
+	c8.Pause()
+
+	assert.NoError(c8.Reset(), "reset")
+	assert.Equal([]uint16{0x202}, c8.CPU().Breakpoints(), "a reset should keep the breakpoints")
+	assert.Equal(emulator.RunState{Paused: true, Speed: 0.25}, c8.State(), "a reset should keep the state")
+
+	assert.NoError(os.WriteFile(path, []byte{0x70, 0x02, 0x12, 0x00}, 0o644), "rebuild")
+	assert.NoError(c8.HardReset(), "hard reset")
+	assert.Equal(uint8(0x02), c8.CPU().Memory[0x201], "a hard reset should read the ROM again")
+	assert.Empty(c8.CPU().Breakpoints(), "a hard reset should clear the breakpoints")
+	assert.Equal(emulator.RunState{Speed: 1}, c8.State(), "a hard reset should run at normal speed")
+
+	// Bytes built from a source keep to themselves unless told their file.
+	c8 = emulator.NewChip8(emulator.DefaultOptions(), nil)
+	assert.NoError(c8.LoadBytes(countROM), "load bytes")
+	assert.NoError(c8.HardReset(), "hard reset")
+	assert.Equal(countROM[1], c8.CPU().Memory[0x201], "a hard reset should keep the bytes loaded")
+	c8.SetROMFile(path)
+	assert.NoError(c8.HardReset(), "hard reset")
+	assert.Equal(uint8(0x02), c8.CPU().Memory[0x201], "a hard reset should read the file set")
+}
@@ -0,0 +1,64 @@
+package emulator
+
+import (
+	"chip-8-go/cpu"
+	"fmt"
+)
+
+// Frontend presents a running Chip8 to the user. A Chip8 without a
+// frontend runs headless: as fast as possible, with no window or input.
+type Frontend interface {
+	// Draw presents the current contents of the CPU screen.
+	Draw(c *cpu.CPU) error
+	// PollInput applies pending input events to the CPU keypad and reports
+	// whether the user asked to quit.
+	PollInput(c *cpu.CPU) bool
+}
+
+// Control changes how a Chip8 runs, see Controller.
+type Control int
+
+const (
+	// Pause, or resume when paused.
+	CONTROL_PAUSE Control = iota
+	// Run one frame and pause.
+	CONTROL_ADVANCE
+	// Run at the fast forward speed until CONTROL_FAST_FORWARD_END.
+	CONTROL_FAST_FORWARD
+	CONTROL_FAST_FORWARD_END
+	// Switch to the next slow motion speed, see SLOW_MOTION_SPEEDS.
+	CONTROL_SLOW_MOTION
+	CONTROL_RESET
+	CONTROL_HARD_RESET
+)
+
+// Controller is a frontend that controls the run as well, e.g. with
+// hotkeys. Chip8 applies the controls after every PollInput.
+type Controller interface {
+	// Controls returns the controls since the last call. state is how
+	// the emulator runs before applying them.
+	Controls(state RunState) []Control
+}
+
+// RunState is how a Chip8 runs.
+type RunState struct {
+	Paused bool
+	// Multiplies the frame rate: above 1 when fast forwarding, below 1 in
+	// slow motion.
+	Speed       float64
+	FastForward bool
+}
+
+// String describes the state when it is not simply running, e.g. "Speed
+// 50%", and is empty otherwise.
+func (s RunState) String() string {
+	switch {
+	case s.Paused:
+		return "Paused"
+	case s.FastForward:
+		return fmt.Sprintf("Fast forward %gx", s.Speed)
+	case s.Speed != 1:
+		return fmt.Sprintf("Speed %g%%", s.Speed*100)
+	}
+	return ""
+}
@@ -0,0 +1,37 @@
+package emulator
+
+import (
+	"chip-8-go/cpu"
+	"strings"
+)
+
+// KeyBindings names the host keys bound to each CHIP-8 key, indexed by the
+// key's hex value. Names follow SDL's key names ("1", "Q", "Up", "Space");
+// several keys can be bound to the same CHIP-8 key by separating them with
+// commas.
+type KeyBindings [cpu.NUM_KEYS]string
+
+// The left-hand block of a QWERTY keyboard mapped onto the hex keypad:
+//
+//	1 2 3 C      1 2 3 4
+//	4 5 6 D  =>  Q W E R
+//	7 8 9 E      A S D F
+//	A 0 B F      Z X C V
+var DefaultKeyBindings = KeyBindings{
+	0x1: "1", 0x2: "2", 0x3: "3", 0xC: "4",
+	0x4: "Q", 0x5: "W", 0x6: "E", 0xD: "R",
+	0x7: "A", 0x8: "S", 0x9: "D", 0xE: "F",
+	0xA: "Z", 0x0: "X", 0xB: "C", 0xF: "V",
+}
+
+// Names returns the host key names bound to key.
+func (k KeyBindings) Names(key uint8) []string {
+	var names []string
+	for _, name := range strings.Split(k[key], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,23 @@
+package emulator
+
+// MenuInput is a command for a menu drawn by a frontend, e.g. the ROM
+// browser, read from the arrow keys or the keypad.
+type MenuInput int
+
+const (
+	MENU_UP MenuInput = iota
+	MENU_DOWN
+	MENU_LEFT
+	MENU_RIGHT
+	MENU_SELECT
+)
+
+// The keypad keys that steer menus, as on the COSMAC VIP: 2 and 8 move up
+// and down, 4 and 6 left and right, and 5 picks.
+var menuKeys = map[uint8]MenuInput{
+	0x2: MENU_UP,
+	0x8: MENU_DOWN,
+	0x4: MENU_LEFT,
+	0x6: MENU_RIGHT,
+	0x5: MENU_SELECT,
+}
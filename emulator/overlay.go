@@ -0,0 +1,109 @@
+package emulator
+
+import (
+	"chip-8-go/cpu"
+	"fmt"
+	"time"
+)
+
+// How long a toast stays on screen.
+const TOAST_DURATION = 2 * time.Second
+
+// A new toast pushes out the oldest beyond this many.
+const MAX_TOASTS = 3
+
+// Overlay is the text a frontend draws over the CHIP-8 screen: the frame
+// and instruction rates with the quirks, a register panel, a status such
+// as "Paused" and short toast messages such as "Palette: amber". It only
+// keeps the text, drawing it is up to the frontend, e.g. in the built-in
+// font.
+type Overlay struct {
+	ShowStats     bool
+	ShowRegisters bool
+	// Shown until changed, e.g. "Paused".
+	Status string
+
+	toasts []toast
+	// Whether a toast was added since the last frame.
+	toasted bool
+
+	// The rates are measured over a second at a time.
+	since    time.Time
+	frames   int
+	cycles   uint64
+	fps, ips float64
+}
+
+type toast struct {
+	text  string
+	until time.Time
+}
+
+// Toast shows text for TOAST_DURATION from now.
+func (o *Overlay) Toast(now time.Time, text string) {
+	if len(o.toasts) == MAX_TOASTS {
+		o.toasts = o.toasts[1:]
+	}
+	o.toasts = append(o.toasts, toast{text: text, until: now.Add(TOAST_DURATION)})
+	o.toasted = true
+}
+
+// Frame counts a frame of c towards the rates and drops the toasts that
+// are over. It reports whether the text changed, so has to be drawn again.
+func (o *Overlay) Frame(c *cpu.CPU, now time.Time) bool {
+	changed := o.ShowRegisters || o.toasted
+	o.toasted = false
+
+	for len(o.toasts) > 0 && !now.Before(o.toasts[0].until) {
+		o.toasts = o.toasts[1:]
+		changed = true
+	}
+
+	// A reset starts the cycles over.
+	if c.Cycles < o.cycles || o.since.IsZero() {
+		o.since, o.frames, o.cycles = now, 0, c.Cycles
+		return changed
+	}
+	o.frames++
+	if elapsed := now.Sub(o.since); elapsed >= time.Second {
+		o.fps = float64(o.frames) / elapsed.Seconds()
+		o.ips = float64(c.Cycles-o.cycles) / elapsed.Seconds()
+		o.since, o.frames, o.cycles = now, 0, c.Cycles
+		changed = changed || o.ShowStats
+	}
+
+	return changed
+}
+
+// Visible reports whether there is anything to draw.
+func (o *Overlay) Visible() bool {
+	return o.ShowStats || o.ShowRegisters || o.Status != "" || len(o.toasts) > 0
+}
+
+// Stats returns the lines of the rates panel.
+func (o *Overlay) Stats(c *cpu.CPU) []string {
+	return []string{
+		fmt.Sprintf("%.0f FPS", o.fps),
+		fmt.Sprintf("%.0f IPS", o.ips),
+		c.Quirks.String(),
+	}
+}
+
+// Registers returns the lines of the register panel.
+func (o *Overlay) Registers(c *cpu.CPU) []string {
+	lines := []string{fmt.Sprintf("PC %03X I %03X SP %X", c.ProgramCounter, c.IndexRegister, c.StackPointer)}
+	for row := 0; row < cpu.NUM_REGS; row += 4 {
+		v := c.VRegisters[row : row+4]
+		lines = append(lines, fmt.Sprintf("V%X %02X V%X %02X V%X %02X V%X %02X", row, v[0], row+1, v[1], row+2, v[2], row+3, v[3]))
+	}
+	return append(lines, fmt.Sprintf("DT %02X ST %02X", c.DelayTimer, c.SoundTimer))
+}
+
+// Toasts returns the toasts showing, the oldest first.
+func (o *Overlay) Toasts() []string {
+	texts := make([]string, len(o.toasts))
+	for i, t := range o.toasts {
+		texts[i] = t.text
+	}
+	return texts
+}
@@ -0,0 +1,53 @@
+package emulator_test
+
+import (
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverlay(t *testing.T) {
+	assert := assert.New(t)
+
+	var overlay emulator.Overlay
+	c := cpu.NewCPU()
+	start := time.Unix(0, 0)
+	assert.False(overlay.Visible(), "nothing is shown at first")
+
+	overlay.Toast(start, "State saved")
+	for i := range emulator.MAX_TOASTS {
+		overlay.Toast(start.Add(time.Duration(i+1)*time.Second), "Palette: amber")
+	}
+	assert.Equal([]string{"Palette: amber", "Palette: amber", "Palette: amber"}, overlay.Toasts(), "the oldest toast should be pushed out")
+	assert.True(overlay.Visible(), "toasts")
+
+	overlay.ShowStats = true
+	assert.True(overlay.Frame(c, start), "new toasts")
+	for frame := 1; frame <= 60; frame++ {
+		c.Cycles += 10
+		changed := overlay.Frame(c, start.Add(time.Duration(frame)*time.Second/60))
+		assert.Equal(frame == 60, changed, "the rates should change after a second, frame %d", frame)
+	}
+	assert.Equal([]string{"60 FPS", "600 IPS", "chip8"}, overlay.Stats(c), "stats")
+
+	assert.True(overlay.Frame(c, start.Add(3500*time.Millisecond)), "a toast is over")
+	assert.Len(overlay.Toasts(), 2, "toasts last two seconds")
+
+	c.ProgramCounter, c.IndexRegister, c.VRegisters[0xF] = 0x2A4, 0x3F, 1
+	registers := overlay.Registers(c)
+	assert.Equal("PC 2A4 I 03F SP 0", registers[0], "registers")
+	assert.Equal("VC 00 VD 00 VE 00 VF 01", registers[4], "registers")
+}
+
+func TestGlyph(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([emulator.GLYPH_HEIGHT]uint8{0x0E, 0x11, 0x11, 0x11, 0x1F, 0x11, 0x11}, emulator.Glyph('A'), "A")
+	assert.Equal(emulator.Glyph('?'), emulator.Glyph('é'), "characters the font lacks")
+	assert.Equal(0, emulator.TextWidth(""), "width")
+	assert.Equal(11, emulator.TextWidth("AB"), "width")
+	assert.Equal([]string{"Brix by", "Andreas", "Gustafsso", "n"}, emulator.WrapText("Brix by  Andreas Gustafsson", 9), "wrapping")
+}
@@ -0,0 +1,87 @@
+package emulator
+
+import (
+	"fmt"
+	"image/color"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Palette struct {
+	Background color.RGBA
+	Foreground color.RGBA
+}
+
+const DEFAULT_PALETTE = "green"
+
+var Palettes = map[string]Palette{
+	"green": {
+		Background: color.RGBA{0, 0, 0, 255},
+		Foreground: color.RGBA{0, 255, 0, 255},
+	},
+	"white": {
+		Background: color.RGBA{0, 0, 0, 255},
+		Foreground: color.RGBA{255, 255, 255, 255},
+	},
+	"amber": {
+		Background: color.RGBA{26, 16, 0, 255},
+		Foreground: color.RGBA{255, 176, 0, 255},
+	},
+	"lcd": {
+		Background: color.RGBA{155, 188, 15, 255},
+		Foreground: color.RGBA{15, 56, 15, 255},
+	},
+	"octo": {
+		Background: color.RGBA{153, 102, 0, 255},
+		Foreground: color.RGBA{255, 204, 0, 255},
+	},
+}
+
+// ParsePalette accepts either a palette name or a custom pair of colours
+// written as "foreground:background" in RRGGBB hex, e.g. "ffb000:1a1000".
+func ParsePalette(value string) (Palette, error) {
+	if palette, ok := Palettes[value]; ok {
+		return palette, nil
+	}
+
+	fg, bg, found := strings.Cut(value, ":")
+	if !found {
+		return Palette{}, fmt.Errorf("unknown palette %q (available: %s, or FG:BG in hex)", value, strings.Join(PaletteNames(), ", "))
+	}
+
+	foreground, fgErr := parseHexColor(fg)
+	if fgErr != nil {
+		return Palette{}, fgErr
+	}
+	background, bgErr := parseHexColor(bg)
+	if bgErr != nil {
+		return Palette{}, bgErr
+	}
+
+	return Palette{Background: background, Foreground: foreground}, nil
+}
+
+func PaletteNames() []string {
+	names := make([]string, 0, len(Palettes))
+	for name := range Palettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func parseHexColor(value string) (color.RGBA, error) {
+	value = strings.TrimPrefix(value, "#")
+	if len(value) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid colour %q: expected RRGGBB", value)
+	}
+
+	rgb, err := strconv.ParseUint(value, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid colour %q: %w", value, err)
+	}
+
+	return color.RGBA{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), 255}, nil
+}
@@ -0,0 +1,175 @@
+package emulator
+
+import (
+	"archive/zip"
+	"bytes"
+	"chip-8-go/cpu"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ZIP_SEPARATOR picks a file inside an archive: "games.zip#PONG".
+const ZIP_SEPARATOR = "#"
+
+var zipMagic = []byte("PK\x03\x04")
+
+// Extensions that mark a file inside an archive as a ROM rather than e.g.
+// a readme. Files without an extension count as well, that is how most
+// classic ROMs are distributed.
+var romExtensions = map[string]bool{
+	"":     true,
+	".ch8": true,
+	".c8":  true,
+	".sc8": true,
+	".xo8": true,
+	".rom": true,
+}
+
+// IsROMName reports whether a file name looks like a ROM, or an archive
+// of ROMs, going by its extension.
+func IsROMName(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return romExtensions[ext] || ext == ".zip"
+}
+
+// ReadROM reads a whole ROM image. Zip archives are unpacked
+// transparently, see ReadROMZip.
+func ReadROM(r io.Reader) ([]byte, error) {
+	return readROM(r, "")
+}
+
+// ReadROMFile reads a ROM image from disk. For archives a member can be
+// picked with ZIP_SEPARATOR, e.g. "games.zip#PONG".
+func ReadROMFile(fileName string) ([]byte, error) {
+	file, err := os.Open(fileName)
+	member := ""
+	if errors.Is(err, fs.ErrNotExist) {
+		archive, name, found := strings.Cut(fileName, ZIP_SEPARATOR)
+		if !found {
+			return nil, err
+		}
+		file, err = os.Open(archive)
+		member = name
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rom, err := readROM(file, member)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+
+	return rom, nil
+}
+
+// ReadROMFS reads a ROM image from a file system, e.g. one embedded with
+// go:embed.
+func ReadROMFS(fsys fs.FS, name string) ([]byte, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rom, err := readROM(file, "")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return rom, nil
+}
+
+// ReadROMZip extracts a ROM from a zip archive. With an empty member name
+// the archive must hold exactly one file that looks like a ROM.
+func ReadROMZip(data []byte, member string) ([]byte, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip archive: %w", err)
+	}
+
+	var candidates []*zip.File
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		if member != "" {
+			if file.Name == member || path.Base(file.Name) == member {
+				candidates = append(candidates, file)
+			}
+		} else if romExtensions[strings.ToLower(path.Ext(file.Name))] {
+			candidates = append(candidates, file)
+		}
+	}
+
+	switch {
+	case len(candidates) == 0 && member != "":
+		return nil, fmt.Errorf("no file %q in zip archive", member)
+	case len(candidates) == 0:
+		return nil, fmt.Errorf("no ROM found in zip archive")
+	case len(candidates) > 1:
+		names := make([]string, len(candidates))
+		for i, file := range candidates {
+			names[i] = file.Name
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("zip archive holds several ROMs, pick one with archive.zip%sNAME: %s", ZIP_SEPARATOR, strings.Join(names, ", "))
+	}
+
+	file, err := candidates[0].Open()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", candidates[0].Name, err)
+	}
+	defer file.Close()
+
+	rom, err := readLimited(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", candidates[0].Name, err)
+	}
+
+	return rom, nil
+}
+
+func readROM(r io.Reader, member string) ([]byte, error) {
+	data, err := readLimited(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(data, zipMagic) {
+		return ReadROMZip(data, member)
+	}
+	if member != "" {
+		return nil, fmt.Errorf("not a zip archive, cannot pick %q", member)
+	}
+
+	return data, nil
+}
+
+// readLimited reads the whole input but refuses anything far larger than
+// a ROM or an archive of ROMs could be, so that pointing the emulator at
+// the wrong file fails fast. Whether the ROM fits in memory is only known
+// once the load address is, see cpu.LoadROM.
+func readLimited(r io.Reader) ([]byte, error) {
+	const limit = 16 << 20
+
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, cpu.ErrEmptyROM
+	}
+	if len(data) > limit {
+		return nil, fmt.Errorf("file is larger than %d MiB, not a ROM", limit>>20)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,87 @@
+package emulator_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testROM = []byte{0x00, 0xE0, 0x12, 0x00}
+
+func makeZip(t *testing.T, files map[string][]byte) []byte {
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for name, data := range files {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestReadROM(t *testing.T) {
+	assert := assert.New(t)
+
+	rom, err := emulator.ReadROM(bytes.NewReader(testROM))
+	assert.NoError(err)
+	assert.Equal(testROM, rom)
+
+	_, err = emulator.ReadROM(bytes.NewReader(nil))
+	assert.ErrorIs(err, cpu.ErrEmptyROM)
+
+	rom, err = emulator.ReadROMFS(fstest.MapFS{"roms/TEST": {Data: testROM}}, "roms/TEST")
+	assert.NoError(err)
+	assert.Equal(testROM, rom, "ReadROMFS should read from any fs.FS")
+}
+
+func TestReadROMZip(t *testing.T) {
+	assert := assert.New(t)
+
+	single := makeZip(t, map[string][]byte{"PONG": testROM, "README.txt": []byte("readme")})
+	rom, err := emulator.ReadROM(bytes.NewReader(single))
+	assert.NoError(err)
+	assert.Equal(testROM, rom, "The only ROM in an archive should be picked")
+
+	several := makeZip(t, map[string][]byte{"games/PONG": testROM, "games/BRIX.ch8": {0x12, 0x00}})
+	_, err = emulator.ReadROM(bytes.NewReader(several))
+	assert.ErrorContains(err, "several ROMs")
+
+	archive := filepath.Join(t.TempDir(), "games.zip")
+	assert.NoError(os.WriteFile(archive, several, 0644))
+
+	rom, err = emulator.ReadROMFile(archive + emulator.ZIP_SEPARATOR + "BRIX.ch8")
+	assert.NoError(err)
+	assert.Equal([]byte{0x12, 0x00}, rom, "A member should be picked by name")
+
+	_, err = emulator.ReadROMFile(archive + emulator.ZIP_SEPARATOR + "TETRIS")
+	assert.ErrorContains(err, "no file")
+
+	_, err = emulator.ReadROM(bytes.NewReader([]byte("PK\x03\x04broken")))
+	assert.ErrorContains(err, "invalid zip archive")
+}
+
+func TestLoadBytesAt(t *testing.T) {
+	assert := assert.New(t)
+
+	c8 := emulator.NewChip8(emulator.DefaultOptions(), nil)
+	assert.NoError(c8.LoadBytesAt(testROM, 0x600))
+	assert.Equal(uint16(0x600), c8.CPU().ProgramCounter, "Execution should start at the load address")
+	assert.Equal(testROM, c8.CPU().Memory[0x600:0x604])
+
+	var sizeErr *cpu.ROMSizeError
+	err := c8.LoadBytesAt(make([]byte, cpu.RAM_SIZE-0x600+1), 0x600)
+	assert.ErrorAs(err, &sizeErr, "ROMs that do not fit should be rejected")
+	assert.Equal(cpu.RAM_SIZE-0x600+1, sizeErr.Size)
+}
@@ -0,0 +1,30 @@
+package emulator
+
+import (
+	"chip-8-go/cpu"
+	"image"
+)
+
+// ScreenHash returns the hex SHA-1 of the framebuffer packed one bit per
+// pixel, row by row. Two runs that end on the same picture hash equally,
+// which makes it a cheap regression check.
+func ScreenHash(c *cpu.CPU) string {
+	return c.Screen.Hash()
+}
+
+// ScreenImage draws screen in the colours of palette, each pixel scaled to
+// a scale by scale square, e.g. for PNG screenshots.
+func ScreenImage(screen *cpu.Framebuffer, palette Palette, scale int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, cpu.SCREEN_WIDTH*scale, cpu.SCREEN_HEIGHT*scale))
+	for y := 0; y < img.Rect.Dy(); y++ {
+		for x := 0; x < img.Rect.Dx(); x++ {
+			color := palette.Background
+			if screen.Pixel(x/scale, y/scale) {
+				color = palette.Foreground
+			}
+			img.SetRGBA(x, y, color)
+		}
+	}
+
+	return img
+}
@@ -0,0 +1,285 @@
+//go:build !js
+
+package emulator
+
+import (
+	"chip-8-go/cpu"
+	"fmt"
+	"time"
+
+	sdl "github.com/veandco/go-sdl2/sdl"
+)
+
+// Hotkeys of the SDL frontend, unless bound to CHIP-8 keys: F1 toggles
+// the frame and instruction rates, F2 the registers and F3 switches to the
+// next palette. Escape returns to the menu, see EnableMenu. The rest are
+// the run controls, see Control: P pauses, N runs a frame, Tab fast
+// forwards while held, M switches to slow motion, F5 resets and F6 hard
+// resets.
+const (
+	HOTKEY_STATS        = sdl.K_F1
+	HOTKEY_REGISTERS    = sdl.K_F2
+	HOTKEY_PALETTE      = sdl.K_F3
+	HOTKEY_MENU         = sdl.K_ESCAPE
+	HOTKEY_PAUSE        = sdl.K_p
+	HOTKEY_ADVANCE      = sdl.K_n
+	HOTKEY_FAST_FORWARD = sdl.K_TAB
+	HOTKEY_SLOW_MOTION  = sdl.K_m
+	HOTKEY_RESET        = sdl.K_F5
+	HOTKEY_HARD_RESET   = sdl.K_F6
+)
+
+// SDLFrontend draws into an SDL renderer and reads the keypad from SDL
+// keyboard events. It draws an Overlay over the CHIP-8 screen and is a
+// Controller, with hotkeys.
+type SDLFrontend struct {
+	renderer      *sdl.Renderer
+	scaleModifier int32
+	palette       Palette
+	keyMap        map[sdl.Keycode]uint8
+
+	overlay Overlay
+	// Whether the screen was drawn in the current frame, and whether the
+	// overlay has to be drawn again.
+	drawn, redraw bool
+	rects         []sdl.Rect
+
+	// Whether HOTKEY_MENU is active, and was pressed.
+	menu, menuRequested bool
+	// The first menu line shown, see DrawMenu.
+	menuScroll int
+
+	// The controls since the last call to Controls, and the state then.
+	controls []Control
+	state    RunState
+}
+
+func NewSDLFrontend(renderer *sdl.Renderer, scaleModifier int32, palette Palette, keys KeyBindings) (*SDLFrontend, error) {
+	keyMap := make(map[sdl.Keycode]uint8, len(keys))
+	for key := range keys {
+		for _, name := range keys.Names(uint8(key)) {
+			keycode := sdl.GetKeyFromName(name)
+			if keycode == sdl.K_UNKNOWN {
+				return nil, fmt.Errorf("unknown key name %q for CHIP-8 key %X", name, key)
+			}
+			keyMap[keycode] = uint8(key)
+		}
+	}
+
+	return &SDLFrontend{
+		renderer:      renderer,
+		scaleModifier: scaleModifier,
+		palette:       palette,
+		keyMap:        keyMap,
+	}, nil
+}
+
+// Overlay returns the overlay, e.g. to show toasts. Use it on the
+// emulation goroutine only, e.g. from a frame hook.
+func (f *SDLFrontend) Overlay() *Overlay {
+	return &f.overlay
+}
+
+// EnableMenu makes HOTKEY_MENU quit the game like closing the window,
+// except that MenuRequested tells the two apart.
+func (f *SDLFrontend) EnableMenu() {
+	f.menu = true
+}
+
+// MenuRequested reports whether the game was quit with HOTKEY_MENU.
+func (f *SDLFrontend) MenuRequested() bool {
+	return f.menuRequested
+}
+
+func (f *SDLFrontend) PollInput(c *cpu.CPU) bool {
+	for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
+		isPressed := isKeyPressed(event)
+		switch et := event.(type) {
+		case *sdl.QuitEvent:
+			return true
+		case *sdl.KeyboardEvent:
+			if key, ok := f.keyMap[et.Keysym.Sym]; ok {
+				c.SetKey(key, isPressed)
+			} else if isPressed && et.Repeat == 0 {
+				f.hotkey(et.Keysym.Sym)
+			} else if !isPressed && et.Keysym.Sym == HOTKEY_FAST_FORWARD {
+				f.controls = append(f.controls, CONTROL_FAST_FORWARD_END)
+			}
+		}
+	}
+	if f.menuRequested {
+		return true
+	}
+
+	// The overlay changes without the CHIP-8 screen changing.
+	if f.overlay.Frame(c, time.Now()) || f.redraw {
+		if !f.drawn {
+			f.Draw(c)
+		}
+	}
+	f.drawn = false
+
+	return false
+}
+
+func (f *SDLFrontend) hotkey(key sdl.Keycode) {
+	switch key {
+	case HOTKEY_STATS:
+		f.overlay.ShowStats = !f.overlay.ShowStats
+	case HOTKEY_REGISTERS:
+		f.overlay.ShowRegisters = !f.overlay.ShowRegisters
+	case HOTKEY_PALETTE:
+		names := PaletteNames()
+		next := names[0]
+		for i, name := range names {
+			if Palettes[name] == f.palette {
+				next = names[(i+1)%len(names)]
+			}
+		}
+		f.palette = Palettes[next]
+		f.overlay.Toast(time.Now(), "Palette: "+next)
+	case HOTKEY_MENU:
+		f.menuRequested = f.menu
+		return
+	case HOTKEY_PAUSE:
+		f.controls = append(f.controls, CONTROL_PAUSE)
+	case HOTKEY_ADVANCE:
+		f.controls = append(f.controls, CONTROL_ADVANCE)
+	case HOTKEY_FAST_FORWARD:
+		f.controls = append(f.controls, CONTROL_FAST_FORWARD)
+	case HOTKEY_SLOW_MOTION:
+		f.controls = append(f.controls, CONTROL_SLOW_MOTION)
+	case HOTKEY_RESET:
+		f.controls = append(f.controls, CONTROL_RESET)
+		f.overlay.Toast(time.Now(), "Reset")
+	case HOTKEY_HARD_RESET:
+		f.controls = append(f.controls, CONTROL_HARD_RESET)
+		f.overlay.Toast(time.Now(), "Hard reset")
+	default:
+		return
+	}
+	f.redraw = true
+}
+
+// Controls returns the controls of the hotkeys pressed, and shows state
+// as the status of the overlay.
+func (f *SDLFrontend) Controls(state RunState) []Control {
+	if state != f.state {
+		f.state = state
+		f.overlay.Status = state.String()
+		f.redraw = true
+	}
+
+	controls := f.controls
+	f.controls = nil
+	return controls
+}
+
+func (f *SDLFrontend) Draw(c *cpu.CPU) error {
+	bg, fg := f.palette.Background, f.palette.Foreground
+
+	f.renderer.SetDrawColor(bg.R, bg.G, bg.B, bg.A)
+	f.renderer.Clear()
+
+	f.renderer.SetDrawColor(fg.R, fg.G, fg.B, fg.A)
+	for j := 0; j < len(c.Screen); j++ {
+		for i := 0; i < cpu.SCREEN_WIDTH; i++ {
+			if !c.Screen.Pixel(i, j) {
+				continue
+			}
+			f.renderer.FillRect(
+				&sdl.Rect{
+					Y: int32(j) * f.scaleModifier,
+					X: int32(i) * f.scaleModifier,
+					W: f.scaleModifier,
+					H: f.scaleModifier,
+				},
+			)
+		}
+	}
+
+	if f.overlay.Visible() {
+		f.drawOverlay(c)
+	}
+
+	f.renderer.Present()
+	f.drawn, f.redraw = true, false
+	return nil
+}
+
+// drawOverlay draws the panels of the overlay in the corners of the
+// screen: the rates top left, the registers top right, the toasts bottom
+// left and the status bottom right.
+func (f *SDLFrontend) drawOverlay(c *cpu.CPU) {
+	if f.overlay.ShowStats {
+		f.drawPanel(f.overlay.Stats(c), false, false)
+	}
+	if f.overlay.ShowRegisters {
+		f.drawPanel(f.overlay.Registers(c), true, false)
+	}
+	if toasts := f.overlay.Toasts(); len(toasts) > 0 {
+		f.drawPanel(toasts, false, true)
+	}
+	if f.overlay.Status != "" {
+		f.drawPanel([]string{f.overlay.Status}, true, true)
+	}
+}
+
+// drawPanel draws lines of text on a dark box in a corner.
+func (f *SDLFrontend) drawPanel(lines []string, right, bottom bool) {
+	// A font pixel is a fifth of a CHIP-8 pixel, so a line of text is
+	// about as high as a CHIP-8 character.
+	size := max(1, f.scaleModifier/5)
+	lineHeight := (GLYPH_HEIGHT + 2) * size
+	width := 0
+	for _, line := range lines {
+		width = max(width, TextWidth(line))
+	}
+
+	box := sdl.Rect{W: int32(width)*size + 4*size, H: int32(len(lines))*lineHeight + 2*size}
+	if right {
+		box.X = cpu.SCREEN_WIDTH*f.scaleModifier - box.W
+	}
+	if bottom {
+		box.Y = cpu.SCREEN_HEIGHT*f.scaleModifier - box.H
+	}
+	f.renderer.SetDrawBlendMode(sdl.BLENDMODE_BLEND)
+	f.renderer.SetDrawColor(0, 0, 0, 176)
+	f.renderer.FillRect(&box)
+
+	f.renderer.SetDrawColor(255, 255, 255, 255)
+	for i, line := range lines {
+		f.drawText(box.X+2*size, box.Y+2*size+int32(i)*lineHeight, line, size)
+	}
+}
+
+// drawText draws text in the built-in font with its top left corner at
+// (x, y), size by size screen pixels a font pixel.
+func (f *SDLFrontend) drawText(x, y int32, text string, size int32) {
+	f.rects = f.rects[:0]
+	for i, r := range []rune(text) {
+		left := x + int32(i*(GLYPH_WIDTH+1))*size
+		for row, bits := range Glyph(r) {
+			for column := range GLYPH_WIDTH {
+				if bits&(1<<(GLYPH_WIDTH-1-column)) != 0 {
+					f.rects = append(f.rects, sdl.Rect{X: left + int32(column)*size, Y: y + int32(row)*size, W: size, H: size})
+				}
+			}
+		}
+	}
+	if len(f.rects) > 0 {
+		f.renderer.FillRects(f.rects)
+	}
+}
+
+func isKeyPressed(event sdl.Event) bool {
+	var isPressed bool
+
+	if event.GetType() == sdl.KEYDOWN {
+		isPressed = true
+	} else {
+		isPressed = false
+	}
+
+	return isPressed
+}
@@ -0,0 +1,111 @@
+//go:build !js
+
+package emulator
+
+import (
+	"chip-8-go/cpu"
+
+	sdl "github.com/veandco/go-sdl2/sdl"
+)
+
+// Footer lines beyond this many are cut off by DrawMenu.
+const MAX_MENU_FOOTER = 4
+
+var menuArrows = map[sdl.Keycode]MenuInput{
+	sdl.K_UP:       MENU_UP,
+	sdl.K_DOWN:     MENU_DOWN,
+	sdl.K_LEFT:     MENU_LEFT,
+	sdl.K_RIGHT:    MENU_RIGHT,
+	sdl.K_RETURN:   MENU_SELECT,
+	sdl.K_KP_ENTER: MENU_SELECT,
+}
+
+// PollMenu reads the menu commands from the arrow keys and Enter, or the
+// keypad, see MenuInput. Closing the window or Escape quits.
+func (f *SDLFrontend) PollMenu() (inputs []MenuInput, quit bool) {
+	for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
+		switch et := event.(type) {
+		case *sdl.QuitEvent:
+			return inputs, true
+		case *sdl.KeyboardEvent:
+			if et.Type != sdl.KEYDOWN {
+				continue
+			}
+			if input, ok := menuArrows[et.Keysym.Sym]; ok {
+				inputs = append(inputs, input)
+			} else if key, ok := f.keyMap[et.Keysym.Sym]; ok {
+				if input, ok := menuKeys[key]; ok {
+					inputs = append(inputs, input)
+				}
+			} else if et.Keysym.Sym == sdl.K_ESCAPE {
+				return inputs, true
+			}
+		}
+	}
+
+	return inputs, false
+}
+
+// DrawMenu fills the window with a menu in the palette's colours: the
+// title at the top, the lines below it with the selected one highlighted
+// and scrolled into view, and the footer, wrapped, at the bottom.
+func (f *SDLFrontend) DrawMenu(title string, lines []string, selected int, footer []string) {
+	bg, fg := f.palette.Background, f.palette.Foreground
+	size := max(1, f.scaleModifier/5)
+	lineHeight := (GLYPH_HEIGHT + 2) * size
+	width, height := cpu.SCREEN_WIDTH*f.scaleModifier, cpu.SCREEN_HEIGHT*f.scaleModifier
+	columns := int((width - 4*size) / ((GLYPH_WIDTH + 1) * size))
+
+	var footerLines []string
+	for _, line := range footer {
+		footerLines = append(footerLines, WrapText(line, columns)...)
+	}
+	footerLines = footerLines[:min(len(footerLines), MAX_MENU_FOOTER)]
+
+	f.renderer.SetDrawBlendMode(sdl.BLENDMODE_NONE)
+	f.renderer.SetDrawColor(bg.R, bg.G, bg.B, bg.A)
+	f.renderer.Clear()
+
+	f.renderer.SetDrawColor(fg.R, fg.G, fg.B, fg.A)
+	f.drawText(2*size, 2*size, clip(title, columns), size)
+	top := lineHeight + 2*size
+	f.renderer.FillRect(&sdl.Rect{X: 0, Y: top - size, W: width, H: size})
+
+	bottom := height - int32(len(footerLines))*lineHeight - size
+	if len(footerLines) > 0 {
+		f.renderer.FillRect(&sdl.Rect{X: 0, Y: bottom, W: width, H: size})
+	}
+	for i, line := range footerLines {
+		f.drawText(2*size, bottom+2*size+int32(i)*lineHeight, line, size)
+	}
+
+	rows := max(1, int((bottom-top)/lineHeight))
+	if selected < f.menuScroll {
+		f.menuScroll = selected
+	} else if selected >= f.menuScroll+rows {
+		f.menuScroll = selected - rows + 1
+	}
+	f.menuScroll = max(0, min(f.menuScroll, len(lines)-rows))
+
+	for i := f.menuScroll; i < len(lines) && i < f.menuScroll+rows; i++ {
+		y := top + int32(i-f.menuScroll)*lineHeight
+		if i == selected {
+			f.renderer.FillRect(&sdl.Rect{X: 0, Y: y, W: width, H: lineHeight})
+			f.renderer.SetDrawColor(bg.R, bg.G, bg.B, bg.A)
+		}
+		f.drawText(2*size, y+size, clip(lines[i], columns), size)
+		if i == selected {
+			f.renderer.SetDrawColor(fg.R, fg.G, fg.B, fg.A)
+		}
+	}
+
+	f.renderer.Present()
+}
+
+// clip cuts text down to columns characters.
+func clip(text string, columns int) string {
+	if runes := []rune(text); len(runes) > columns {
+		return string(runes[:columns])
+	}
+	return text
+}
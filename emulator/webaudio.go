@@ -0,0 +1,96 @@
+//go:build js && wasm
+
+package emulator
+
+import (
+	"chip-8-go/audio"
+	"encoding/binary"
+	"errors"
+	"math"
+	"syscall/js"
+)
+
+// WebBeeper plays the emulator's audio through the browser's WebAudio API,
+// scheduling one buffer per frame back to back.
+type WebBeeper struct {
+	context  js.Value
+	nextTime float64
+
+	buffer []byte
+	bytes  js.Value
+	floats js.Value
+}
+
+func NewWebBeeper() (*WebBeeper, error) {
+	constructor := js.Global().Get("AudioContext")
+	if constructor.IsUndefined() {
+		constructor = js.Global().Get("webkitAudioContext")
+	}
+	if constructor.IsUndefined() {
+		return nil, errors.New("WebAudio is not supported by this browser")
+	}
+
+	bytes := js.Global().Get("Uint8Array").New(audio.SAMPLES_PER_FRAME * 4)
+
+	return &WebBeeper{
+		context: constructor.New(),
+		buffer:  make([]byte, audio.SAMPLES_PER_FRAME*4),
+		bytes:   bytes,
+		floats:  js.Global().Get("Float32Array").New(bytes.Get("buffer")),
+	}, nil
+}
+
+// WriteSamples schedules one frame of samples after the previous one.
+// Silent frames are skipped.
+func (b *WebBeeper) WriteSamples(samples []int16) error {
+	silent := true
+	for _, sample := range samples {
+		if sample != 0 {
+			silent = false
+			break
+		}
+	}
+	if silent {
+		b.nextTime = 0
+		return nil
+	}
+
+	// Browsers only start audio after the user interacted with the page.
+	if b.context.Get("state").String() == "suspended" {
+		b.context.Call("resume")
+	}
+
+	frameTime := float64(len(samples)) / audio.SAMPLE_RATE
+	now := b.context.Get("currentTime").Float()
+	if b.nextTime < now {
+		b.nextTime = now + frameTime
+	}
+	if b.nextTime > now+frameTime*maxQueuedFrames {
+		return nil
+	}
+
+	if len(b.buffer) != len(samples)*4 {
+		b.buffer = make([]byte, len(samples)*4)
+		b.bytes = js.Global().Get("Uint8Array").New(len(b.buffer))
+		b.floats = js.Global().Get("Float32Array").New(b.bytes.Get("buffer"))
+	}
+	for i, sample := range samples {
+		binary.LittleEndian.PutUint32(b.buffer[i*4:], math.Float32bits(float32(sample)/math.MaxInt16))
+	}
+	js.CopyBytesToJS(b.bytes, b.buffer)
+
+	buffer := b.context.Call("createBuffer", 1, len(samples), audio.SAMPLE_RATE)
+	buffer.Call("copyToChannel", b.floats, 0)
+
+	source := b.context.Call("createBufferSource")
+	source.Set("buffer", buffer)
+	source.Call("connect", b.context.Get("destination"))
+	source.Call("start", b.nextTime)
+	b.nextTime += frameTime
+
+	return nil
+}
+
+func (b *WebBeeper) Close() {
+	b.context.Call("close")
+}
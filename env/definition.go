@@ -0,0 +1,171 @@
+package env
+
+import (
+	"chip-8-go/cpu"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Frames an action holds its keys for unless it says otherwise.
+const DEFAULT_FRAME_SKIP = 4
+
+// Source is where a game keeps a number: a register ("VE"), a byte of
+// memory ("0x2F3") or three BCD digits as written by FX33 ("bcd:0x314").
+type Source struct {
+	register int
+	address  uint16
+	bcd      bool
+	text     string
+}
+
+func ParseSource(text string) (Source, error) {
+	s := Source{register: -1, text: text}
+
+	upper := strings.ToUpper(text)
+	if len(upper) == 2 && upper[0] == 'V' {
+		n, err := strconv.ParseUint(upper[1:], 16, 4)
+		if err != nil {
+			return Source{}, fmt.Errorf("invalid register %q", text)
+		}
+		s.register = int(n)
+		return s, nil
+	}
+
+	addressText, bcd := strings.CutPrefix(text, "bcd:")
+	address, err := strconv.ParseUint(addressText, 0, 16)
+	if err != nil || address > cpu.RAM_SIZE-1 || (bcd && address > cpu.RAM_SIZE-3) {
+		return Source{}, fmt.Errorf("invalid source %q, want a register (VE), an address (0x2F3) or BCD digits (bcd:0x2F2)", text)
+	}
+	s.address, s.bcd = uint16(address), bcd
+	return s, nil
+}
+
+func (s Source) String() string {
+	return s.text
+}
+
+// Read returns the number from the CPU.
+func (s Source) Read(c *cpu.CPU) int {
+	switch {
+	case s.register >= 0:
+		return int(c.VRegisters[s.register])
+	case s.bcd:
+		digits := c.Memory[s.address : s.address+3]
+		return int(digits[0])*100 + int(digits[1])*10 + int(digits[2])
+	}
+	return int(c.Memory[s.address])
+}
+
+func (s Source) MarshalText() ([]byte, error) {
+	return []byte(s.text), nil
+}
+
+func (s *Source) UnmarshalText(text []byte) error {
+	parsed, err := ParseSource(string(text))
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
+
+// Address is a memory address written as a string, e.g. "0x2DE".
+type Address uint16
+
+func (a Address) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("0x%03X", uint16(a))), nil
+}
+
+func (a *Address) UnmarshalText(text []byte) error {
+	address, err := strconv.ParseUint(string(text), 0, 16)
+	if err != nil || address >= cpu.RAM_SIZE {
+		return fmt.Errorf("invalid address %q", text)
+	}
+	*a = Address(address)
+	return nil
+}
+
+// Definition says how to play a ROM: the keys that make sense and where the
+// score and lives are. The reward for a step is the rise in Score, less the
+// rise in Opponent.
+type Definition struct {
+	Name string `json:"name"`
+	// The SHA-1 of the ROM, for the built in definitions.
+	SHA1 string `json:"sha1,omitempty"`
+	// A quirk spec as taken by cpu.ParseQuirks.
+	Quirks    string `json:"quirks,omitempty"`
+	IPF       int    `json:"ipf,omitempty"`
+	FrameSkip int    `json:"frame_skip,omitempty"`
+	// The keys an agent chooses from.
+	Keys     []int   `json:"keys"`
+	Score    Source  `json:"score"`
+	Opponent *Source `json:"opponent,omitempty"`
+	// The episode is done when lives drop to 0.
+	Lives *Source `json:"lives,omitempty"`
+	// The episode is done when the game reaches one of these addresses,
+	// usually the loop it halts in.
+	EndPC []Address `json:"end_pc,omitempty"`
+	// The episode is cut off after this many frames, 0 for never.
+	MaxFrames int `json:"max_frames,omitempty"`
+}
+
+//go:embed games.json
+var gamesJSON []byte
+
+// Builtin returns the definitions that come with the package, for BRIX,
+// PONG and MISSILE.
+func Builtin() ([]Definition, error) {
+	var definitions []Definition
+	if err := json.Unmarshal(gamesJSON, &definitions); err != nil {
+		return nil, err
+	}
+	return definitions, nil
+}
+
+// Lookup finds the built in definition of the ROM with the given SHA-1.
+func Lookup(hash string) (Definition, bool) {
+	definitions, _ := Builtin()
+	for _, def := range definitions {
+		if strings.EqualFold(def.SHA1, hash) {
+			return def, true
+		}
+	}
+	return Definition{}, false
+}
+
+// LoadDefinition reads a definition from a JSON file.
+func LoadDefinition(fileName string) (Definition, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return Definition{}, err
+	}
+
+	var def Definition
+	if err := json.Unmarshal(data, &def); err != nil {
+		return Definition{}, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return def, nil
+}
+
+func (def *Definition) validate() error {
+	if def.Score.text == "" {
+		return errors.New("the definition has no score")
+	}
+	if len(def.Keys) == 0 {
+		return errors.New("the definition has no keys")
+	}
+	for _, key := range def.Keys {
+		if key < 0 || key >= cpu.NUM_KEYS {
+			return fmt.Errorf("invalid key %d", key)
+		}
+	}
+	if def.IPF < 0 || def.FrameSkip < 0 || def.MaxFrames < 0 {
+		return errors.New("ipf, frame_skip and max_frames must not be negative")
+	}
+	return nil
+}
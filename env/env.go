@@ -0,0 +1,201 @@
+// Package env wraps a ROM into a reinforcement-learning environment in the
+// style of Gym: Reset starts an episode, Step holds keys for a few frames
+// and returns the screen, the reward and whether the episode is over. The
+// score, lives and end of a game come from a Definition. Serve exposes an
+// Env over JSON lines for trainers written in other languages.
+package env
+
+import (
+	"chip-8-go/cpu"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// OBSERVATION_SIZE is the length of an observation: a byte per pixel, 0 or
+// 1, row by row.
+const OBSERVATION_SIZE = cpu.SCREEN_WIDTH * cpu.SCREEN_HEIGHT
+
+var ErrNotStarted = errors.New("no episode: call Reset first")
+var ErrDone = errors.New("the episode is over: call Reset to start another")
+
+// Action holds Keys for Frames frames, the definition's frame skip when 0.
+type Action struct {
+	Keys   []int `json:"keys"`
+	Frames int   `json:"frames,omitempty"`
+}
+
+// Info is the state of the game behind the observation.
+type Info struct {
+	Frame    int `json:"frame"`
+	Score    int `json:"score"`
+	Opponent int `json:"opponent,omitempty"`
+	Lives    int `json:"lives,omitempty"`
+	// Unknown instructions the game ran into, skipped by the CPU.
+	InvalidOpCodes uint64 `json:"invalid_opcodes,omitempty"`
+}
+
+type Env struct {
+	def    Definition
+	rom    []byte
+	quirks cpu.Quirks
+
+	cpu  *cpu.CPU
+	info Info
+	done bool
+}
+
+func New(rom []byte, def Definition) (*Env, error) {
+	if err := def.validate(); err != nil {
+		return nil, err
+	}
+	if def.Quirks == "" {
+		def.Quirks = cpu.DEFAULT_QUIRKS
+	}
+	quirks, err := cpu.ParseQuirks(def.Quirks)
+	if err != nil {
+		return nil, err
+	}
+	if def.IPF == 0 {
+		def.IPF = cpu.DEFAULT_IPF
+	}
+	if def.FrameSkip == 0 {
+		def.FrameSkip = DEFAULT_FRAME_SKIP
+	}
+
+	return &Env{def: def, rom: slices.Clone(rom), quirks: quirks}, nil
+}
+
+func (e *Env) Definition() Definition {
+	return e.def
+}
+
+// Reset starts a new episode with CXNN seeded by seed.
+func (e *Env) Reset(seed int64) ([]byte, error) {
+	c := cpu.NewCPU()
+	c.Quirks = e.quirks
+	c.Seed(seed)
+	if err := c.LoadROM(e.rom, cpu.START_ADDR); err != nil {
+		return nil, err
+	}
+
+	e.cpu, e.done = c, false
+	e.info = Info{}
+	e.read()
+	return e.Observation(), nil
+}
+
+// Step holds the keys of the action, and only those, for its frames and
+// returns the screen after them, the reward earned meanwhile and whether
+// the episode is over.
+func (e *Env) Step(action Action) (observation []byte, reward float64, done bool, err error) {
+	if e.cpu == nil {
+		return nil, 0, false, ErrNotStarted
+	}
+	if e.done {
+		return nil, 0, true, ErrDone
+	}
+	frames := action.Frames
+	if frames == 0 {
+		frames = e.def.FrameSkip
+	}
+	if frames < 0 {
+		return nil, 0, false, fmt.Errorf("invalid frames %d", action.Frames)
+	}
+
+	var keys [cpu.NUM_KEYS]bool
+	for _, key := range action.Keys {
+		if key < 0 || key >= cpu.NUM_KEYS {
+			return nil, 0, false, fmt.Errorf("invalid key %d", key)
+		}
+		keys[key] = true
+	}
+
+	before := e.info
+	if err := e.run(keys, frames); err != nil {
+		// The game is broken, e.g. its stack overflowed: end the episode.
+		e.done = true
+		return e.Observation(), 0, true, err
+	}
+
+	reward = float64(e.info.Score-before.Score) - float64(e.info.Opponent-before.Opponent)
+	return e.Observation(), reward, e.done, nil
+}
+
+// run emulates frames, stopping early when the episode ends. Panics, e.g.
+// on a stack overflow, are returned as errors.
+func (e *Env) run(keys [cpu.NUM_KEYS]bool, frames int) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("the game crashed: %v", r)
+		}
+	}()
+
+	e.cpu.Keys = keys
+	for range frames {
+		if _, err := e.cpu.RunFrame(e.def.IPF); err != nil {
+			return err
+		}
+		e.info.Frame++
+
+		lives := e.info.Lives
+		e.read()
+		switch {
+		case e.def.Lives != nil && lives > 0 && e.info.Lives == 0,
+			slices.Contains(e.def.EndPC, Address(e.cpu.ProgramCounter)),
+			e.def.MaxFrames > 0 && e.info.Frame >= e.def.MaxFrames:
+			e.done = true
+			return nil
+		}
+	}
+	return nil
+}
+
+func (e *Env) read() {
+	e.info.InvalidOpCodes = e.cpu.InvalidOpCodes
+	e.info.Score = e.def.Score.Read(e.cpu)
+	if e.def.Opponent != nil {
+		e.info.Opponent = e.def.Opponent.Read(e.cpu)
+	}
+	if e.def.Lives != nil {
+		e.info.Lives = e.def.Lives.Read(e.cpu)
+	}
+}
+
+func (e *Env) Info() Info {
+	return e.info
+}
+
+// Observation returns the screen, a byte per pixel, see OBSERVATION_SIZE.
+func (e *Env) Observation() []byte {
+	observation := make([]byte, OBSERVATION_SIZE)
+	if e.cpu == nil {
+		return observation
+	}
+	for y := range cpu.SCREEN_HEIGHT {
+		for x := range cpu.SCREEN_WIDTH {
+			if e.cpu.Screen.Pixel(x, y) {
+				observation[y*cpu.SCREEN_WIDTH+x] = 1
+			}
+		}
+	}
+	return observation
+}
+
+// Render draws the screen as text, '#' for pixels that are on.
+func (e *Env) Render() string {
+	var b strings.Builder
+	observation := e.Observation()
+	for y := range cpu.SCREEN_HEIGHT {
+		for _, pixel := range observation[y*cpu.SCREEN_WIDTH : (y+1)*cpu.SCREEN_WIDTH] {
+			if pixel != 0 {
+				b.WriteByte('#')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,192 @@
+package env_test
+
+import (
+	"bytes"
+	"chip-8-go/cpu"
+	"chip-8-go/env"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newEnv(t *testing.T, name string) *env.Env {
+	rom, err := os.ReadFile("../bin/roms/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha1.Sum(rom)
+	def, ok := env.Lookup(hex.EncodeToString(sum[:]))
+	if !ok {
+		t.Fatalf("no definition for %s", name)
+	}
+	e, err := env.New(rom, def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// play steps with the keys from policy until the episode ends and returns
+// the total reward.
+func play(t *testing.T, e *env.Env, seed int64, policy func(step int) []int) (total float64, steps int) {
+	if _, err := e.Reset(seed); err != nil {
+		t.Fatal(err)
+	}
+	for steps = 0; steps < 10000; steps++ {
+		_, reward, done, err := e.Step(env.Action{Keys: policy(steps)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += reward
+		if done {
+			return total, steps + 1
+		}
+	}
+	t.Fatal("the episode did not end")
+	return total, steps
+}
+
+func TestBrix(t *testing.T) {
+	assert := assert.New(t)
+
+	e := newEnv(t, "BRIX")
+	total, _ := play(t, e, 1, func(int) []int { return nil })
+	assert.Equal(0, e.Info().Lives, "the episode should end with the last life")
+	assert.Equal(float64(e.Info().Score), total, "the rewards should add up to the score")
+
+	_, _, _, err := e.Step(env.Action{})
+	assert.ErrorIs(err, env.ErrDone, "stepping past the end")
+
+	observation, err := e.Reset(1)
+	assert.NoError(err)
+	assert.Len(observation, env.OBSERVATION_SIZE, "observation size")
+	assert.Equal(env.Info{}, e.Info(), "Reset should start over")
+
+	again, steps := play(t, e, 1, func(int) []int { return nil })
+	assert.Equal(total, again, "the same seed and actions should replay the same episode")
+	assert.Greater(steps, 10, "steps")
+}
+
+func TestMissile(t *testing.T) {
+	assert := assert.New(t)
+
+	e := newEnv(t, "MISSILE")
+	total, _ := play(t, e, 7, func(int) []int { return []int{8} })
+	assert.Equal(float64(e.Info().Score), total, "the rewards should add up to the score")
+	assert.Equal(0, e.Info().Score%5, "MISSILE scores 5 a hit")
+	assert.Equal(0, e.Info().Lives, "the episode should end with the last missile")
+}
+
+func TestPong(t *testing.T) {
+	assert := assert.New(t)
+
+	e := newEnv(t, "PONG")
+	total, steps := play(t, e, 1, func(int) []int { return nil })
+	assert.Equal(e.Definition().MaxFrames, e.Info().Frame, "PONG only ends at max_frames")
+	assert.Equal(e.Definition().MaxFrames/env.DEFAULT_FRAME_SKIP, steps, "steps")
+	assert.Equal(float64(e.Info().Score-e.Info().Opponent), total, "the opponent's points should count against the agent")
+	assert.NotZero(total, "points should have been scored")
+}
+
+func TestParseSource(t *testing.T) {
+	assert := assert.New(t)
+
+	c := cpu.NewCPU()
+	c.VRegisters[0xE] = 7
+	copy(c.Memory[0x300:], []byte{1, 2, 3})
+
+	for text, want := range map[string]int{"VE": 7, "ve": 7, "0x301": 2, "bcd:0x300": 123} {
+		source, err := env.ParseSource(text)
+		if assert.NoError(err, text) {
+			assert.Equal(want, source.Read(c), text)
+		}
+	}
+	for _, text := range []string{"VG", "V10", "0x1000", "bcd:0xFFE", "score"} {
+		_, err := env.ParseSource(text)
+		assert.Error(err, text)
+	}
+}
+
+func TestServe(t *testing.T) {
+	assert := assert.New(t)
+
+	e := newEnv(t, "BRIX")
+	requests := strings.Join([]string{
+		`{"cmd": "spec"}`,
+		`{"cmd": "reset", "seed": 2}`,
+		`{"cmd": "step", "keys": [4], "frames": 2}`,
+		`{"cmd": "render"}`,
+		`not json`,
+		`{"cmd": "close"}`,
+		`{"cmd": "spec"}`,
+	}, "\n")
+	var out bytes.Buffer
+	assert.NoError(e.Serve(strings.NewReader(requests), &out))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if !assert.Len(lines, 5, "a response per request up to close") {
+		return
+	}
+
+	var spec struct {
+		Spec env.Spec `json:"spec"`
+	}
+	assert.NoError(json.Unmarshal([]byte(lines[0]), &spec))
+	assert.Equal([]int{4, 6}, spec.Spec.Keys, "keys")
+	assert.Equal(cpu.SCREEN_WIDTH, spec.Spec.Width, "width")
+
+	var step struct {
+		Observation []byte   `json:"observation"`
+		Info        env.Info `json:"info"`
+	}
+	assert.NoError(json.Unmarshal([]byte(lines[2]), &step))
+	assert.Len(step.Observation, env.OBSERVATION_SIZE, "observation")
+	assert.Equal(2, step.Info.Frame, "frames")
+
+	var render struct {
+		Render string `json:"render"`
+	}
+	assert.NoError(json.Unmarshal([]byte(lines[3]), &render))
+	assert.Contains(render.Render, "#", "the bricks should be drawn")
+	assert.Contains(lines[4], `"error"`, "a bad request")
+}
+
+func TestServeInvalidOpCode(t *testing.T) {
+	assert := assert.New(t)
+
+	// 00E1 is not an instruction, the loop adds 1 to V0 each time round.
+	rom := []byte{0x00, 0xE1, 0x70, 0x01, 0x12, 0x00}
+	var def env.Definition
+	assert.NoError(json.Unmarshal([]byte(`{"name": "invalid", "keys": [5], "score": "V0", "ipf": 9}`), &def))
+	e, err := env.New(rom, def)
+	if !assert.NoError(err) {
+		return
+	}
+
+	requests := strings.Join([]string{
+		`{"cmd": "reset", "seed": 1}`,
+		`{"cmd": "step", "keys": [5], "frames": 1}`,
+		`{"cmd": "render"}`,
+	}, "\n")
+	var out bytes.Buffer
+	assert.NoError(e.Serve(strings.NewReader(requests), &out))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(lines, 3, "a response per request and nothing else")
+	for _, line := range lines {
+		assert.True(json.Valid([]byte(line)), "every line should be JSON: %s", line)
+	}
+
+	var step struct {
+		Reward float64  `json:"reward"`
+		Info   env.Info `json:"info"`
+	}
+	assert.NoError(json.Unmarshal([]byte(lines[1]), &step))
+	assert.Equal(uint64(3), step.Info.InvalidOpCodes, "the unknown instructions should be counted")
+	assert.Equal(3.0, step.Reward, "the game should go on past them")
+}
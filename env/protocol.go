@@ -0,0 +1,105 @@
+package env
+
+import (
+	"bufio"
+	"chip-8-go/cpu"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// The stdio protocol: a JSON request per line, answered by a JSON line.
+//
+//	{"cmd": "spec"}                          the observation size and the keys
+//	{"cmd": "reset", "seed": 1}              {"observation": ..., "info": ...}
+//	{"cmd": "step", "keys": [4], "frames": 4} {"observation": ..., "reward": 1, "done": false, "info": ...}
+//	{"cmd": "render"}                        {"render": "....#...\n..."}
+//	{"cmd": "close"}                         ends the session
+//
+// Observations are base64 encoded, see Env.Observation. Failed requests
+// are answered with {"error": "..."}.
+
+type request struct {
+	Cmd  string `json:"cmd"`
+	Seed int64  `json:"seed"`
+	Action
+}
+
+// Spec describes the environment to the trainer.
+type Spec struct {
+	Name      string `json:"name"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Keys      []int  `json:"keys"`
+	FrameSkip int    `json:"frame_skip"`
+}
+
+type response struct {
+	Spec        *Spec   `json:"spec,omitempty"`
+	Observation []byte  `json:"observation,omitempty"`
+	Reward      float64 `json:"reward"`
+	Done        bool    `json:"done"`
+	Info        *Info   `json:"info,omitempty"`
+	Render      string  `json:"render,omitempty"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// Serve answers requests from r on w until r ends or a close request.
+func (e *Env) Serve(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	encoder := json.NewEncoder(w)
+
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var req request
+		var resp response
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp.Error = fmt.Sprintf("invalid request: %v", err)
+		} else if req.Cmd == "close" {
+			return nil
+		} else {
+			resp = e.handle(req)
+		}
+
+		if err := encoder.Encode(resp); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (e *Env) handle(req request) response {
+	var resp response
+	var err error
+
+	switch req.Cmd {
+	case "spec":
+		resp.Spec = &Spec{
+			Name:      e.def.Name,
+			Width:     cpu.SCREEN_WIDTH,
+			Height:    cpu.SCREEN_HEIGHT,
+			Keys:      e.def.Keys,
+			FrameSkip: e.def.FrameSkip,
+		}
+		return resp
+	case "reset":
+		resp.Observation, err = e.Reset(req.Seed)
+	case "step":
+		resp.Observation, resp.Reward, resp.Done, err = e.Step(req.Action)
+	case "render":
+		resp.Render = e.Render()
+		return resp
+	default:
+		return response{Error: fmt.Sprintf("unknown command %q, want spec, reset, step, render or close", req.Cmd)}
+	}
+
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	info := e.Info()
+	resp.Info = &info
+	return resp
+}
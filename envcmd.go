@@ -0,0 +1,41 @@
+package main
+
+import (
+	"chip-8-go/config"
+	"chip-8-go/env"
+	"fmt"
+	"os"
+)
+
+func envCommand(args []string) error {
+	fs := newFlagSet("env")
+	definition := fs.String("def", "", "read the game `definition` from a JSON file (default: the built in one for the ROM)")
+
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	rom, err := readROM(positional[0])
+	if err != nil {
+		return err
+	}
+
+	var def env.Definition
+	if *definition != "" {
+		if def, err = env.LoadDefinition(*definition); err != nil {
+			return err
+		}
+	} else {
+		var ok bool
+		if def, ok = env.Lookup(config.HashROM(rom)); !ok {
+			return fmt.Errorf("no built in definition for %s, pass one with -def", positional[0])
+		}
+	}
+
+	e, err := env.New(rom, def)
+	if err != nil {
+		return err
+	}
+	return e.Serve(os.Stdin, os.Stdout)
+}
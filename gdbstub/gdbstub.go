@@ -0,0 +1,497 @@
+// Package gdbstub lets gdb, or any other debugger that speaks the GDB
+// remote serial protocol, debug a ROM running in the emulator. V0-VF, I,
+// PC, SP, DT and ST make up the register set and the 4 KiB of RAM the
+// address space.
+package gdbstub
+
+import (
+	"bufio"
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const DEFAULT_ADDRESS = "127.0.0.1:1234"
+
+// register describes one register of the set gdb sees, in order.
+type register struct {
+	name string
+	bits int
+	kind string
+	// Values must be below limit, when it is not 0.
+	limit uint16
+	get   func(c *cpu.CPU) uint16
+	set   func(c *cpu.CPU, value uint16)
+}
+
+var registers = func() []register {
+	var regs []register
+	for i := 0; i < cpu.NUM_REGS; i++ {
+		i := i
+		regs = append(regs, register{
+			name: fmt.Sprintf("v%x", i),
+			bits: 8,
+			kind: "uint8",
+			get:  func(c *cpu.CPU) uint16 { return uint16(c.VRegisters[i]) },
+			set:  func(c *cpu.CPU, value uint16) { c.VRegisters[i] = uint8(value) },
+		})
+	}
+
+	return append(regs,
+		register{
+			name: "i", bits: 16, kind: "data_ptr", limit: cpu.RAM_SIZE,
+			get: func(c *cpu.CPU) uint16 { return c.IndexRegister },
+			set: func(c *cpu.CPU, value uint16) { c.IndexRegister = value },
+		},
+		register{
+			// Both bytes of the instruction must be in memory.
+			name: "pc", bits: 16, kind: "code_ptr", limit: cpu.RAM_SIZE - 1,
+			get: func(c *cpu.CPU) uint16 { return c.ProgramCounter },
+			set: func(c *cpu.CPU, value uint16) { c.ProgramCounter = value },
+		},
+		register{
+			name: "sp", bits: 8, kind: "uint8",
+			get: func(c *cpu.CPU) uint16 { return c.StackPointer },
+			set: func(c *cpu.CPU, value uint16) { c.StackPointer = min(value, cpu.STACK_SIZE) },
+		},
+		register{
+			name: "dt", bits: 8, kind: "uint8",
+			get: func(c *cpu.CPU) uint16 { return uint16(c.DelayTimer) },
+			set: func(c *cpu.CPU, value uint16) { c.DelayTimer = uint8(value) },
+		},
+		register{
+			name: "st", bits: 8, kind: "uint8",
+			get: func(c *cpu.CPU) uint16 { return uint16(c.SoundTimer) },
+			set: func(c *cpu.CPU, value uint16) { c.SoundTimer = uint8(value) },
+		},
+	)
+}()
+
+// targetXML tells gdb the names and sizes of the registers.
+var targetXML = func() string {
+	var xml strings.Builder
+	xml.WriteString(`<?xml version="1.0"?>` + "\n")
+	xml.WriteString(`<!DOCTYPE target SYSTEM "gdb-target.dtd">` + "\n")
+	xml.WriteString(`<target version="1.0">` + "\n")
+	xml.WriteString(`  <feature name="org.chip8.core">` + "\n")
+	for i, reg := range registers {
+		fmt.Fprintf(&xml, `    <reg name="%s" bitsize="%d" type="%s" regnum="%d"/>`+"\n", reg.name, reg.bits, reg.kind, i)
+	}
+	xml.WriteString("  </feature>\n")
+	xml.WriteString("</target>\n")
+	return xml.String()
+}()
+
+// Stop replies: stopped by a trap (breakpoint or step) or by an interrupt.
+const (
+	stopTrap      = "T05"
+	stopBreak     = "T05swbreak:;"
+	stopInterrupt = "T02"
+)
+
+// Server debugs one emulator, for one debugger connection at a time.
+type Server struct {
+	c8    *emulator.Chip8
+	stops chan struct{}
+}
+
+func NewServer(c8 *emulator.Chip8) *Server {
+	s := &Server{
+		c8:    c8,
+		stops: make(chan struct{}, 1),
+	}
+	c8.AddBreakpointHook(func(c *cpu.CPU) {
+		select {
+		case s.stops <- struct{}{}:
+		default:
+		}
+	})
+
+	return s
+}
+
+// Listen opens a TCP listener, on the loopback interface if addr has no
+// host.
+func Listen(addr string) (net.Listener, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+
+	return net.Listen("tcp", net.JoinHostPort(host, port))
+}
+
+// Serve debugs over the connections accepted on listener, one after the
+// other, until the listener is closed.
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		s.ServeConn(conn)
+	}
+}
+
+// ServeConn pauses the emulator and debugs it over conn until the
+// debugger detaches or disconnects, which resumes it.
+func (s *Server) ServeConn(nc net.Conn) {
+	defer nc.Close()
+
+	c := &conn{r: bufio.NewReader(nc), w: nc}
+	packets := make(chan []byte)
+	go func() {
+		defer close(packets)
+		for {
+			data, err := c.read()
+			if err != nil {
+				return
+			}
+			packets <- data
+		}
+	}()
+
+	s.c8.Pause()
+	defer func() {
+		s.c8.Sync(func(c *cpu.CPU) {
+			c.ClearBreakpoints()
+		})
+		s.c8.Resume()
+	}()
+
+	running := false
+	for {
+		select {
+		case <-s.stops:
+			if running {
+				running = false
+				if c.write(stopBreak) != nil {
+					return
+				}
+			}
+
+		case data, ok := <-packets:
+			if !ok {
+				return
+			}
+
+			if len(data) == 0 {
+				// "$#00" has a valid checksum but no command.
+				if c.write("") != nil {
+					return
+				}
+				continue
+			}
+			if data[0] == interrupt {
+				if running {
+					s.c8.Pause()
+					running = false
+					if c.write(stopInterrupt) != nil {
+						return
+					}
+				}
+				continue
+			}
+
+			reply, action := s.handle(c, string(data))
+			switch action {
+			case actionContinue:
+				select {
+				case <-s.stops:
+				default:
+				}
+				s.c8.Resume()
+				running = true
+				continue
+			case actionDetach:
+				c.write(reply)
+				return
+			case actionKill:
+				return
+			}
+
+			if c.write(reply) != nil {
+				return
+			}
+		}
+	}
+}
+
+type action int
+
+const (
+	actionReply action = iota
+	actionContinue
+	actionDetach
+	actionKill
+)
+
+// handle executes one command and returns the reply. An empty reply tells
+// gdb the command is not supported.
+func (s *Server) handle(c *conn, packet string) (string, action) {
+	if packet == "" {
+		return "", actionReply
+	}
+	command, args := packet[:1], packet[1:]
+
+	switch command {
+	case "?":
+		return stopTrap, actionReply
+	case "c":
+		return "", actionContinue
+	case "s":
+		if err := s.c8.Step(1); err != nil {
+			return "E01", actionReply
+		}
+		return stopTrap, actionReply
+	case "g":
+		return s.readRegisters(), actionReply
+	case "G":
+		return s.writeRegisters(args), actionReply
+	case "p":
+		return s.readRegister(args), actionReply
+	case "P":
+		return s.writeRegister(args), actionReply
+	case "m":
+		return s.readMemory(args), actionReply
+	case "M":
+		return s.writeMemory(args, true), actionReply
+	case "X":
+		return s.writeMemory(args, false), actionReply
+	case "Z", "z":
+		return s.breakpoint(command == "Z", args), actionReply
+	case "H", "T":
+		return "OK", actionReply
+	case "D":
+		return "OK", actionDetach
+	case "k":
+		return "", actionKill
+	case "q", "Q":
+		return s.query(c, packet), actionReply
+	}
+
+	return "", actionReply
+}
+
+func (s *Server) query(c *conn, packet string) string {
+	name, args, _ := strings.Cut(packet, ":")
+
+	switch name {
+	case "qSupported":
+		return "PacketSize=1000;qXfer:features:read+;swbreak+;QStartNoAckMode+"
+	case "QStartNoAckMode":
+		c.noAck.Store(true)
+		return "OK"
+	case "qAttached":
+		return "1"
+	case "qC":
+		return "QC1"
+	case "qfThreadInfo":
+		return "m1"
+	case "qsThreadInfo":
+		return "l"
+	case "qXfer":
+		return readFeatures(args)
+	}
+
+	return ""
+}
+
+// readFeatures answers "qXfer:features:read:target.xml:offset,length".
+func readFeatures(args string) string {
+	object, rest, _ := strings.Cut(args, ":")
+	if object != "features" || !strings.HasPrefix(rest, "read:target.xml:") {
+		return ""
+	}
+
+	offset, length, ok := parseRange(strings.TrimPrefix(rest, "read:target.xml:"))
+	if !ok {
+		return "E01"
+	}
+	if offset >= len(targetXML) {
+		return "l"
+	}
+
+	end := min(offset+length, len(targetXML))
+	if end == len(targetXML) {
+		return "l" + targetXML[offset:end]
+	}
+	return "m" + targetXML[offset:end]
+}
+
+// encodeRegister writes a register little endian, in its own size.
+func encodeRegister(reg register, c *cpu.CPU) string {
+	var buf [2]byte
+	binary.LittleEndian.PutUint16(buf[:], reg.get(c))
+	return hex.EncodeToString(buf[:reg.bits/8])
+}
+
+func decodeRegister(reg register, text string) (uint16, bool) {
+	data, err := hex.DecodeString(text)
+	if err != nil || len(data) != reg.bits/8 {
+		return 0, false
+	}
+
+	var buf [2]byte
+	copy(buf[:], data)
+	value := binary.LittleEndian.Uint16(buf[:])
+	if reg.limit > 0 && value >= reg.limit {
+		return 0, false
+	}
+	return value, true
+}
+
+func (s *Server) readRegisters() string {
+	var reply strings.Builder
+	s.c8.Sync(func(c *cpu.CPU) {
+		for _, reg := range registers {
+			reply.WriteString(encodeRegister(reg, c))
+		}
+	})
+	return reply.String()
+}
+
+func (s *Server) writeRegisters(args string) string {
+	values := make([]uint16, len(registers))
+	for i, reg := range registers {
+		size := reg.bits / 4
+		if len(args) < size {
+			return "E01"
+		}
+		value, ok := decodeRegister(reg, args[:size])
+		if !ok {
+			return "E01"
+		}
+		values[i], args = value, args[size:]
+	}
+
+	s.c8.Sync(func(c *cpu.CPU) {
+		for i, reg := range registers {
+			reg.set(c, values[i])
+		}
+	})
+	return "OK"
+}
+
+func (s *Server) readRegister(args string) string {
+	n, err := strconv.ParseUint(args, 16, 8)
+	if err != nil || int(n) >= len(registers) {
+		return "E01"
+	}
+
+	var reply string
+	s.c8.Sync(func(c *cpu.CPU) {
+		reply = encodeRegister(registers[n], c)
+	})
+	return reply
+}
+
+// writeRegister answers "Pn=value".
+func (s *Server) writeRegister(args string) string {
+	number, text, _ := strings.Cut(args, "=")
+	n, err := strconv.ParseUint(number, 16, 8)
+	if err != nil || int(n) >= len(registers) {
+		return "E01"
+	}
+	value, ok := decodeRegister(registers[n], text)
+	if !ok {
+		return "E01"
+	}
+
+	s.c8.Sync(func(c *cpu.CPU) {
+		registers[n].set(c, value)
+	})
+	return "OK"
+}
+
+// readMemory answers "maddr,length". Reads past the end of RAM are cut
+// short.
+func (s *Server) readMemory(args string) string {
+	address, length, ok := parseRange(args)
+	if !ok || address >= cpu.RAM_SIZE {
+		return "E01"
+	}
+	end := min(address+length, cpu.RAM_SIZE)
+
+	var reply string
+	s.c8.Sync(func(c *cpu.CPU) {
+		reply = hex.EncodeToString(c.Memory[address:end])
+	})
+	return reply
+}
+
+// writeMemory answers "Maddr,length:hex" and "Xaddr,length:binary".
+func (s *Server) writeMemory(args string, isHex bool) string {
+	where, payload, found := strings.Cut(args, ":")
+	address, length, ok := parseRange(where)
+	if !found || !ok || address+length > cpu.RAM_SIZE {
+		return "E01"
+	}
+
+	data := []byte(payload)
+	if isHex {
+		var err error
+		if data, err = hex.DecodeString(payload); err != nil {
+			return "E01"
+		}
+	}
+	if len(data) != length {
+		return "E01"
+	}
+
+	s.c8.Sync(func(c *cpu.CPU) {
+		c.WriteMemory(uint16(address), data)
+	})
+	return "OK"
+}
+
+// breakpoint answers "Ztype,addr,kind" and "ztype,addr,kind". Software
+// and hardware breakpoints are the same thing here, watchpoints are not
+// supported.
+func (s *Server) breakpoint(insert bool, args string) string {
+	fields := strings.Split(args, ",")
+	if len(fields) < 2 || (fields[0] != "0" && fields[0] != "1") {
+		return ""
+	}
+
+	address, err := strconv.ParseUint(fields[1], 16, 16)
+	if err != nil || address >= cpu.RAM_SIZE {
+		return "E01"
+	}
+
+	s.c8.Sync(func(c *cpu.CPU) {
+		if insert {
+			c.SetBreakpoint(uint16(address))
+		} else {
+			c.ClearBreakpoint(uint16(address))
+		}
+	})
+	return "OK"
+}
+
+// parseRange reads "start,length" in hex.
+func parseRange(text string) (int, int, bool) {
+	start, length, found := strings.Cut(text, ",")
+	if !found {
+		return 0, 0, false
+	}
+
+	a, err := strconv.ParseUint(start, 16, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	n, err := strconv.ParseUint(length, 16, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return int(a), int(n), true
+}
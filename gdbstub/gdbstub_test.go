@@ -0,0 +1,149 @@
+package gdbstub_test
+
+import (
+	"bufio"
+	"chip-8-go/emulator"
+	"chip-8-go/gdbstub"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testROM = []byte{
+	0x60, 0x05, // LD V0, 5
+	0x70, 0x01, // ADD V0, 1
+	0x12, 0x02, // JP 0x202
+}
+
+// client is a minimal gdb: it sends packets and reads the replies.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newClient(t *testing.T) *client {
+	opts := emulator.DefaultOptions()
+	c8 := emulator.NewChip8(opts, nil)
+	if err := c8.LoadBytes(testROM); err != nil {
+		t.Fatal(err)
+	}
+	c8.Pause()
+
+	listener, err := gdbstub.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go gdbstub.NewServer(c8).Serve(listener)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c8.Run()
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		c8.Stop()
+		<-done
+	})
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *client) send(data string) {
+	var sum uint8
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	if _, err := fmt.Fprintf(c.conn, "$%s#%02x", data, sum); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// reply reads the next packet, skipping acks.
+func (c *client) reply() string {
+	if _, err := c.r.ReadString('$'); err != nil {
+		c.t.Fatal(err)
+	}
+	data, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := c.r.Discard(2); err != nil {
+		c.t.Fatal(err)
+	}
+	c.conn.Write([]byte("+"))
+
+	return strings.TrimSuffix(data, "#")
+}
+
+func (c *client) command(data string) string {
+	c.send(data)
+	return c.reply()
+}
+
+func TestRegistersAndMemory(t *testing.T) {
+	assert := assert.New(t)
+	c := newClient(t)
+
+	assert.Contains(c.command("qSupported:swbreak+"), "qXfer:features:read+", "qSupported")
+	assert.Contains(c.command("qXfer:features:read:target.xml:0,fff"), `<reg name="pc" bitsize="16"`, "target description")
+	assert.Equal("T05", c.command("?"), "stop reason")
+	assert.Equal("0002", c.command("p11"), "PC before stepping")
+
+	assert.Equal("T05", c.command("s"), "step")
+	assert.Equal("0202", c.command("p11"), "PC after stepping")
+	assert.Equal("05", c.command("p0"), "V0 after stepping")
+
+	regs := c.command("g")
+	assert.Equal(16*2+4+4+2+2+2, len(regs), "register block size")
+	assert.Equal("05", regs[:2], "V0 in the register block")
+
+	assert.Equal("OK", c.command("P0=2a"), "write V0")
+	assert.Equal("2a", c.command("p0"), "V0 after writing")
+	assert.Equal("E01", c.command("P10=0010"), "I outside memory")
+	assert.Equal("E01", c.command("P11=ff0f"), "PC on the last byte of memory")
+	assert.Equal("OK", c.command("P11=fe0f"), "PC on the last instruction")
+	assert.Equal("E01", c.command("G"+regs[:36]+"ff0f"+regs[40:]), "a register block with PC outside memory")
+	assert.Equal("fe0f", c.command("p11"), "PC after the rejected block")
+
+	assert.Equal("60057001", c.command("m200,4"), "read memory")
+	assert.Equal("OK", c.command("M300,2:abcd"), "write memory")
+	assert.Equal("abcd", c.command("m300,2"), "memory after writing")
+	assert.Equal("E01", c.command("m1000,1"), "read past the end of memory")
+
+	assert.Equal("OK", c.command("QStartNoAckMode"), "no-ack mode")
+	assert.Equal("l", c.command("qsThreadInfo"), "works without acks")
+	assert.Equal("", c.command("vMustReplyEmpty"), "unknown command")
+	assert.Equal("", c.command(""), "an empty packet should get an empty reply")
+	assert.Equal("T05", c.command("?"), "the server should go on after an empty packet")
+}
+
+func TestBreakpoints(t *testing.T) {
+	assert := assert.New(t)
+	c := newClient(t)
+
+	assert.Equal("OK", c.command("Z0,204,2"), "set breakpoint")
+	assert.Equal("T05swbreak:;", c.command("c"), "continue to the breakpoint")
+	assert.Equal("0402", c.command("p11"), "PC at the breakpoint")
+	assert.Equal("06", c.command("p0"), "V0 at the breakpoint")
+
+	assert.Equal("T05swbreak:;", c.command("c"), "continue around the loop")
+	assert.Equal("07", c.command("p0"), "V0 after another iteration")
+
+	assert.Equal("OK", c.command("z0,204,2"), "clear breakpoint")
+	c.send("c")
+	c.conn.Write([]byte{0x03})
+	assert.Equal("T02", c.reply(), "interrupt")
+
+	assert.Equal("OK", c.command("D"), "detach")
+}
@@ -0,0 +1,119 @@
+package gdbstub
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// The byte gdb sends outside of any packet to interrupt the target.
+const interrupt = 0x03
+
+// conn speaks the framing of the remote serial protocol: packets look
+// like "$data#checksum" and are acknowledged with "+" until no-ack mode
+// is switched on.
+type conn struct {
+	r *bufio.Reader
+	w io.Writer
+	// Set from the command loop, read by the goroutine reading packets.
+	noAck atomic.Bool
+}
+
+var errBadChecksum = errors.New("bad packet checksum")
+
+// read returns the next packet's data, or a single interrupt byte.
+func (c *conn) read() ([]byte, error) {
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch b {
+		case interrupt:
+			return []byte{interrupt}, nil
+		case '$':
+			data, readErr := c.readPacket()
+			if errors.Is(readErr, errBadChecksum) {
+				if !c.noAck.Load() {
+					io.WriteString(c.w, "-")
+				}
+				continue
+			}
+			if readErr != nil {
+				return nil, readErr
+			}
+			if !c.noAck.Load() {
+				if _, writeErr := io.WriteString(c.w, "+"); writeErr != nil {
+					return nil, writeErr
+				}
+			}
+			return data, nil
+		}
+		// Acks from gdb and line noise.
+	}
+}
+
+func (c *conn) readPacket() ([]byte, error) {
+	data, err := c.r.ReadBytes('#')
+	if err != nil {
+		return nil, err
+	}
+	data = data[:len(data)-1]
+
+	var sum [2]byte
+	if _, readErr := io.ReadFull(c.r, sum[:]); readErr != nil {
+		return nil, readErr
+	}
+
+	var expected uint8
+	if _, scanErr := fmt.Sscanf(string(sum[:]), "%02x", &expected); scanErr != nil || expected != checksum(data) {
+		return nil, errBadChecksum
+	}
+
+	return unescape(data), nil
+}
+
+// write sends a packet. gdb's acknowledgement is skipped by read.
+func (c *conn) write(data string) error {
+	_, err := fmt.Fprintf(c.w, "$%s#%02x", escape(data), checksum([]byte(escape(data))))
+	return err
+}
+
+func checksum(data []byte) uint8 {
+	var sum uint8
+	for _, b := range data {
+		sum += b
+	}
+	return sum
+}
+
+// Binary data in packets escapes '#', '$', '}' and '*' as '}' followed by
+// the byte XOR 0x20.
+func escape(data string) string {
+	var escaped []byte
+	for i := 0; i < len(data); i++ {
+		switch b := data[i]; b {
+		case '#', '$', '}', '*':
+			escaped = append(escaped, '}', b^0x20)
+		default:
+			escaped = append(escaped, b)
+		}
+	}
+	return string(escaped)
+}
+
+func unescape(data []byte) []byte {
+	var unescaped []byte
+	for i := 0; i < len(data); i++ {
+		if data[i] == '}' && i+1 < len(data) {
+			i++
+			unescaped = append(unescaped, data[i]^0x20)
+			continue
+		}
+		unescaped = append(unescaped, data[i])
+	}
+	return unescaped
+}
@@ -3,12 +3,16 @@ module chip-8-go
 go 1.22.5
 
 require (
+	github.com/gorilla/websocket v1.5.3
 	github.com/stretchr/testify v1.9.0
 	github.com/veandco/go-sdl2 v0.4.40
+	github.com/yuin/gopher-lua v1.1.1
+	golang.org/x/term v0.27.0
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+func testCommand(args []string) (err error) {
+	fs := newFlagSet("test")
+	configFlags := addConfigFlags(fs)
+	configFlags.addMachineFlags(fs)
+	seed := addSeedFlag(fs)
+	engine := addEngineFlag(fs)
+	scriptOpts := addScriptFlag(fs)
+	frames := fs.Int("frames", 300, "number of frames to run")
+	expect := fs.String("expect", "", "fail unless the final screen has this SHA-1 `hash`")
+	quiet := fs.Bool("quiet", false, "do not print the final screen")
+	traceOpts := addTraceFlags(fs)
+	profileOpts := addProfileFlags(fs)
+
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	rom, err := readROM(positional[0])
+	if err != nil {
+		return err
+	}
+	cfg, entry, err := configFlags.resolve(rom)
+	if err != nil {
+		return err
+	}
+
+	opts := cfg.Options()
+	// Test runs must be reproducible.
+	opts.Seed = 0
+	seed.apply(&opts)
+	if err := engine.apply(&opts); err != nil {
+		return err
+	}
+	if *frames < 1 {
+		return usageError{fmt.Sprintf("invalid -frames %d: must be at least 1", *frames)}
+	}
+	opts.MaxFrames = *frames
+
+	tracer, err := traceOpts.open()
+	if err != nil {
+		return err
+	}
+	if tracer != nil {
+		addTracer(&opts, tracer)
+		defer func() {
+			err = finishTrace(tracer, err, recover())
+		}()
+	}
+	if profiler := profileOpts.start(); profiler != nil {
+		addTracer(&opts, profiler)
+		defer func() {
+			if writeErr := profileOpts.write(profiler, positional[0]); err == nil {
+				err = writeErr
+			}
+		}()
+	}
+
+	s, err := scriptOpts.load(cfg, &opts)
+	if err != nil {
+		return err
+	}
+
+	c8 := emulator.NewChip8(opts, nil)
+	if loadErr := c8.LoadBytes(rom); loadErr != nil {
+		return loadErr
+	}
+	if s != nil {
+		defer s.Close()
+		if attachErr := s.Attach(c8); attachErr != nil {
+			return attachErr
+		}
+	}
+	if runErr := c8.Run(); runErr != nil {
+		return runErr
+	}
+	warnInvalidOpCodes(c8.CPU())
+	if s != nil && s.Err() != nil {
+		return s.Err()
+	}
+
+	hash := emulator.ScreenHash(c8.CPU())
+	if entry != nil {
+		fmt.Println(entry.Summary())
+	}
+	if !*quiet {
+		printScreen(os.Stdout, c8.CPU())
+	}
+	fmt.Printf("screen %s after %d frames\n", hash, c8.Frames())
+
+	if *expect != "" && *expect != hash {
+		return fmt.Errorf("screen hash mismatch: expected %s, got %s", *expect, hash)
+	}
+
+	return nil
+}
+
+func benchCommand(args []string) error {
+	fs := newFlagSet("bench")
+	configFlags := addConfigFlags(fs)
+	configFlags.addMachineFlags(fs)
+	seed := addSeedFlag(fs)
+	engine := addEngineFlag(fs)
+	frames := fs.Int("frames", 3600, "number of frames to run")
+
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	rom, err := readROM(positional[0])
+	if err != nil {
+		return err
+	}
+	cfg, _, err := configFlags.resolve(rom)
+	if err != nil {
+		return err
+	}
+
+	opts := cfg.Options()
+	seed.apply(&opts)
+	if err := engine.apply(&opts); err != nil {
+		return err
+	}
+	if *frames < 1 {
+		return usageError{fmt.Sprintf("invalid -frames %d: must be at least 1", *frames)}
+	}
+	opts.MaxFrames = *frames
+
+	c8 := emulator.NewChip8(opts, nil)
+	if loadErr := c8.LoadBytes(rom); loadErr != nil {
+		return loadErr
+	}
+
+	start := time.Now()
+	if runErr := c8.Run(); runErr != nil {
+		return runErr
+	}
+	elapsed := time.Since(start)
+
+	cycles := c8.CPU().Cycles
+	seconds := elapsed.Seconds()
+	fmt.Printf("%d frames, %d instructions in %v\n", c8.Frames(), cycles, elapsed.Round(time.Microsecond))
+	fmt.Printf("%.0f frames/s (%.1fx real time), %.2f M instructions/s\n",
+		float64(c8.Frames())/seconds,
+		float64(c8.Frames())/60/seconds,
+		float64(cycles)/seconds/1e6,
+	)
+
+	return nil
+}
+
+func printScreen(w io.Writer, c *cpu.CPU) {
+	for y := range c.Screen {
+		row := c.Screen.Row(y)
+		line := make([]byte, len(row))
+		for x, pixel := range row {
+			if pixel {
+				line[x] = '#'
+			} else {
+				line[x] = '.'
+			}
+		}
+		fmt.Fprintf(w, "%s\n", line)
+	}
+}
@@ -1,57 +1,174 @@
 package main
 
 import (
-	"chip-8-go/cpu"
-	"chip-8-go/emulator"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
-
-	"github.com/veandco/go-sdl2/sdl"
+	"strings"
 )
 
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"run", "[flags] <rom>", "Run a ROM in a window (or headless)", runCommand},
+		{"disasm", "[flags] <rom>", "Print a disassembly of a ROM", disasmCommand},
+		{"info", "[flags] <rom>", "Print size, hash, load range and database entry of a ROM", infoCommand},
+		{"test", "[flags] <rom>", "Run a ROM headless and print the final screen", testCommand},
+		{"tracediff", "[flags] <ours> <reference>", "Find where two execution traces first diverge", tracediffCommand},
+		{"dap", "[flags]", "Serve the Debug Adapter Protocol on stdin/stdout for IDEs", dapCommand},
+		{"bench", "[flags] <rom>", "Measure emulation speed on a ROM", benchCommand},
+		{"env", "[flags] <rom>", "Serve a ROM as a reinforcement-learning environment on stdin/stdout", envCommand},
+		{"batch", "[flags] <manifest>", "Run many ROM, quirk, seed and input jobs in parallel", batchCommand},
+		{"cheat", "[flags] <rom> [command]", "Manage the cheat codes of a ROM, help lists the commands", cheatCommand},
+		{"menu", "[flags] [dir]", "Browse the ROMs in a directory and play them in a window", menuCommand},
+		{"config", "dump|path [flags] [rom]", "Show the effective configuration, optionally for a ROM", configCommand},
+	}
+}
+
+// usageError marks errors caused by a bad command line; they exit with 2.
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Print("Please provide file")
-		os.Exit(0)
+	os.Exit(cli(os.Args[1:]))
+}
+
+func cli(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return 2
 	}
 
-	fileName := os.Args[1]
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		usage(os.Stdout)
+		return 0
+	}
 
-	if sdlErr := sdl.Init(sdl.INIT_EVERYTHING); sdlErr != nil {
-		panic(sdlErr)
+	cmd, ok := lookupCommand(args[0])
+	if ok {
+		args = args[1:]
+	} else if _, statErr := os.Stat(args[0]); statErr == nil || strings.HasPrefix(args[0], "-") {
+		// `chip-8-go <rom>` is shorthand for `chip-8-go run <rom>`
+		cmd, _ = lookupCommand("run")
+	} else {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+		usage(os.Stderr)
+		return 2
 	}
-	defer sdl.Quit()
 
-	var scaleModifier int32 = 10 //Adjust screen size
+	err := cmd.run(args)
 
-	window, windowErr := sdl.CreateWindow(
-		"Chip 8 - "+fileName,
-		sdl.WINDOWPOS_UNDEFINED,
-		sdl.WINDOWPOS_UNDEFINED,
-		cpu.SCREEN_WIDTH*scaleModifier,
-		cpu.SCREEN_HEIGHT*scaleModifier,
-		sdl.WINDOW_SHOWN,
-	)
-	if windowErr != nil {
-		panic(windowErr)
+	var usageErr usageError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.As(err, &usageErr):
+		if usageErr.msg != "" {
+			fmt.Fprintln(os.Stderr, usageErr.msg)
+		}
+		return 2
+	default:
+		fmt.Fprintf(os.Stderr, "%s: %v\n", cmd.name, err)
+		return 1
 	}
-	defer window.Destroy()
+}
 
-	renderer, rendererErr := sdl.CreateRenderer(window, -1, 0)
-	if rendererErr != nil {
-		panic(rendererErr)
+func lookupCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
 	}
-	defer renderer.Destroy()
+	return command{}, false
+}
 
-	c8, err := emulator.InitChip8(fileName, scaleModifier, renderer)
-	if err != nil {
-		fmt.Print(err)
-		os.Exit(1)
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "CHIP-8 emulator\n\nUsage:\n  chip-8-go <command> [flags] <rom>\n  chip-8-go <rom>\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-9s %s\n", cmd.name, cmd.summary)
 	}
+	fmt.Fprintf(w, "\nRun 'chip-8-go <command> -help' for the flags of a command.\n")
+}
 
-	runErr := c8.Run()
-	if runErr != nil {
-		fmt.Print(runErr)
-		os.Exit(1)
+func newFlagSet(name string) *flag.FlagSet {
+	cmd, _ := lookupCommand(name)
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: chip-8-go %s %s\n\n%s\n", cmd.name, cmd.args, cmd.summary)
+		if hasFlags(fs) {
+			fmt.Fprintf(fs.Output(), "\nFlags:\n")
+			fs.PrintDefaults()
+		}
+	}
+
+	return fs
+}
+
+func hasFlags(fs *flag.FlagSet) bool {
+	found := false
+	fs.VisitAll(func(*flag.Flag) { found = true })
+	return found
+}
+
+// parseArgs parses flags that may appear before or after the positional
+// arguments and checks that exactly nargs positional arguments were given.
+func parseArgs(fs *flag.FlagSet, args []string, nargs int) ([]string, error) {
+	return parseArgsRange(fs, args, nargs, nargs)
+}
+
+func parseArgsRange(fs *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	var positional []string
+
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			// The flag package has already reported the problem.
+			return nil, usageError{}
+		}
+
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	if len(positional) < min || len(positional) > max {
+		fs.SetOutput(os.Stderr)
+		fs.Usage()
+		return nil, usageError{}
 	}
+
+	return positional, nil
+}
+
+// flagWasSet reports whether name was given explicitly on the command line.
+func flagWasSet(fs *flag.FlagSet, name string) bool {
+	found := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
 }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"chip-8-go/browser"
+	"chip-8-go/config"
+	"chip-8-go/emulator"
+	"chip-8-go/romdb"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// The menu is polled and drawn at about the CHIP-8 frame rate.
+const menuFrameDuration = time.Second / emulator.DEFAULT_FRAME_RATE
+
+func menuCommand(args []string) error {
+	fs := newFlagSet("menu")
+	configFlags := addConfigFlags(fs)
+	configFlags.addMachineFlags(fs)
+	configFlags.addDisplayFlags(fs)
+
+	positional, err := parseArgsRange(fs, args, 0, 1)
+	if err != nil {
+		return err
+	}
+	dir := "."
+	if len(positional) == 1 {
+		dir = positional[0]
+	}
+
+	roms, err := scanROMs(configFlags, dir)
+	if err != nil {
+		return err
+	}
+	if len(roms) == 0 {
+		return fmt.Errorf("no ROMs in %s", dir)
+	}
+
+	recentPath, err := browser.RecentPath()
+	if err != nil {
+		return err
+	}
+	recent, err := browser.LoadRecent(recentPath)
+	if err != nil {
+		return err
+	}
+	menu := browser.NewMenu(roms, recent)
+
+	// The window follows the settings without a ROM, the games keep to it.
+	cfg, _, err := configFlags.resolve(nil)
+	if err != nil {
+		return err
+	}
+	palette, err := emulator.ParsePalette(cfg.Palette)
+	if err != nil {
+		return err
+	}
+	keys, err := cfg.Keys.Bindings()
+	if err != nil {
+		return err
+	}
+	window, renderer, closeWindow, err := openWindow("Chip 8", cfg)
+	if err != nil {
+		return err
+	}
+	defer closeWindow()
+	frontend, err := emulator.NewSDLFrontend(renderer, int32(cfg.Scale), palette, keys)
+	if err != nil {
+		return err
+	}
+
+	title := "Chip 8 - " + dir
+	for {
+		window.SetTitle(title)
+		choice, ok := showMenu(frontend, menu, title)
+		if !ok {
+			return nil
+		}
+
+		window.SetTitle("Chip 8 - " + choice.ROM.Name)
+		backToMenu, playErr := playChoice(configFlags, renderer, cfg, choice)
+		if playErr != nil {
+			return playErr
+		}
+
+		menu.Played(choice.ROM.Path)
+		if saveErr := browser.SaveRecent(recentPath, menu.Recent()); saveErr != nil {
+			fmt.Fprintf(os.Stderr, "could not save the recently played ROMs: %v\n", saveErr)
+		}
+		if !backToMenu {
+			return nil
+		}
+	}
+}
+
+// scanROMs lists the ROMs in dir, looking them up in the ROM database
+// unless that was disabled.
+func scanROMs(configFlags *configFlags, dir string) ([]browser.ROM, error) {
+	identify := func(rom []byte) *romdb.Entry { return nil }
+	if !configFlags.noROMDB {
+		db, err := configFlags.loadROMDB()
+		if err != nil {
+			return nil, err
+		}
+		identify = func(rom []byte) *romdb.Entry {
+			if entry, ok := db.Identify(rom); ok {
+				return &entry
+			}
+			return nil
+		}
+	}
+
+	return browser.Scan(dir, identify)
+}
+
+// showMenu runs the menu until a ROM is picked, or returns false when the
+// user quits.
+func showMenu(frontend *emulator.SDLFrontend, menu *browser.Menu, title string) (browser.Choice, bool) {
+	for {
+		inputs, quit := frontend.PollMenu()
+		if quit {
+			return browser.Choice{}, false
+		}
+		for _, input := range inputs {
+			if choice, ok := menu.Do(input); ok {
+				return choice, true
+			}
+		}
+
+		lines, selected := menu.Lines()
+		frontend.DrawMenu(title, lines, selected, menu.Info())
+		sdl.Delay(uint32(menuFrameDuration / time.Millisecond))
+	}
+}
+
+// playChoice runs the ROM picked in the menu, with the settings picked
+// there over its config, until the user quits or asks for the menu.
+func playChoice(configFlags *configFlags, renderer *sdl.Renderer, windowCfg config.Config, choice browser.Choice) (backToMenu bool, err error) {
+	rom, err := readROM(choice.ROM.Path)
+	if err != nil {
+		return false, err
+	}
+	cfg, _, err := configFlags.resolve(rom)
+	if err != nil {
+		return false, err
+	}
+	if choice.Quirks != browser.AUTO {
+		cfg.Quirks = choice.Quirks
+	}
+	if choice.IPF > 0 {
+		cfg.IPF = choice.IPF
+	}
+
+	palette, err := emulator.ParsePalette(cfg.Palette)
+	if err != nil {
+		return false, err
+	}
+	keys, err := cfg.Keys.Bindings()
+	if err != nil {
+		return false, err
+	}
+	frontend, err := emulator.NewSDLFrontend(renderer, int32(windowCfg.Scale), palette, keys)
+	if err != nil {
+		return false, err
+	}
+	frontend.EnableMenu()
+
+	c8 := emulator.NewChip8(cfg.Options(), frontend)
+	if loadErr := c8.LoadBytes(rom); loadErr != nil {
+		return false, loadErr
+	}
+	c8.SetROMFile(choice.ROM.Path)
+	if !cfg.Mute {
+		beeper, beeperErr := emulator.NewBeeper()
+		if beeperErr != nil {
+			return false, beeperErr
+		}
+		defer beeper.Close()
+		c8.AddAudioSink(beeper)
+	}
+
+	if runErr := c8.Run(); runErr != nil {
+		return false, runErr
+	}
+	warnInvalidOpCodes(c8.CPU())
+	return frontend.MenuRequested(), nil
+}
@@ -0,0 +1,472 @@
+// Package netplay lets two emulators play one game over TCP. Each peer
+// owns some of the CHIP-8 keys and the two machines run in lockstep: the
+// keys pressed on a frame are sent to the other side and applied by both
+// a few frames later, so both emulate exactly the same inputs.
+//
+// Every HASH_INTERVAL frames the client sends a hash of its state. If it
+// does not match the host's, the host sends its state for that frame and
+// the client replays the frames since from its input history.
+package netplay
+
+import (
+	"bufio"
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DEFAULT_DELAY = 4
+const HASH_INTERVAL = 60
+
+// Input and snapshots older than this many frames are forgotten, a resync
+// can only go back that far.
+const HISTORY_FRAMES = 10 * HASH_INTERVAL
+
+const handshakeTimeout = 30 * time.Second
+
+// Config is what both peers have to agree on. The host's wins; the client
+// only chooses its keys and proves it has the same ROM.
+type Config struct {
+	// Bit n set means the peer owns CHIP-8 key n.
+	Keys    uint16 `json:"keys"`
+	Delay   int    `json:"delay"`
+	Seed    int64  `json:"seed"`
+	Quirks  string `json:"quirks"`
+	IPF     int    `json:"ipf"`
+	ROMHash string `json:"rom_hash"`
+}
+
+type message struct {
+	Type   string     `json:"type"`
+	Frame  int        `json:"frame,omitempty"`
+	Keys   uint16     `json:"keys,omitempty"`
+	Hash   string     `json:"hash,omitempty"`
+	State  *cpu.State `json:"state,omitempty"`
+	Config *Config    `json:"config,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// Session is one side of a netplay connection.
+type Session struct {
+	conn     net.Conn
+	writer   *bufio.Writer
+	decoder  *json.Decoder
+	messages chan message
+
+	host       bool
+	config     Config
+	remoteKeys uint16
+
+	// Index of the next frame to run.
+	next       int
+	lastCycles uint64
+	// Keys held on this machine, as the wrapped frontend reports them.
+	held [cpu.NUM_KEYS]bool
+
+	local      map[int]uint16
+	remote     map[int]uint16
+	hashes     map[int]string
+	peerHashes map[int]string
+	snapshots  map[int]cpu.State
+
+	// Called on the emulation goroutine when the peers went out of sync at
+	// the given frame and were brought back in sync.
+	OnDesync func(frame int)
+}
+
+// Host waits for a client on listener and agrees on cfg with it. A host
+// without keys gets all those the client does not ask for.
+func Host(listener net.Listener, cfg Config) (*Session, error) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	s := newSession(conn, true)
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	if writeErr := s.send(message{Type: "hello", Config: &cfg}); writeErr != nil {
+		conn.Close()
+		return nil, writeErr
+	}
+
+	var join message
+	if readErr := s.decoder.Decode(&join); readErr != nil {
+		conn.Close()
+		return nil, fmt.Errorf("netplay handshake: %w", readErr)
+	}
+
+	if join.Type != "join" || join.Config == nil {
+		conn.Close()
+		return nil, fmt.Errorf("netplay handshake: unexpected %q message", join.Type)
+	}
+
+	clientKeys := join.Config.Keys
+	if cfg.Keys == 0 {
+		cfg.Keys = ^clientKeys
+	}
+
+	var refusal string
+	switch {
+	case join.Config.ROMHash != cfg.ROMHash:
+		refusal = "the peers are running different ROMs"
+	case clientKeys == 0:
+		refusal = "the client owns no keys"
+	case clientKeys&cfg.Keys != 0:
+		refusal = fmt.Sprintf("both peers own keys %s", FormatKeys(clientKeys&cfg.Keys))
+	}
+	if refusal != "" {
+		s.send(message{Type: "error", Error: refusal})
+		conn.Close()
+		return nil, errors.New(refusal)
+	}
+
+	if writeErr := s.send(message{Type: "welcome", Config: &cfg}); writeErr != nil {
+		conn.Close()
+		return nil, writeErr
+	}
+
+	s.config = cfg
+	s.remoteKeys = clientKeys
+	s.start()
+
+	return s, nil
+}
+
+// Join connects to a host at addr. It returns the session with the
+// settings of the host, which the emulator has to be set up with.
+func Join(addr string, cfg Config) (*Session, error) {
+	conn, err := net.DialTimeout("tcp", addr, handshakeTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newSession(conn, false)
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	var hello message
+	if readErr := s.decoder.Decode(&hello); readErr != nil || hello.Type != "hello" {
+		conn.Close()
+		return nil, fmt.Errorf("netplay handshake: no hello from %s", addr)
+	}
+
+	join := Config{Keys: cfg.Keys, ROMHash: cfg.ROMHash}
+	if writeErr := s.send(message{Type: "join", Config: &join}); writeErr != nil {
+		conn.Close()
+		return nil, writeErr
+	}
+
+	var welcome message
+	if readErr := s.decoder.Decode(&welcome); readErr != nil {
+		conn.Close()
+		return nil, fmt.Errorf("netplay handshake: %w", readErr)
+	}
+	if welcome.Type == "error" {
+		conn.Close()
+		return nil, fmt.Errorf("refused by host: %s", welcome.Error)
+	}
+	if welcome.Type != "welcome" || welcome.Config == nil {
+		conn.Close()
+		return nil, fmt.Errorf("netplay handshake: unexpected %q message", welcome.Type)
+	}
+
+	s.config = *welcome.Config
+	s.remoteKeys = s.config.Keys
+	s.config.Keys = cfg.Keys
+	s.start()
+
+	return s, nil
+}
+
+func newSession(conn net.Conn, host bool) *Session {
+	return &Session{
+		conn:       conn,
+		writer:     bufio.NewWriter(conn),
+		decoder:    json.NewDecoder(bufio.NewReader(conn)),
+		messages:   make(chan message, 64),
+		host:       host,
+		local:      make(map[int]uint16),
+		remote:     make(map[int]uint16),
+		hashes:     make(map[int]string),
+		peerHashes: make(map[int]string),
+		snapshots:  make(map[int]cpu.State),
+	}
+}
+
+// start begins lockstep after the handshake. The first frames run before
+// any input can have arrived, so both sides hold no keys during them.
+func (s *Session) start() {
+	s.conn.SetDeadline(time.Time{})
+	if s.config.Delay < 1 {
+		s.config.Delay = 1
+	}
+
+	s.next = 1
+	for frame := 0; frame <= s.config.Delay; frame++ {
+		s.local[frame] = 0
+		s.remote[frame] = 0
+	}
+
+	go s.readMessages()
+}
+
+// Config returns the settings both peers agreed on, with Keys being the
+// keys owned by this side.
+func (s *Session) Config() Config {
+	return s.config
+}
+
+func (s *Session) Close() error {
+	return s.conn.Close()
+}
+
+func (s *Session) readMessages() {
+	defer close(s.messages)
+
+	for {
+		var msg message
+		if err := s.decoder.Decode(&msg); err != nil {
+			return
+		}
+		s.messages <- msg
+	}
+}
+
+func (s *Session) send(msg message) error {
+	if err := json.NewEncoder(s.writer).Encode(msg); err != nil {
+		return err
+	}
+	return s.writer.Flush()
+}
+
+// Frontend wraps inner, which may be nil when running headless, so that
+// every frame runs on the inputs of both peers. The run controls of inner
+// are left out, pausing or resetting one peer only would desync them.
+func (s *Session) Frontend(inner emulator.Frontend) emulator.Frontend {
+	return &frontend{session: s, inner: inner}
+}
+
+type frontend struct {
+	session *Session
+	inner   emulator.Frontend
+}
+
+func (f *frontend) Draw(c *cpu.CPU) error {
+	if f.inner == nil {
+		return nil
+	}
+	return f.inner.Draw(c)
+}
+
+func (f *frontend) PollInput(c *cpu.CPU) bool {
+	return f.session.poll(c, f.inner)
+}
+
+// poll runs between two frames. It sends the local keys for the frame
+// Delay frames ahead, waits for the peer's keys for the next frame and
+// applies the keys of both. It reports whether to quit: the local user
+// asked to, or the connection is gone.
+func (s *Session) poll(c *cpu.CPU, inner emulator.Frontend) bool {
+	c.Keys, s.held = s.held, c.Keys
+	quit := inner != nil && inner.PollInput(c)
+	c.Keys, s.held = s.held, c.Keys
+
+	// Paused, no frame ran since the last poll.
+	if c.Cycles == s.lastCycles && s.next > 1 {
+		return quit
+	}
+	if quit {
+		return true
+	}
+
+	if s.next%HASH_INTERVAL == 0 {
+		if err := s.checkpoint(c); err != nil {
+			return true
+		}
+	}
+
+	frame := s.next + s.config.Delay
+	s.local[frame] = keyMask(s.held) & s.config.Keys
+	if err := s.send(message{Type: "input", Frame: frame, Keys: s.local[frame]}); err != nil {
+		return true
+	}
+
+	for {
+		if _, ok := s.remote[s.next]; ok {
+			break
+		}
+		msg, ok := <-s.messages
+		if !ok {
+			return true
+		}
+		if err := s.handle(c, msg, inner); err != nil {
+			return true
+		}
+	}
+
+	c.Keys = keyArray(s.inputs(s.next))
+	delete(s.local, s.next-HISTORY_FRAMES)
+	delete(s.remote, s.next-HISTORY_FRAMES)
+	s.next++
+	s.lastCycles = c.Cycles
+
+	return false
+}
+
+// inputs is the keypad for frame, combined from both peers.
+func (s *Session) inputs(frame int) uint16 {
+	return s.local[frame]&s.config.Keys | s.remote[frame]&s.remoteKeys
+}
+
+// checkpoint hashes the state after s.next frames. The client sends its
+// hash to the host, the host keeps its own along with a snapshot to
+// resync from.
+func (s *Session) checkpoint(c *cpu.CPU) error {
+	state := c.SaveState()
+	hash := stateHash(state)
+
+	if !s.host {
+		return s.send(message{Type: "hash", Frame: s.next, Hash: hash})
+	}
+
+	s.hashes[s.next] = hash
+	s.snapshots[s.next] = state
+	delete(s.snapshots, s.next-HISTORY_FRAMES)
+
+	return s.compare(s.next)
+}
+
+func (s *Session) handle(c *cpu.CPU, msg message, inner emulator.Frontend) error {
+	switch msg.Type {
+	case "input":
+		s.remote[msg.Frame] = msg.Keys
+	case "hash":
+		if s.host {
+			s.peerHashes[msg.Frame] = msg.Hash
+			return s.compare(msg.Frame)
+		}
+	case "state":
+		if !s.host && msg.State != nil {
+			return s.resync(c, msg.Frame, *msg.State, inner)
+		}
+	}
+
+	return nil
+}
+
+// compare runs on the host once both hashes of a frame are in.
+func (s *Session) compare(frame int) error {
+	hash, ok := s.hashes[frame]
+	peerHash, peerOk := s.peerHashes[frame]
+	if !ok || !peerOk {
+		return nil
+	}
+	delete(s.hashes, frame)
+	delete(s.peerHashes, frame)
+
+	if hash == peerHash {
+		return nil
+	}
+
+	state, ok := s.snapshots[frame]
+	if !ok {
+		return fmt.Errorf("desync at frame %d: no snapshot left to resync from", frame)
+	}
+	if s.OnDesync != nil {
+		s.OnDesync(frame)
+	}
+
+	return s.send(message{Type: "state", Frame: frame, State: &state})
+}
+
+// resync loads the host's state after frame frames and replays the frames
+// run since with the inputs both peers used for them.
+func (s *Session) resync(c *cpu.CPU, frame int, state cpu.State, inner emulator.Frontend) error {
+	if frame < s.next-HISTORY_FRAMES {
+		return fmt.Errorf("desync at frame %d: input history is gone", frame)
+	}
+	if err := c.LoadState(state); err != nil {
+		return err
+	}
+
+	ipf := s.config.IPF
+	for f := frame; f < s.next; f++ {
+		c.Keys = keyArray(s.inputs(f))
+		if _, err := c.RunFrame(ipf); err != nil {
+			return err
+		}
+	}
+
+	if s.OnDesync != nil {
+		s.OnDesync(frame)
+	}
+	if inner != nil {
+		return inner.Draw(c)
+	}
+
+	return nil
+}
+
+// Hash of the whole machine state, a cheap way to tell whether two peers
+// are still in sync.
+func stateHash(state cpu.State) string {
+	data, _ := json.Marshal(state)
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func keyMask(keys [cpu.NUM_KEYS]bool) uint16 {
+	var mask uint16
+	for key, pressed := range keys {
+		if pressed {
+			mask |= 1 << key
+		}
+	}
+	return mask
+}
+
+func keyArray(mask uint16) [cpu.NUM_KEYS]bool {
+	var keys [cpu.NUM_KEYS]bool
+	for key := range keys {
+		keys[key] = mask&(1<<key) != 0
+	}
+	return keys
+}
+
+// ParseKeys reads a set of CHIP-8 keys written as hex digits, e.g. "C,D"
+// or "1 4".
+func ParseKeys(text string) (uint16, error) {
+	var mask uint16
+	for _, r := range text {
+		if r == ',' || r == ' ' {
+			continue
+		}
+		key, err := strconv.ParseUint(string(r), 16, 8)
+		if err != nil {
+			return 0, fmt.Errorf("invalid key %q: must be 0-F", r)
+		}
+		mask |= 1 << key
+	}
+
+	return mask, nil
+}
+
+// FormatKeys writes a set of keys the way ParseKeys reads them.
+func FormatKeys(mask uint16) string {
+	var keys []string
+	for key := 0; key < cpu.NUM_KEYS; key++ {
+		if mask&(1<<key) != 0 {
+			keys = append(keys, fmt.Sprintf("%X", key))
+		}
+	}
+
+	return strings.Join(keys, ",")
+}
@@ -0,0 +1,179 @@
+package netplay_test
+
+import (
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"chip-8-go/netplay"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Draws random numbers and counts the frames key 5 (in V2) and key 6 (in
+// V3) are held.
+var testROM = []byte{
+	0xC0, 0xFF, // RND V0, 0xFF
+	0x61, 0x05, // LD V1, 5
+	0xE1, 0xA1, // SKNP V1
+	0x72, 0x01, // ADD V2, 1
+	0x61, 0x06, // LD V1, 6
+	0xE1, 0xA1, // SKNP V1
+	0x73, 0x01, // ADD V3, 1
+	0x12, 0x00, // JP 0x200
+}
+
+// holdKey holds key during polls [from, to).
+type holdKey struct {
+	key      uint8
+	from, to int
+	polls    int
+}
+
+func (h *holdKey) Draw(c *cpu.CPU) error {
+	return nil
+}
+
+func (h *holdKey) PollInput(c *cpu.CPU) bool {
+	h.polls++
+	c.SetKey(h.key, h.polls >= h.from && h.polls < h.to)
+	return false
+}
+
+func connect(t *testing.T, host, client netplay.Config) (*netplay.Session, *netplay.Session, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	hosted := make(chan *netplay.Session)
+	go func() {
+		session, _ := netplay.Host(listener, host)
+		hosted <- session
+	}()
+
+	joined, joinErr := netplay.Join(listener.Addr().String(), client)
+	return <-hosted, joined, joinErr
+}
+
+func newChip8(t *testing.T, session *netplay.Session, inner emulator.Frontend) *emulator.Chip8 {
+	cfg := session.Config()
+
+	opts := emulator.DefaultOptions()
+	opts.Seed = cfg.Seed
+	opts.IPF = cfg.IPF
+	opts.FrameRate = 2000
+	opts.MaxFrames = 300
+
+	c8 := emulator.NewChip8(opts, session.Frontend(inner))
+	if err := c8.LoadBytes(testROM); err != nil {
+		t.Fatal(err)
+	}
+	return c8
+}
+
+func runBoth(t *testing.T, first, second *emulator.Chip8) {
+	errs := make(chan error, 2)
+	for _, c8 := range []*emulator.Chip8{first, second} {
+		go func(c8 *emulator.Chip8) {
+			errs <- c8.Run()
+		}(c8)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLockstep(t *testing.T) {
+	assert := assert.New(t)
+
+	host, client, err := connect(t,
+		netplay.Config{Keys: 1 << 5, Delay: 3, Seed: 99, IPF: 10, ROMHash: "rom"},
+		netplay.Config{Keys: 1 << 6, ROMHash: "rom"},
+	)
+	if !assert.NoError(err) {
+		return
+	}
+	defer host.Close()
+	defer client.Close()
+
+	assert.Equal(int64(99), client.Config().Seed, "the client should get the host's settings")
+	assert.Equal(uint16(1<<6), client.Config().Keys, "the client should keep its own keys")
+
+	desyncs := 0
+	host.OnDesync = func(int) { desyncs++ }
+
+	hostC8 := newChip8(t, host, &holdKey{key: 5, from: 10, to: 50})
+	// Key 5 belongs to the host, the client pressing it must not count.
+	clientC8 := newChip8(t, client, &holdKey{key: 5, from: 100, to: 200})
+	runBoth(t, hostC8, clientC8)
+
+	hostState, clientState := hostC8.SaveState(), clientC8.SaveState()
+	assert.Equal(hostState, clientState, "both peers should end in the same state")
+	assert.NotZero(hostState.VRegisters[2], "the host's key 5 should reach both machines")
+	assert.Zero(desyncs)
+}
+
+func TestResync(t *testing.T) {
+	assert := assert.New(t)
+
+	host, client, err := connect(t,
+		netplay.Config{Delay: 2, Seed: 5, IPF: 10, ROMHash: "rom"},
+		netplay.Config{Keys: 1 << 6, ROMHash: "rom"},
+	)
+	if !assert.NoError(err) {
+		return
+	}
+	defer host.Close()
+	defer client.Close()
+	assert.Equal(^uint16(1<<6), host.Config().Keys, "the host should own the other keys")
+
+	var hostDesyncs, clientDesyncs []int
+	host.OnDesync = func(frame int) { hostDesyncs = append(hostDesyncs, frame) }
+	client.OnDesync = func(frame int) { clientDesyncs = append(clientDesyncs, frame) }
+
+	hostC8 := newChip8(t, host, &holdKey{key: 5, from: 10, to: 250})
+	clientC8 := newChip8(t, client, &holdKey{key: 6, from: 50, to: 150})
+	frames := 0
+	clientC8.AddFrameHook(func(c *cpu.CPU) {
+		if frames++; frames == 90 {
+			c.VRegisters[4] = 0x42
+		}
+	})
+	runBoth(t, hostC8, clientC8)
+
+	assert.Equal(hostC8.SaveState(), clientC8.SaveState(), "the client should have been brought back in sync")
+	assert.Equal([]int{120}, hostDesyncs, "the host should notice the desync at the next hash")
+	assert.Equal([]int{120}, clientDesyncs)
+}
+
+func TestRefuse(t *testing.T) {
+	assert := assert.New(t)
+
+	_, _, err := connect(t,
+		netplay.Config{ROMHash: "rom"},
+		netplay.Config{Keys: 1 << 6, ROMHash: "other"},
+	)
+	assert.ErrorContains(err, "different ROMs")
+
+	_, _, err = connect(t,
+		netplay.Config{Keys: 1<<6 | 1<<7, ROMHash: "rom"},
+		netplay.Config{Keys: 1 << 6, ROMHash: "rom"},
+	)
+	assert.ErrorContains(err, "both peers own keys 6")
+}
+
+func TestParseKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	mask, err := netplay.ParseKeys("C,d 1")
+	assert.NoError(err)
+	assert.Equal(uint16(1<<0xC|1<<0xD|1<<1), mask)
+	assert.Equal("1,C,D", netplay.FormatKeys(mask))
+
+	_, err = netplay.ParseKeys("G")
+	assert.Error(err)
+}
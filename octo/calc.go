@@ -0,0 +1,173 @@
+package octo
+
+import (
+	"fmt"
+	"math"
+)
+
+// Octo evaluates the expressions in :calc, :byte { } and friends right to
+// left without operator precedence, so "2 * 3 + 1" is 8. Parentheses
+// group as usual.
+
+var binaryOps = map[string]func(a, b float64) float64{
+	"+":   func(a, b float64) float64 { return a + b },
+	"-":   func(a, b float64) float64 { return a - b },
+	"*":   func(a, b float64) float64 { return a * b },
+	"/":   func(a, b float64) float64 { return a / b },
+	"%":   func(a, b float64) float64 { return math.Mod(a, b) },
+	"&":   func(a, b float64) float64 { return float64(int64(a) & int64(b)) },
+	"|":   func(a, b float64) float64 { return float64(int64(a) | int64(b)) },
+	"^":   func(a, b float64) float64 { return float64(int64(a) ^ int64(b)) },
+	"<<":  func(a, b float64) float64 { return float64(int64(a) << uint64(b)) },
+	">>":  func(a, b float64) float64 { return float64(int64(a) >> uint64(b)) },
+	"pow": math.Pow,
+	"min": math.Min,
+	"max": math.Max,
+	"<":   func(a, b float64) float64 { return boolValue(a < b) },
+	">":   func(a, b float64) float64 { return boolValue(a > b) },
+	"<=":  func(a, b float64) float64 { return boolValue(a <= b) },
+	">=":  func(a, b float64) float64 { return boolValue(a >= b) },
+	"==":  func(a, b float64) float64 { return boolValue(a == b) },
+	"!=":  func(a, b float64) float64 { return boolValue(a != b) },
+}
+
+var unaryOps = map[string]func(a float64) float64{
+	"-":     func(a float64) float64 { return -a },
+	"~":     func(a float64) float64 { return float64(^int64(a)) },
+	"!":     func(a float64) float64 { return boolValue(a == 0) },
+	"sin":   math.Sin,
+	"cos":   math.Cos,
+	"tan":   math.Tan,
+	"exp":   math.Exp,
+	"log":   math.Log,
+	"abs":   math.Abs,
+	"sqrt":  math.Sqrt,
+	"sign":  func(a float64) float64 { return boolValue(a > 0) - boolValue(a < 0) },
+	"ceil":  math.Ceil,
+	"floor": math.Floor,
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// calc reads "{ expression }" and evaluates it.
+func (a *assembler) calc() (float64, error) {
+	if err := a.expect("{"); err != nil {
+		return 0, err
+	}
+	value, err := a.expression()
+	if err != nil {
+		return 0, err
+	}
+	if err := a.expect("}"); err != nil {
+		return 0, err
+	}
+
+	return value, nil
+}
+
+func (a *assembler) expression() (float64, error) {
+	left, err := a.term()
+	if err != nil {
+		return 0, err
+	}
+
+	op, ok := binaryOps[a.peek().text]
+	if !ok || a.peek().quoted {
+		return left, nil
+	}
+	a.next()
+
+	right, err := a.expression()
+	if err != nil {
+		return 0, err
+	}
+
+	return op(left, right), nil
+}
+
+func (a *assembler) term() (float64, error) {
+	tok := a.next()
+	if tok.quoted {
+		return 0, a.errorf(tok, "unexpected string %q in expression", tok.text)
+	}
+
+	switch tok.text {
+	case "(":
+		value, err := a.expression()
+		if err != nil {
+			return 0, err
+		}
+		return value, a.expect(")")
+	case "@":
+		address, err := a.term()
+		if err != nil {
+			return 0, err
+		}
+		return float64(a.byteAt(int(address))), nil
+	case "strlen":
+		str := a.next()
+		if !str.quoted {
+			return 0, a.errorf(str, "strlen needs a string")
+		}
+		return float64(len(str.text)), nil
+	}
+
+	if op, ok := unaryOps[tok.text]; ok {
+		value, err := a.term()
+		if err != nil {
+			return 0, err
+		}
+		return op(value), nil
+	}
+
+	value, ok := a.constant(tok.text)
+	if !ok {
+		return 0, a.errorf(tok, "undefined name %q in expression", tok.text)
+	}
+
+	return value, nil
+}
+
+// constant returns the value of a number, constant or defined label.
+func (a *assembler) constant(name string) (float64, bool) {
+	if n, ok := parseNumber(name); ok {
+		return float64(n), true
+	}
+	if value, ok := a.consts[name]; ok {
+		return value, true
+	}
+	if address, ok := a.labels[name]; ok {
+		return float64(address), true
+	}
+
+	switch name {
+	case "HERE":
+		return float64(a.here), true
+	case "PI":
+		return math.Pi, true
+	case "E":
+		return math.E, true
+	}
+
+	return 0, false
+}
+
+func (a *assembler) expect(text string) error {
+	tok := a.next()
+	if tok.text != text || tok.quoted {
+		return a.errorf(tok, "expected %q, got %s", text, describe(tok))
+	}
+	return nil
+}
+
+func describe(tok token) string {
+	if tok.eof {
+		return "end of file"
+	}
+	return fmt.Sprintf("%q", tok.text)
+}
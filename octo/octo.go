@@ -0,0 +1,1272 @@
+// Package octo assembles Octo (https://github.com/JohnEarnest/Octo)
+// sources, the .8o files, into CHIP-8 ROMs. Besides the ROM it keeps the
+// labels and which source line every instruction came from, so debuggers
+// can show the source instead of a disassembly.
+//
+// The CHIP-8, SUPER-CHIP and XO-CHIP instructions are supported, as are
+// :alias, :const, :calc, :byte, :org, :next, :unpack, :pointer, :macro
+// and :stringmode.
+package octo
+
+import (
+	"chip-8-go/cpu"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Program is an assembled source.
+type Program struct {
+	// ROM is loaded at cpu.START_ADDR.
+	ROM    []byte
+	Labels map[string]uint16
+	// Lines maps the address of every instruction to its 1-based source
+	// line. Instructions from macros belong to the line using the macro.
+	Lines map[uint16]int
+}
+
+// Line returns the source line of the instruction at address.
+func (p *Program) Line(address uint16) (int, bool) {
+	line, ok := p.Lines[address]
+	return line, ok
+}
+
+// Address returns the first instruction of line, or of the next line
+// that has instructions when it has none (e.g. comments or labels), and
+// the line it belongs to.
+func (p *Program) Address(line int) (uint16, int, bool) {
+	found := false
+	var best uint16
+	bestLine := 0
+	for address, l := range p.Lines {
+		if l < line {
+			continue
+		}
+		if !found || l < bestLine || (l == bestLine && address < best) {
+			found, best, bestLine = true, address, l
+		}
+	}
+
+	return best, bestLine, found
+}
+
+// Label returns the name of the label at address, if there is one.
+func (p *Program) Label(address uint16) (string, bool) {
+	var names []string
+	for name, a := range p.Labels {
+		if a == address {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", false
+	}
+
+	sort.Strings(names)
+	return names[0], true
+}
+
+type token struct {
+	text string
+	// A "string" token, which is never a name or number.
+	quoted bool
+	line   int
+	eof    bool
+}
+
+type macro struct {
+	params []string
+	body   []token
+	calls  int
+}
+
+type stringMode struct {
+	alphabet string
+	body     []token
+}
+
+type fixupKind int
+
+const (
+	// The low 12 bits of an instruction, e.g. jump and i :=.
+	fixupAddress fixupKind = iota
+	// A full 16-bit big endian word, from :pointer and i := long.
+	fixupWord
+	// The high nibble and low byte of the two instructions of :unpack.
+	fixupUnpack
+	// The byte of a "vx := n" following :next.
+	fixupByte
+)
+
+type fixup struct {
+	at    int
+	label string
+	kind  fixupKind
+	tok   token
+}
+
+type block struct {
+	tok token
+	// Addresses of jump instructions to patch when the block ends.
+	jumps []int
+	// Start of a loop.
+	start int
+	loop  bool
+	// Whether an if block already had its else.
+	seenElse bool
+}
+
+type assembler struct {
+	file   string
+	tokens []token
+	pos    int
+
+	memory  [cpu.RAM_SIZE]byte
+	here    int
+	end     int
+	hasMain bool
+
+	labels      map[string]uint16
+	consts      map[string]float64
+	aliases     map[string]uint8
+	macros      map[string]*macro
+	stringModes map[string][]stringMode
+	fixups      []fixup
+	blocks      []block
+	lines       map[uint16]int
+	// The line of the statement being assembled.
+	line int
+	// Labels waiting for the address of the next instruction's byte.
+	nextLabels []string
+}
+
+// Assemble assembles source. file is only used in error messages.
+func Assemble(file string, source []byte) (*Program, error) {
+	a := &assembler{
+		file:        file,
+		tokens:      tokenize(string(source)),
+		here:        cpu.START_ADDR + 2,
+		labels:      make(map[string]uint16),
+		consts:      make(map[string]float64),
+		aliases:     make(map[string]uint8),
+		macros:      make(map[string]*macro),
+		stringModes: make(map[string][]stringMode),
+		lines:       make(map[uint16]int),
+	}
+	// The first instruction jumps to main, unless main comes first.
+	a.end = a.here
+
+	for !a.peek().eof {
+		if err := a.statement(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(a.blocks) > 0 {
+		b := a.blocks[len(a.blocks)-1]
+		if b.loop {
+			return nil, a.errorf(b.tok, "loop without again")
+		}
+		return nil, a.errorf(b.tok, "begin without end")
+	}
+	if len(a.nextLabels) > 0 {
+		return nil, fmt.Errorf("%s: :next %s is not followed by an instruction", file, a.nextLabels[0])
+	}
+
+	main, ok := a.labels["main"]
+	if !ok {
+		return nil, fmt.Errorf("%s: missing the main label", file)
+	}
+	if a.hasMain {
+		a.memory[cpu.START_ADDR] = 0x10 | uint8(main>>8)
+		a.memory[cpu.START_ADDR+1] = uint8(main)
+		a.lines[cpu.START_ADDR] = a.lines[main]
+	}
+
+	for _, f := range a.fixups {
+		if err := a.resolve(f); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Program{
+		ROM:    append([]byte(nil), a.memory[cpu.START_ADDR:a.end]...),
+		Labels: a.labels,
+		Lines:  a.lines,
+	}, nil
+}
+
+func tokenize(source string) []token {
+	source = strings.TrimPrefix(source, "\ufeff")
+
+	var tokens []token
+	line := 1
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(source) && source[i] != '\n' {
+				i++
+			}
+		case c == '"':
+			var text strings.Builder
+			start := line
+			for i++; i < len(source) && source[i] != '"'; i++ {
+				if source[i] == '\n' {
+					line++
+				}
+				if source[i] == '\\' && i+1 < len(source) {
+					i++
+					switch source[i] {
+					case 'n':
+						text.WriteByte('\n')
+					case 't':
+						text.WriteByte('\t')
+					case 'r':
+						text.WriteByte('\r')
+					case '0':
+						text.WriteByte(0)
+					default:
+						text.WriteByte(source[i])
+					}
+					continue
+				}
+				text.WriteByte(source[i])
+			}
+			i++
+			tokens = append(tokens, token{text: text.String(), quoted: true, line: start})
+		default:
+			start := i
+			for i < len(source) && !unicode.IsSpace(rune(source[i])) {
+				i++
+			}
+			tokens = append(tokens, token{text: source[start:i], line: line})
+		}
+	}
+
+	return tokens
+}
+
+func (a *assembler) peek() token {
+	if a.pos >= len(a.tokens) {
+		return token{eof: true, line: a.line}
+	}
+	return a.tokens[a.pos]
+}
+
+func (a *assembler) next() token {
+	tok := a.peek()
+	if !tok.eof {
+		a.pos++
+	}
+	return tok
+}
+
+func (a *assembler) errorf(tok token, format string, args ...any) error {
+	return fmt.Errorf("%s:%d: %s", a.file, tok.line, fmt.Sprintf(format, args...))
+}
+
+// name reads an identifier for a label, constant or macro.
+func (a *assembler) name() (token, error) {
+	tok := a.next()
+	if tok.eof || tok.quoted {
+		return tok, a.errorf(tok, "expected a name, got %s", describe(tok))
+	}
+	if _, isNumber := parseNumber(tok.text); isNumber {
+		return tok, a.errorf(tok, "expected a name, got the number %s", tok.text)
+	}
+	return tok, nil
+}
+
+func parseNumber(text string) (int, bool) {
+	var n int64
+	var err error
+	switch {
+	case strings.HasPrefix(text, "0x"), strings.HasPrefix(text, "0X"):
+		n, err = strconv.ParseInt(text[2:], 16, 32)
+	case strings.HasPrefix(text, "0b"), strings.HasPrefix(text, "0B"):
+		n, err = strconv.ParseInt(text[2:], 2, 32)
+	case strings.HasPrefix(text, "-0x"):
+		n, err = strconv.ParseInt(text[3:], 16, 32)
+		n = -n
+	default:
+		n, err = strconv.ParseInt(text, 10, 32)
+	}
+
+	return int(n), err == nil
+}
+
+func (a *assembler) isRegister(tok token) bool {
+	_, ok := a.registerNumber(tok)
+	return ok
+}
+
+func (a *assembler) registerNumber(tok token) (uint8, bool) {
+	if tok.quoted {
+		return 0, false
+	}
+	if n, ok := a.aliases[tok.text]; ok {
+		return n, true
+	}
+	if len(tok.text) == 2 && (tok.text[0] == 'v' || tok.text[0] == 'V') {
+		n, err := strconv.ParseUint(tok.text[1:], 16, 8)
+		return uint8(n), err == nil
+	}
+
+	return 0, false
+}
+
+func (a *assembler) register() (uint8, error) {
+	tok := a.next()
+	n, ok := a.registerNumber(tok)
+	if !ok {
+		return 0, a.errorf(tok, "expected a register, got %s", describe(tok))
+	}
+	return n, nil
+}
+
+// byteValue reads a constant that fits in a byte, signed or not.
+func (a *assembler) byteValue() (uint8, error) {
+	tok := a.peek()
+	var value float64
+	if tok.text == "{" && !tok.quoted {
+		calculated, err := a.calc()
+		if err != nil {
+			return 0, err
+		}
+		value = calculated
+	} else {
+		a.next()
+		constant, ok := a.constant(tok.text)
+		if !ok || tok.quoted {
+			return 0, a.errorf(tok, "expected a number, got %s", describe(tok))
+		}
+		value = constant
+	}
+
+	n := int(value)
+	if n < -128 || n > 255 {
+		return 0, a.errorf(tok, "%d does not fit in a byte", n)
+	}
+	return uint8(n), nil
+}
+
+// nibble reads a constant from 0 to 15.
+func (a *assembler) nibble() (uint8, error) {
+	tok := a.next()
+	n, ok := a.constant(tok.text)
+	if !ok || tok.quoted || n < 0 || n > 15 {
+		return 0, a.errorf(tok, "expected a number from 0 to 15, got %s", describe(tok))
+	}
+	return uint8(n), nil
+}
+
+// addressValue reads a 12-bit (or, when wide, 16-bit) constant or label
+// and emits it with the instruction bits in hi. Labels that are not
+// defined yet are filled in at the end.
+func (a *assembler) emitAddress(hi uint8, wide bool) error {
+	tok := a.peek()
+	limit := 0xFFF
+	if wide {
+		limit = 0xFFFF
+	}
+
+	var value int
+	if tok.text == "{" && !tok.quoted {
+		calculated, err := a.calc()
+		if err != nil {
+			return err
+		}
+		value = int(calculated)
+	} else {
+		a.next()
+		if tok.quoted || tok.eof || a.isRegister(tok) {
+			return a.errorf(tok, "expected an address, got %s", describe(tok))
+		}
+		constant, ok := a.constant(tok.text)
+		if !ok {
+			kind := fixupAddress
+			if wide {
+				kind = fixupWord
+			}
+			a.fixups = append(a.fixups, fixup{at: a.here, label: tok.text, kind: kind, tok: tok})
+			if wide {
+				return a.emit(0, 0)
+			}
+			return a.emit(hi, 0)
+		}
+		value = int(constant)
+	}
+
+	if value < 0 || value > limit {
+		return a.errorf(tok, "address 0x%X out of range", value)
+	}
+	if wide {
+		return a.emit(uint8(value>>8), uint8(value))
+	}
+	return a.emit(hi|uint8(value>>8), uint8(value))
+}
+
+func (a *assembler) resolve(f fixup) error {
+	address, ok := a.labels[f.label]
+	if !ok {
+		if value, isConst := a.consts[f.label]; isConst {
+			address = uint16(value)
+		} else {
+			return a.errorf(f.tok, "undefined name %q", f.label)
+		}
+	}
+
+	switch f.kind {
+	case fixupAddress:
+		if address > 0xFFF {
+			return a.errorf(f.tok, "address 0x%X of %s out of range", address, f.label)
+		}
+		a.memory[f.at] = a.memory[f.at]&0xF0 | uint8(address>>8)
+		a.memory[f.at+1] = uint8(address)
+	case fixupWord:
+		a.memory[f.at] = uint8(address >> 8)
+		a.memory[f.at+1] = uint8(address)
+	case fixupUnpack:
+		a.memory[f.at+1] |= uint8(address>>8) & 0x0F
+		a.memory[f.at+3] = uint8(address)
+	case fixupByte:
+		a.memory[f.at] = uint8(address)
+	}
+
+	return nil
+}
+
+func (a *assembler) byteAt(address int) uint8 {
+	if address < 0 || address >= len(a.memory) {
+		return 0
+	}
+	return a.memory[address]
+}
+
+// emit writes bytes at the current address.
+func (a *assembler) emit(data ...uint8) error {
+	if a.here+len(data) > len(a.memory) {
+		return fmt.Errorf("%s:%d: program does not fit in memory", a.file, a.line)
+	}
+	for _, label := range a.nextLabels {
+		a.labels[label] = uint16(a.here + 1)
+	}
+	a.nextLabels = nil
+
+	copy(a.memory[a.here:], data)
+	a.here += len(data)
+	a.end = max(a.end, a.here)
+	return nil
+}
+
+// inst emits a two byte instruction and maps it to the current line.
+func (a *assembler) inst(hi, lo uint8) error {
+	a.lines[uint16(a.here)] = a.line
+	return a.emit(hi, lo)
+}
+
+func (a *assembler) defineLabel(tok token) error {
+	if _, exists := a.labels[tok.text]; exists {
+		return a.errorf(tok, "%q is already defined", tok.text)
+	}
+	if tok.text == "main" && !a.hasMain {
+		// Nothing before main: start right at it, no jump needed.
+		if a.here == cpu.START_ADDR+2 && a.end == a.here {
+			a.here, a.end = cpu.START_ADDR, cpu.START_ADDR
+		} else {
+			a.hasMain = true
+		}
+	}
+	a.labels[tok.text] = uint16(a.here)
+	return nil
+}
+
+// Instructions that take no operands.
+var simple = map[string][2]uint8{
+	"clear":        {0x00, 0xE0},
+	"return":       {0x00, 0xEE},
+	";":            {0x00, 0xEE},
+	"exit":         {0x00, 0xFD},
+	"lores":        {0x00, 0xFE},
+	"hires":        {0x00, 0xFF},
+	"scroll-right": {0x00, 0xFB},
+	"scroll-left":  {0x00, 0xFC},
+	"audio":        {0xF0, 0x02},
+}
+
+// Instructions that take one register, as Fx__.
+var registerOps = map[string]uint8{
+	"bcd":       0x33,
+	"saveflags": 0x75,
+	"loadflags": 0x85,
+}
+
+func (a *assembler) statement() error {
+	tok := a.next()
+	a.line = tok.line
+	if tok.quoted {
+		return a.errorf(tok, "unexpected string %q", tok.text)
+	}
+	text := tok.text
+
+	if ops, ok := simple[text]; ok {
+		return a.inst(ops[0], ops[1])
+	}
+	if op, ok := registerOps[text]; ok {
+		x, err := a.register()
+		if err != nil {
+			return err
+		}
+		return a.inst(0xF0|x, op)
+	}
+	if a.isRegister(tok) {
+		x, _ := a.registerNumber(tok)
+		return a.assignRegister(x)
+	}
+	if m, ok := a.macros[text]; ok {
+		return a.expandMacro(tok, m)
+	}
+	if modes, ok := a.stringModes[text]; ok {
+		return a.expandString(tok, modes)
+	}
+	if n, ok := parseNumber(text); ok {
+		if n < -128 || n > 255 {
+			return a.errorf(tok, "%d does not fit in a byte", n)
+		}
+		return a.emit(uint8(n))
+	}
+
+	switch text {
+	case ":":
+		label, err := a.name()
+		if err != nil {
+			return err
+		}
+		return a.defineLabel(label)
+	case ":alias":
+		return a.alias()
+	case ":const":
+		name, err := a.name()
+		if err != nil {
+			return err
+		}
+		valueTok := a.next()
+		value, ok := a.constant(valueTok.text)
+		if !ok || valueTok.quoted {
+			return a.errorf(valueTok, "expected a number, got %s", describe(valueTok))
+		}
+		a.consts[name.text] = value
+		return nil
+	case ":calc":
+		name, err := a.name()
+		if err != nil {
+			return err
+		}
+		value, err := a.calc()
+		if err != nil {
+			return err
+		}
+		a.consts[name.text] = value
+		return nil
+	case ":byte":
+		value, err := a.byteValue()
+		if err != nil {
+			return err
+		}
+		return a.emit(value)
+	case ":org":
+		addrTok := a.peek()
+		var value float64
+		if addrTok.text == "{" {
+			calculated, err := a.calc()
+			if err != nil {
+				return err
+			}
+			value = calculated
+		} else {
+			a.next()
+			constant, ok := a.constant(addrTok.text)
+			if !ok {
+				return a.errorf(addrTok, "expected an address, got %s", describe(addrTok))
+			}
+			value = constant
+		}
+		if value < cpu.START_ADDR || value >= cpu.RAM_SIZE {
+			return a.errorf(addrTok, ":org 0x%X out of range", int(value))
+		}
+		a.here = int(value)
+		return nil
+	case ":next":
+		name, err := a.name()
+		if err != nil {
+			return err
+		}
+		if _, exists := a.labels[name.text]; exists {
+			return a.errorf(name, "%q is already defined", name.text)
+		}
+		a.nextLabels = append(a.nextLabels, name.text)
+		return nil
+	case ":unpack":
+		return a.unpack()
+	case ":pointer":
+		return a.emitAddress(0, true)
+	case ":call":
+		return a.instAddress(0x20)
+	case ":macro":
+		return a.defineMacro()
+	case ":stringmode":
+		return a.defineStringMode()
+	case ":breakpoint":
+		_, err := a.name()
+		return err
+	case ":monitor":
+		a.next()
+		a.next()
+		return nil
+	case ":assert":
+		return a.assert(tok)
+	case "jump":
+		return a.instAddress(0x10)
+	case "jump0":
+		return a.instAddress(0xB0)
+	case "native":
+		return a.instAddress(0x00)
+	case "sprite":
+		x, err := a.register()
+		if err != nil {
+			return err
+		}
+		y, err := a.register()
+		if err != nil {
+			return err
+		}
+		n, err := a.nibble()
+		if err != nil {
+			return err
+		}
+		return a.inst(0xD0|x, y<<4|n)
+	case "save", "load":
+		return a.saveLoad(text == "save")
+	case "scroll-down", "scroll-up":
+		n, err := a.nibble()
+		if err != nil {
+			return err
+		}
+		if text == "scroll-down" {
+			return a.inst(0x00, 0xC0|n)
+		}
+		return a.inst(0x00, 0xD0|n)
+	case "plane":
+		n, err := a.nibble()
+		if err != nil {
+			return err
+		}
+		return a.inst(0xF0|n, 0x01)
+	case "delay", "buzzer", "pitch":
+		if err := a.expect(":="); err != nil {
+			return err
+		}
+		x, err := a.register()
+		if err != nil {
+			return err
+		}
+		return a.inst(0xF0|x, map[string]uint8{"delay": 0x15, "buzzer": 0x18, "pitch": 0x3A}[text])
+	case "i":
+		return a.assignIndex()
+	case "if":
+		return a.ifStatement(tok)
+	case "else":
+		return a.elseStatement(tok)
+	case "end":
+		return a.endStatement(tok)
+	case "loop":
+		a.blocks = append(a.blocks, block{tok: tok, start: a.here, loop: true})
+		return nil
+	case "while":
+		return a.whileStatement(tok)
+	case "again":
+		return a.againStatement(tok)
+	case "{", "}", "then", "begin":
+		return a.errorf(tok, "unexpected %q", text)
+	}
+
+	if _, isConst := a.consts[text]; isConst {
+		return a.errorf(tok, "unexpected constant %q", text)
+	}
+	// Anything else is a subroutine call, possibly to a later label.
+	a.pos--
+	return a.instAddress(0x20)
+}
+
+func (a *assembler) instAddress(hi uint8) error {
+	a.lines[uint16(a.here)] = a.line
+	return a.emitAddress(hi, false)
+}
+
+func (a *assembler) alias() error {
+	name, err := a.name()
+	if err != nil {
+		return err
+	}
+
+	valueTok := a.peek()
+	if valueTok.text == "{" {
+		value, calcErr := a.calc()
+		if calcErr != nil {
+			return calcErr
+		}
+		if value < 0 || value > 15 {
+			return a.errorf(valueTok, "register %v out of range", value)
+		}
+		a.aliases[name.text] = uint8(value)
+		return nil
+	}
+
+	x, err := a.register()
+	if err != nil {
+		return err
+	}
+	a.aliases[name.text] = x
+	return nil
+}
+
+func (a *assembler) assert(tok token) error {
+	var message string
+	if a.peek().quoted {
+		message = a.next().text
+	}
+	value, err := a.calc()
+	if err != nil {
+		return err
+	}
+	if value == 0 {
+		if message == "" {
+			message = "assertion failed"
+		}
+		return a.errorf(tok, "%s", message)
+	}
+	return nil
+}
+
+// saveLoad handles "save vx", "load vx" and the XO-CHIP ranges
+// "save vx - vy" and "load vx - vy".
+func (a *assembler) saveLoad(save bool) error {
+	x, err := a.register()
+	if err != nil {
+		return err
+	}
+
+	if a.peek().text != "-" || !a.isRegister(a.tokenAt(a.pos+1)) {
+		if save {
+			return a.inst(0xF0|x, 0x55)
+		}
+		return a.inst(0xF0|x, 0x65)
+	}
+
+	a.next()
+	y, err := a.register()
+	if err != nil {
+		return err
+	}
+	if save {
+		return a.inst(0x50|x, y<<4|0x2)
+	}
+	return a.inst(0x50|x, y<<4|0x3)
+}
+
+func (a *assembler) tokenAt(i int) token {
+	if i >= len(a.tokens) {
+		return token{eof: true}
+	}
+	return a.tokens[i]
+}
+
+func (a *assembler) unpack() error {
+	tok := a.next()
+	if tok.text == "long" {
+		a.lines[uint16(a.here)] = a.line
+		// v0 := high byte, v1 := low byte.
+		if err := a.emit(0x60, 0x00, 0x61, 0x00); err != nil {
+			return err
+		}
+		target := a.next()
+		return a.unpackTarget(target, 0, a.here-4)
+	}
+
+	hi, ok := a.constant(tok.text)
+	if !ok || hi < 0 || hi > 15 {
+		return a.errorf(tok, "expected a number from 0 to 15, got %s", describe(tok))
+	}
+	a.lines[uint16(a.here)] = a.line
+	if err := a.emit(0x60, uint8(hi)<<4, 0x61, 0x00); err != nil {
+		return err
+	}
+	return a.unpackTarget(a.next(), uint8(hi)<<4, a.here-4)
+}
+
+func (a *assembler) unpackTarget(tok token, hi uint8, at int) error {
+	address, ok := a.constant(tok.text)
+	if !ok {
+		if tok.quoted || tok.eof {
+			return a.errorf(tok, "expected an address, got %s", describe(tok))
+		}
+		a.fixups = append(a.fixups, fixup{at: at, label: tok.text, kind: fixupUnpack, tok: tok})
+		return nil
+	}
+
+	a.memory[at+1] = hi | uint8(int(address)>>8)&0x0F
+	a.memory[at+3] = uint8(int(address))
+	return nil
+}
+
+// assignIndex handles "i := nnn", "i := long nnnn", "i := hex vx",
+// "i := bighex vx" and "i += vx".
+func (a *assembler) assignIndex() error {
+	op := a.next()
+	switch op.text {
+	case "+=":
+		x, err := a.register()
+		if err != nil {
+			return err
+		}
+		return a.inst(0xF0|x, 0x1E)
+	case ":=":
+	default:
+		return a.errorf(op, "expected := or += after i, got %s", describe(op))
+	}
+
+	switch a.peek().text {
+	case "hex", "bighex":
+		kind := a.next().text
+		x, err := a.register()
+		if err != nil {
+			return err
+		}
+		if kind == "hex" {
+			return a.inst(0xF0|x, 0x29)
+		}
+		return a.inst(0xF0|x, 0x30)
+	case "long":
+		a.next()
+		if err := a.inst(0xF0, 0x00); err != nil {
+			return err
+		}
+		return a.emitAddress(0, true)
+	}
+
+	return a.instAddress(0xA0)
+}
+
+// assignRegister handles the "vx op ..." instructions.
+func (a *assembler) assignRegister(x uint8) error {
+	op := a.next()
+
+	if op.text == ":=" {
+		switch source := a.peek(); source.text {
+		case "key":
+			a.next()
+			return a.inst(0xF0|x, 0x0A)
+		case "delay":
+			a.next()
+			return a.inst(0xF0|x, 0x07)
+		case "random":
+			a.next()
+			n, err := a.byteValue()
+			if err != nil {
+				return err
+			}
+			return a.inst(0xC0|x, n)
+		}
+	}
+
+	registerOps := map[string]uint8{":=": 0x0, "|=": 0x1, "&=": 0x2, "^=": 0x3, "+=": 0x4, "-=": 0x5, ">>=": 0x6, "=-": 0x7, "<<=": 0xE}
+	n, ok := registerOps[op.text]
+	if !ok || op.quoted {
+		return a.errorf(op, "unknown operator %s", describe(op))
+	}
+
+	if y, isRegister := a.registerNumber(a.peek()); isRegister {
+		a.next()
+		return a.inst(0x80|x, y<<4|n)
+	}
+	if op.text == ">>=" || op.text == "<<=" {
+		// A lone shift shifts the register itself.
+		return a.inst(0x80|x, x<<4|n)
+	}
+
+	switch op.text {
+	case ":=", "+=", "-=":
+	default:
+		return a.errorf(op, "%s needs a register", op.text)
+	}
+
+	if len(a.nextLabels) > 0 && op.text == ":=" {
+		// :next labels the byte of this instruction, which may hold a
+		// label that is not defined yet.
+		if tok := a.peek(); !tok.quoted && tok.text != "{" {
+			if _, isConst := a.constant(tok.text); !isConst {
+				a.next()
+				at := a.here + 1
+				a.fixups = append(a.fixups, fixup{at: at, label: tok.text, kind: fixupByte, tok: tok})
+				return a.inst(0x60|x, 0)
+			}
+		}
+	}
+
+	value, err := a.byteValue()
+	if err != nil {
+		return err
+	}
+	switch op.text {
+	case ":=":
+		return a.inst(0x60|x, value)
+	case "+=":
+		return a.inst(0x70|x, value)
+	default:
+		return a.inst(0x70|x, -value)
+	}
+}
+
+type condition struct {
+	x     uint8
+	op    string
+	y     uint8
+	value uint8
+	isReg bool
+	tok   token
+}
+
+var negations = map[string]string{
+	"==": "!=", "!=": "==",
+	"<": ">=", ">=": "<",
+	">": "<=", "<=": ">",
+	"key": "-key", "-key": "key",
+}
+
+func (a *assembler) condition() (condition, error) {
+	c := condition{tok: a.peek()}
+	x, err := a.register()
+	if err != nil {
+		return c, err
+	}
+	c.x = x
+
+	op := a.next()
+	if _, ok := negations[op.text]; !ok || op.quoted {
+		return c, a.errorf(op, "unknown comparison %s", describe(op))
+	}
+	c.op = op.text
+	if c.op == "key" || c.op == "-key" {
+		return c, nil
+	}
+
+	if y, isRegister := a.registerNumber(a.peek()); isRegister {
+		a.next()
+		c.y, c.isReg = y, true
+		return c, nil
+	}
+	c.value, err = a.byteValue()
+	return c, err
+}
+
+// emitCondition emits the instructions that skip the next one unless the
+// condition (or, when negated, its opposite) holds.
+func (a *assembler) emitCondition(c condition, negated bool) error {
+	op := c.op
+	if negated {
+		op = negations[op]
+	}
+
+	switch op {
+	case "==":
+		if c.isReg {
+			return a.inst(0x90|c.x, c.y<<4)
+		}
+		return a.inst(0x40|c.x, c.value)
+	case "!=":
+		if c.isReg {
+			return a.inst(0x50|c.x, c.y<<4)
+		}
+		return a.inst(0x30|c.x, c.value)
+	case "key":
+		return a.inst(0xE0|c.x, 0xA1)
+	case "-key":
+		return a.inst(0xE0|c.x, 0x9E)
+	}
+
+	// The other comparisons subtract in VF and test the borrow flag that
+	// replaces the difference: VF := n; VF -= vx leaves 0 when vx > n.
+	var err error
+	if c.isReg {
+		err = a.inst(0x8F, c.y<<4)
+	} else {
+		err = a.inst(0x6F, c.value)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch op {
+	case ">":
+		if err := a.inst(0x8F, c.x<<4|0x5); err != nil {
+			return err
+		}
+		return a.inst(0x4F, 0x00)
+	case "<":
+		if err := a.inst(0x8F, c.x<<4|0x7); err != nil {
+			return err
+		}
+		return a.inst(0x4F, 0x00)
+	case ">=":
+		if err := a.inst(0x8F, c.x<<4|0x7); err != nil {
+			return err
+		}
+		return a.inst(0x3F, 0x00)
+	default:
+		if err := a.inst(0x8F, c.x<<4|0x5); err != nil {
+			return err
+		}
+		return a.inst(0x3F, 0x00)
+	}
+}
+
+func (a *assembler) ifStatement(tok token) error {
+	c, err := a.condition()
+	if err != nil {
+		return err
+	}
+
+	switch then := a.next(); then.text {
+	case "then":
+		return a.emitCondition(c, false)
+	case "begin":
+		if err := a.emitCondition(c, true); err != nil {
+			return err
+		}
+		a.blocks = append(a.blocks, block{tok: tok, jumps: []int{a.here}})
+		return a.inst(0x10, 0x00)
+	default:
+		return a.errorf(then, "expected then or begin, got %s", describe(then))
+	}
+}
+
+func (a *assembler) elseStatement(tok token) error {
+	if len(a.blocks) == 0 || a.blocks[len(a.blocks)-1].loop || a.blocks[len(a.blocks)-1].seenElse {
+		return a.errorf(tok, "else without if ... begin")
+	}
+	b := &a.blocks[len(a.blocks)-1]
+
+	jumpToEnd := a.here
+	if err := a.inst(0x10, 0x00); err != nil {
+		return err
+	}
+	a.patchJumps(b.jumps)
+	b.jumps = []int{jumpToEnd}
+	b.seenElse = true
+	return nil
+}
+
+func (a *assembler) endStatement(tok token) error {
+	if len(a.blocks) == 0 || a.blocks[len(a.blocks)-1].loop {
+		return a.errorf(tok, "end without if ... begin")
+	}
+	b := a.blocks[len(a.blocks)-1]
+	a.blocks = a.blocks[:len(a.blocks)-1]
+
+	a.patchJumps(b.jumps)
+	return nil
+}
+
+func (a *assembler) whileStatement(tok token) error {
+	loop := -1
+	for i := len(a.blocks) - 1; i >= 0; i-- {
+		if a.blocks[i].loop {
+			loop = i
+			break
+		}
+	}
+	if loop < 0 {
+		return a.errorf(tok, "while outside of loop")
+	}
+
+	c, err := a.condition()
+	if err != nil {
+		return err
+	}
+	if err := a.emitCondition(c, true); err != nil {
+		return err
+	}
+	a.blocks[loop].jumps = append(a.blocks[loop].jumps, a.here)
+	return a.inst(0x10, 0x00)
+}
+
+func (a *assembler) againStatement(tok token) error {
+	if len(a.blocks) == 0 || !a.blocks[len(a.blocks)-1].loop {
+		return a.errorf(tok, "again without loop")
+	}
+	b := a.blocks[len(a.blocks)-1]
+	a.blocks = a.blocks[:len(a.blocks)-1]
+
+	if err := a.inst(0x10|uint8(b.start>>8), uint8(b.start)); err != nil {
+		return err
+	}
+	a.patchJumps(b.jumps)
+	return nil
+}
+
+// patchJumps points the jump instructions at addresses to here.
+func (a *assembler) patchJumps(addresses []int) {
+	for _, at := range addresses {
+		a.memory[at] = 0x10 | uint8(a.here>>8)
+		a.memory[at+1] = uint8(a.here)
+	}
+}
+
+// body reads "{ ... }" with nested braces and returns what is inside.
+func (a *assembler) body() ([]token, error) {
+	if err := a.expect("{"); err != nil {
+		return nil, err
+	}
+
+	var tokens []token
+	for depth := 1; ; {
+		tok := a.next()
+		if tok.eof {
+			return nil, a.errorf(tok, "missing }")
+		}
+		if !tok.quoted {
+			switch tok.text {
+			case "{":
+				depth++
+			case "}":
+				depth--
+			}
+		}
+		if depth == 0 {
+			return tokens, nil
+		}
+		tokens = append(tokens, tok)
+	}
+}
+
+func (a *assembler) defineMacro() error {
+	name, err := a.name()
+	if err != nil {
+		return err
+	}
+
+	m := &macro{}
+	for a.peek().text != "{" || a.peek().quoted {
+		param, paramErr := a.name()
+		if paramErr != nil {
+			return paramErr
+		}
+		m.params = append(m.params, param.text)
+	}
+	if m.body, err = a.body(); err != nil {
+		return err
+	}
+
+	a.macros[name.text] = m
+	return nil
+}
+
+// splice inserts tokens to be read next. They all get the line of at, so
+// the instructions they make belong to the line that used the macro.
+func (a *assembler) splice(at token, tokens []token) {
+	expanded := make([]token, len(tokens))
+	for i, tok := range tokens {
+		tok.line = at.line
+		expanded[i] = tok
+	}
+
+	rest := append(expanded, a.tokens[a.pos:]...)
+	a.tokens = append(a.tokens[:a.pos], rest...)
+}
+
+func substitute(body []token, args map[string]token) []token {
+	expanded := make([]token, len(body))
+	for i, tok := range body {
+		if arg, ok := args[tok.text]; ok && !tok.quoted {
+			tok = token{text: arg.text, quoted: arg.quoted}
+		}
+		expanded[i] = tok
+	}
+	return expanded
+}
+
+func (a *assembler) expandMacro(at token, m *macro) error {
+	args := map[string]token{"CALLS": {text: strconv.Itoa(m.calls)}}
+	m.calls++
+	for _, param := range m.params {
+		arg := a.next()
+		if arg.eof {
+			return a.errorf(at, "not enough arguments for macro %s", at.text)
+		}
+		args[param] = arg
+	}
+
+	a.splice(at, substitute(m.body, args))
+	return nil
+}
+
+func (a *assembler) defineStringMode() error {
+	name, err := a.name()
+	if err != nil {
+		return err
+	}
+	alphabet := a.next()
+	if !alphabet.quoted {
+		return a.errorf(alphabet, "expected the alphabet string, got %s", describe(alphabet))
+	}
+	body, err := a.body()
+	if err != nil {
+		return err
+	}
+
+	a.stringModes[name.text] = append(a.stringModes[name.text], stringMode{alphabet: alphabet.text, body: body})
+	return nil
+}
+
+// expandString expands a string mode's body for every character of the
+// string that follows, with CHAR (its code), INDEX (its position in the
+// string) and VALUE (its position in the alphabet) defined.
+func (a *assembler) expandString(at token, modes []stringMode) error {
+	str := a.next()
+	if !str.quoted {
+		return a.errorf(str, "expected a string, got %s", describe(str))
+	}
+
+	var tokens []token
+	for index := 0; index < len(str.text); index++ {
+		char := str.text[index]
+		found := false
+		for _, mode := range modes {
+			value := strings.IndexByte(mode.alphabet, char)
+			if value < 0 {
+				continue
+			}
+			tokens = append(tokens, substitute(mode.body, map[string]token{
+				"CHAR":  {text: strconv.Itoa(int(char))},
+				"INDEX": {text: strconv.Itoa(index)},
+				"VALUE": {text: strconv.Itoa(value)},
+			})...)
+			found = true
+			break
+		}
+		if !found {
+			return a.errorf(str, "%s has no character %q", at.text, char)
+		}
+	}
+
+	a.splice(at, tokens)
+	return nil
+}
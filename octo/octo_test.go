@@ -0,0 +1,94 @@
+package octo_test
+
+import (
+	"chip-8-go/octo"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The test ROMs come with their Octo sources.
+func TestAssembleTestROMs(t *testing.T) {
+	sources, err := filepath.Glob("../bin/tests/*.8o")
+	if err != nil || len(sources) == 0 {
+		t.Fatal("no test sources found", err)
+	}
+
+	for _, source := range sources {
+		t.Run(filepath.Base(source), func(t *testing.T) {
+			assert := assert.New(t)
+
+			text, err := os.ReadFile(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected, err := os.ReadFile(strings.TrimSuffix(source, ".8o") + ".ch8")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			program, err := octo.Assemble(source, text)
+			if assert.NoError(err) {
+				assert.Equal(expected, program.ROM, "the ROM should match the one assembled by Octo")
+			}
+		})
+	}
+}
+
+func TestLines(t *testing.T) {
+	assert := assert.New(t)
+
+	program, err := octo.Assemble("test.8o", []byte(`:macro bump X {
+  X += 1
+  X += 1
+}
+
+: main     # line 6
+  clear
+  loop
+    bump v0
+    # nothing here
+    if v0 == 10 then
+      jump main
+  again
+`))
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal([]byte{0x00, 0xE0, 0x70, 0x01, 0x70, 0x01, 0x40, 0x0A, 0x12, 0x00, 0x12, 0x02}, program.ROM, "ROM")
+	assert.Equal(uint16(0x200), program.Labels["main"], "main label")
+
+	line, ok := program.Line(0x204)
+	assert.True(ok, "the second instruction of a macro should have a line")
+	assert.Equal(9, line, "macro instructions should belong to the line using the macro")
+
+	address, line, ok := program.Address(10)
+	assert.True(ok, "a line without instructions should map to the next one")
+	assert.Equal(uint16(0x206), address, "address of the if")
+	assert.Equal(11, line, "line of the if")
+
+	_, _, ok = program.Address(20)
+	assert.False(ok, "there should be no instructions after the end")
+}
+
+func TestErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	for source, message := range map[string]string{
+		": main\n  jump nowhere":         "test.8o:2: undefined name \"nowhere\"",
+		": main\n  v0 := 300":            "test.8o:2: 300 does not fit in a byte",
+		": main\n  loop\n  v0 += 1":      "test.8o:2: loop without again",
+		": start\n  clear":               "test.8o: missing the main label",
+		": main\n: main":                 "test.8o:2: \"main\" is already defined",
+		": main\n  if v0 == 1 jump main": "test.8o:2: expected then or begin, got \"jump\"",
+	} {
+		_, err := octo.Assemble("test.8o", []byte(source))
+		if assert.Error(err, source) {
+			assert.Equal(message, err.Error(), source)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package profile
+
+import (
+	"chip-8-go/cpu"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+)
+
+const (
+	// The heatmap shows memory as rows of HEATMAP_COLUMNS bytes, each a
+	// HEATMAP_SCALE pixel square.
+	HEATMAP_COLUMNS = 64
+	HEATMAP_SCALE   = 8
+)
+
+// Heatmap draws how often the instruction at each address of the 4 KiB of
+// memory ran, from dark blue for never over red to white for the hottest,
+// on a log scale. Both bytes of an instruction are coloured.
+func (p *Profiler) Heatmap() *image.RGBA {
+	rows := cpu.RAM_SIZE / HEATMAP_COLUMNS
+	img := image.NewRGBA(image.Rect(0, 0, HEATMAP_COLUMNS*HEATMAP_SCALE, rows*HEATMAP_SCALE))
+
+	var heat [cpu.RAM_SIZE]uint64
+	var hottest uint64
+	for address, count := range p.counts {
+		heat[address] += count
+		heat[(address+1)%cpu.RAM_SIZE] += count
+		hottest = max(hottest, count)
+	}
+
+	for address, count := range heat {
+		c := heatColor(count, hottest)
+		x := address % HEATMAP_COLUMNS * HEATMAP_SCALE
+		y := address / HEATMAP_COLUMNS * HEATMAP_SCALE
+		for dy := 0; dy < HEATMAP_SCALE; dy++ {
+			for dx := 0; dx < HEATMAP_SCALE; dx++ {
+				img.SetRGBA(x+dx, y+dy, c)
+			}
+		}
+	}
+
+	return img
+}
+
+func heatColor(count, hottest uint64) color.RGBA {
+	if count == 0 {
+		return color.RGBA{0x10, 0x10, 0x30, 0xFF}
+	}
+
+	heat := 1.0
+	if hottest > 1 {
+		heat = math.Log(float64(count)) / math.Log(float64(hottest))
+	}
+	heat = min(heat, 1)
+
+	// Black through red and yellow to white.
+	channel := func(from float64) uint8 {
+		return uint8(255 * min(max((heat-from)*3, 0), 1))
+	}
+	return color.RGBA{max(channel(0), 0x40), channel(1.0 / 3), channel(2.0 / 3), 0xFF}
+}
+
+func (p *Profiler) WriteHeatmap(w io.Writer) error {
+	return png.Encode(w, p.Heatmap())
+}
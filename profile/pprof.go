@@ -0,0 +1,163 @@
+package profile
+
+import (
+	"chip-8-go/cpu"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// WritePprof writes the samples as a gzipped pprof profile, for
+// `go tool pprof`, with ROM addresses for locations and the call stack of
+// each instruction. Routines are named after where they start: "main"
+// for where execution began and e.g. "sub_0x2A0" for subroutines.
+func (p *Profiler) WritePprof(w io.Writer, romName string) error {
+	var strings []string
+	index := make(map[string]int64)
+	str := func(s string) int64 {
+		if i, ok := index[s]; ok {
+			return i
+		}
+		index[s] = int64(len(strings))
+		strings = append(strings, s)
+		return index[s]
+	}
+	str("")
+
+	var profile protobuf
+	valueType := func(field int, kind, unit string) {
+		var vt protobuf
+		vt.int(1, str(kind))
+		vt.int(2, str(unit))
+		profile.message(field, vt)
+	}
+	valueType(1, "instructions", "count")
+
+	// Functions, one per routine.
+	filename := str(romName)
+	functions := make(map[uint16]int64)
+	function := func(routine uint16) int64 {
+		if id, ok := functions[routine]; ok {
+			return id
+		}
+		id := int64(len(functions) + 1)
+		functions[routine] = id
+
+		name := fmt.Sprintf("sub_0x%03X", routine)
+		if routine == p.start {
+			name = "main"
+		}
+		var f protobuf
+		f.int(1, id)
+		f.int(2, str(name))
+		f.int(3, str(name))
+		f.int(4, filename)
+		f.int(5, int64(routine))
+		profile.message(5, f)
+		return id
+	}
+
+	// Locations, one per address, and the samples.
+	var mapping protobuf
+	mapping.int(1, 1)
+	mapping.int(3, cpu.RAM_SIZE)
+	mapping.int(5, filename)
+	mapping.int(7, 1)
+	profile.message(3, mapping)
+
+	locations := make(map[frame]uint64)
+	location := func(f frame) uint64 {
+		if id, ok := locations[f]; ok {
+			return id
+		}
+		id := uint64(len(locations) + 1)
+		locations[f] = id
+
+		var line, loc protobuf
+		line.int(1, function(f.routine))
+		line.int(2, int64(f.address))
+		loc.int(1, int64(id))
+		loc.int(2, 1)
+		loc.int(3, int64(f.address))
+		loc.message(4, line)
+		profile.message(4, loc)
+		return id
+	}
+
+	samples := make([]stack, 0, len(p.stacks))
+	for s := range p.stacks {
+		samples = append(samples, s)
+	}
+	sort.Slice(samples, func(i, j int) bool { return lessStack(samples[i], samples[j]) })
+	for _, s := range samples {
+		ids := make([]uint64, 0, s.depth+1)
+		for _, f := range s.frames[:s.depth+1] {
+			ids = append(ids, location(f))
+		}
+		var sample protobuf
+		sample.packed(1, ids)
+		sample.packed(2, []uint64{p.stacks[s]})
+		profile.message(2, sample)
+	}
+
+	valueType(11, "instructions", "count")
+	profile.int(12, 1)
+	for _, s := range strings {
+		profile.bytes(6, []byte(s))
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(profile.buf); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func lessStack(a, b stack) bool {
+	for i := 0; i <= int(min(a.depth, b.depth)); i++ {
+		if a.frames[i] != b.frames[i] {
+			if a.frames[i].address != b.frames[i].address {
+				return a.frames[i].address < b.frames[i].address
+			}
+			return a.frames[i].routine < b.frames[i].routine
+		}
+	}
+	return a.depth < b.depth
+}
+
+// protobuf encodes just the wire types the profile format needs.
+type protobuf struct {
+	buf []byte
+}
+
+func (pb *protobuf) varint(v uint64) {
+	for v >= 0x80 {
+		pb.buf = append(pb.buf, byte(v)|0x80)
+		v >>= 7
+	}
+	pb.buf = append(pb.buf, byte(v))
+}
+
+func (pb *protobuf) int(field int, v int64) {
+	pb.varint(uint64(field) << 3)
+	pb.varint(uint64(v))
+}
+
+func (pb *protobuf) bytes(field int, b []byte) {
+	pb.varint(uint64(field)<<3 | 2)
+	pb.varint(uint64(len(b)))
+	pb.buf = append(pb.buf, b...)
+}
+
+func (pb *protobuf) message(field int, m protobuf) {
+	pb.bytes(field, m.buf)
+}
+
+func (pb *protobuf) packed(field int, values []uint64) {
+	var packed protobuf
+	for _, v := range values {
+		packed.varint(v)
+	}
+	pb.bytes(field, packed.buf)
+}
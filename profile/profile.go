@@ -0,0 +1,248 @@
+// Package profile counts the instructions a ROM executes, by opcode and
+// by address, to show which instructions it uses and where its time goes:
+// hot loops and time spent waiting for a key or the delay timer.
+package profile
+
+import (
+	"chip-8-go/cpu"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// Loops at most this many instructions long that read the delay timer
+// count as waiting for it.
+const MAX_WAIT_LOOP = 8
+
+// frame is an address and the routine it belongs to, by its start.
+type frame struct {
+	address, routine uint16
+}
+
+// stack is a sample's frame followed by the call sites it is under,
+// innermost first.
+type stack struct {
+	depth  uint8
+	frames [cpu.STACK_SIZE + 1]frame
+}
+
+// Profiler is a cpu.Tracer counting what the CPU executes.
+type Profiler struct {
+	total   uint64
+	frames  int
+	counts  [cpu.RAM_SIZE]uint64
+	ops     [cpu.RAM_SIZE]cpu.OpCode
+	opcodes map[cpu.OpCode]uint64
+	// Jumps back, keyed by [to, from].
+	loops map[[2]uint16]uint64
+	// FX0A executions that did not get a key.
+	keyWaits uint64
+
+	// The routine running and the call sites under it.
+	routine uint16
+	calls   []frame
+	stacks  map[stack]uint64
+
+	start   uint16
+	last    cpu.TraceEntry
+	started bool
+}
+
+func New() *Profiler {
+	return &Profiler{
+		opcodes: make(map[cpu.OpCode]uint64),
+		loops:   make(map[[2]uint16]uint64),
+		stacks:  make(map[stack]uint64),
+	}
+}
+
+func (p *Profiler) TraceFrame() {
+	p.frames++
+}
+
+func (p *Profiler) TraceInstruction(entry *cpu.TraceEntry) {
+	pc := entry.PC % cpu.RAM_SIZE
+	if !p.started {
+		p.start, p.routine, p.started = pc, pc, true
+	} else {
+		p.follow(entry.PC)
+	}
+
+	p.total++
+	p.counts[pc]++
+	p.ops[pc] = entry.OpCode
+	p.opcodes[entry.OpCode]++
+
+	s := stack{frames: [cpu.STACK_SIZE + 1]frame{{pc, p.routine}}}
+	for i := len(p.calls) - 1; i >= 0; i-- {
+		s.depth++
+		s.frames[s.depth] = p.calls[i]
+	}
+	p.stacks[s]++
+
+	switch op := uint16(entry.OpCode); {
+	case op>>12 == 0x2 && len(p.calls) < cpu.STACK_SIZE:
+		p.calls = append(p.calls, frame{pc, p.routine})
+		p.routine = op & 0x0FFF
+	case op == 0x00EE && len(p.calls) > 0:
+		p.routine = p.calls[len(p.calls)-1].routine
+		p.calls = p.calls[:len(p.calls)-1]
+	}
+
+	p.last = *entry
+}
+
+// follow looks at where the last instruction went.
+func (p *Profiler) follow(pc uint16) {
+	last := uint16(p.last.OpCode)
+	switch {
+	case last&0xF0FF == 0xF00A && pc == p.last.PC:
+		p.keyWaits++
+	case (last>>12 == 0x1 || last>>12 == 0xB) && pc <= p.last.PC:
+		p.loops[[2]uint16{pc, p.last.PC}]++
+	}
+}
+
+// Total returns the number of instructions executed.
+func (p *Profiler) Total() uint64 {
+	return p.total
+}
+
+// Count returns how many times the instruction at address was executed.
+func (p *Profiler) Count(address uint16) uint64 {
+	return p.counts[address%cpu.RAM_SIZE]
+}
+
+// Class counts the executions of one kind of instruction.
+type Class struct {
+	Pattern string
+	Count   uint64
+}
+
+// Classes returns the kinds of instructions executed, most used first.
+func (p *Profiler) Classes() []Class {
+	counts := make(map[string]uint64)
+	for op, count := range p.opcodes {
+		counts[op.Pattern()] += count
+	}
+
+	classes := make([]Class, 0, len(counts))
+	for pattern, count := range counts {
+		classes = append(classes, Class{pattern, count})
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		if classes[i].Count != classes[j].Count {
+			return classes[i].Count > classes[j].Count
+		}
+		return classes[i].Pattern < classes[j].Pattern
+	})
+
+	return classes
+}
+
+// Loop is a jump back from End to Start.
+type Loop struct {
+	Start, End uint16
+	Iterations uint64
+	// Instructions executed between Start and End, nested loops included.
+	Instructions uint64
+	// Whether the loop waits for the delay timer.
+	DelayWait bool
+}
+
+// Loops returns the loops, the ones executing the most instructions first.
+func (p *Profiler) Loops() []Loop {
+	loops := make([]Loop, 0, len(p.loops))
+	for edge, iterations := range p.loops {
+		loop := Loop{Start: edge[0], End: edge[1], Iterations: iterations}
+
+		length, readsTimer := 0, false
+		for address := loop.Start; address <= loop.End; address++ {
+			if p.counts[address] == 0 {
+				continue
+			}
+			loop.Instructions += p.counts[address]
+			length++
+			readsTimer = readsTimer || uint16(p.ops[address])&0xF0FF == 0xF007
+		}
+		loop.DelayWait = readsTimer && length <= MAX_WAIT_LOOP
+
+		loops = append(loops, loop)
+	}
+	sort.Slice(loops, func(i, j int) bool {
+		if loops[i].Instructions != loops[j].Instructions {
+			return loops[i].Instructions > loops[j].Instructions
+		}
+		return loops[i].Start < loops[j].Start
+	})
+
+	return loops
+}
+
+// KeyWaits returns the number of FX0A executions spent waiting for a key.
+func (p *Profiler) KeyWaits() uint64 {
+	return p.keyWaits
+}
+
+// DelayWaits returns the number of instructions spent in loops waiting
+// for the delay timer.
+func (p *Profiler) DelayWaits() uint64 {
+	var waits uint64
+	for _, loop := range p.Loops() {
+		if loop.DelayWait {
+			waits += loop.Instructions
+		}
+	}
+	return waits
+}
+
+func (p *Profiler) percent(count uint64) float64 {
+	if p.total == 0 {
+		return 0
+	}
+	return 100 * float64(count) / float64(p.total)
+}
+
+// WriteReport writes a text report with the top entries of each list.
+func (p *Profiler) WriteReport(w io.Writer, top int) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "%d instructions in %d frames\n", p.total, p.frames)
+	fmt.Fprintf(tw, "waiting for a key (FX0A):\t%d\t%.1f%%\t\n", p.keyWaits, p.percent(p.keyWaits))
+	delayWaits := p.DelayWaits()
+	fmt.Fprintf(tw, "waiting for the delay timer:\t%d\t%.1f%%\t\n", delayWaits, p.percent(delayWaits))
+
+	fmt.Fprintf(tw, "\nopcodes\t\t\t\n")
+	for _, class := range p.Classes() {
+		fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t\n", class.Pattern, class.Count, p.percent(class.Count))
+	}
+
+	fmt.Fprintf(tw, "\nhottest addresses\t\t\t\n")
+	addresses := make([]uint16, 0, cpu.RAM_SIZE)
+	for address, count := range p.counts {
+		if count > 0 {
+			addresses = append(addresses, uint16(address))
+		}
+	}
+	sort.SliceStable(addresses, func(i, j int) bool { return p.counts[addresses[i]] > p.counts[addresses[j]] })
+	for _, address := range addresses[:min(top, len(addresses))] {
+		count := p.counts[address]
+		fmt.Fprintf(tw, "0x%03X  %s\t%d\t%.1f%%\t\n", address, p.ops[address].Mnemonic(), count, p.percent(count))
+	}
+
+	fmt.Fprintf(tw, "\nhottest loops\titerations\tinstructions\t\n")
+	loops := p.Loops()
+	for _, loop := range loops[:min(top, len(loops))] {
+		var note string
+		switch {
+		case loop.Start == loop.End:
+			note = "  (halt)"
+		case loop.DelayWait:
+			note = "  (delay timer wait)"
+		}
+		fmt.Fprintf(tw, "0x%03X-0x%03X%s\t%d\t%d\t%.1f%%\t\n", loop.Start, loop.End, note, loop.Iterations, loop.Instructions, p.percent(loop.Instructions))
+	}
+
+	return tw.Flush()
+}
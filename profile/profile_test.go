@@ -0,0 +1,94 @@
+package profile_test
+
+import (
+	"bytes"
+	"chip-8-go/emulator"
+	"chip-8-go/profile"
+	"compress/gzip"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testROM = []byte{
+	0x60, 0x05, // 0x200: LD V0, 5
+	0xF0, 0x15, // 0x202: LD DT, V0
+	0xF1, 0x07, // 0x204: LD V1, DT
+	0x31, 0x00, // 0x206: SE V1, 0
+	0x12, 0x04, // 0x208: JP 0x204
+	0x22, 0x10, // 0x20A: CALL 0x210
+	0xF2, 0x0A, // 0x20C: LD V2, K
+	0x12, 0x0E, // 0x20E: JP 0x20E
+	0x00, 0xEE, // 0x210: RET
+}
+
+func run(t *testing.T, frames int) *profile.Profiler {
+	profiler := profile.New()
+	opts := emulator.DefaultOptions()
+	opts.MaxFrames = frames
+	opts.Tracer = profiler
+
+	c8 := emulator.NewChip8(opts, nil)
+	if err := c8.LoadBytes(testROM); err != nil {
+		t.Fatal(err)
+	}
+	if err := c8.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return profiler
+}
+
+func TestProfile(t *testing.T) {
+	assert := assert.New(t)
+
+	profiler := run(t, 20)
+	assert.Equal(uint64(1), profiler.Count(0x200), "executions of the first instruction")
+	assert.Zero(profiler.Count(0x20E), "never reached")
+
+	classes := map[string]uint64{}
+	for _, class := range profiler.Classes() {
+		classes[class.Pattern] = class.Count
+	}
+	assert.Equal(profiler.Count(0x204), classes["FX07"], "FX07 executions")
+	assert.Equal(uint64(1), classes["2NNN"], "2NNN executions")
+
+	loops := profiler.Loops()
+	if assert.Len(loops, 1, "loops") {
+		assert.Equal(uint16(0x204), loops[0].Start, "loop start")
+		assert.Equal(uint16(0x208), loops[0].End, "loop end")
+		assert.True(loops[0].DelayWait, "the loop waits for the delay timer")
+	}
+	assert.Equal(loops[0].Instructions, profiler.DelayWaits(), "delay timer wait")
+	assert.Equal(profiler.Count(0x20C)-1, profiler.KeyWaits(), "every FX0A but the first waits for a key")
+	assert.Equal(profiler.Total(), profiler.Count(0x20C)+loops[0].Instructions+4, "total")
+
+	var report strings.Builder
+	assert.NoError(profiler.WriteReport(&report, 5))
+	assert.Contains(report.String(), "0x204-0x208  (delay timer wait)", "report")
+}
+
+func TestOutputs(t *testing.T) {
+	assert := assert.New(t)
+
+	profiler := run(t, 20)
+
+	var heatmap bytes.Buffer
+	assert.NoError(profiler.WriteHeatmap(&heatmap))
+	img, err := png.Decode(&heatmap)
+	if assert.NoError(err, "heatmap") {
+		assert.Equal(profile.HEATMAP_COLUMNS*profile.HEATMAP_SCALE, img.Bounds().Dx(), "heatmap width")
+	}
+
+	var pprof bytes.Buffer
+	assert.NoError(profiler.WritePprof(&pprof, "test.ch8"))
+	gz, err := gzip.NewReader(&pprof)
+	if assert.NoError(err, "pprof should be gzipped") {
+		raw, readErr := io.ReadAll(gz)
+		assert.NoError(readErr)
+		assert.Contains(string(raw), "sub_0x210", "subroutine name")
+		assert.Contains(string(raw), "test.ch8", "ROM name")
+	}
+}
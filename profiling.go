@@ -0,0 +1,68 @@
+package main
+
+import (
+	"chip-8-go/profile"
+	"flag"
+	"os"
+	"path/filepath"
+)
+
+// profileFlags are the flags of commands that can profile a ROM.
+type profileFlags struct {
+	report  string
+	heatmap string
+	pprof   string
+	top     int
+}
+
+func addProfileFlags(fs *flag.FlagSet) *profileFlags {
+	f := &profileFlags{}
+	fs.StringVar(&f.report, "profile", "", "write an opcode and hotspot report to `file` when done, - for stdout")
+	fs.StringVar(&f.heatmap, "profile-heatmap", "", "write a PNG heatmap of the addresses executed to `file`")
+	fs.StringVar(&f.pprof, "profile-pprof", "", "write a profile for go tool pprof to `file`")
+	fs.IntVar(&f.top, "profile-top", 20, "number of addresses and loops in the report")
+	return f
+}
+
+// start returns nil when no profile was asked for.
+func (f *profileFlags) start() *profile.Profiler {
+	if f.report == "" && f.heatmap == "" && f.pprof == "" {
+		return nil
+	}
+	return profile.New()
+}
+
+func (f *profileFlags) write(profiler *profile.Profiler, romName string) error {
+	if f.report == "-" {
+		if err := profiler.WriteReport(os.Stdout, f.top); err != nil {
+			return err
+		}
+	} else if f.report != "" {
+		if err := writeFile(f.report, func(file *os.File) error { return profiler.WriteReport(file, f.top) }); err != nil {
+			return err
+		}
+	}
+
+	if f.heatmap != "" {
+		if err := writeFile(f.heatmap, func(file *os.File) error { return profiler.WriteHeatmap(file) }); err != nil {
+			return err
+		}
+	}
+
+	if f.pprof != "" {
+		return writeFile(f.pprof, func(file *os.File) error { return profiler.WritePprof(file, filepath.Base(romName)) })
+	}
+	return nil
+}
+
+func writeFile(path string, write func(file *os.File) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if writeErr := write(file); writeErr != nil {
+		file.Close()
+		return writeErr
+	}
+	return file.Close()
+}
@@ -0,0 +1,445 @@
+// Package remote exposes a running emulator over HTTP so that scripts and
+// dashboards can drive it. All requests and responses are JSON, except
+// for ROM uploads and PNG screenshots. A WebSocket streams the screen to
+// browsers and takes key presses back, see stream.go.
+package remote
+
+import (
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Only the local machine can connect unless a host is given explicitly.
+const DEFAULT_ADDRESS = "127.0.0.1:8700"
+
+// Uploaded ROMs larger than this are rejected before reading them.
+const MAX_ROM_SIZE = cpu.RAM_SIZE
+
+// A step request runs at most this many instructions, as the emulator is
+// held while they run.
+const MAX_STEP_COUNT = 100000
+
+// Server serves the API for one emulator. It is an http.Handler.
+type Server struct {
+	c8      *emulator.Chip8
+	palette emulator.Palette
+	mux     *http.ServeMux
+	stream  *stream
+}
+
+func NewServer(c8 *emulator.Chip8, palette emulator.Palette) *Server {
+	s := &Server{
+		c8:      c8,
+		palette: palette,
+		mux:     http.NewServeMux(),
+		stream:  newStream(c8, palette),
+	}
+
+	s.mux.HandleFunc("GET /{$}", s.stream.serveClient)
+	s.mux.HandleFunc("GET /ws", s.stream.serveWebSocket)
+
+	s.mux.HandleFunc("GET /status", s.getStatus)
+	s.mux.HandleFunc("POST /rom", s.postROM)
+	s.mux.HandleFunc("POST /pause", s.postPause)
+	s.mux.HandleFunc("POST /resume", s.postResume)
+	s.mux.HandleFunc("POST /step", s.postStep)
+	s.mux.HandleFunc("POST /reset", s.postReset)
+	s.mux.HandleFunc("GET /registers", s.getRegisters)
+	s.mux.HandleFunc("PATCH /registers", s.patchRegisters)
+	s.mux.HandleFunc("GET /memory", s.getMemory)
+	s.mux.HandleFunc("PUT /memory", s.putMemory)
+	s.mux.HandleFunc("GET /keys", s.getKeys)
+	s.mux.HandleFunc("PUT /keys/{key}", s.putKey)
+	s.mux.HandleFunc("DELETE /keys/{key}", s.deleteKey)
+	s.mux.HandleFunc("GET /screen", s.getScreen)
+	s.mux.HandleFunc("GET /screen.png", s.getScreenPNG)
+	s.mux.HandleFunc("GET /state", s.getState)
+	s.mux.HandleFunc("PUT /state", s.putState)
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves the API on addr until it fails. An address without
+// a host, like ":8700", listens on the loopback interface only.
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := Listen(addr)
+	if err != nil {
+		return err
+	}
+
+	return http.Serve(listener, s)
+}
+
+// Listen opens a TCP listener, on the loopback interface if addr has no
+// host.
+func Listen(addr string) (net.Listener, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+
+	return net.Listen("tcp", net.JoinHostPort(host, port))
+}
+
+type Status struct {
+	Paused bool   `json:"paused"`
+	Frames int    `json:"frames"`
+	Cycles uint64 `json:"cycles"`
+	PC     uint16 `json:"pc"`
+}
+
+func (s *Server) getStatus(w http.ResponseWriter, r *http.Request) {
+	status := Status{
+		Paused: s.c8.Paused(),
+		Frames: s.c8.Frames(),
+	}
+	s.c8.Sync(func(c *cpu.CPU) {
+		status.Cycles = c.Cycles
+		status.PC = c.ProgramCounter
+	})
+
+	writeJSON(w, status)
+}
+
+func (s *Server) postROM(w http.ResponseWriter, r *http.Request) {
+	rom, err := emulator.ReadROM(http.MaxBytesReader(w, r.Body, MAX_ROM_SIZE))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if loadErr := s.c8.Load(rom); loadErr != nil {
+		writeError(w, http.StatusBadRequest, loadErr)
+		return
+	}
+
+	s.getStatus(w, r)
+}
+
+func (s *Server) postPause(w http.ResponseWriter, r *http.Request) {
+	s.c8.Pause()
+	s.getStatus(w, r)
+}
+
+func (s *Server) postResume(w http.ResponseWriter, r *http.Request) {
+	s.c8.Resume()
+	s.getStatus(w, r)
+}
+
+// postStep executes ?count= instructions, one by default and at most
+// MAX_STEP_COUNT.
+func (s *Server) postStep(w http.ResponseWriter, r *http.Request) {
+	count := 1
+	if value := r.URL.Query().Get("count"); value != "" {
+		var err error
+		if count, err = strconv.Atoi(value); err != nil || count < 1 || count > MAX_STEP_COUNT {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid count %q", value))
+			return
+		}
+	}
+
+	if err := s.c8.Step(count); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	s.getRegisters(w, r)
+}
+
+func (s *Server) postReset(w http.ResponseWriter, r *http.Request) {
+	if err := s.c8.Reset(); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	s.getStatus(w, r)
+}
+
+// Registers are the CPU registers. In requests every field is optional
+// and only the ones given are written.
+type Registers struct {
+	V     *[cpu.NUM_REGS]uint8    `json:"v,omitempty"`
+	I     *uint16                 `json:"i,omitempty"`
+	PC    *uint16                 `json:"pc,omitempty"`
+	SP    *uint16                 `json:"sp,omitempty"`
+	DT    *uint8                  `json:"dt,omitempty"`
+	ST    *uint8                  `json:"st,omitempty"`
+	Stack *[cpu.STACK_SIZE]uint16 `json:"stack,omitempty"`
+}
+
+func (s *Server) getRegisters(w http.ResponseWriter, r *http.Request) {
+	var registers Registers
+	s.c8.Sync(func(c *cpu.CPU) {
+		v, stack := c.VRegisters, c.Stack
+		i, pc, sp := c.IndexRegister, c.ProgramCounter, c.StackPointer
+		dt, st := c.DelayTimer, c.SoundTimer
+		registers = Registers{V: &v, I: &i, PC: &pc, SP: &sp, DT: &dt, ST: &st, Stack: &stack}
+	})
+
+	writeJSON(w, registers)
+}
+
+func (s *Server) patchRegisters(w http.ResponseWriter, r *http.Request) {
+	var registers Registers
+	if err := readJSON(r, &registers); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if registers.SP != nil && *registers.SP > cpu.STACK_SIZE {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid sp %d", *registers.SP))
+		return
+	}
+	if registers.I != nil && *registers.I >= cpu.RAM_SIZE {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid i 0x%X: outside memory", *registers.I))
+		return
+	}
+	// An instruction is two bytes, both must be in memory.
+	if registers.PC != nil && *registers.PC >= cpu.RAM_SIZE-1 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid pc 0x%X: outside memory", *registers.PC))
+		return
+	}
+	if registers.Stack != nil {
+		for _, address := range registers.Stack {
+			if address >= cpu.RAM_SIZE-1 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid return address 0x%X: outside memory", address))
+				return
+			}
+		}
+	}
+
+	s.c8.Sync(func(c *cpu.CPU) {
+		if registers.V != nil {
+			c.VRegisters = *registers.V
+		}
+		if registers.I != nil {
+			c.IndexRegister = *registers.I
+		}
+		if registers.PC != nil {
+			c.ProgramCounter = *registers.PC
+		}
+		if registers.SP != nil {
+			c.StackPointer = *registers.SP
+		}
+		if registers.DT != nil {
+			c.DelayTimer = *registers.DT
+		}
+		if registers.ST != nil {
+			c.SoundTimer = *registers.ST
+		}
+		if registers.Stack != nil {
+			c.Stack = *registers.Stack
+		}
+	})
+
+	s.getRegisters(w, r)
+}
+
+// Memory is a block of memory, with the data hex encoded.
+type Memory struct {
+	Address uint16 `json:"address"`
+	Data    string `json:"data"`
+}
+
+// getMemory reads ?length= bytes (all of memory by default) from
+// ?address= (0 by default). Both accept decimal or 0x prefixed hex.
+func (s *Server) getMemory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	address, err := parseNumber(query.Get("address"), 0)
+	if err != nil || address >= cpu.RAM_SIZE {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid address %q", query.Get("address")))
+		return
+	}
+	length, err := parseNumber(query.Get("length"), cpu.RAM_SIZE-address)
+	if err != nil || address+length > cpu.RAM_SIZE {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid length %q", query.Get("length")))
+		return
+	}
+
+	var data string
+	s.c8.Sync(func(c *cpu.CPU) {
+		data = hex.EncodeToString(c.Memory[address : address+length])
+	})
+
+	writeJSON(w, Memory{Address: uint16(address), Data: data})
+}
+
+func (s *Server) putMemory(w http.ResponseWriter, r *http.Request) {
+	var memory Memory
+	if err := readJSON(r, &memory); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	data, err := hex.DecodeString(memory.Data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid data: %w", err))
+		return
+	}
+	if int(memory.Address)+len(data) > cpu.RAM_SIZE {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%d bytes at 0x%03X do not fit in memory", len(data), memory.Address))
+		return
+	}
+
+	s.c8.Sync(func(c *cpu.CPU) {
+		c.WriteMemory(memory.Address, data)
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getKeys(w http.ResponseWriter, r *http.Request) {
+	var keys [cpu.NUM_KEYS]bool
+	s.c8.Sync(func(c *cpu.CPU) {
+		keys = c.Keys
+	})
+
+	writeJSON(w, keys)
+}
+
+func (s *Server) putKey(w http.ResponseWriter, r *http.Request) {
+	s.setKey(w, r, true)
+}
+
+func (s *Server) deleteKey(w http.ResponseWriter, r *http.Request) {
+	s.setKey(w, r, false)
+}
+
+// setKey presses or releases the key named by its hex digit in the path,
+// e.g. PUT /keys/A.
+func (s *Server) setKey(w http.ResponseWriter, r *http.Request, pressed bool) {
+	key, err := strconv.ParseUint(r.PathValue("key"), 16, 8)
+	if err != nil || key >= cpu.NUM_KEYS {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid key %q: must be 0-F", r.PathValue("key")))
+		return
+	}
+
+	s.c8.Sync(func(c *cpu.CPU) {
+		c.SetKey(uint8(key), pressed)
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Screen is the framebuffer as rows of '0' and '1'.
+type Screen struct {
+	Width  int      `json:"width"`
+	Height int      `json:"height"`
+	Rows   []string `json:"rows"`
+	Hash   string   `json:"hash"`
+}
+
+func (s *Server) getScreen(w http.ResponseWriter, r *http.Request) {
+	screen := Screen{Width: cpu.SCREEN_WIDTH, Height: cpu.SCREEN_HEIGHT}
+	s.c8.Sync(func(c *cpu.CPU) {
+		for y := range c.Screen {
+			row := c.Screen.Row(y)
+			var line strings.Builder
+			for _, pixel := range row {
+				if pixel {
+					line.WriteByte('1')
+				} else {
+					line.WriteByte('0')
+				}
+			}
+			screen.Rows = append(screen.Rows, line.String())
+		}
+		screen.Hash = emulator.ScreenHash(c)
+	})
+
+	writeJSON(w, screen)
+}
+
+// getScreenPNG renders the framebuffer in the palette, ?scale= times the
+// CHIP-8 resolution.
+func (s *Server) getScreenPNG(w http.ResponseWriter, r *http.Request) {
+	scale := 1
+	if value := r.URL.Query().Get("scale"); value != "" {
+		var err error
+		if scale, err = strconv.Atoi(value); err != nil || scale < 1 || scale > 32 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid scale %q", value))
+			return
+		}
+	}
+
+	var screen cpu.Framebuffer
+	s.c8.Sync(func(c *cpu.CPU) {
+		screen = c.Screen
+	})
+
+	w.Header().Set("Content-Type", "image/png")
+	png.Encode(w, emulator.ScreenImage(&screen, s.palette, scale))
+}
+
+func (s *Server) getState(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.c8.SaveState())
+}
+
+func (s *Server) putState(w http.ResponseWriter, r *http.Request) {
+	var state cpu.State
+	if err := readJSON(r, &state); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := s.c8.LoadState(state); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.getStatus(w, r)
+}
+
+func parseNumber(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+
+	number, err := strconv.ParseUint(value, 0, 16)
+	return int(number), err
+}
+
+// Requests are small, state uploads are the largest at around 6 KiB.
+const maxRequestSize = 64 * 1024
+
+func readJSON(r *http.Request, v any) error {
+	decoder := json.NewDecoder(io.LimitReader(r.Body, maxRequestSize))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("empty request body")
+		}
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,212 @@
+package remote_test
+
+import (
+	"bytes"
+	"chip-8-go/emulator"
+	"chip-8-go/remote"
+	"encoding/json"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Draws the font sprite for 0 at (0, 0) and loops forever.
+var testROM = []byte{
+	0xA0, 0x00, // LD I, 0x000
+	0xD0, 0x05, // DRW V0, V0, 5
+	0x12, 0x04, // JP 0x204
+}
+
+func newServer(t *testing.T) (*emulator.Chip8, *httptest.Server) {
+	opts := emulator.DefaultOptions()
+	opts.Seed = 1
+	c8 := emulator.NewChip8(opts, nil)
+	if err := c8.LoadBytes(testROM); err != nil {
+		t.Fatal(err)
+	}
+	c8.Pause()
+
+	server := httptest.NewServer(remote.NewServer(c8, emulator.Palettes["white"]))
+	t.Cleanup(server.Close)
+
+	return c8, server
+}
+
+func request(t *testing.T, method, url, body string, v any) int {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if v != nil {
+		if decodeErr := json.NewDecoder(resp.Body).Decode(v); decodeErr != nil {
+			t.Fatal(decodeErr)
+		}
+	}
+
+	return resp.StatusCode
+}
+
+func TestStepAndRegisters(t *testing.T) {
+	assert := assert.New(t)
+	_, server := newServer(t)
+
+	var registers remote.Registers
+	assert.Equal(http.StatusOK, request(t, "POST", server.URL+"/step?count=2", "", &registers))
+	assert.Equal(uint16(0x204), *registers.PC, "two instructions should have run")
+
+	status := request(t, "PATCH", server.URL+"/registers", `{"i": 768, "dt": 9}`, &registers)
+	assert.Equal(http.StatusOK, status)
+	assert.Equal(uint16(0x300), *registers.I, "I should be written")
+	assert.Equal(uint8(9), *registers.DT, "DT should be written")
+	assert.Equal(uint16(0x204), *registers.PC, "PC should be left alone")
+
+	assert.Equal(http.StatusBadRequest, request(t, "PATCH", server.URL+"/registers", `{"x": 1}`, nil))
+	assert.Equal(http.StatusBadRequest, request(t, "POST", server.URL+"/step?count=0", "", nil))
+	assert.Equal(http.StatusBadRequest, request(t, "POST", server.URL+"/step?count=100001", "", nil), "count over MAX_STEP_COUNT")
+
+	assert.Equal(http.StatusBadRequest, request(t, "PATCH", server.URL+"/registers", `{"i": 4096}`, nil), "I outside memory")
+	assert.Equal(http.StatusBadRequest, request(t, "PATCH", server.URL+"/registers", `{"pc": 4095}`, nil), "PC on the last byte")
+	request(t, "GET", server.URL+"/registers", "", &registers)
+	assert.Equal(uint16(0x300), *registers.I, "a rejected request should write nothing")
+	assert.Equal(uint16(0x204), *registers.PC, "a rejected request should write nothing")
+}
+
+func TestMemory(t *testing.T) {
+	assert := assert.New(t)
+	_, server := newServer(t)
+
+	var memory remote.Memory
+	assert.Equal(http.StatusOK, request(t, "GET", server.URL+"/memory?address=0x200&length=6", "", &memory))
+	assert.Equal("a000d0051204", memory.Data)
+
+	assert.Equal(http.StatusNoContent, request(t, "PUT", server.URL+"/memory", `{"address": 4094, "data": "beef"}`, nil))
+	request(t, "GET", server.URL+"/memory?address=4094", "", &memory)
+	assert.Equal("beef", memory.Data, "the write should be read back")
+
+	assert.Equal(http.StatusBadRequest, request(t, "PUT", server.URL+"/memory", `{"address": 4095, "data": "beef"}`, nil))
+	assert.Equal(http.StatusBadRequest, request(t, "GET", server.URL+"/memory?address=4096", "", nil))
+}
+
+func TestKeys(t *testing.T) {
+	assert := assert.New(t)
+	_, server := newServer(t)
+
+	var keys [16]bool
+	assert.Equal(http.StatusNoContent, request(t, "PUT", server.URL+"/keys/a", "", nil))
+	request(t, "GET", server.URL+"/keys", "", &keys)
+	assert.True(keys[0xA], "key A should be pressed")
+
+	assert.Equal(http.StatusNoContent, request(t, "DELETE", server.URL+"/keys/A", "", nil))
+	request(t, "GET", server.URL+"/keys", "", &keys)
+	assert.False(keys[0xA], "key A should be released")
+
+	assert.Equal(http.StatusBadRequest, request(t, "PUT", server.URL+"/keys/10", "", nil))
+}
+
+func TestScreen(t *testing.T) {
+	assert := assert.New(t)
+	_, server := newServer(t)
+	request(t, "POST", server.URL+"/step?count=2", "", nil)
+
+	var screen remote.Screen
+	assert.Equal(http.StatusOK, request(t, "GET", server.URL+"/screen", "", &screen))
+	assert.Len(screen.Rows, 32)
+	assert.True(strings.HasPrefix(screen.Rows[0], "11110000"), "the top of the 0 sprite should be drawn")
+	assert.True(strings.HasPrefix(screen.Rows[1], "10010000"))
+
+	resp, err := http.Get(server.URL + "/screen.png?scale=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	img, err := png.Decode(resp.Body)
+	assert.NoError(err)
+	assert.Equal(128, img.Bounds().Dx(), "the PNG should be scaled")
+	r, _, _, _ := img.At(0, 0).RGBA()
+	assert.NotZero(r, "lit pixels should use the foreground colour")
+}
+
+func TestStateAndReset(t *testing.T) {
+	assert := assert.New(t)
+	_, server := newServer(t)
+	request(t, "POST", server.URL+"/step?count=2", "", nil)
+
+	resp, err := http.Get(server.URL + "/state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	var registers remote.Registers
+	assert.Equal(http.StatusOK, request(t, "POST", server.URL+"/reset", "", nil))
+	request(t, "GET", server.URL+"/registers", "", &registers)
+	assert.Equal(uint16(0x200), *registers.PC, "reset should start the ROM over")
+
+	assert.Equal(http.StatusOK, request(t, "PUT", server.URL+"/state", string(state), nil))
+	request(t, "GET", server.URL+"/registers", "", &registers)
+	assert.Equal(uint16(0x204), *registers.PC, "loading the state should restore PC")
+
+	assert.Equal(http.StatusBadRequest, request(t, "PUT", server.URL+"/state", `{"memory": ""}`, nil))
+}
+
+func TestLoadROMAndPause(t *testing.T) {
+	assert := assert.New(t)
+	_, server := newServer(t)
+
+	resp, err := http.Post(server.URL+"/rom", "application/octet-stream", bytes.NewReader([]byte{0x12, 0x00}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	var memory remote.Memory
+	request(t, "GET", server.URL+"/memory?address=0x200&length=4", "", &memory)
+	assert.Equal("12000000", memory.Data, "the new ROM should replace the old one")
+
+	var status remote.Status
+	request(t, "POST", server.URL+"/resume", "", &status)
+	assert.False(status.Paused)
+	request(t, "POST", server.URL+"/pause", "", &status)
+	assert.True(status.Paused)
+
+	resp, err = http.Post(server.URL+"/rom", "application/octet-stream", bytes.NewReader(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(http.StatusBadRequest, resp.StatusCode, "an empty ROM should be rejected")
+}
+
+func TestRunConcurrently(t *testing.T) {
+	assert := assert.New(t)
+	c8, server := newServer(t)
+	c8.Resume()
+
+	done := make(chan error)
+	go func() {
+		done <- c8.Run()
+	}()
+
+	var status remote.Status
+	for i := 0; i < 20; i++ {
+		request(t, "GET", server.URL+"/status", "", &status)
+		request(t, "PUT", server.URL+"/keys/1", "", nil)
+	}
+	c8.Stop()
+
+	assert.NoError(<-done)
+	assert.Greater(status.Frames, 0, "frames should run while the API is used")
+}
@@ -0,0 +1,249 @@
+package remote
+
+import (
+	"bytes"
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	_ "embed"
+	"fmt"
+	"image/color"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Binary WebSocket messages from the server start with their type.
+//
+// A frame delta holds the rows of the screen that changed since the last
+// one sent to the same viewer:
+//
+//	0x01 bytesPerRow count (row bitmap[bytesPerRow]) * count
+//
+// with each row's pixels packed most significant bit first. The first
+// delta a viewer gets has every row.
+const MESSAGE_FRAME_DELTA = 0x01
+
+// Text messages in both directions are JSON objects with a "type":
+//
+//	server: {"type": "hello", "role": "controller" | "viewer", "width": 64, "height": 32, "foreground": "#..", "background": "#.."}
+//	client: {"type": "key", "key": 10, "pressed": true}
+//
+// Key messages from viewers are ignored.
+type message struct {
+	Type       string `json:"type"`
+	Role       string `json:"role,omitempty"`
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	Foreground string `json:"foreground,omitempty"`
+	Background string `json:"background,omitempty"`
+	Key        *uint8 `json:"key,omitempty"`
+	Pressed    bool   `json:"pressed,omitempty"`
+}
+
+const (
+	ROLE_CONTROLLER = "controller"
+	ROLE_VIEWER     = "viewer"
+)
+
+const writeTimeout = 5 * time.Second
+
+const rowBytes = cpu.SCREEN_WIDTH / 8
+
+//go:embed client.html
+var clientHTML []byte
+
+// stream fans the screen out to WebSocket clients. Every client has its
+// own copy of what it was last sent, so slow clients skip frames instead
+// of holding up the emulator or the others.
+type stream struct {
+	c8       *emulator.Chip8
+	palette  emulator.Palette
+	upgrader websocket.Upgrader
+
+	mu         sync.Mutex
+	screen     [cpu.SCREEN_BYTES]byte
+	viewers    map[*viewer]struct{}
+	controller *viewer
+}
+
+type viewer struct {
+	conn    *websocket.Conn
+	role    string
+	changed chan struct{}
+	sent    [cpu.SCREEN_BYTES]byte
+	// Nothing was sent yet, the next delta has every row.
+	full    bool
+	pressed [cpu.NUM_KEYS]bool
+}
+
+func newStream(c8 *emulator.Chip8, palette emulator.Palette) *stream {
+	s := &stream{
+		c8:      c8,
+		palette: palette,
+		viewers: make(map[*viewer]struct{}),
+	}
+	c8.AddFrameHook(s.frame)
+
+	return s
+}
+
+// frame runs on the emulation goroutine at the end of every frame.
+func (s *stream) frame(c *cpu.CPU) {
+	screen := c.PackedScreen()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if screen == s.screen {
+		return
+	}
+	s.screen = screen
+
+	for v := range s.viewers {
+		select {
+		case v.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *stream) serveClient(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(clientHTML)
+}
+
+// serveWebSocket streams to a new viewer. With ?control=1 it asks to be
+// the controller, which it becomes unless another client already is.
+func (s *stream) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	v := &viewer{
+		conn:    conn,
+		role:    ROLE_VIEWER,
+		changed: make(chan struct{}, 1),
+		full:    true,
+	}
+
+	s.mu.Lock()
+	if r.URL.Query().Get("control") == "1" && s.controller == nil {
+		s.controller = v
+		v.role = ROLE_CONTROLLER
+	}
+	s.viewers[v] = struct{}{}
+	s.mu.Unlock()
+	v.changed <- struct{}{}
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		s.readMessages(v)
+	}()
+	defer func() {
+		conn.Close()
+		<-closed
+		s.remove(v)
+	}()
+
+	hello := message{
+		Type:       "hello",
+		Role:       v.role,
+		Width:      cpu.SCREEN_WIDTH,
+		Height:     cpu.SCREEN_HEIGHT,
+		Foreground: hexColor(s.palette.Foreground),
+		Background: hexColor(s.palette.Background),
+	}
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if writeErr := conn.WriteJSON(hello); writeErr != nil {
+		return
+	}
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-v.changed:
+			if writeErr := s.writeDelta(v); writeErr != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeDelta sends the rows that changed since the viewer's last delta.
+func (s *stream) writeDelta(v *viewer) error {
+	s.mu.Lock()
+	screen := s.screen
+	s.mu.Unlock()
+
+	delta := []byte{MESSAGE_FRAME_DELTA, rowBytes, 0}
+	for y := 0; y < cpu.SCREEN_HEIGHT; y++ {
+		row := screen[y*rowBytes : (y+1)*rowBytes]
+		if !v.full && bytes.Equal(row, v.sent[y*rowBytes:(y+1)*rowBytes]) {
+			continue
+		}
+		delta = append(delta, byte(y))
+		delta = append(delta, row...)
+		delta[2]++
+	}
+	if delta[2] == 0 {
+		return nil
+	}
+
+	v.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := v.conn.WriteMessage(websocket.BinaryMessage, delta); err != nil {
+		return err
+	}
+	v.sent = screen
+	v.full = false
+
+	return nil
+}
+
+func (s *stream) readMessages(v *viewer) {
+	for {
+		var msg message
+		if err := v.conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		if msg.Type != "key" || v.role != ROLE_CONTROLLER || msg.Key == nil || *msg.Key >= cpu.NUM_KEYS {
+			continue
+		}
+
+		key, pressed := *msg.Key, msg.Pressed
+		v.pressed[key] = pressed
+		s.c8.Sync(func(c *cpu.CPU) {
+			c.SetKey(key, pressed)
+		})
+	}
+}
+
+// remove forgets a viewer. A leaving controller lets go of its keys and
+// makes room for a new one.
+func (s *stream) remove(v *viewer) {
+	s.mu.Lock()
+	delete(s.viewers, v)
+	if s.controller == v {
+		s.controller = nil
+	}
+	s.mu.Unlock()
+
+	if v.role != ROLE_CONTROLLER {
+		return
+	}
+	s.c8.Sync(func(c *cpu.CPU) {
+		for key, pressed := range v.pressed {
+			if pressed {
+				c.SetKey(uint8(key), false)
+			}
+		}
+	})
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
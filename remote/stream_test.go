@@ -0,0 +1,95 @@
+package remote_test
+
+import (
+	"chip-8-go/remote"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	return conn
+}
+
+func readHello(t *testing.T, conn *websocket.Conn) map[string]any {
+	var hello map[string]any
+	if err := conn.ReadJSON(&hello); err != nil {
+		t.Fatal(err)
+	}
+	return hello
+}
+
+func readDelta(t *testing.T, conn *websocket.Conn) []byte {
+	kind, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind != websocket.BinaryMessage || data[0] != remote.MESSAGE_FRAME_DELTA {
+		t.Fatalf("expected a frame delta, got %v %q", kind, data)
+	}
+	return data
+}
+
+func TestStream(t *testing.T) {
+	assert := assert.New(t)
+	c8, server := newServer(t)
+
+	done := make(chan error)
+	go func() {
+		done <- c8.Run()
+	}()
+	defer func() {
+		c8.Stop()
+		<-done
+	}()
+
+	controller := dial(t, server.URL+"/ws?control=1")
+	assert.Equal(remote.ROLE_CONTROLLER, readHello(t, controller)["role"])
+	full := readDelta(t, controller)
+	assert.Equal(byte(32), full[2], "the first delta should have every row")
+	assert.Len(full, 3+32*9)
+
+	viewer := dial(t, server.URL+"/ws?control=1")
+	assert.Equal(remote.ROLE_VIEWER, readHello(t, viewer)["role"], "there can only be one controller")
+	readDelta(t, viewer)
+
+	request(t, "POST", server.URL+"/step?count=2", "", nil)
+	for _, conn := range []*websocket.Conn{controller, viewer} {
+		delta := readDelta(t, conn)
+		assert.Equal(byte(5), delta[2], "only the rows of the sprite should be sent")
+		assert.Equal([]byte{0, 0xF0}, delta[3:5], "row 0 should hold the top of the sprite")
+	}
+
+	var keys [16]bool
+	viewer.WriteJSON(map[string]any{"type": "key", "key": 3, "pressed": true})
+	controller.WriteJSON(map[string]any{"type": "key", "key": 5, "pressed": true})
+	assert.Eventually(func() bool {
+		request(t, "GET", server.URL+"/keys", "", &keys)
+		return keys[5]
+	}, time.Second, 10*time.Millisecond, "the controller should press keys")
+	assert.False(keys[3], "viewers should not press keys")
+
+	controller.Close()
+	assert.Eventually(func() bool {
+		request(t, "GET", server.URL+"/keys", "", &keys)
+		return !keys[5]
+	}, time.Second, 10*time.Millisecond, "keys should be released when the controller leaves")
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal("text/html; charset=utf-8", resp.Header.Get("Content-Type"), "the client page should be served")
+}
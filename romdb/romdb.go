@@ -0,0 +1,313 @@
+// Package romdb identifies ROMs by their SHA-1 and knows which platform,
+// quirks, speed and keys they need. The data follows the schema of the
+// community CHIP-8 database (https://github.com/chip-8/chip-8-database):
+// programs.json, sha1-hashes.json and platforms.json.
+package romdb
+
+import (
+	"chip-8-go/config"
+	"chip-8-go/cpu"
+	"crypto/sha1"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+)
+
+const OVERRIDE_FILE_NAME = "programs.json"
+
+//go:embed database/*.json
+var embedded embed.FS
+
+type Program struct {
+	Title       string           `json:"title"`
+	Description string           `json:"description,omitempty"`
+	Release     string           `json:"release,omitempty"`
+	Authors     []string         `json:"authors,omitempty"`
+	Images      map[string]Image `json:"images"`
+}
+
+// Image is one specific binary of a program.
+type Image struct {
+	Platforms       []string                  `json:"platforms"`
+	Description     string                    `json:"description,omitempty"`
+	Release         string                    `json:"release,omitempty"`
+	Tickrate        int                       `json:"tickrate,omitempty"`
+	StartAddress    int                       `json:"startAddress,omitempty"`
+	QuirkyPlatforms map[string]PlatformQuirks `json:"quirkyPlatforms,omitempty"`
+	Keys            map[string]int            `json:"keys,omitempty"`
+	Colors          *Colors                   `json:"colors,omitempty"`
+}
+
+type Colors struct {
+	// Background first, then the foreground.
+	Pixels  []string `json:"pixels,omitempty"`
+	Buzzer  string   `json:"buzzer,omitempty"`
+	Silence string   `json:"silence,omitempty"`
+}
+
+type Platform struct {
+	ID              string         `json:"id"`
+	Name            string         `json:"name"`
+	DefaultTickrate int            `json:"defaultTickrate"`
+	Quirks          PlatformQuirks `json:"quirks"`
+}
+
+// PlatformQuirks uses the database's quirk names: shift,
+// memoryIncrementByX, memoryLeaveIUnchanged, wrap, jump, vblank and logic.
+type PlatformQuirks map[string]bool
+
+type Database struct {
+	programs  []Program
+	hashes    map[string]int
+	platforms map[string]Platform
+}
+
+var (
+	embeddedOnce sync.Once
+	embeddedDB   *Database
+	embeddedErr  error
+)
+
+// Embedded returns the database compiled into the binary.
+func Embedded() (*Database, error) {
+	embeddedOnce.Do(func() {
+		sub, _ := fs.Sub(embedded, "database")
+		embeddedDB, embeddedErr = Load(sub)
+	})
+
+	return embeddedDB, embeddedErr
+}
+
+// Load reads a database laid out like the community repository's
+// database directory.
+func Load(fsys fs.FS) (*Database, error) {
+	db := &Database{}
+
+	if err := readJSON(fsys, "programs.json", &db.programs); err != nil {
+		return nil, err
+	}
+	if err := readJSON(fsys, "sha1-hashes.json", &db.hashes); err != nil {
+		return nil, err
+	}
+
+	var platforms []Platform
+	if err := readJSON(fsys, "platforms.json", &platforms); err != nil {
+		return nil, err
+	}
+	db.platforms = make(map[string]Platform, len(platforms))
+	for _, platform := range platforms {
+		db.platforms[platform.ID] = platform
+	}
+
+	for hash, index := range db.hashes {
+		if index < 0 || index >= len(db.programs) {
+			return nil, fmt.Errorf("sha1-hashes.json: %s points at missing program %d", hash, index)
+		}
+	}
+
+	return db, nil
+}
+
+// Clone returns a copy that can take overrides without affecting db.
+func (db *Database) Clone() *Database {
+	clone := &Database{
+		programs:  append([]Program(nil), db.programs...),
+		hashes:    make(map[string]int, len(db.hashes)),
+		platforms: db.platforms,
+	}
+	for hash, index := range db.hashes {
+		clone.hashes[hash] = index
+	}
+
+	return clone
+}
+
+// LoadOverride adds the programs from a local file in programs.json
+// format. Their images take precedence over the ones already known, so
+// users can correct or extend the bundled data.
+func (db *Database) LoadOverride(fileName string) error {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	var programs []Program
+	if jsonErr := json.Unmarshal(data, &programs); jsonErr != nil {
+		return fmt.Errorf("%s: %w", fileName, jsonErr)
+	}
+
+	for _, program := range programs {
+		db.programs = append(db.programs, program)
+		for hash := range program.Images {
+			db.hashes[strings.ToLower(hash)] = len(db.programs) - 1
+		}
+	}
+
+	return nil
+}
+
+// Entry is what the database knows about one ROM image.
+type Entry struct {
+	Hash     string
+	Program  Program
+	Image    Image
+	Platform Platform
+}
+
+func (db *Database) Lookup(hash string) (Entry, bool) {
+	hash = strings.ToLower(hash)
+
+	index, ok := db.hashes[hash]
+	if !ok {
+		return Entry{}, false
+	}
+
+	program := db.programs[index]
+	image, ok := program.Images[hash]
+	if !ok {
+		// sha1-hashes.json and programs.json disagree.
+		return Entry{}, false
+	}
+
+	entry := Entry{Hash: hash, Program: program, Image: image}
+	for _, id := range image.Platforms {
+		if platform, known := db.platforms[id]; known {
+			entry.Platform = platform
+			break
+		}
+	}
+
+	return entry, true
+}
+
+// Identify hashes rom and looks it up.
+func (db *Database) Identify(rom []byte) (Entry, bool) {
+	sum := sha1.Sum(rom)
+	return db.Lookup(hex.EncodeToString(sum[:]))
+}
+
+// Quirks translates the platform's quirks, plus any image specific
+// corrections, into the emulator's. CHIP-48's "I += X" is not emulated
+// and comes out as the closest match, "I += X + 1".
+func (e Entry) Quirks() cpu.Quirks {
+	quirks := PlatformQuirks{}
+	for name, value := range e.Platform.Quirks {
+		quirks[name] = value
+	}
+	for name, value := range e.Image.QuirkyPlatforms[e.Platform.ID] {
+		quirks[name] = value
+	}
+
+	return cpu.Quirks{
+		ResetVF:        quirks["logic"],
+		IncrementIndex: !quirks["memoryLeaveIUnchanged"],
+		ShiftUsesVY:    !quirks["shift"],
+		JumpUsesVX:     quirks["jump"],
+		ClipSprites:    !quirks["wrap"],
+		DisplayWait:    quirks["vblank"],
+	}
+}
+
+// hostKeys maps the database's key hints onto host keys. They are added
+// to the regular keypad bindings, not replacing them.
+var hostKeys = map[string]string{
+	"up":          "Up",
+	"down":        "Down",
+	"left":        "Left",
+	"right":       "Right",
+	"a":           "Space",
+	"b":           "Left Shift",
+	"player1Up":   "Up",
+	"player1Down": "Down",
+	"player2Up":   "Keypad 8",
+	"player2Down": "Keypad 2",
+}
+
+// Settings returns the configuration layer implied by the entry.
+func (e Entry) Settings() config.Settings {
+	var settings config.Settings
+
+	if e.Platform.ID != "" {
+		quirks := e.Quirks().String()
+		settings.Quirks = &quirks
+	}
+
+	tickrate := e.Image.Tickrate
+	if tickrate == 0 {
+		tickrate = e.Platform.DefaultTickrate
+	}
+	if tickrate > 0 {
+		settings.IPF = &tickrate
+	}
+
+	if e.Image.StartAddress > 0 {
+		address := config.Address(e.Image.StartAddress)
+		settings.LoadAddress = &address
+	}
+
+	if colors := e.Image.Colors; colors != nil && len(colors.Pixels) >= 2 {
+		palette := strings.TrimPrefix(colors.Pixels[1], "#") + ":" + strings.TrimPrefix(colors.Pixels[0], "#")
+		settings.Palette = &palette
+	}
+
+	for hint, key := range e.Image.Keys {
+		name, ok := hostKeys[hint]
+		if !ok || key < 0 || key >= cpu.NUM_KEYS {
+			continue
+		}
+		if settings.Keys == nil {
+			settings.Keys = config.KeyMap{}
+		}
+		settings.Keys[fmt.Sprintf("%X", key)] = "+" + name
+	}
+
+	return settings
+}
+
+// Summary is a one-line description for printing when a ROM is loaded,
+// e.g. "Brix by Andreas Gustafsson (1990) [CHIP-48]".
+func (e Entry) Summary() string {
+	summary := e.Program.Title
+	if len(e.Program.Authors) > 0 {
+		summary += " by " + strings.Join(e.Program.Authors, ", ")
+	}
+
+	release := e.Image.Release
+	if release == "" {
+		release = e.Program.Release
+	}
+	if release != "" {
+		summary += " (" + release + ")"
+	}
+
+	if e.Platform.Name != "" {
+		summary += " [" + e.Platform.Name + "]"
+	}
+
+	return summary
+}
+
+// Description prefers the image specific description.
+func (e Entry) Description() string {
+	if e.Image.Description != "" {
+		return e.Image.Description
+	}
+	return e.Program.Description
+}
+
+func readJSON(fsys fs.FS, name string, v any) error {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
+	}
+	if jsonErr := json.Unmarshal(data, v); jsonErr != nil {
+		return fmt.Errorf("%s: %w", name, jsonErr)
+	}
+
+	return nil
+}
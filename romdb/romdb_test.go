@@ -0,0 +1,90 @@
+package romdb_test
+
+import (
+	"chip-8-go/cpu"
+	"chip-8-go/romdb"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBundledROMsAreKnown(t *testing.T) {
+	assert := assert.New(t)
+
+	db, err := romdb.Embedded()
+	assert.NoError(err)
+
+	files, err := filepath.Glob("../bin/roms/*")
+	assert.NoError(err)
+	assert.NotEmpty(files)
+
+	for _, file := range files {
+		rom, readErr := os.ReadFile(file)
+		assert.NoError(readErr)
+
+		entry, ok := db.Identify(rom)
+		if assert.True(ok, "%s should be in the database", file) {
+			assert.NotEmpty(entry.Program.Title)
+			assert.NotEmpty(entry.Platform.ID, "%s should have a known platform", file)
+		}
+	}
+}
+
+func TestEntrySettings(t *testing.T) {
+	assert := assert.New(t)
+
+	db, err := romdb.Embedded()
+	assert.NoError(err)
+
+	brix, ok := db.Lookup("F13766C14AEB02AD8D4D103CB5EADD282D20CDDC")
+	assert.True(ok, "Lookup should ignore the case of the hash")
+	assert.Equal("Brix by Andreas Gustafsson (1990) [Cosmac VIP]", brix.Summary())
+	assert.Equal(cpu.QuirkPresets["chip8"], brix.Quirks(), "VIP platform should map onto the chip8 preset")
+
+	settings := brix.Settings()
+	assert.Equal("chip8", *settings.Quirks)
+	assert.Equal(15, *settings.IPF, "IPF should fall back to the platform tickrate")
+	assert.Equal("+Left", settings.Keys["4"], "Key hints should add to the keypad bindings")
+
+	blinky, _ := db.Lookup("d40abc54374e4343639f993e897e00904ddf85d9")
+	assert.Equal(cpu.QuirkPresets["schip"], blinky.Quirks())
+}
+
+func TestLoadOverride(t *testing.T) {
+	assert := assert.New(t)
+
+	override := filepath.Join(t.TempDir(), romdb.OVERRIDE_FILE_NAME)
+	assert.NoError(os.WriteFile(override, []byte(`[{
+		"title": "My Brix",
+		"images": {
+			"f13766c14aeb02ad8d4d103cb5eadd282d20cddc": {
+				"platforms": ["xochip"],
+				"tickrate": 20,
+				"startAddress": 1536,
+				"quirkyPlatforms": {"xochip": {"vblank": true}},
+				"colors": {"pixels": ["#000000", "#ffb000"]}
+			}
+		}
+	}]`), 0644))
+
+	embedded, err := romdb.Embedded()
+	assert.NoError(err)
+
+	db := embedded.Clone()
+	assert.NoError(db.LoadOverride(override))
+
+	entry, ok := db.Lookup("f13766c14aeb02ad8d4d103cb5eadd282d20cddc")
+	assert.True(ok)
+	assert.Equal("My Brix", entry.Program.Title, "Override should take precedence")
+
+	settings := entry.Settings()
+	assert.Equal("xochip+vblank", *settings.Quirks, "Image quirks should override the platform")
+	assert.Equal(20, *settings.IPF)
+	assert.Equal("0x600", settings.LoadAddress.String())
+	assert.Equal("ffb000:000000", *settings.Palette)
+
+	original, _ := embedded.Lookup("f13766c14aeb02ad8d4d103cb5eadd282d20cddc")
+	assert.Equal("Brix", original.Program.Title, "Override should not change the embedded database")
+}
@@ -0,0 +1,521 @@
+package main
+
+import (
+	"chip-8-go/cheat"
+	"chip-8-go/config"
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"chip-8-go/gdbstub"
+	"chip-8-go/netplay"
+	"chip-8-go/remote"
+	"chip-8-go/script"
+	"chip-8-go/tui"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// seedFlag is kept out of the config file on purpose: a fixed seed is a
+// property of a single run, not a preference.
+type seedFlag struct {
+	seed int64
+	fs   *flag.FlagSet
+}
+
+func addSeedFlag(fs *flag.FlagSet) *seedFlag {
+	s := &seedFlag{fs: fs}
+	fs.Int64Var(&s.seed, "seed", 0, "random seed for CXNN (default: random)")
+	return s
+}
+
+func (s *seedFlag) apply(opts *emulator.Options) {
+	if flagWasSet(s.fs, "seed") {
+		opts.Seed = s.seed
+	}
+}
+
+// engineFlag selects the CPU's execution engine.
+type engineFlag struct {
+	name string
+}
+
+func addEngineFlag(fs *flag.FlagSet) *engineFlag {
+	e := &engineFlag{}
+	fs.StringVar(&e.name, "engine", "interpreter", "execution engine: interpreter, recompiler, or lockstep to check the recompiler against the interpreter")
+	return e
+}
+
+func (e *engineFlag) apply(opts *emulator.Options) error {
+	engine, err := cpu.ParseEngine(e.name)
+	if err != nil {
+		return usageError{err.Error()}
+	}
+	opts.Engine = engine
+	return nil
+}
+
+// scriptFlag runs a Lua script alongside the ROM.
+type scriptFlag struct {
+	fileName string
+}
+
+func addScriptFlag(fs *flag.FlagSet) *scriptFlag {
+	f := &scriptFlag{}
+	fs.StringVar(&f.fileName, "script", "", "run the Lua script in `file` alongside the ROM, see the script package")
+	return f
+}
+
+// load compiles the script, if one was given, and adds it to the tracers
+// of opts.
+func (f *scriptFlag) load(cfg config.Config, opts *emulator.Options) (*script.Script, error) {
+	if f.fileName == "" {
+		return nil, nil
+	}
+	palette, err := emulator.ParsePalette(cfg.Palette)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := script.Load(f.fileName, script.Options{Palette: palette})
+	if err != nil {
+		return nil, err
+	}
+	addTracer(opts, s)
+	return s, nil
+}
+
+// runExtras are the run features that work the same with every frontend.
+type runExtras struct {
+	recordAudio string
+	remote      net.Listener
+	gdb         net.Listener
+	netplay     *netplay.Session
+	script      *script.Script
+	cheats      *cheat.File
+	// Manage the cheats from stdin, saving them to cheatPath.
+	cheatConsole bool
+	cheatPath    string
+	// The file the ROM was read from, for a hard reset to read it again.
+	// Empty when the ROM was built, e.g. from an Octo source.
+	romFile string
+	// Called last, e.g. to hand the emulator to a debug adapter.
+	debug func(c8 *emulator.Chip8)
+}
+
+// wrap puts netplay and the script overlay between the emulator and the
+// frontend, which is nil when running headless.
+func (e runExtras) wrap(frontend emulator.Frontend) emulator.Frontend {
+	if e.netplay != nil {
+		frontend = e.netplay.Frontend(frontend)
+	}
+	if e.script != nil {
+		frontend = e.script.Frontend(frontend)
+	}
+	return frontend
+}
+
+// attach starts them on c8 once the ROM is loaded.
+func (e runExtras) attach(c8 *emulator.Chip8, cfg config.Config) error {
+	if e.romFile != "" {
+		c8.SetROMFile(e.romFile)
+	}
+
+	if e.recordAudio != "" {
+		if recordErr := c8.RecordAudio(e.recordAudio); recordErr != nil {
+			return recordErr
+		}
+	}
+
+	if e.remote != nil {
+		palette, err := emulator.ParsePalette(cfg.Palette)
+		if err != nil {
+			return err
+		}
+		go http.Serve(e.remote, remote.NewServer(c8, palette))
+	}
+
+	if e.gdb != nil {
+		// Hold the ROM at its first instruction until the debugger attaches.
+		c8.Pause()
+		go gdbstub.NewServer(c8).Serve(e.gdb)
+	}
+
+	if e.cheats != nil {
+		e.cheats.Attach(c8)
+	}
+	if e.cheatConsole {
+		fmt.Println("Cheat console, help lists the commands")
+		go cheat.NewConsole(e.cheats, e.cheatPath, c8).Run(os.Stdin, os.Stdout)
+	}
+
+	if e.script != nil {
+		if scriptErr := e.script.Attach(c8); scriptErr != nil {
+			return scriptErr
+		}
+	}
+
+	if e.debug != nil {
+		e.debug(c8)
+	}
+
+	return nil
+}
+
+func runCommand(args []string) (err error) {
+	fs := newFlagSet("run")
+	configFlags := addConfigFlags(fs)
+	configFlags.addMachineFlags(fs)
+	configFlags.addDisplayFlags(fs)
+	seed := addSeedFlag(fs)
+	engine := addEngineFlag(fs)
+	scriptOpts := addScriptFlag(fs)
+	cheatOpts := addCheatFlags(fs)
+	cheatOpts.addConsoleFlag(fs)
+	headless := fs.Bool("headless", false, "run without window, input or sound device")
+	terminal := fs.Bool("tui", false, "run in the terminal instead of a window")
+	terminalMode := fs.String("tui-mode", "halfblock", "terminal rendering: halfblock or braille")
+	terminalHold := fs.Duration("tui-hold", tui.HOLD_TIME, "how long a key press holds the key in terminals that do not report releases; at least the keyboard's repeat delay")
+	frames := fs.Int("frames", 0, "stop after this many frames (default: run until quit)")
+	recordAudio := fs.String("record-audio", "", "record the sound output to a WAV `file`")
+	httpAddr := fs.String("http", "", "serve the remote-control API on `address`, e.g. :8700 (loopback unless a host is given)")
+	gdbAddr := fs.String("gdb", "", "wait for a GDB remote debugger on `address`, e.g. :1234 (loopback unless a host is given)")
+	netplayHost := fs.String("netplay-host", "", "wait for a netplay peer on `address`, e.g. :7800")
+	netplayJoin := fs.String("netplay-join", "", "join the netplay peer at `address`, e.g. 192.168.1.20:7800")
+	netplayKeys := fs.String("netplay-keys", "", "CHIP-8 `keys` this peer plays, e.g. C,D (host default: all the peer does not take)")
+	netplayDelay := fs.Int("netplay-delay", netplay.DEFAULT_DELAY, "input delay in `frames`, set by the host")
+	traceOpts := addTraceFlags(fs)
+	profileOpts := addProfileFlags(fs)
+
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	fileName := positional[0]
+
+	rom, err := readROM(fileName)
+	if err != nil {
+		return err
+	}
+
+	cfg, entry, err := configFlags.resolve(rom)
+	if err != nil {
+		return err
+	}
+	printROMInfo(os.Stdout, entry)
+	if *frames < 0 {
+		return usageError{"invalid -frames: must not be negative"}
+	}
+
+	opts := cfg.Options()
+	seed.apply(&opts)
+	if err := engine.apply(&opts); err != nil {
+		return err
+	}
+	opts.MaxFrames = *frames
+
+	tracer, err := traceOpts.open()
+	if err != nil {
+		return err
+	}
+	if tracer != nil {
+		addTracer(&opts, tracer)
+		defer func() {
+			err = finishTrace(tracer, err, recover())
+		}()
+	}
+	if profiler := profileOpts.start(); profiler != nil {
+		addTracer(&opts, profiler)
+		defer func() {
+			if writeErr := profileOpts.write(profiler, fileName); err == nil {
+				err = writeErr
+			}
+		}()
+	}
+
+	extras := runExtras{recordAudio: *recordAudio, cheatConsole: cheatOpts.console, romFile: fileName}
+	if extras.cheats, extras.cheatPath, err = cheatOpts.load(fileName, rom); err != nil {
+		return err
+	}
+	if cheatOpts.console && *terminal {
+		return usageError{"-cheat-console and -tui both want the terminal"}
+	}
+	if extras.script, err = scriptOpts.load(cfg, &opts); err != nil {
+		return err
+	}
+	if extras.script != nil {
+		defer func() {
+			if err == nil {
+				err = extras.script.Err()
+			}
+			extras.script.Close()
+		}()
+	}
+	if *netplayHost != "" || *netplayJoin != "" {
+		session, netplayErr := startNetplay(*netplayHost, *netplayJoin, *netplayKeys, *netplayDelay, rom, &opts)
+		if netplayErr != nil {
+			return netplayErr
+		}
+		defer session.Close()
+		extras.netplay = session
+	}
+	if *httpAddr != "" {
+		listener, listenErr := remote.Listen(*httpAddr)
+		if listenErr != nil {
+			return listenErr
+		}
+		defer listener.Close()
+		fmt.Printf("Remote API on http://%s\n", listener.Addr())
+		extras.remote = listener
+	}
+	if *gdbAddr != "" {
+		listener, listenErr := gdbstub.Listen(*gdbAddr)
+		if listenErr != nil {
+			return listenErr
+		}
+		defer listener.Close()
+		fmt.Printf("Waiting for GDB on %s\n", listener.Addr())
+		extras.gdb = listener
+	}
+
+	if *headless {
+		return runHeadless(rom, cfg, opts, extras)
+	}
+	if *terminal {
+		mode, modeErr := tui.ParseMode(*terminalMode)
+		if modeErr != nil {
+			return usageError{modeErr.Error()}
+		}
+		if *terminalHold <= 0 {
+			return usageError{"invalid -tui-hold: must be positive"}
+		}
+		return runTerminal(rom, cfg, opts, mode, *terminalHold, extras)
+	}
+
+	return runWindowed(fileName, rom, cfg, opts, extras)
+}
+
+// startNetplay connects to the peer and switches opts to the settings
+// agreed on with it.
+func startNetplay(hostAddr, joinAddr, keys string, delay int, rom []byte, opts *emulator.Options) (*netplay.Session, error) {
+	if hostAddr != "" && joinAddr != "" {
+		return nil, usageError{"-netplay-host and -netplay-join are exclusive"}
+	}
+	mask, err := netplay.ParseKeys(keys)
+	if err != nil {
+		return nil, usageError{"invalid -netplay-keys: " + err.Error()}
+	}
+
+	cfg := netplay.Config{
+		Keys:    mask,
+		Delay:   delay,
+		Seed:    opts.Seed,
+		Quirks:  opts.Quirks.String(),
+		IPF:     opts.IPF,
+		ROMHash: config.HashROM(rom),
+	}
+
+	var session *netplay.Session
+	if hostAddr != "" {
+		listener, listenErr := net.Listen("tcp", hostAddr)
+		if listenErr != nil {
+			return nil, listenErr
+		}
+		defer listener.Close()
+
+		fmt.Printf("Waiting for a netplay peer on %s\n", listener.Addr())
+		session, err = netplay.Host(listener, cfg)
+	} else {
+		if mask == 0 {
+			return nil, usageError{"-netplay-join needs -netplay-keys"}
+		}
+		session, err = netplay.Join(joinAddr, cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	agreed := session.Config()
+	quirks, err := cpu.ParseQuirks(agreed.Quirks)
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	opts.Seed, opts.IPF, opts.Quirks = agreed.Seed, agreed.IPF, quirks
+
+	fmt.Printf("Netplay: playing keys %s, %d frames input delay\n", netplay.FormatKeys(agreed.Keys), agreed.Delay)
+	session.OnDesync = func(frame int) {
+		fmt.Fprintf(os.Stderr, "netplay: out of sync at frame %d, resynced\n", frame)
+	}
+
+	return session, nil
+}
+
+func runWindowed(fileName string, rom []byte, cfg config.Config, opts emulator.Options, extras runExtras) error {
+	palette, err := emulator.ParsePalette(cfg.Palette)
+	if err != nil {
+		return err
+	}
+	keys, err := cfg.Keys.Bindings()
+	if err != nil {
+		return err
+	}
+
+	_, renderer, closeWindow, err := openWindow("Chip 8 - "+fileName, cfg)
+	if err != nil {
+		return err
+	}
+	defer closeWindow()
+
+	frontend, err := emulator.NewSDLFrontend(renderer, int32(cfg.Scale), palette, keys)
+	if err != nil {
+		return err
+	}
+
+	c8 := emulator.NewChip8(opts, extras.wrap(frontend))
+	if loadErr := c8.LoadBytes(rom); loadErr != nil {
+		return loadErr
+	}
+
+	if !cfg.Mute {
+		beeper, beeperErr := emulator.NewBeeper()
+		if beeperErr != nil {
+			return beeperErr
+		}
+		defer beeper.Close()
+		c8.AddAudioSink(beeper)
+	}
+
+	if attachErr := extras.attach(c8, cfg); attachErr != nil {
+		return attachErr
+	}
+
+	runErr := c8.Run()
+	warnInvalidOpCodes(c8.CPU())
+	return runErr
+}
+
+// openWindow initialises SDL and opens a window the size of the CHIP-8
+// screen at cfg's scale. closeWindow destroys it and shuts SDL down.
+func openWindow(title string, cfg config.Config) (window *sdl.Window, renderer *sdl.Renderer, closeWindow func(), err error) {
+	if sdlErr := sdl.Init(sdl.INIT_EVERYTHING); sdlErr != nil {
+		return nil, nil, nil, sdlErr
+	}
+
+	var scaleModifier int32 = int32(cfg.Scale)
+
+	var windowFlags uint32 = sdl.WINDOW_SHOWN
+	if cfg.Fullscreen {
+		windowFlags |= sdl.WINDOW_FULLSCREEN_DESKTOP
+	}
+
+	window, windowErr := sdl.CreateWindow(
+		title,
+		sdl.WINDOWPOS_UNDEFINED,
+		sdl.WINDOWPOS_UNDEFINED,
+		cpu.SCREEN_WIDTH*scaleModifier,
+		cpu.SCREEN_HEIGHT*scaleModifier,
+		windowFlags,
+	)
+	if windowErr != nil {
+		sdl.Quit()
+		return nil, nil, nil, windowErr
+	}
+
+	renderer, rendererErr := sdl.CreateRenderer(window, -1, 0)
+	if rendererErr != nil {
+		window.Destroy()
+		sdl.Quit()
+		return nil, nil, nil, rendererErr
+	}
+
+	// Keep the aspect ratio and let SDL scale when fullscreen.
+	renderer.SetLogicalSize(cpu.SCREEN_WIDTH*scaleModifier, cpu.SCREEN_HEIGHT*scaleModifier)
+
+	closeWindow = func() {
+		renderer.Destroy()
+		window.Destroy()
+		sdl.Quit()
+	}
+	return window, renderer, closeWindow, nil
+}
+
+func runTerminal(rom []byte, cfg config.Config, opts emulator.Options, mode tui.Mode, hold time.Duration, extras runExtras) error {
+	palette, err := emulator.ParsePalette(cfg.Palette)
+	if err != nil {
+		return err
+	}
+	keys, err := cfg.Keys.Bindings()
+	if err != nil {
+		return err
+	}
+
+	terminal, err := tui.Open(os.Stdin, os.Stdout, tui.Options{
+		Mode:     mode,
+		Palette:  palette,
+		Keys:     keys,
+		HoldTime: hold,
+		Bell:     !cfg.Mute,
+	})
+	if err != nil {
+		return err
+	}
+	defer terminal.Close()
+
+	c8 := emulator.NewChip8(opts, extras.wrap(terminal))
+	if loadErr := c8.LoadBytes(rom); loadErr != nil {
+		return loadErr
+	}
+	c8.AddAudioSink(terminal)
+
+	if attachErr := extras.attach(c8, cfg); attachErr != nil {
+		return attachErr
+	}
+
+	// Leave the terminal first, or the warning would be drawn over.
+	runErr := c8.Run()
+	terminal.Close()
+	warnInvalidOpCodes(c8.CPU())
+	return runErr
+}
+
+func runHeadless(rom []byte, cfg config.Config, opts emulator.Options, extras runExtras) error {
+	c8 := emulator.NewChip8(opts, extras.wrap(nil))
+	if loadErr := c8.LoadBytes(rom); loadErr != nil {
+		return loadErr
+	}
+
+	if attachErr := extras.attach(c8, cfg); attachErr != nil {
+		return attachErr
+	}
+
+	// Let Ctrl-C finish the recording instead of killing the process.
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer func() {
+		signal.Stop(interrupt)
+		close(interrupt)
+	}()
+	go func() {
+		if _, ok := <-interrupt; ok {
+			c8.Stop()
+		}
+	}()
+
+	runErr := c8.Run()
+	warnInvalidOpCodes(c8.CPU())
+	return runErr
+}
+
+// warnInvalidOpCodes tells about the unknown instructions the program ran
+// into, which were skipped, on stderr as stdout may be taken by output.
+func warnInvalidOpCodes(c *cpu.CPU) {
+	if c.InvalidOpCodes > 0 {
+		fmt.Fprintf(os.Stderr, "warning: skipped %d unknown instructions, the last %s\n", c.InvalidOpCodes, c.LastInvalidOpCode)
+	}
+}
@@ -0,0 +1,278 @@
+package script
+
+import (
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"fmt"
+	"image/png"
+	"os"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// The API, as global tables:
+//
+//	emu.frame()                        frames emulated since the script started
+//	emu.stop()                         makes the emulator quit after the frame
+//	emu.on_frame(fn)                   fn() at the end of every frame
+//	emu.on_pc(address, fn)             fn(address) in front of the instruction at address
+//	emu.on_write(address, [size,] fn)  fn(address, value) for each byte FX33 or FX55 write there
+//	emu.on_key(fn)                     fn(key, pressed) when a key goes down or up
+//
+//	cpu.v(x [, value])                 gets or sets VX, and likewise
+//	cpu.i, cpu.pc, cpu.dt, cpu.st      for I, PC and the timers
+//	cpu.cycles()                       instructions executed so far
+//	memory.read(address [, count])     returns count bytes, 1 by default
+//	memory.write(address, byte, ...)
+//	keys.get(key)
+//	keys.set(key, pressed)
+//
+//	gui.pixel(x, y [, on])             draws on the overlay over the screen
+//	gui.rect(x, y, width, height [, on])
+//	gui.number(x, y, n)                in the CHIP-8 font, 5 pixels a digit
+//	gui.clear()
+//	screen.pixel(x, y)                 whether the game's pixel is on
+//	screen.hash()                      as printed by the test command
+//	screen.save(file [, scale])        writes a PNG screenshot, without the overlay
+//
+// Writes by the script go through to the machine at once, they do not run
+// the write hooks.
+
+func (s *Script) register() {
+	L := s.L
+	tables := map[string]map[string]lua.LGFunction{
+		"emu": {
+			"frame":    s.frame,
+			"stop":     s.stop,
+			"on_frame": s.addFrameHook,
+			"on_pc":    s.addPCHook,
+			"on_write": s.addWriteHook,
+			"on_key":   s.addKeyHook,
+		},
+		"cpu": {
+			"v":      s.v,
+			"i":      s.register16(func(c *cpu.CPU) *uint16 { return &c.IndexRegister }, cpu.RAM_SIZE-1),
+			"pc":     s.register16(func(c *cpu.CPU) *uint16 { return &c.ProgramCounter }, cpu.RAM_SIZE-2),
+			"dt":     s.register8(func(c *cpu.CPU) *uint8 { return &c.DelayTimer }),
+			"st":     s.register8(func(c *cpu.CPU) *uint8 { return &c.SoundTimer }),
+			"cycles": s.cycles,
+		},
+		"memory": {
+			"read":  s.readMemory,
+			"write": s.writeMemory,
+		},
+		"keys": {
+			"get": s.getKey,
+			"set": s.setKey,
+		},
+		"gui": {
+			"pixel":  s.guiPixel,
+			"rect":   s.guiRect,
+			"number": s.guiNumber,
+			"clear":  s.guiClear,
+		},
+		"screen": {
+			"pixel": s.screenPixel,
+			"hash":  s.screenHash,
+			"save":  s.screenSave,
+		},
+	}
+	for name, funcs := range tables {
+		L.SetGlobal(name, L.SetFuncs(L.NewTable(), funcs))
+	}
+	L.SetGlobal("print", L.NewFunction(s.print))
+}
+
+func (s *Script) cpu() *cpu.CPU {
+	return s.c8.CPU()
+}
+
+// checkRange returns argument n, raising an error unless it is between
+// low and high.
+func checkRange(L *lua.LState, n, low, high int) int {
+	value := L.CheckInt(n)
+	if value < low || value > high {
+		L.ArgError(n, fmt.Sprintf("%d out of range 0x%X-0x%X", value, low, high))
+	}
+	return value
+}
+
+func (s *Script) frame(L *lua.LState) int {
+	L.Push(lua.LNumber(s.frames))
+	return 1
+}
+
+func (s *Script) stop(L *lua.LState) int {
+	s.c8.Stop()
+	return 0
+}
+
+func (s *Script) addFrameHook(L *lua.LState) int {
+	s.onFrame = append(s.onFrame, L.CheckFunction(1))
+	return 0
+}
+
+func (s *Script) addPCHook(L *lua.LState) int {
+	address := uint16(checkRange(L, 1, 0, cpu.RAM_SIZE-2))
+	s.onPC[address] = append(s.onPC[address], L.CheckFunction(2))
+	return 0
+}
+
+func (s *Script) addWriteHook(L *lua.LState) int {
+	address := checkRange(L, 1, 0, cpu.RAM_SIZE-1)
+	size, fn := 1, 2
+	if L.GetTop() > 2 {
+		size, fn = checkRange(L, 2, 1, cpu.RAM_SIZE-address), 3
+	}
+	s.onWrite = append(s.onWrite, writeHook{start: address, end: address + size, fn: L.CheckFunction(fn)})
+	return 0
+}
+
+func (s *Script) addKeyHook(L *lua.LState) int {
+	s.onKey = append(s.onKey, L.CheckFunction(1))
+	return 0
+}
+
+func (s *Script) v(L *lua.LState) int {
+	x := checkRange(L, 1, 0, cpu.NUM_REGS-1)
+	if L.GetTop() > 1 {
+		s.cpu().VRegisters[x] = uint8(checkRange(L, 2, 0, 0xFF))
+		return 0
+	}
+	L.Push(lua.LNumber(s.cpu().VRegisters[x]))
+	return 1
+}
+
+// register8 makes a function getting the register without an argument,
+// and setting it to the argument otherwise.
+func (s *Script) register8(register func(c *cpu.CPU) *uint8) lua.LGFunction {
+	return func(L *lua.LState) int {
+		r := register(s.cpu())
+		if L.GetTop() > 0 {
+			*r = uint8(checkRange(L, 1, 0, 0xFF))
+			return 0
+		}
+		L.Push(lua.LNumber(*r))
+		return 1
+	}
+}
+
+func (s *Script) register16(register func(c *cpu.CPU) *uint16, high int) lua.LGFunction {
+	return func(L *lua.LState) int {
+		r := register(s.cpu())
+		if L.GetTop() > 0 {
+			*r = uint16(checkRange(L, 1, 0, high))
+			return 0
+		}
+		L.Push(lua.LNumber(*r))
+		return 1
+	}
+}
+
+func (s *Script) cycles(L *lua.LState) int {
+	L.Push(lua.LNumber(s.cpu().Cycles))
+	return 1
+}
+
+func (s *Script) readMemory(L *lua.LState) int {
+	address := checkRange(L, 1, 0, cpu.RAM_SIZE-1)
+	count := 1
+	if L.GetTop() > 1 {
+		count = checkRange(L, 2, 1, cpu.RAM_SIZE-address)
+	}
+	for _, b := range s.cpu().Memory[address : address+count] {
+		L.Push(lua.LNumber(b))
+	}
+	return count
+}
+
+func (s *Script) writeMemory(L *lua.LState) int {
+	address := checkRange(L, 1, 0, cpu.RAM_SIZE-1)
+	data := make([]byte, L.GetTop()-1)
+	if address+len(data) > cpu.RAM_SIZE {
+		L.RaiseError("writing %d bytes at 0x%03X runs past the end of memory", len(data), address)
+	}
+	for i := range data {
+		data[i] = byte(checkRange(L, i+2, 0, 0xFF))
+	}
+	s.cpu().WriteMemory(uint16(address), data)
+	return 0
+}
+
+func (s *Script) getKey(L *lua.LState) int {
+	L.Push(lua.LBool(s.cpu().Keys[checkRange(L, 1, 0, cpu.NUM_KEYS-1)]))
+	return 1
+}
+
+func (s *Script) setKey(L *lua.LState) int {
+	s.cpu().SetKey(uint8(checkRange(L, 1, 0, cpu.NUM_KEYS-1)), L.CheckBool(2))
+	return 0
+}
+
+func (s *Script) guiPixel(L *lua.LState) int {
+	s.drawOverlay(L.CheckInt(1), L.CheckInt(2), 1, 1, L.OptBool(3, true))
+	return 0
+}
+
+func (s *Script) guiRect(L *lua.LState) int {
+	s.drawOverlay(L.CheckInt(1), L.CheckInt(2), L.CheckInt(3), L.CheckInt(4), L.OptBool(5, true))
+	return 0
+}
+
+func (s *Script) guiNumber(L *lua.LState) int {
+	x, y, n := L.CheckInt(1), L.CheckInt(2), L.CheckInt(3)
+	if n < 0 {
+		L.ArgError(3, "negative numbers are not drawn")
+	}
+	s.drawNumber(x, y, n)
+	return 0
+}
+
+func (s *Script) guiClear(L *lua.LState) int {
+	s.overlay.Clear()
+	s.overlayDirty = true
+	return 0
+}
+
+func (s *Script) screenPixel(L *lua.LState) int {
+	x, y := checkRange(L, 1, 0, cpu.SCREEN_WIDTH-1), checkRange(L, 2, 0, cpu.SCREEN_HEIGHT-1)
+	L.Push(lua.LBool(s.cpu().Screen.Pixel(x, y)))
+	return 1
+}
+
+func (s *Script) screenHash(L *lua.LState) int {
+	L.Push(lua.LString(emulator.ScreenHash(s.cpu())))
+	return 1
+}
+
+func (s *Script) screenSave(L *lua.LState) int {
+	fileName := L.CheckString(1)
+	scale := 1
+	if L.GetTop() > 1 {
+		scale = checkRange(L, 2, 1, 32)
+	}
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		L.RaiseError("%v", err)
+	}
+	err = png.Encode(file, emulator.ScreenImage(&s.cpu().Screen, s.opts.Palette, scale))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		L.RaiseError("%v", err)
+	}
+	return 0
+}
+
+// print writes its arguments like Lua's, to Options.Stdout.
+func (s *Script) print(L *lua.LState) int {
+	values := make([]string, L.GetTop())
+	for i := range values {
+		values[i] = L.ToStringMeta(L.Get(i + 1)).String()
+	}
+	fmt.Fprintln(s.opts.Stdout, strings.Join(values, "\t"))
+	return 0
+}
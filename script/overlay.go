@@ -0,0 +1,82 @@
+package script
+
+import (
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"strconv"
+)
+
+// drawOverlay sets the pixels of a rectangle of the overlay, clipped to
+// the screen.
+func (s *Script) drawOverlay(x, y, width, height int, on bool) {
+	for row := max(y, 0); row < min(y+height, cpu.SCREEN_HEIGHT); row++ {
+		for column := max(x, 0); column < min(x+width, cpu.SCREEN_WIDTH); column++ {
+			s.overlay.Set(column, row, on)
+		}
+	}
+	s.overlayDirty = true
+}
+
+// drawNumber draws the decimal digits of n with the sprites of the
+// built-in font, which are 4 by 5 pixels.
+func (s *Script) drawNumber(x, y, n int) {
+	for i, digit := range strconv.Itoa(n) {
+		sprite := cpu.FONTSET[(digit-'0')*5:][:5]
+		for row, bits := range sprite {
+			for column := range 4 {
+				if bits&(0x80>>column) != 0 {
+					s.drawOverlay(x+i*5+column, y+row, 1, 1, true)
+				}
+			}
+		}
+	}
+}
+
+// Frontend draws the script's overlay over the screen of inner. A nil
+// inner stays nil, there is nothing to draw on when running headless.
+func (s *Script) Frontend(inner emulator.Frontend) emulator.Frontend {
+	if inner == nil {
+		return nil
+	}
+	return &frontend{script: s, inner: inner}
+}
+
+type frontend struct {
+	script *Script
+	inner  emulator.Frontend
+}
+
+// Draw ORs the overlay into the screen for the time inner draws it.
+func (f *frontend) Draw(c *cpu.CPU) error {
+	f.script.overlayDirty = false
+	if f.script.overlay == (cpu.Framebuffer{}) {
+		return f.inner.Draw(c)
+	}
+
+	screen := c.Screen
+	for y, row := range f.script.overlay {
+		c.Screen[y] |= row
+	}
+	err := f.inner.Draw(c)
+	c.Screen = screen
+	return err
+}
+
+// PollInput also draws when only the overlay changed, as the emulator
+// does not know about it.
+func (f *frontend) PollInput(c *cpu.CPU) bool {
+	if f.script.overlayDirty {
+		if err := f.Draw(c); err != nil {
+			f.script.fail(err)
+		}
+	}
+	return f.inner.PollInput(c)
+}
+
+// Controls passes on the controls of inner, if it is a Controller.
+func (f *frontend) Controls(state emulator.RunState) []emulator.Control {
+	if controller, ok := f.inner.(emulator.Controller); ok {
+		return controller.Controls(state)
+	}
+	return nil
+}
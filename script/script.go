@@ -0,0 +1,184 @@
+// Package script runs Lua scripts alongside the emulator, for auto-play
+// tests, trainers and TAS tooling. A script registers hooks on the end of
+// a frame, the PC reaching an address, memory being written and keys
+// changing, and reads and writes the machine from them, see api.go.
+//
+// A Script is a cpu.Tracer, which is how it sees every instruction, so the
+// CPU always interprets while one runs.
+package script
+
+import (
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+type Options struct {
+	// Colours of the screenshots.
+	Palette emulator.Palette
+	// Where print writes, os.Stdout when nil.
+	Stdout io.Writer
+}
+
+type writeHook struct {
+	start, end int
+	fn         *lua.LFunction
+}
+
+type Script struct {
+	opts Options
+	L    *lua.LState
+	main *lua.LFunction
+
+	c8 *emulator.Chip8
+	// The first error from the script, which stops the emulator.
+	err error
+
+	frames    int
+	frameDone bool
+	keys      [cpu.NUM_KEYS]bool
+
+	onFrame []*lua.LFunction
+	onPC    map[uint16][]*lua.LFunction
+	onWrite []writeHook
+	onKey   []*lua.LFunction
+
+	overlay      cpu.Framebuffer
+	overlayDirty bool
+}
+
+// Load compiles the script in fileName. It runs once attached.
+func Load(fileName string, opts Options) (*Script, error) {
+	source, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return New(fileName, string(source), opts)
+}
+
+// New compiles source, name is used in error messages.
+func New(name, source string, opts Options) (*Script, error) {
+	if opts.Stdout == nil {
+		opts.Stdout = os.Stdout
+	}
+	if opts.Palette == (emulator.Palette{}) {
+		opts.Palette = emulator.Palettes[emulator.DEFAULT_PALETTE]
+	}
+
+	s := &Script{opts: opts, L: lua.NewState(), onPC: make(map[uint16][]*lua.LFunction)}
+	main, err := s.L.Load(strings.NewReader(source), name)
+	if err != nil {
+		s.L.Close()
+		return nil, luaError(err)
+	}
+	s.main = main
+	s.register()
+
+	return s, nil
+}
+
+// Attach runs the script on c8, which has to have the script among the
+// tracers of its options. The script's hooks run from then on.
+func (s *Script) Attach(c8 *emulator.Chip8) error {
+	s.c8 = c8
+	c8.AddFrameHook(s.frameHook)
+
+	var err error
+	c8.Sync(func(c *cpu.CPU) {
+		s.keys = c.Keys
+		s.L.Push(s.main)
+		err = s.L.PCall(0, lua.MultRet, nil)
+	})
+	return luaError(err)
+}
+
+// Err returns the error that stopped the script, if any.
+func (s *Script) Err() error {
+	return s.err
+}
+
+func (s *Script) Close() {
+	s.L.Close()
+}
+
+func (s *Script) TraceInstruction(entry *cpu.TraceEntry) {
+	if s.err != nil || s.c8 == nil {
+		return
+	}
+
+	if len(s.onWrite) > 0 {
+		if start, end := entry.Written(); start < end {
+			memory := &s.c8.CPU().Memory
+			for _, hook := range s.onWrite {
+				for address := max(start, hook.start); address < min(end, hook.end); address++ {
+					s.call(hook.fn, lua.LNumber(address), lua.LNumber(memory[address]))
+				}
+			}
+		}
+	}
+
+	// The PC hooks run in front of the instruction at the address.
+	if hooks := s.onPC[s.c8.CPU().ProgramCounter]; len(hooks) > 0 {
+		pc := lua.LNumber(s.c8.CPU().ProgramCounter)
+		for _, fn := range hooks {
+			s.call(fn, pc)
+		}
+	}
+}
+
+func (s *Script) TraceFrame() {
+	s.frames++
+	s.frameDone = true
+}
+
+// frameHook runs the key and frame hooks after a frame was emulated, not
+// while paused. Keys set by the script itself are not reported.
+func (s *Script) frameHook(c *cpu.CPU) {
+	if !s.frameDone || s.err != nil {
+		return
+	}
+	s.frameDone = false
+
+	for key, pressed := range c.Keys {
+		if pressed == s.keys[key] {
+			continue
+		}
+		for _, fn := range s.onKey {
+			s.call(fn, lua.LNumber(key), lua.LBool(pressed))
+		}
+	}
+	for _, fn := range s.onFrame {
+		s.call(fn)
+	}
+	s.keys = c.Keys
+}
+
+// call runs a hook, stopping the emulator if it fails.
+func (s *Script) call(fn *lua.LFunction, args ...lua.LValue) {
+	if s.err != nil {
+		return
+	}
+	if err := s.L.CallByParam(lua.P{Fn: fn, Protect: true}, args...); err != nil {
+		s.fail(err)
+	}
+}
+
+func (s *Script) fail(err error) {
+	s.err = luaError(err)
+	s.c8.Stop()
+}
+
+// luaError drops the stack traceback from Lua errors, their message
+// already says where in the script they happened.
+func luaError(err error) error {
+	var apiErr *lua.ApiError
+	if errors.As(err, &apiErr) {
+		return errors.New(strings.TrimSpace(apiErr.Object.String()))
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package script_test
+
+import (
+	"bytes"
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"chip-8-go/script"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The test ROM writes 123 as BCD to 0x300, then counts in V1 forever.
+var rom = []byte{
+	0xA3, 0x00, // 0x200: LD I, 0x300
+	0x60, 0x7B, // 0x202: LD V0, 123
+	0xF0, 0x33, // 0x204: LD B, V0
+	0x71, 0x01, // 0x206: ADD V1, 1
+	0x12, 0x06, // 0x208: JP 0x206
+}
+
+// start runs source on a headless emulator running the test ROM for
+// frames frames.
+func start(t *testing.T, source string, frames int, frontend emulator.Frontend) (*script.Script, *emulator.Chip8, *bytes.Buffer) {
+	var out bytes.Buffer
+	s, err := script.New("test.lua", source, script.Options{Stdout: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+
+	opts := emulator.DefaultOptions()
+	opts.MaxFrames = frames
+	opts.Tracer = s
+	c8 := emulator.NewChip8(opts, s.Frontend(frontend))
+	if err := c8.LoadBytes(rom); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Attach(c8); err != nil {
+		t.Fatal(err)
+	}
+	return s, c8, &out
+}
+
+func TestHooks(t *testing.T) {
+	assert := assert.New(t)
+
+	s, c8, out := start(t, `
+		emu.on_write(0x300, 3, function(address, value) print("write", address, value) end)
+		emu.on_pc(0x206, function() loops = (loops or 0) + 1 end)
+		emu.on_key(function(key, pressed) print("key", key, pressed) end)
+		emu.on_frame(function()
+			if emu.frame() == 5 then
+				print("frame", emu.frame(), "V1", cpu.v(1), "loops", loops)
+				cpu.v(1, 0)
+				memory.write(0x300, 7, 8)
+				keys.set(2, true)
+			end
+		end)
+	`, 10, nil)
+	frames := 0
+	c8.AddFrameHook(func(c *cpu.CPU) {
+		if frames++; frames == 7 {
+			c.SetKey(0xA, true)
+		}
+	})
+	assert.NoError(c8.Run())
+	assert.NoError(s.Err())
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal([]string{
+		"write\t768\t1",
+		"write\t769\t2",
+		"write\t770\t3",
+		"frame\t5\tV1\t24\tloops\t24",
+		"key\t10\ttrue",
+	}, lines, "the hooks should have run in order")
+
+	c := c8.CPU()
+	assert.Equal([]byte{7, 8, 3}, c.Memory[0x300:0x303], "memory.write")
+	assert.True(c.Keys[2], "keys.set")
+	assert.Equal(uint8(25), c.VRegisters[1], "cpu.v should have reset V1")
+}
+
+func TestError(t *testing.T) {
+	assert := assert.New(t)
+
+	s, c8, _ := start(t, `emu.on_frame(function() if emu.frame() == 3 then memory.read(0x1000) end end)`, 10, nil)
+	assert.NoError(c8.Run())
+	assert.ErrorContains(s.Err(), "test.lua:1: bad argument #1 to read", "the error should say where it happened")
+	assert.Equal(3, c8.Frames(), "the error should stop the emulator")
+
+	s, c8, _ = start(t, `emu.on_frame(function() cpu.i(0x1000) end)`, 10, nil)
+	assert.NoError(c8.Run())
+	assert.ErrorContains(s.Err(), "bad argument #1 to i", "I outside memory should be rejected")
+
+	_, err := script.New("test.lua", "emu.on_frame(", script.Options{})
+	assert.Error(err, "syntax errors should be reported by New")
+}
+
+// recorder is a frontend keeping what it was last asked to draw.
+type recorder struct {
+	screen cpu.Framebuffer
+	draws  int
+}
+
+func (r *recorder) Draw(c *cpu.CPU) error {
+	r.screen = c.Screen
+	r.draws++
+	return nil
+}
+
+func (r *recorder) PollInput(c *cpu.CPU) bool {
+	return false
+}
+
+func TestOverlay(t *testing.T) {
+	assert := assert.New(t)
+
+	screenshot := filepath.Join(t.TempDir(), "screen.png")
+	frontend := &recorder{}
+	_, c8, out := start(t, `
+		gui.rect(60, 30, 10, 10)
+		gui.number(0, 0, 10)
+		emu.on_frame(function()
+			print(screen.hash())
+			screen.save("`+filepath.ToSlash(screenshot)+`", 2)
+		end)
+	`, 1, frontend)
+	assert.NoError(c8.Run())
+
+	assert.Equal(1, frontend.draws, "the overlay should be drawn although the game did not draw")
+	for _, pixel := range [][2]int{{63, 31}, {60, 30}, {2, 0}, {5, 0}} {
+		assert.True(frontend.screen.Pixel(pixel[0], pixel[1]), "overlay pixel %v", pixel)
+	}
+	assert.False(frontend.screen.Pixel(59, 31), "outside the rectangle")
+	assert.Equal(cpu.Framebuffer{}, c8.CPU().Screen, "the overlay should not stay on the screen")
+	assert.Equal(emulator.ScreenHash(c8.CPU())+"\n", out.String(), "screen.hash")
+
+	info, err := os.Stat(screenshot)
+	if assert.NoError(err, "screen.save") {
+		assert.NotZero(info.Size(), "screenshot size")
+	}
+}
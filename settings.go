@@ -0,0 +1,289 @@
+package main
+
+import (
+	"chip-8-go/config"
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"chip-8-go/romdb"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// optionalValue is a flag that only overrides a config setting when it is
+// given on the command line. The default shown in the help text is the
+// built-in one.
+type optionalValue[T any] struct {
+	target **T
+	def    T
+	parse  func(string) (T, error)
+}
+
+func (v *optionalValue[T]) String() string {
+	if v == nil || v.target == nil {
+		return ""
+	}
+	if *v.target != nil {
+		return fmt.Sprint(**v.target)
+	}
+	return fmt.Sprint(v.def)
+}
+
+func (v *optionalValue[T]) Set(text string) error {
+	value, err := v.parse(text)
+	if err != nil {
+		return err
+	}
+	*v.target = &value
+	return nil
+}
+
+type optionalBool struct {
+	optionalValue[bool]
+}
+
+func (v *optionalBool) IsBoolFlag() bool {
+	return true
+}
+
+func parseString(text string) (string, error) {
+	return text, nil
+}
+
+func parseAddress(text string) (config.Address, error) {
+	var address config.Address
+	err := address.Set(text)
+	return address, err
+}
+
+// configFlags collects the command-line layer of configuration.
+type configFlags struct {
+	path      string
+	romdbPath string
+	noROMDB   bool
+	overrides config.Settings
+}
+
+func addConfigFlags(fs *flag.FlagSet) *configFlags {
+	c := &configFlags{}
+	fs.StringVar(&c.path, "config", "", "config `file` to use instead of the one in the user config directory")
+	fs.StringVar(&c.romdbPath, "romdb", "", "ROM database `file` in programs.json format overriding the built-in one")
+	fs.BoolVar(&c.noROMDB, "no-romdb", false, "do not detect platform and settings from the ROM database")
+	return c
+}
+
+func (c *configFlags) addMachineFlags(fs *flag.FlagSet) {
+	defaults := config.Default()
+	s := &c.overrides
+
+	fs.Var(&optionalValue[int]{&s.IPF, defaults.IPF, strconv.Atoi}, "ipf", "instructions executed per frame (60 frames per second)")
+	fs.Var(&optionalValue[string]{&s.Quirks, defaults.Quirks, parseString}, "quirks", "quirk preset ("+strings.Join(cpu.QuirkPresetNames(), ", ")+"), optionally followed by +quirk or -quirk")
+	fs.Var(&optionalValue[config.Address]{&s.LoadAddress, defaults.LoadAddress, parseAddress}, "load-addr", "address the ROM is loaded at, e.g. 0x600 for ETI 660 programs")
+}
+
+func (c *configFlags) addDisplayFlags(fs *flag.FlagSet) {
+	defaults := config.Default()
+	s := &c.overrides
+
+	fs.Var(&optionalValue[int]{&s.Scale, defaults.Scale, strconv.Atoi}, "scale", "window pixels per CHIP-8 pixel")
+	fs.Var(&optionalValue[int]{&s.FrameRate, defaults.FrameRate, strconv.Atoi}, "frame-rate", "frames per second, the timers tick once per frame")
+	fs.Var(&optionalValue[int]{&s.FastForward, defaults.FastForward, strconv.Atoi}, "fast-forward", "times the frame rate to run at while Tab is held")
+	fs.Var(&optionalValue[string]{&s.Palette, defaults.Palette, parseString}, "palette", "colour palette: "+strings.Join(emulator.PaletteNames(), ", ")+", or FG:BG in hex")
+	fs.Var(&optionalBool{optionalValue[bool]{&s.Mute, defaults.Mute, strconv.ParseBool}}, "mute", "disable sound output")
+	fs.Var(&optionalBool{optionalValue[bool]{&s.Fullscreen, defaults.Fullscreen, strconv.ParseBool}}, "fullscreen", "start in fullscreen")
+}
+
+// loadFile reads the config file given with -config, or the default one
+// if it exists. It returns the path that was actually used, if any.
+func (c *configFlags) loadFile() (*config.File, string, error) {
+	if c.path == "" {
+		return config.LoadDefault()
+	}
+
+	file, err := config.Load(c.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, "", usageError{fmt.Sprintf("config file %s does not exist", c.path)}
+	}
+
+	return file, c.path, err
+}
+
+// loadROMDB returns the built-in ROM database with the local override
+// file applied: the one given with -romdb, or programs.json next to the
+// config file if it exists.
+func (c *configFlags) loadROMDB() (*romdb.Database, error) {
+	db, err := romdb.Embedded()
+	if err != nil {
+		return nil, err
+	}
+
+	overridePath := c.romdbPath
+	if overridePath == "" {
+		if dir, dirErr := config.DefaultPath(); dirErr == nil {
+			defaultPath := filepath.Join(filepath.Dir(dir), romdb.OVERRIDE_FILE_NAME)
+			if _, statErr := os.Stat(defaultPath); statErr == nil {
+				overridePath = defaultPath
+			}
+		}
+	}
+	if overridePath == "" {
+		return db, nil
+	}
+
+	// Overrides must not leak into the shared embedded database.
+	db = db.Clone()
+	if overrideErr := db.LoadOverride(overridePath); overrideErr != nil {
+		return nil, overrideErr
+	}
+
+	return db, nil
+}
+
+// identify looks rom up in the ROM database, unless that was disabled.
+func (c *configFlags) identify(rom []byte) (*romdb.Entry, error) {
+	if rom == nil || c.noROMDB {
+		return nil, nil
+	}
+
+	db, err := c.loadROMDB()
+	if err != nil {
+		return nil, err
+	}
+
+	entry, ok := db.Identify(rom)
+	if !ok {
+		return nil, nil
+	}
+
+	return &entry, nil
+}
+
+// resolve returns the effective config for rom: defaults, the global
+// config file settings, what the ROM database knows about the ROM, the
+// config file section for the ROM and finally the command-line flags.
+// The database entry is returned as well when the ROM was recognised.
+func (c *configFlags) resolve(rom []byte) (config.Config, *romdb.Entry, error) {
+	file, _, err := c.loadFile()
+	if err != nil {
+		return config.Config{}, nil, err
+	}
+
+	return c.resolveFile(file, rom)
+}
+
+// resolveFile is resolve with the config file already loaded.
+func (c *configFlags) resolveFile(file *config.File, rom []byte) (config.Config, *romdb.Entry, error) {
+	entry, err := c.identify(rom)
+	if err != nil {
+		return config.Config{}, nil, err
+	}
+
+	var romHash string
+	var detected config.Settings
+	if rom != nil {
+		romHash = config.HashROM(rom)
+	}
+	if entry != nil {
+		detected = entry.Settings()
+	}
+
+	cfg := file.Resolve(romHash, detected)
+	cfg.Apply(c.overrides)
+
+	if validateErr := cfg.Validate(); validateErr != nil {
+		return cfg, entry, validateErr
+	}
+
+	return cfg, entry, nil
+}
+
+// printROMInfo introduces a recognised ROM when it is loaded.
+func printROMInfo(w io.Writer, entry *romdb.Entry) {
+	if entry == nil {
+		return
+	}
+
+	fmt.Fprintln(w, entry.Summary())
+	if description := entry.Description(); description != "" {
+		fmt.Fprintln(w, description)
+	}
+}
+
+func configCommand(args []string) error {
+	fs := newFlagSet("config")
+	configFlags := addConfigFlags(fs)
+	configFlags.addMachineFlags(fs)
+	configFlags.addDisplayFlags(fs)
+
+	positional, err := parseArgsRange(fs, args, 1, 2)
+	if err != nil {
+		return err
+	}
+
+	switch positional[0] {
+	case "path":
+		if len(positional) != 1 {
+			return usageError{"usage: chip-8-go config path [-config file]"}
+		}
+		return printConfigPath(configFlags)
+	case "dump":
+		var rom []byte
+		if len(positional) == 2 {
+			if rom, err = readROM(positional[1]); err != nil {
+				return err
+			}
+		}
+		return dumpConfig(configFlags, rom)
+	default:
+		return usageError{fmt.Sprintf("unknown config action %q: expected dump or path", positional[0])}
+	}
+}
+
+func printConfigPath(configFlags *configFlags) error {
+	if configFlags.path != "" {
+		fmt.Println(configFlags.path)
+		return nil
+	}
+
+	path, err := config.DefaultPath()
+	if err != nil {
+		return err
+	}
+	fmt.Println(path)
+
+	return nil
+}
+
+func dumpConfig(configFlags *configFlags, rom []byte) error {
+	file, path, err := configFlags.loadFile()
+	if err != nil {
+		return err
+	}
+
+	cfg, entry, err := configFlags.resolveFile(file, rom)
+	if err != nil {
+		return err
+	}
+
+	if path == "" {
+		path = "none, using defaults"
+	}
+	fmt.Fprintf(os.Stderr, "config file: %s\n", path)
+	if rom != nil {
+		fmt.Fprintf(os.Stderr, "ROM SHA-1:   %s\n", config.HashROM(rom))
+	}
+	if entry != nil {
+		fmt.Fprintf(os.Stderr, "ROM:         %s\n", entry.Summary())
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(cfg)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"crypto/sha1"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// readROM reads a ROM file for commands that only inspect it. Zip
+// archives are unpacked like when running.
+func readROM(fileName string) ([]byte, error) {
+	return emulator.ReadROMFile(fileName)
+}
+
+func disasmCommand(args []string) error {
+	fs := newFlagSet("disasm")
+	loadAddr := fs.Uint("load-addr", cpu.START_ADDR, "address the ROM is loaded at")
+
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if *loadAddr >= cpu.RAM_SIZE {
+		return usageError{fmt.Sprintf("invalid -load-addr 0x%X", *loadAddr)}
+	}
+
+	rom, err := readROM(positional[0])
+	if err != nil {
+		return err
+	}
+
+	for _, instruction := range cpu.Disassemble(rom, uint16(*loadAddr)) {
+		fmt.Println(instruction)
+	}
+
+	return nil
+}
+
+func infoCommand(args []string) error {
+	fs := newFlagSet("info")
+	configFlags := addConfigFlags(fs)
+
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	rom, err := readROM(positional[0])
+	if err != nil {
+		return err
+	}
+
+	fits := "yes"
+	if len(rom) > cpu.RAM_SIZE-cpu.START_ADDR {
+		fits = "no, too big for 0x200"
+	}
+
+	fmt.Printf("File:   %s\n", positional[0])
+	fmt.Printf("Size:   %d bytes\n", len(rom))
+	fmt.Printf("SHA-1:  %x\n", sha1.Sum(rom))
+	fmt.Printf("Range:  0x%03X-0x%03X\n", cpu.START_ADDR, cpu.START_ADDR+len(rom)-1)
+	fmt.Printf("Fits:   %s\n", fits)
+
+	entry, err := configFlags.identify(rom)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		fmt.Printf("Not found in the ROM database\n")
+		return nil
+	}
+
+	fmt.Printf("\nTitle:    %s\n", entry.Program.Title)
+	if len(entry.Program.Authors) > 0 {
+		fmt.Printf("Authors:  %s\n", strings.Join(entry.Program.Authors, ", "))
+	}
+	if entry.Program.Release != "" {
+		fmt.Printf("Release:  %s\n", entry.Program.Release)
+	}
+	if description := entry.Description(); description != "" {
+		fmt.Printf("About:    %s\n", description)
+	}
+	if entry.Platform.ID != "" {
+		fmt.Printf("Platform: %s (%s)\n", entry.Platform.Name, entry.Platform.ID)
+	}
+
+	settings := entry.Settings()
+	if settings.Quirks != nil {
+		fmt.Printf("Quirks:   %s\n", *settings.Quirks)
+	}
+	if settings.IPF != nil {
+		fmt.Printf("IPF:      %d\n", *settings.IPF)
+	}
+	if len(settings.Keys) > 0 {
+		keys := make([]string, 0, len(settings.Keys))
+		for key, name := range settings.Keys {
+			keys = append(keys, key+"="+strings.TrimPrefix(name, "+"))
+		}
+		sort.Strings(keys)
+		fmt.Printf("Keys:     %s\n", strings.Join(keys, " "))
+	}
+
+	return nil
+}
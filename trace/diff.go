@@ -0,0 +1,118 @@
+package trace
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Divergence is where two traces first disagree.
+type Divergence struct {
+	Ours, Reference *Record
+	// What differs, e.g. "V3: 05 != 06", ours first.
+	Differences []string
+	// Our instructions leading up to it, oldest first. The last one
+	// usually caused the difference.
+	Context []*Record
+}
+
+type Result struct {
+	// Instructions found in both traces that agreed.
+	Compared int
+	// Nil when the traces agree.
+	Divergence *Divergence
+	// Whether a trace went on after the other ended.
+	OursLeft, ReferenceLeft bool
+}
+
+// Diff lines up two traces by cycle and compares the registers before
+// each instruction found in both, as far as both recorded them. Cycles
+// only one trace has, e.g. because of a filter, are skipped. It keeps
+// up to context of our instructions to show before a divergence.
+func Diff(ours, reference *Reader, context int) (Result, error) {
+	var result Result
+	var recent []*Record
+
+	a, err := next(ours)
+	if err != nil {
+		return result, err
+	}
+	b, err := next(reference)
+	if err != nil {
+		return result, err
+	}
+
+	for a != nil && b != nil {
+		switch {
+		case a.Cycle < b.Cycle:
+			a, err = next(ours)
+		case b.Cycle < a.Cycle:
+			b, err = next(reference)
+		default:
+			if differences := compare(a, b); len(differences) > 0 {
+				result.Divergence = &Divergence{Ours: a, Reference: b, Differences: differences, Context: recent}
+				return result, nil
+			}
+
+			result.Compared++
+			if context > 0 {
+				if len(recent) == context {
+					recent = recent[1:]
+				}
+				recent = append(recent, a)
+			}
+			if a, err = next(ours); err == nil {
+				b, err = next(reference)
+			}
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+
+	result.OursLeft, result.ReferenceLeft = a != nil, b != nil
+	return result, nil
+}
+
+// next is Reader.Next with nil at the end.
+func next(r *Reader) (*Record, error) {
+	record, err := r.Next()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	return record, err
+}
+
+func compare(a, b *Record) []string {
+	var differences []string
+	differ := func(format string, args ...any) {
+		differences = append(differences, fmt.Sprintf(format, args...))
+	}
+
+	both := a.has & b.has
+	if a.PC != b.PC {
+		differ("PC: 0x%03X != 0x%03X", a.PC, b.PC)
+	}
+	if both&hasOpCode != 0 && a.OpCode != b.OpCode {
+		differ("opcode: %04X != %04X", a.OpCode, b.OpCode)
+	}
+	for i := range a.Before.V {
+		if both&(1<<i) != 0 && a.Before.V[i] != b.Before.V[i] {
+			differ("V%X: %02X != %02X", i, a.Before.V[i], b.Before.V[i])
+		}
+	}
+	if both&hasI != 0 && a.Before.I != b.Before.I {
+		differ("I: 0x%03X != 0x%03X", a.Before.I, b.Before.I)
+	}
+	if both&hasSP != 0 && a.Before.SP != b.Before.SP {
+		differ("SP: %d != %d", a.Before.SP, b.Before.SP)
+	}
+	if both&hasDT != 0 && a.Before.DT != b.Before.DT {
+		differ("DT: %d != %d", a.Before.DT, b.Before.DT)
+	}
+	if both&hasST != 0 && a.Before.ST != b.Before.ST {
+		differ("ST: %d != %d", a.Before.ST, b.Before.ST)
+	}
+
+	return differences
+}
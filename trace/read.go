@@ -0,0 +1,292 @@
+package trace
+
+import (
+	"bufio"
+	"chip-8-go/cpu"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// FORMAT_AUTO makes a Reader guess the format from the first line.
+const FORMAT_AUTO Format = -1
+
+// fields tells which registers a trace recorded; bit n is Vn.
+type fields uint32
+
+const (
+	hasV      fields = 0xFFFF
+	hasI      fields = 1 << 16
+	hasSP     fields = 1 << 17
+	hasDT     fields = 1 << 18
+	hasST     fields = 1 << 19
+	hasOpCode fields = 1 << 20
+	hasAll    fields = hasV | hasI | hasSP | hasDT | hasST | hasOpCode
+)
+
+// Record is an instruction read back from a trace. Register dumps only
+// have the registers before the instruction, and maybe not all of them.
+type Record struct {
+	Line
+	has fields
+}
+
+func (r *Record) String() string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "c%d 0x%03X", r.Cycle, r.PC)
+	if r.has&hasOpCode != 0 {
+		fmt.Fprintf(&text, " %04X %-16s", r.OpCode, r.Mnemonic)
+	}
+	if r.has&hasV == hasV {
+		fmt.Fprintf(&text, " V=%X", r.Before.V[:])
+	} else {
+		for i, v := range r.Before.V {
+			if r.has&(1<<i) != 0 {
+				fmt.Fprintf(&text, " V%X=%02X", i, v)
+			}
+		}
+	}
+	if r.has&hasI != 0 {
+		fmt.Fprintf(&text, " I=0x%03X", r.Before.I)
+	}
+	if r.has&hasSP != 0 {
+		fmt.Fprintf(&text, " SP=%d", r.Before.SP)
+	}
+	if r.has&hasDT != 0 {
+		fmt.Fprintf(&text, " DT=%d", r.Before.DT)
+	}
+	if r.has&hasST != 0 {
+		fmt.Fprintf(&text, " ST=%d", r.Before.ST)
+	}
+
+	return text.String()
+}
+
+// Reader reads a trace written by a Tracer, or a register dump from
+// another emulator, one instruction at a time.
+type Reader struct {
+	// Added to the cycles read, to line up a trace counting from 1.
+	Offset int64
+
+	format  Format
+	scanner *bufio.Scanner
+	line    int
+	// Instructions read, the cycle of dumps without cycles.
+	count uint64
+}
+
+func NewReader(r io.Reader, format Format) *Reader {
+	return &Reader{format: format, scanner: bufio.NewScanner(r)}
+}
+
+// DetectFormat guesses the format of a trace from one of its lines.
+func DetectFormat(line string) Format {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "{") {
+		return FORMAT_JSON
+	}
+	if fields := strings.Fields(line); len(fields) > 2 && strings.HasPrefix(fields[0], "f") && strings.HasPrefix(fields[1], "c") {
+		if _, err := strconv.Atoi(fields[0][1:]); err == nil {
+			return FORMAT_TEXT
+		}
+	}
+	return FORMAT_REGS
+}
+
+// Next returns the next instruction, or io.EOF at the end of the trace.
+// Blank lines, # comments and, in register dumps, lines without a PC are
+// skipped.
+func (r *Reader) Next() (*Record, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if r.format == FORMAT_AUTO {
+			r.format = DetectFormat(line)
+		}
+
+		var record *Record
+		var err error
+		switch r.format {
+		case FORMAT_JSON:
+			record, err = parseJSON(line)
+		case FORMAT_REGS:
+			record, err = parseRegs(line, r.count)
+		default:
+			record, err = parseText(line)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		if record == nil {
+			continue
+		}
+
+		r.count++
+		record.Cycle = uint64(int64(record.Cycle) + r.Offset)
+		if record.has&hasOpCode != 0 && record.Mnemonic == "" {
+			record.Mnemonic = cpu.OpCode(record.OpCode).Mnemonic()
+		}
+		return record, nil
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func parseJSON(text string) (*Record, error) {
+	record := &Record{has: hasAll}
+	if err := json.Unmarshal([]byte(text), &record.Line); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// parseText reads the format written by formatText.
+func parseText(text string) (*Record, error) {
+	bad := fmt.Errorf("not a text trace line: %q", text)
+
+	fields := strings.Fields(text)
+	if len(fields) < 4 {
+		return nil, bad
+	}
+	frame, frameErr := strconv.Atoi(strings.TrimPrefix(fields[0], "f"))
+	cycle, cycleErr := strconv.ParseUint(strings.TrimPrefix(fields[1], "c"), 10, 64)
+	pc, pcErr := strconv.ParseUint(fields[2], 0, 16)
+	op, opErr := strconv.ParseUint(fields[3], 16, 16)
+	registers := strings.Index(text, " V=")
+	if frameErr != nil || cycleErr != nil || pcErr != nil || opErr != nil || registers < 0 {
+		return nil, bad
+	}
+
+	record := &Record{has: hasAll}
+	record.Frame, record.Cycle, record.PC, record.OpCode = frame, cycle, uint16(pc), uint16(op)
+
+	before, changes, _ := strings.Cut(text[registers+1:], " -> ")
+	for _, field := range strings.Fields(before) {
+		if err := setText(&record.Before, field); err != nil {
+			return nil, bad
+		}
+	}
+	record.After = record.Before
+	for _, field := range strings.Fields(changes) {
+		if err := setText(&record.After, field); err != nil {
+			return nil, bad
+		}
+	}
+
+	return record, nil
+}
+
+// setText sets a register from a "name=value" field of the text format.
+func setText(regs *cpu.Registers, field string) error {
+	name, value, _ := strings.Cut(field, "=")
+	if name == "V" {
+		if len(value) != 2*len(regs.V) {
+			return fmt.Errorf("bad registers %q", value)
+		}
+		for i := range regs.V {
+			v, err := strconv.ParseUint(value[2*i:2*i+2], 16, 8)
+			if err != nil {
+				return err
+			}
+			regs.V[i] = uint8(v)
+		}
+		return nil
+	}
+
+	if len(name) == 2 && name[0] == 'V' {
+		x, xErr := strconv.ParseUint(name[1:], 16, 4)
+		v, vErr := strconv.ParseUint(value, 16, 8)
+		if xErr != nil || vErr != nil {
+			return fmt.Errorf("bad register %q", field)
+		}
+		regs.V[x] = uint8(v)
+		return nil
+	}
+
+	n, err := strconv.ParseUint(value, 0, 16)
+	if err != nil {
+		return err
+	}
+	return setRegister(regs, nil, name, n)
+}
+
+// parseRegs reads a register dump line of "NAME:value" or "NAME=value"
+// fields in hex, except for the cycle. It returns nil for lines without
+// a PC. Dumps without cycles are numbered from 0.
+func parseRegs(text string, count uint64) (*Record, error) {
+	record := &Record{}
+	record.Cycle = count
+	hasPC := false
+
+	for _, field := range strings.Fields(strings.ReplaceAll(text, ",", " ")) {
+		separator := strings.IndexAny(field, ":=")
+		if separator <= 0 {
+			continue
+		}
+		name, value := strings.ToUpper(field[:separator]), field[separator+1:]
+
+		if name == "CYCLE" || name == "CYCLES" || name == "CYC" {
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad cycle %q", value)
+			}
+			record.Cycle = n
+			continue
+		}
+
+		value = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(value), "0x"), "$")
+		n, err := strconv.ParseUint(value, 16, 16)
+		if err != nil {
+			continue
+		}
+		switch {
+		case name == "PC":
+			record.PC, hasPC = uint16(n), true
+		case name == "OP" || name == "OPCODE":
+			record.OpCode = uint16(n)
+			record.has |= hasOpCode
+		case len(name) == 2 && name[0] == 'V':
+			if x, xErr := strconv.ParseUint(name[1:], 16, 4); xErr == nil {
+				record.Before.V[x] = uint8(n)
+				record.has |= 1 << x
+			}
+		default:
+			setRegister(&record.Before, &record.has, name, n)
+		}
+	}
+
+	if !hasPC {
+		return nil, nil
+	}
+	return record, nil
+}
+
+// setRegister sets I, SP, DT or ST, noting it in has if not nil.
+func setRegister(regs *cpu.Registers, has *fields, name string, n uint64) error {
+	var field fields
+	switch name {
+	case "I":
+		regs.I, field = uint16(n), hasI
+	case "SP":
+		regs.SP, field = uint16(n), hasSP
+	case "DT":
+		regs.DT, field = uint8(n), hasDT
+	case "ST":
+		regs.ST, field = uint8(n), hasST
+	default:
+		return fmt.Errorf("unknown register %q", name)
+	}
+
+	if has != nil {
+		*has |= field
+	}
+	return nil
+}
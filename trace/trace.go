@@ -0,0 +1,338 @@
+// Package trace logs the instructions a CPU executes, as text or JSON
+// lines, to a file that is rotated when it grows too big or to a ring
+// buffer of the last instructions that is only written out on error. It
+// also reads traces back, including other emulators' register dumps, and
+// compares them.
+package trace
+
+import (
+	"bufio"
+	"chip-8-go/cpu"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type Format int
+
+const (
+	FORMAT_TEXT Format = iota
+	FORMAT_JSON
+	// Register dumps as many emulators log them, e.g.
+	// "PC:0200 OP:00E0 V0:00 ... VF:00 I:0000 SP:00 DT:00 ST:00".
+	FORMAT_REGS
+)
+
+func ParseFormat(text string) (Format, error) {
+	switch text {
+	case "text":
+		return FORMAT_TEXT, nil
+	case "json":
+		return FORMAT_JSON, nil
+	case "regs":
+		return FORMAT_REGS, nil
+	}
+	return 0, fmt.Errorf("unknown trace format %q, want text, json or regs", text)
+}
+
+// Range is an inclusive range of addresses or frames.
+type Range struct {
+	From, To int
+}
+
+func (r *Range) contains(n int) bool {
+	return r == nil || (n >= r.From && n <= r.To)
+}
+
+// ParseRange parses "from-to", or a single value, in decimal or 0x hex.
+func ParseRange(text string) (*Range, error) {
+	fromText, toText, isRange := strings.Cut(text, "-")
+	if !isRange {
+		toText = fromText
+	}
+
+	from, err := strconv.ParseUint(strings.TrimSpace(fromText), 0, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid range %q", text)
+	}
+	to, err := strconv.ParseUint(strings.TrimSpace(toText), 0, 32)
+	if err != nil || to < from {
+		return nil, fmt.Errorf("invalid range %q", text)
+	}
+
+	return &Range{From: int(from), To: int(to)}, nil
+}
+
+// Filter selects the instructions to trace. The zero Filter traces all.
+type Filter struct {
+	// Addresses of the instructions, nil for all.
+	PC *Range
+	// Opcode classes by their first nibble, bit n for class n. 0 is all.
+	Classes uint16
+	// Frames, counted from 0, nil for all.
+	Frames *Range
+}
+
+func (f Filter) match(frame int, entry *cpu.TraceEntry) bool {
+	if !f.PC.contains(int(entry.PC)) || !f.Frames.contains(frame) {
+		return false
+	}
+	return f.Classes == 0 || f.Classes&(1<<(entry.OpCode>>12)) != 0
+}
+
+// ParseClasses parses opcode classes as comma separated hex digits, e.g.
+// "8,D,F" for the arithmetic, draw and FX__ instructions.
+func ParseClasses(text string) (uint16, error) {
+	var classes uint16
+	for _, field := range strings.Split(text, ",") {
+		n, err := strconv.ParseUint(strings.TrimSpace(field), 16, 4)
+		if err != nil {
+			return 0, fmt.Errorf("invalid opcode class %q, want 0-F", field)
+		}
+		classes |= 1 << n
+	}
+	return classes, nil
+}
+
+type Options struct {
+	Format Format
+	Filter Filter
+	// Rotate the file when it grows past this many bytes, keeping Files
+	// old ones as name.1 (the newest) to name.N. 0 never rotates.
+	MaxSize int64
+	Files   int
+	// Keep only the last Ring instructions in memory and write them when
+	// Dump is called, e.g. on error, instead of writing as they run.
+	Ring int
+}
+
+type record struct {
+	frame int
+	entry cpu.TraceEntry
+}
+
+// Tracer is a cpu.Tracer writing trace lines.
+type Tracer struct {
+	opts  Options
+	frame int
+
+	path    string
+	file    *os.File
+	w       *bufio.Writer
+	written int64
+	err     error
+
+	ring []record
+	next int
+	full bool
+}
+
+// New traces to w.
+func New(w io.Writer, opts Options) *Tracer {
+	t := &Tracer{opts: opts, w: bufio.NewWriter(w)}
+	if opts.Ring > 0 {
+		t.ring = make([]record, opts.Ring)
+	}
+	return t
+}
+
+// Open traces to the file at path, which is created or truncated.
+func Open(path string, opts Options) (*Tracer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	t := New(file, opts)
+	t.path, t.file = path, file
+	return t, nil
+}
+
+func (t *Tracer) TraceFrame() {
+	t.frame++
+}
+
+func (t *Tracer) TraceInstruction(entry *cpu.TraceEntry) {
+	if !t.opts.Filter.match(t.frame, entry) {
+		return
+	}
+
+	if t.ring != nil {
+		t.ring[t.next] = record{frame: t.frame, entry: *entry}
+		t.next = (t.next + 1) % len(t.ring)
+		t.full = t.full || t.next == 0
+		return
+	}
+
+	t.write(t.frame, entry)
+}
+
+func (t *Tracer) write(frame int, entry *cpu.TraceEntry) {
+	if t.err != nil {
+		return
+	}
+
+	var line []byte
+	switch t.opts.Format {
+	case FORMAT_JSON:
+		line = formatJSON(frame, entry)
+	case FORMAT_REGS:
+		line = formatRegs(entry)
+	default:
+		line = formatText(frame, entry)
+	}
+
+	if t.opts.MaxSize > 0 && t.file != nil && t.written > 0 && t.written+int64(len(line)) > t.opts.MaxSize {
+		if t.err = t.rotate(); t.err != nil {
+			return
+		}
+	}
+
+	n, err := t.w.Write(line)
+	t.written += int64(n)
+	t.err = err
+}
+
+// rotate moves name.1 to name.2 and so on, the current file to name.1,
+// and starts a new one.
+func (t *Tracer) rotate() error {
+	if err := t.w.Flush(); err != nil {
+		return err
+	}
+	if err := t.file.Close(); err != nil {
+		return err
+	}
+
+	if t.opts.Files > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", t.path, t.opts.Files))
+		for n := t.opts.Files - 1; n >= 1; n-- {
+			os.Rename(fmt.Sprintf("%s.%d", t.path, n), fmt.Sprintf("%s.%d", t.path, n+1))
+		}
+		if err := os.Rename(t.path, t.path+".1"); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.Create(t.path)
+	if err != nil {
+		return err
+	}
+	t.file, t.written = file, 0
+	t.w.Reset(file)
+	return nil
+}
+
+// Dump writes out the instructions kept in the ring buffer, oldest first,
+// and empties it.
+func (t *Tracer) Dump() error {
+	if t.ring != nil {
+		start, count := 0, t.next
+		if t.full {
+			start, count = t.next, len(t.ring)
+		}
+		for i := 0; i < count; i++ {
+			r := &t.ring[(start+i)%len(t.ring)]
+			t.write(r.frame, &r.entry)
+		}
+		t.next, t.full = 0, false
+	}
+
+	if t.err != nil {
+		return t.err
+	}
+	return t.w.Flush()
+}
+
+// Close flushes the trace and closes the file. The ring buffer is
+// dropped: call Dump first to keep it.
+func (t *Tracer) Close() error {
+	err := t.w.Flush()
+	if t.err != nil {
+		err = t.err
+	}
+	if t.file != nil {
+		if closeErr := t.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// formatText writes e.g.
+//
+//	f12 c3456 0x204 7001 ADD V0, 0x01  V=0500000000000000000000000000000F I=0x2A0 SP=0 DT=0 ST=0 -> V0=06
+//
+// with the registers before the instruction and the ones it changed.
+func formatText(frame int, e *cpu.TraceEntry) []byte {
+	var line strings.Builder
+	fmt.Fprintf(&line, "f%d c%d 0x%03X %04X %-16s V=%X I=0x%03X SP=%d DT=%d ST=%d",
+		frame, e.Cycle, e.PC, uint16(e.OpCode), e.OpCode.Mnemonic(),
+		e.Before.V[:], e.Before.I, e.Before.SP, e.Before.DT, e.Before.ST)
+
+	var changes []string
+	for i := range e.Before.V {
+		if e.Before.V[i] != e.After.V[i] {
+			changes = append(changes, fmt.Sprintf("V%X=%02X", i, e.After.V[i]))
+		}
+	}
+	if e.Before.I != e.After.I {
+		changes = append(changes, fmt.Sprintf("I=0x%03X", e.After.I))
+	}
+	if e.Before.SP != e.After.SP {
+		changes = append(changes, fmt.Sprintf("SP=%d", e.After.SP))
+	}
+	if e.Before.DT != e.After.DT {
+		changes = append(changes, fmt.Sprintf("DT=%d", e.After.DT))
+	}
+	if e.Before.ST != e.After.ST {
+		changes = append(changes, fmt.Sprintf("ST=%d", e.After.ST))
+	}
+	if len(changes) > 0 {
+		line.WriteString(" -> ")
+		line.WriteString(strings.Join(changes, " "))
+	}
+	line.WriteByte('\n')
+
+	return []byte(line.String())
+}
+
+// Line is one instruction in the JSON format.
+type Line struct {
+	Frame    int           `json:"frame"`
+	Cycle    uint64        `json:"cycle"`
+	PC       uint16        `json:"pc"`
+	OpCode   uint16        `json:"opcode"`
+	Mnemonic string        `json:"mnemonic"`
+	Before   cpu.Registers `json:"before"`
+	After    cpu.Registers `json:"after"`
+}
+
+func formatJSON(frame int, e *cpu.TraceEntry) []byte {
+	line, _ := json.Marshal(Line{
+		Frame:    frame,
+		Cycle:    e.Cycle,
+		PC:       e.PC,
+		OpCode:   uint16(e.OpCode),
+		Mnemonic: e.OpCode.Mnemonic(),
+		Before:   e.Before,
+		After:    e.After,
+	})
+	return append(line, '\n')
+}
+
+// formatRegs writes the registers before the instruction, e.g.
+//
+//	CYCLE:3456 PC:0204 OP:7001 V0:05 V1:00 ... VF:0F I:02A0 SP:00 DT:00 ST:00
+func formatRegs(e *cpu.TraceEntry) []byte {
+	var line strings.Builder
+	fmt.Fprintf(&line, "CYCLE:%d PC:%04X OP:%04X", e.Cycle, e.PC, uint16(e.OpCode))
+	for i, v := range e.Before.V {
+		fmt.Fprintf(&line, " V%X:%02X", i, v)
+	}
+	fmt.Fprintf(&line, " I:%04X SP:%02X DT:%02X ST:%02X\n", e.Before.I, e.Before.SP, e.Before.DT, e.Before.ST)
+
+	return []byte(line.String())
+}
@@ -0,0 +1,182 @@
+package trace_test
+
+import (
+	"bytes"
+	"chip-8-go/emulator"
+	"chip-8-go/trace"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// CLS, LD V0 0x05, ADD V0 0x01, JP 0x204
+var testROM = []byte{0x00, 0xE0, 0x60, 0x05, 0x70, 0x01, 0x12, 0x04}
+
+func run(t *testing.T, tracer *trace.Tracer, frames int) {
+	opts := emulator.DefaultOptions()
+	opts.MaxFrames = frames
+	opts.Tracer = tracer
+
+	c8 := emulator.NewChip8(opts, nil)
+	if err := c8.LoadBytes(testROM); err != nil {
+		t.Fatal(err)
+	}
+	if err := c8.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func lines(text string) []string {
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+func TestText(t *testing.T) {
+	assert := assert.New(t)
+
+	var out bytes.Buffer
+	tracer := trace.New(&out, trace.Options{})
+	run(t, tracer, 1)
+	assert.NoError(tracer.Close())
+
+	traced := lines(out.String())
+	if assert.Greater(len(traced), 3, "lines") {
+		assert.True(strings.HasPrefix(traced[0], "f0 c0 0x200 00E0 CLS"), "first line: %s", traced[0])
+		assert.True(strings.HasSuffix(traced[1], "-> V0=05"), "changed register: %s", traced[1])
+		assert.True(strings.HasSuffix(traced[2], "-> V0=06"), "changed register: %s", traced[2])
+		assert.NotContains(traced[3], "->", "a jump changes no register")
+	}
+}
+
+func TestJSONAndFilters(t *testing.T) {
+	assert := assert.New(t)
+
+	pc, err := trace.ParseRange("0x204")
+	assert.NoError(err)
+	frames, err := trace.ParseRange("1-2")
+	assert.NoError(err)
+	classes, err := trace.ParseClasses("7")
+	assert.NoError(err)
+
+	var out bytes.Buffer
+	tracer := trace.New(&out, trace.Options{
+		Format: trace.FORMAT_JSON,
+		Filter: trace.Filter{PC: pc, Classes: classes, Frames: frames},
+	})
+	run(t, tracer, 4)
+	assert.NoError(tracer.Close())
+
+	traced := lines(out.String())
+	assert.NotEmpty(traced, "lines")
+	for _, text := range traced {
+		var line trace.Line
+		assert.NoError(json.Unmarshal([]byte(text), &line))
+		assert.Equal(uint16(0x204), line.PC, "PC filter")
+		assert.Equal("ADD V0, 0x01", line.Mnemonic, "class filter")
+		assert.Contains([]int{1, 2}, line.Frame, "frame filter")
+		assert.Equal(line.Before.V[0]+1, line.After.V[0], "registers before and after")
+	}
+
+	_, err = trace.ParseRange("9-1")
+	assert.Error(err, "reversed range")
+	_, err = trace.ParseClasses("8,G")
+	assert.Error(err, "bad class")
+}
+
+func TestRing(t *testing.T) {
+	assert := assert.New(t)
+
+	var out bytes.Buffer
+	tracer := trace.New(&out, trace.Options{Ring: 5})
+	run(t, tracer, 3)
+	assert.Zero(out.Len(), "nothing should be written before Dump")
+
+	assert.NoError(tracer.Dump())
+	traced := lines(out.String())
+	if assert.Len(traced, 5, "the ring should keep the last instructions") {
+		assert.True(strings.HasPrefix(traced[0], "f2 "), "oldest kept: %s", traced[0])
+	}
+	assert.NoError(tracer.Close())
+}
+
+func TestRotation(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "trace.txt")
+	tracer, err := trace.Open(path, trace.Options{MaxSize: 1024, Files: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(t, tracer, 10)
+	assert.NoError(tracer.Close())
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, statErr := os.Stat(name)
+		if assert.NoError(statErr, name) {
+			assert.LessOrEqual(info.Size(), int64(1024), "size of %s", name)
+		}
+	}
+	assert.NoFileExists(path+".3", "only Files old files should be kept")
+}
+
+func TestReadBack(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, format := range []trace.Format{trace.FORMAT_TEXT, trace.FORMAT_JSON, trace.FORMAT_REGS} {
+		var out bytes.Buffer
+		tracer := trace.New(&out, trace.Options{Format: format})
+		run(t, tracer, 2)
+		assert.NoError(tracer.Close())
+
+		written := lines(out.String())
+		r := trace.NewReader(&out, trace.FORMAT_AUTO)
+		for i := range written {
+			record, err := r.Next()
+			if !assert.NoError(err, "format %d, line %d", format, i) {
+				break
+			}
+			assert.Equal(uint64(i), record.Cycle, "cycle")
+			if i == 2 {
+				assert.Equal(uint16(0x204), record.PC, "PC")
+				assert.Equal("ADD V0, 0x01", record.Mnemonic, "mnemonic")
+				assert.Equal(uint8(5), record.Before.V[0], "V0 before")
+			}
+		}
+		_, err := r.Next()
+		assert.ErrorIs(err, io.EOF, "format %d should end with the trace", format)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	assert := assert.New(t)
+
+	ours := "f0 c0 0x200 00E0 CLS V=00000000000000000000000000000000 I=0x000 SP=0 DT=0 ST=0\n" +
+		"f0 c1 0x202 6005 LD V0, 0x05 V=00000000000000000000000000000000 I=0x000 SP=0 DT=0 ST=0 -> V0=05\n" +
+		"f0 c2 0x204 7001 ADD V0, 0x01 V=05000000000000000000000000000000 I=0x000 SP=0 DT=0 ST=0 -> V0=06\n"
+
+	// Another emulator's log without cycles or timers, with a header.
+	same := "PC OP registers\n" +
+		"PC:0200 OP:00E0 V0:00 I:0000\n" +
+		"PC:0202 OP:6005 V0:00 I:0000\n" +
+		"PC:0204 OP:7001 V0:05 I:0000\n"
+	result, err := trace.Diff(trace.NewReader(strings.NewReader(ours), trace.FORMAT_AUTO), trace.NewReader(strings.NewReader(same), trace.FORMAT_AUTO), 2)
+	assert.NoError(err)
+	assert.Nil(result.Divergence, "only recorded registers should be compared")
+	assert.Equal(3, result.Compared, "compared")
+
+	different := "PC=0x202 V0=00\nPC=0x204 V0=04\n"
+	reference := trace.NewReader(strings.NewReader(different), trace.FORMAT_REGS)
+	reference.Offset = 1
+	result, err = trace.Diff(trace.NewReader(strings.NewReader(ours), trace.FORMAT_TEXT), reference, 2)
+	assert.NoError(err)
+	if assert.NotNil(result.Divergence, "divergence") {
+		assert.Equal(uint64(2), result.Divergence.Ours.Cycle, "cycle of the divergence")
+		assert.Equal([]string{"V0: 05 != 04"}, result.Divergence.Differences, "differences")
+		assert.Len(result.Divergence.Context, 1, "the cycle missing from the reference should not be compared")
+	}
+	assert.Equal(1, result.Compared, "compared")
+}
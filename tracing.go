@@ -0,0 +1,278 @@
+package main
+
+import (
+	"chip-8-go/config"
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"chip-8-go/trace"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// traceFlags are the flags of commands that can trace execution.
+type traceFlags struct {
+	path   string
+	format string
+	pc     string
+	ops    string
+	frames string
+	maxMB  int
+	files  int
+	ring   int
+}
+
+func addTraceFlags(fs *flag.FlagSet) *traceFlags {
+	f := &traceFlags{}
+	fs.StringVar(&f.path, "trace", "", "log every executed instruction to `file`")
+	fs.StringVar(&f.format, "trace-format", "text", "trace format: text, json (one object per line) or regs (register dumps like other emulators log)")
+	fs.StringVar(&f.pc, "trace-pc", "", "only trace instructions in the address `range`, e.g. 0x200-0x2FF")
+	fs.StringVar(&f.ops, "trace-ops", "", "only trace these opcode `classes` (first hex digit), e.g. 8,D")
+	fs.StringVar(&f.frames, "trace-frames", "", "only trace the frames in `range`, e.g. 600-660")
+	fs.IntVar(&f.maxMB, "trace-max-mb", 0, "rotate the trace file when it grows past this many `MB` (default: never)")
+	fs.IntVar(&f.files, "trace-files", 3, "number of rotated trace files to keep")
+	fs.IntVar(&f.ring, "trace-ring", 0, "keep only the last `n` instructions and write them when the run fails")
+	return f
+}
+
+// open returns nil when no trace was asked for.
+func (f *traceFlags) open() (*trace.Tracer, error) {
+	if f.path == "" {
+		return nil, nil
+	}
+
+	var opts trace.Options
+	var err error
+	if opts.Format, err = trace.ParseFormat(f.format); err != nil {
+		return nil, usageError{err.Error()}
+	}
+	if f.pc != "" {
+		if opts.Filter.PC, err = trace.ParseRange(f.pc); err != nil {
+			return nil, usageError{fmt.Sprintf("invalid -trace-pc: %v", err)}
+		}
+	}
+	if f.ops != "" {
+		if opts.Filter.Classes, err = trace.ParseClasses(f.ops); err != nil {
+			return nil, usageError{fmt.Sprintf("invalid -trace-ops: %v", err)}
+		}
+	}
+	if f.frames != "" {
+		if opts.Filter.Frames, err = trace.ParseRange(f.frames); err != nil {
+			return nil, usageError{fmt.Sprintf("invalid -trace-frames: %v", err)}
+		}
+	}
+	if f.maxMB < 0 || f.files < 0 || f.ring < 0 {
+		return nil, usageError{"invalid trace flags: sizes must not be negative"}
+	}
+	opts.MaxSize = int64(f.maxMB) << 20
+	opts.Files = f.files
+	opts.Ring = f.ring
+
+	return trace.Open(f.path, opts)
+}
+
+// addTracer adds t to the tracers of opts.
+func addTracer(opts *emulator.Options, t cpu.Tracer) {
+	if opts.Tracer == nil {
+		opts.Tracer = t
+	} else {
+		opts.Tracer = cpu.MultiTracer(opts.Tracer, t)
+	}
+}
+
+// finishTrace closes the trace after a run, first writing out the ring
+// buffer if the run failed or panicked, in which case it panics again. It
+// returns the run's error, or else the trace's.
+func finishTrace(tracer *trace.Tracer, runErr error, panicked any) error {
+	if panicked != nil {
+		tracer.Dump()
+		tracer.Close()
+		panic(panicked)
+	}
+
+	if runErr != nil {
+		tracer.Dump()
+	}
+	if closeErr := tracer.Close(); runErr == nil {
+		return closeErr
+	}
+	return runErr
+}
+
+func tracediffCommand(args []string) error {
+	fs := newFlagSet("tracediff")
+	format := fs.String("format", "auto", "format of the reference trace: auto, text, json or regs")
+	offset := fs.Int64("offset", 0, "add `n` to the reference trace's cycles, e.g. -1 if it counts from 1")
+	context := fs.Int("context", 8, "number of instructions to show before the divergence")
+	romFile := fs.String("rom", "", "replay this ROM to the divergence to show memory around PC and I")
+	stop := fs.Bool("stop", false, "then keep the replay open in a window, paused at the divergence")
+	configFlags := addConfigFlags(fs)
+	configFlags.addMachineFlags(fs)
+	configFlags.addDisplayFlags(fs)
+	seed := addSeedFlag(fs)
+
+	positional, err := parseArgs(fs, args, 2)
+	if err != nil {
+		return err
+	}
+	referenceFormat := trace.FORMAT_AUTO
+	if *format != "auto" {
+		if referenceFormat, err = trace.ParseFormat(*format); err != nil {
+			return usageError{err.Error()}
+		}
+	}
+	if *stop && *romFile == "" {
+		return usageError{"-stop needs -rom"}
+	}
+
+	oursFile, err := os.Open(positional[0])
+	if err != nil {
+		return err
+	}
+	defer oursFile.Close()
+	referenceFile, err := os.Open(positional[1])
+	if err != nil {
+		return err
+	}
+	defer referenceFile.Close()
+
+	reference := trace.NewReader(referenceFile, referenceFormat)
+	reference.Offset = *offset
+	result, err := trace.Diff(trace.NewReader(oursFile, trace.FORMAT_AUTO), reference, *context)
+	if err != nil {
+		return err
+	}
+
+	d := result.Divergence
+	if d == nil {
+		fmt.Printf("the traces agree on %d instructions\n", result.Compared)
+		if result.OursLeft {
+			fmt.Println("the reference trace ends first")
+		} else if result.ReferenceLeft {
+			fmt.Println("our trace ends first")
+		}
+		return nil
+	}
+
+	fmt.Printf("the traces diverge at cycle %d, after %d instructions agreed\n\n", d.Ours.Cycle, result.Compared)
+	for _, record := range d.Context {
+		fmt.Printf("    %s\n", record)
+	}
+	fmt.Printf("ours %s\nref  %s\n\n", d.Ours, d.Reference)
+	for _, difference := range d.Differences {
+		fmt.Printf("  %s\n", difference)
+	}
+
+	if *romFile != "" {
+		rom, readErr := readROM(*romFile)
+		if readErr != nil {
+			return readErr
+		}
+		cfg, _, resolveErr := configFlags.resolve(rom)
+		if resolveErr != nil {
+			return resolveErr
+		}
+		opts := cfg.Options()
+		seed.apply(&opts)
+
+		if replayErr := replayTo(d.Ours, rom, opts); replayErr != nil {
+			return replayErr
+		}
+		if *stop {
+			return stopAt(*romFile, d.Ours.Cycle, rom, cfg, opts)
+		}
+	}
+
+	return fmt.Errorf("traces diverge at cycle %d", d.Ours.Cycle)
+}
+
+// cycleStop stops the emulator in front of the instruction of a cycle, by
+// setting a breakpoint on it once the one before has run.
+type cycleStop struct {
+	c8    *emulator.Chip8
+	cycle uint64
+}
+
+func (s *cycleStop) TraceInstruction(entry *cpu.TraceEntry) {
+	if entry.Cycle+1 == s.cycle {
+		c := s.c8.CPU()
+		c.SetBreakpoint(c.ProgramCounter)
+	}
+}
+
+func (s *cycleStop) TraceFrame() {}
+
+func (s *cycleStop) stopped(c *cpu.CPU) bool {
+	if c.Cycles != s.cycle {
+		return false
+	}
+	c.ClearBreakpoint(c.ProgramCounter)
+	return true
+}
+
+// replayTo runs the ROM headless up to the instruction of our trace's
+// record and prints the memory around PC and I.
+func replayTo(record *trace.Record, rom []byte, opts emulator.Options) error {
+	stop := &cycleStop{cycle: record.Cycle}
+	opts.Tracer = stop
+	opts.MaxFrames = 0
+
+	c8 := emulator.NewChip8(opts, nil)
+	stop.c8 = c8
+	if loadErr := c8.LoadBytes(rom); loadErr != nil {
+		return loadErr
+	}
+	c8.AddBreakpointHook(func(c *cpu.CPU) {
+		if stop.stopped(c) {
+			c8.Stop()
+		}
+	})
+	if record.Cycle > 0 {
+		if runErr := c8.Run(); runErr != nil {
+			return runErr
+		}
+	}
+
+	c := c8.CPU()
+	fmt.Println()
+	if c.Cycles != record.Cycle || c.ProgramCounter != record.PC {
+		fmt.Printf("the replay did not get to cycle %d at 0x%03X, check that -seed and the machine flags match the traced run\n", record.Cycle, record.PC)
+		return nil
+	}
+	fmt.Printf("memory around PC 0x%03X:\n", c.ProgramCounter)
+	dumpMemory(os.Stdout, c, c.ProgramCounter)
+	fmt.Printf("memory around I 0x%03X:\n", c.IndexRegister)
+	dumpMemory(os.Stdout, c, c.IndexRegister)
+
+	return nil
+}
+
+// dumpMemory prints the 16 byte rows before, at and after address.
+func dumpMemory(w io.Writer, c *cpu.CPU, address uint16) {
+	row := int(address) &^ 0xF
+	for start := max(row-16, 0); start <= row+16 && start < len(c.Memory); start += 16 {
+		fmt.Fprintf(w, "  0x%03X: % X\n", start, c.Memory[start:start+16])
+	}
+}
+
+// stopAt runs the ROM in a window, paused in front of the instruction of
+// cycle, to go on from there.
+func stopAt(fileName string, cycle uint64, rom []byte, cfg config.Config, opts emulator.Options) error {
+	stop := &cycleStop{cycle: cycle}
+	opts.Tracer = stop
+	opts.MaxFrames = 0
+
+	extras := runExtras{romFile: fileName, debug: func(c8 *emulator.Chip8) {
+		stop.c8 = c8
+		c8.AddBreakpointHook(func(c *cpu.CPU) {
+			if stop.stopped(c) {
+				fmt.Printf("\npaused at cycle %d\n", cycle)
+			}
+		})
+		if cycle == 0 {
+			c8.Pause()
+		}
+	}}
+	return runWindowed(fileName, rom, cfg, opts, extras)
+}
@@ -0,0 +1,154 @@
+package tui
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+type eventKind int
+
+const (
+	keyPress eventKind = iota
+	keyRepeat
+	keyRelease
+	quit
+)
+
+// event is one key reported by the terminal. Keys are named like
+// emulator.KeyBindings names, in lower case: "q", "1", "up", "space".
+type event struct {
+	kind eventKind
+	key  string
+	// Sent with the kitty keyboard protocol, so releases will follow.
+	kitty bool
+}
+
+// Keyboard protocol flags sent with CSI > flags u: disambiguate escape
+// codes (1), report event types (2) and report all keys as escape codes
+// (8). Terminals that support it (kitty, foot, WezTerm, ghostty) then
+// report key releases, others ignore the request.
+const (
+	enableKeyReleases  = "\x1b[>11u"
+	disableKeyReleases = "\x1b[<u"
+)
+
+// An unfinished escape sequence longer than this is dropped instead of
+// waiting for the rest.
+const MAX_PENDING_INPUT = 32
+
+// An unfinished escape sequence is given up on when nothing follows it for
+// this long, a lone ESC then being the Esc key.
+const ESCAPE_TIMEOUT = 50 * time.Millisecond
+
+// parseInput splits a chunk read from the terminal into key events.
+// Unknown sequences are skipped. An escape sequence cut off at the end of
+// the chunk, as a read can end anywhere, is returned as rest to be parsed
+// with the next chunk, even a lone ESC, which may be the Esc key or the
+// start of a sequence, see ESCAPE_TIMEOUT.
+func parseInput(data []byte) (events []event, rest []byte) {
+
+	for i := 0; i < len(data); {
+		b := data[i]
+
+		switch {
+		case b == 0x03:
+			// Ctrl-C, raw mode turned off the signal
+			events = append(events, event{kind: quit})
+			i++
+		case b == 0x1b && i+1 == len(data):
+			return events, data[i:]
+		case b == 0x1b && data[i+1] == '[':
+			end := i + 2
+			for end < len(data) && (data[end] < 0x40 || data[end] > 0x7e) {
+				end++
+			}
+			if end == len(data) {
+				return events, data[i:]
+			}
+			if ev, ok := parseCSI(string(data[i+2:end]), data[end]); ok {
+				events = append(events, ev)
+			}
+			i = end + 1
+		case b == 0x1b && data[i+1] == 'O':
+			// Arrows in application cursor mode: ESC O A
+			if i+2 == len(data) {
+				return events, data[i:]
+			}
+			if key, ok := arrowKeys[data[i+2]]; ok {
+				events = append(events, event{kind: keyPress, key: key})
+			}
+			i += 3
+		case b == 0x1b:
+			events = append(events, event{kind: quit})
+			i++
+		default:
+			events = append(events, event{kind: keyPress, key: keyName(rune(b))})
+			i++
+		}
+	}
+
+	return events, nil
+}
+
+var arrowKeys = map[byte]string{
+	'A': "up",
+	'B': "down",
+	'C': "right",
+	'D': "left",
+}
+
+// parseCSI decodes "ESC [ params final". Besides the classic arrow key
+// sequences this understands the kitty keyboard protocol:
+// "ESC [ code ; modifiers : event u" and "ESC [ 1 ; modifiers : event A".
+func parseCSI(params string, final byte) (event, bool) {
+	kind := keyPress
+	modifiers := 1
+	eventType := ""
+
+	fields := strings.Split(params, ";")
+	if len(fields) > 1 {
+		var mods string
+		mods, eventType, _ = strings.Cut(fields[1], ":")
+		modifiers, _ = strconv.Atoi(mods)
+		switch eventType {
+		case "2":
+			kind = keyRepeat
+		case "3":
+			kind = keyRelease
+		}
+	}
+
+	if key, ok := arrowKeys[final]; ok {
+		return event{kind: kind, key: key, kitty: eventType != ""}, true
+	}
+	if final != 'u' {
+		return event{}, false
+	}
+
+	codepoint, _, _ := strings.Cut(fields[0], ":")
+	code, err := strconv.Atoi(codepoint)
+	if err != nil {
+		return event{}, false
+	}
+
+	const ctrl = 4
+	switch {
+	case code == 27 && kind != keyRelease:
+		return event{kind: quit}, true
+	case code == 'c' && (modifiers-1)&ctrl != 0:
+		return event{kind: quit}, true
+	}
+
+	return event{kind: kind, key: keyName(rune(code)), kitty: true}, true
+}
+
+func keyName(r rune) string {
+	switch r {
+	case ' ':
+		return "space"
+	case '\r':
+		return "return"
+	}
+	return strings.ToLower(string(r))
+}
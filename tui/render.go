@@ -0,0 +1,94 @@
+package tui
+
+import (
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"fmt"
+	"image/color"
+	"strings"
+)
+
+type Mode int
+
+const (
+	// Two pixels per character cell, 64x16 cells.
+	HalfBlock Mode = iota
+	// Eight pixels per character cell, 32x8 cells.
+	Braille
+)
+
+func ParseMode(name string) (Mode, error) {
+	switch name {
+	case "halfblock":
+		return HalfBlock, nil
+	case "braille":
+		return Braille, nil
+	}
+	return HalfBlock, fmt.Errorf("unknown terminal mode %q: expected halfblock or braille", name)
+}
+
+// Both pixels of a half-block cell, indexed by top<<1 | bottom.
+var halfBlocks = [4]rune{' ', '▄', '▀', '█'}
+
+// Dot bits of a braille cell, indexed by [y][x] within the 2x4 cell.
+var brailleDots = [4][2]rune{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+// Render draws the screen as lines of text in the given mode, colours
+// included, ready to be written to an ANSI terminal. Lines are separated
+// by "\r\n" because the terminal is in raw mode.
+func Render(screen *cpu.Framebuffer, mode Mode, palette emulator.Palette) string {
+	var b strings.Builder
+
+	b.WriteString(ansiColor(38, palette.Foreground))
+	b.WriteString(ansiColor(48, palette.Background))
+
+	switch mode {
+	case Braille:
+		for y := 0; y < cpu.SCREEN_HEIGHT; y += 4 {
+			if y > 0 {
+				b.WriteString("\r\n")
+			}
+			for x := 0; x < cpu.SCREEN_WIDTH; x += 2 {
+				cell := rune(0x2800)
+				for dy := 0; dy < 4; dy++ {
+					for dx := 0; dx < 2; dx++ {
+						if screen.Pixel(x+dx, y+dy) {
+							cell |= brailleDots[dy][dx]
+						}
+					}
+				}
+				b.WriteRune(cell)
+			}
+		}
+	default:
+		for y := 0; y < cpu.SCREEN_HEIGHT; y += 2 {
+			if y > 0 {
+				b.WriteString("\r\n")
+			}
+			for x := 0; x < cpu.SCREEN_WIDTH; x++ {
+				index := 0
+				if screen.Pixel(x, y) {
+					index |= 2
+				}
+				if screen.Pixel(x, y+1) {
+					index |= 1
+				}
+				b.WriteRune(halfBlocks[index])
+			}
+		}
+	}
+
+	b.WriteString("\x1b[0m")
+	return b.String()
+}
+
+// ansiColor selects a 24-bit colour, layer 38 for the foreground and 48
+// for the background.
+func ansiColor(layer int, c color.RGBA) string {
+	return fmt.Sprintf("\x1b[%d;2;%d;%d;%dm", layer, c.R, c.G, c.B)
+}
@@ -0,0 +1,235 @@
+// Package tui runs the emulator in a text terminal: the screen is drawn
+// with Unicode block or braille characters and the keypad is read from a
+// raw-mode TTY, so a ROM can be played over SSH without an X server.
+package tui
+
+import (
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Terminals only report that a key went down, and then repeat it while it
+// is held after the keyboard's repeat delay. Without release events a key
+// counts as held for HOLD_TIME after the first report and REPEAT_HOLD_TIME
+// after each repeat. HOLD_TIME covers the usual 500ms repeat delay, so a
+// held key is not let go before the first repeat.
+const HOLD_TIME = 600 * time.Millisecond
+const REPEAT_HOLD_TIME = 100 * time.Millisecond
+
+type Options struct {
+	Mode    Mode
+	Palette emulator.Palette
+	Keys    emulator.KeyBindings
+	// Zero means HOLD_TIME and REPEAT_HOLD_TIME.
+	HoldTime       time.Duration
+	RepeatHoldTime time.Duration
+	// Ring the terminal bell when the sound timer starts.
+	Bell bool
+}
+
+// Terminal is an emulator.Frontend and audio.Sink for ANSI terminals.
+type Terminal struct {
+	in       io.Reader
+	out      io.Writer
+	fd       int
+	oldState *term.State
+
+	opts   Options
+	keyMap map[string]uint8
+	now    func() time.Time
+
+	mu     sync.Mutex
+	events []event
+	// The start of an escape sequence the last Feed cut off, and when.
+	pending   []byte
+	pendingAt time.Time
+
+	// Key releases are reported by the terminal, no need to guess.
+	exactReleases bool
+	releaseAt     [cpu.NUM_KEYS]time.Time
+	beeping       bool
+}
+
+// Open switches the terminal to raw mode and the alternate screen. Close
+// must be called to restore it.
+func Open(in *os.File, out *os.File, opts Options) (*Terminal, error) {
+	t := New(in, out, opts)
+
+	t.fd = int(in.Fd())
+	oldState, err := term.MakeRaw(t.fd)
+	if err != nil {
+		return nil, err
+	}
+	t.oldState = oldState
+
+	// Alternate screen, hidden cursor, cleared screen.
+	io.WriteString(out, "\x1b[?1049h\x1b[?25l\x1b[2J"+enableKeyReleases)
+
+	go t.readInput()
+
+	return t, nil
+}
+
+// New creates a terminal frontend on arbitrary streams without touching
+// any TTY settings. Input has to be fed with Feed.
+func New(in io.Reader, out io.Writer, opts Options) *Terminal {
+	if opts.HoldTime == 0 {
+		opts.HoldTime = HOLD_TIME
+	}
+	if opts.RepeatHoldTime == 0 {
+		opts.RepeatHoldTime = REPEAT_HOLD_TIME
+	}
+
+	keyMap := make(map[string]uint8)
+	for key := range opts.Keys {
+		for _, name := range opts.Keys.Names(uint8(key)) {
+			keyMap[strings.ToLower(name)] = uint8(key)
+		}
+	}
+
+	return &Terminal{
+		in:     in,
+		out:    out,
+		opts:   opts,
+		keyMap: keyMap,
+		now:    time.Now,
+	}
+}
+
+// Close restores the terminal to the state Open found it in.
+func (t *Terminal) Close() error {
+	if t.oldState == nil {
+		return nil
+	}
+
+	io.WriteString(t.out, disableKeyReleases+"\x1b[0m\x1b[?25h\x1b[?1049l")
+	err := term.Restore(t.fd, t.oldState)
+	t.oldState = nil
+
+	return err
+}
+
+func (t *Terminal) readInput() {
+	buf := make([]byte, 256)
+	for {
+		n, err := t.in.Read(buf)
+		if n > 0 {
+			t.Feed(buf[:n])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Feed queues raw terminal input for the next PollInput. An escape
+// sequence split between two Feeds is put back together.
+func (t *Terminal) Feed(data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.pending) > 0 {
+		data = append(t.pending, data...)
+	}
+	events, rest := parseInput(data)
+	t.events = append(t.events, events...)
+	t.pending = nil
+	if len(rest) <= MAX_PENDING_INPUT {
+		t.pending = append(t.pending, rest...)
+		t.pendingAt = t.now()
+	}
+}
+
+// flushPending gives up on an escape sequence nothing followed for
+// ESCAPE_TIMEOUT: a lone ESC is the Esc key, anything longer is dropped.
+func (t *Terminal) flushPending(now time.Time) {
+	if len(t.pending) == 0 || now.Sub(t.pendingAt) < ESCAPE_TIMEOUT {
+		return
+	}
+	if len(t.pending) == 1 {
+		t.events = append(t.events, event{kind: quit})
+	}
+	t.pending = nil
+}
+
+func (t *Terminal) Draw(c *cpu.CPU) error {
+	_, err := io.WriteString(t.out, "\x1b[H"+Render(&c.Screen, t.opts.Mode, t.opts.Palette))
+	return err
+}
+
+func (t *Terminal) PollInput(c *cpu.CPU) bool {
+	now := t.now()
+	t.mu.Lock()
+	t.flushPending(now)
+	events := t.events
+	t.events = nil
+	t.mu.Unlock()
+
+	for _, ev := range events {
+		if ev.kind == quit {
+			return true
+		}
+		if ev.kitty {
+			t.exactReleases = true
+		}
+
+		key, ok := t.keyMap[ev.key]
+		if !ok {
+			continue
+		}
+
+		switch ev.kind {
+		case keyRelease:
+			c.SetKey(key, false)
+		case keyRepeat:
+			c.SetKey(key, true)
+			t.releaseAt[key] = now.Add(t.opts.RepeatHoldTime)
+		case keyPress:
+			if c.Keys[key] {
+				// A repeat from a terminal that does not tell them apart.
+				if repeat := now.Add(t.opts.RepeatHoldTime); repeat.After(t.releaseAt[key]) {
+					t.releaseAt[key] = repeat
+				}
+			} else {
+				t.releaseAt[key] = now.Add(t.opts.HoldTime)
+			}
+			c.SetKey(key, true)
+		}
+	}
+
+	if !t.exactReleases {
+		for key := range c.Keys {
+			if c.Keys[key] && now.After(t.releaseAt[key]) {
+				c.SetKey(uint8(key), false)
+			}
+		}
+	}
+
+	return false
+}
+
+// WriteSamples rings the bell when a frame with sound follows a silent one.
+func (t *Terminal) WriteSamples(samples []int16) error {
+	sounding := false
+	for _, sample := range samples {
+		if sample != 0 {
+			sounding = true
+			break
+		}
+	}
+
+	var err error
+	if t.opts.Bell && sounding && !t.beeping {
+		_, err = io.WriteString(t.out, "\a")
+	}
+	t.beeping = sounding
+
+	return err
+}
@@ -0,0 +1,175 @@
+package tui_test
+
+import (
+	"bytes"
+	"chip-8-go/cpu"
+	"chip-8-go/emulator"
+	"strings"
+	"testing"
+	"time"
+
+	"chip-8-go/tui"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTerminal(out *bytes.Buffer, hold time.Duration) *tui.Terminal {
+	return tui.New(nil, out, tui.Options{
+		Palette:        emulator.Palettes["white"],
+		Keys:           emulator.DefaultKeyBindings,
+		HoldTime:       hold,
+		RepeatHoldTime: hold,
+		Bell:           true,
+	})
+}
+
+func TestRender(t *testing.T) {
+	assert := assert.New(t)
+
+	var screen cpu.Framebuffer
+	screen.Set(0, 0, true)
+	screen.Set(1, 1, true)
+	screen.Set(2, 0, true)
+	screen.Set(2, 1, true)
+
+	palette := emulator.Palettes["white"]
+	output := strip(tui.Render(&screen, tui.HalfBlock, palette))
+	lines := strings.Split(output, "\r\n")
+	assert.Len(lines, cpu.SCREEN_HEIGHT/2, "half-blocks should draw two rows per line")
+	assert.True(strings.HasPrefix(lines[0], "▀▄█ "), "half-blocks should combine the top and bottom pixel")
+
+	output = strip(tui.Render(&screen, tui.Braille, palette))
+	lines = strings.Split(output, "\r\n")
+	assert.Len(lines, cpu.SCREEN_HEIGHT/4, "braille should draw four rows per line")
+	assert.Len([]rune(lines[0]), cpu.SCREEN_WIDTH/2, "braille should draw two columns per character")
+	assert.Equal('⠑', []rune(lines[0])[0], "braille dots 1 and 5 should be set")
+	assert.Equal('⠃', []rune(lines[0])[1], "braille dots 1 and 2 should be set")
+}
+
+// strip removes ANSI escape sequences.
+func strip(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0x1b {
+			for i < len(s) && s[i] != 'm' {
+				i++
+			}
+			continue
+		}
+		out.WriteByte(s[i])
+	}
+	return out.String()
+}
+
+func TestParseMode(t *testing.T) {
+	assert := assert.New(t)
+
+	mode, err := tui.ParseMode("braille")
+	assert.NoError(err)
+	assert.Equal(tui.Braille, mode)
+
+	_, err = tui.ParseMode("sixel")
+	assert.Error(err, "unknown modes should be rejected")
+}
+
+func TestKeyReleaseTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	c := cpu.NewCPU()
+	terminal := newTerminal(&bytes.Buffer{}, 20*time.Millisecond)
+
+	terminal.Feed([]byte("q"))
+	assert.False(terminal.PollInput(c))
+	assert.True(c.Keys[0x4], "q should press key 4")
+
+	terminal.Feed([]byte("q"))
+	terminal.PollInput(c)
+	assert.True(c.Keys[0x4], "a repeat should keep the key held")
+
+	time.Sleep(40 * time.Millisecond)
+	terminal.PollInput(c)
+	assert.False(c.Keys[0x4], "the key should be released after the hold time")
+}
+
+func TestKittyKeyReleases(t *testing.T) {
+	assert := assert.New(t)
+
+	c := cpu.NewCPU()
+	terminal := newTerminal(&bytes.Buffer{}, time.Millisecond)
+
+	terminal.Feed([]byte("\x1b[119;1:1u"))
+	terminal.PollInput(c)
+	assert.True(c.Keys[0x5], "a kitty press of w should press key 5")
+
+	time.Sleep(5 * time.Millisecond)
+	terminal.Feed([]byte("\x1b[49u"))
+	terminal.PollInput(c)
+	assert.True(c.Keys[0x5], "keys should stay held until the terminal reports the release")
+	assert.True(c.Keys[0x1], "a kitty press of 1 should press key 1")
+
+	terminal.Feed([]byte("\x1b[119;1:3u\x1b[49;1:3u"))
+	terminal.PollInput(c)
+	assert.False(c.Keys[0x5], "a kitty release should release key 5")
+	assert.False(c.Keys[0x1], "a kitty release should release key 1")
+}
+
+func TestQuitKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	c := cpu.NewCPU()
+	for _, input := range []string{"\x03", "\x1b[99;5u", "\x1b[27u", "\x1bq"} {
+		terminal := newTerminal(&bytes.Buffer{}, time.Second)
+		terminal.Feed([]byte(input))
+		assert.True(terminal.PollInput(c), "%q should quit", input)
+	}
+
+	terminal := newTerminal(&bytes.Buffer{}, time.Second)
+	terminal.Feed([]byte("\x1b"))
+	assert.False(terminal.PollInput(c), "a lone ESC may start a sequence")
+	time.Sleep(tui.ESCAPE_TIMEOUT + 10*time.Millisecond)
+	assert.True(terminal.PollInput(c), "a lone ESC nothing followed should quit")
+
+	terminal = newTerminal(&bytes.Buffer{}, time.Second)
+	terminal.Feed([]byte("\x1b[A\x1bOB"))
+	assert.False(terminal.PollInput(c), "arrow keys should not quit")
+}
+
+func TestSplitEscapeSequences(t *testing.T) {
+	assert := assert.New(t)
+
+	c := cpu.NewCPU()
+	terminal := newTerminal(&bytes.Buffer{}, time.Second)
+	terminal.Feed([]byte("\x1b[119;1"))
+	assert.False(terminal.PollInput(c), "the start of a sequence should not quit")
+	assert.False(c.Keys[0x5], "the start of a sequence should not press a key")
+
+	terminal.Feed([]byte(":1u"))
+	assert.False(terminal.PollInput(c), "the finished sequence should not quit")
+	assert.True(c.Keys[0x5], "a kitty press of w split across reads should press key 5")
+
+	terminal = newTerminal(&bytes.Buffer{}, time.Second)
+	terminal.Feed([]byte("\x1b"))
+	terminal.Feed([]byte("[A"))
+	assert.False(terminal.PollInput(c), "an arrow split right after ESC should not quit")
+	time.Sleep(tui.ESCAPE_TIMEOUT + 10*time.Millisecond)
+	assert.False(terminal.PollInput(c), "nothing should be left pending")
+
+	terminal = newTerminal(&bytes.Buffer{}, time.Second)
+	terminal.Feed([]byte("\x1bO"))
+	terminal.Feed([]byte("A"))
+	assert.False(terminal.PollInput(c), "an arrow split across reads should not quit")
+}
+
+func TestBell(t *testing.T) {
+	assert := assert.New(t)
+
+	var out bytes.Buffer
+	terminal := newTerminal(&out, time.Second)
+
+	silence := make([]int16, 4)
+	tone := []int16{0, 100, -100, 0}
+	for _, samples := range [][]int16{silence, tone, tone, silence, tone} {
+		assert.NoError(terminal.WriteSamples(samples))
+	}
+	assert.Equal("\a\a", out.String(), "the bell should ring once each time sound starts")
+}
@@ -0,0 +1,192 @@
+//go:build js && wasm
+
+// Command web runs the emulator in a browser. Build it to chip-8.wasm
+// next to index.html, see the README.
+package main
+
+import (
+	"chip-8-go/config"
+	"chip-8-go/emulator"
+	"chip-8-go/romdb"
+	"fmt"
+	"sync"
+	"syscall/js"
+)
+
+var (
+	db     *romdb.Database
+	beeper *emulator.WebBeeper
+
+	// Guards the running ROM against overlapping loads.
+	mu       sync.Mutex
+	running  *emulator.Chip8
+	frontend *emulator.CanvasFrontend
+	done     chan struct{}
+)
+
+func main() {
+	var dbErr error
+	db, dbErr = romdb.Embedded()
+	if dbErr != nil {
+		js.Global().Get("console").Call("warn", "ROM database: "+dbErr.Error())
+	}
+
+	js.Global().Set("chip8Load", js.FuncOf(load))
+	js.Global().Set("chip8Stop", js.FuncOf(func(this js.Value, args []js.Value) any {
+		go stop()
+		return nil
+	}))
+
+	select {}
+}
+
+// load is chip8Load(canvas, rom, options) from JavaScript: it runs the
+// ROM (a Uint8Array) on the canvas and returns a promise of what the ROM
+// database knows about it. Empty options are detected from the database.
+func load(this js.Value, args []js.Value) any {
+	if len(args) < 2 {
+		return js.Global().Get("Promise").Call("reject", "chip8Load(canvas, rom, options) needs a canvas and a ROM")
+	}
+
+	canvas := args[0]
+	rom := make([]byte, args[1].Get("length").Int())
+	js.CopyBytesToGo(rom, args[1])
+
+	var options js.Value
+	if len(args) > 2 {
+		options = args[2]
+	}
+
+	handler := js.FuncOf(func(this js.Value, promise []js.Value) any {
+		resolve, reject := promise[0], promise[1]
+		go func() {
+			info, err := start(canvas, rom, options)
+			if err != nil {
+				reject.Invoke(js.Global().Get("Error").New(err.Error()))
+				return
+			}
+			resolve.Invoke(info)
+		}()
+		return nil
+	})
+	defer handler.Release()
+
+	return js.Global().Get("Promise").New(handler)
+}
+
+func start(canvas js.Value, rom []byte, options js.Value) (map[string]any, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	stopRunning()
+
+	var detected config.Settings
+	info := map[string]any{}
+	if db != nil {
+		if entry, ok := db.Identify(rom); ok {
+			detected = entry.Settings()
+			info["title"] = entry.Summary()
+			info["description"] = entry.Description()
+		}
+	}
+
+	cfg := (&config.File{}).Resolve(config.HashROM(rom), detected)
+	cfg.Apply(pageSettings(options))
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	info["quirks"] = cfg.Quirks
+	info["ipf"] = cfg.IPF
+	info["palette"] = cfg.Palette
+
+	palette, err := emulator.ParsePalette(cfg.Palette)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := cfg.Keys.Bindings()
+	if err != nil {
+		return nil, err
+	}
+
+	frontend, err = emulator.NewCanvasFrontend(canvas, palette, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	c8 := emulator.NewChip8(cfg.Options(), frontend)
+	if loadErr := c8.LoadBytes(rom); loadErr != nil {
+		frontend.Close()
+		return nil, loadErr
+	}
+
+	if !cfg.Mute {
+		if beeper == nil {
+			beeper, err = emulator.NewWebBeeper()
+			if err != nil {
+				js.Global().Get("console").Call("warn", "Audio disabled: "+err.Error())
+			}
+		}
+		if beeper != nil {
+			c8.AddAudioSink(beeper)
+		}
+	}
+
+	running = c8
+	done = make(chan struct{})
+	go func(finished chan struct{}) {
+		defer close(finished)
+		if runErr := c8.Run(); runErr != nil {
+			js.Global().Get("console").Call("error", fmt.Sprintf("Emulation stopped: %v", runErr))
+		}
+	}(done)
+
+	return info, nil
+}
+
+func stop() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	stopRunning()
+}
+
+// stopRunning ends the running ROM, if any, and waits for its goroutine to
+// exit.
+func stopRunning() {
+	if running == nil {
+		return
+	}
+
+	running.Stop()
+	<-done
+	frontend.Close()
+	running, frontend = nil, nil
+}
+
+// pageSettings reads the options object passed by the page: quirks,
+// palette, ipf and mute. Empty or missing fields are left to detection.
+func pageSettings(options js.Value) config.Settings {
+	var settings config.Settings
+	if options.Type() != js.TypeObject {
+		return settings
+	}
+
+	if quirks := options.Get("quirks"); quirks.Type() == js.TypeString && quirks.String() != "" {
+		value := quirks.String()
+		settings.Quirks = &value
+	}
+	if palette := options.Get("palette"); palette.Type() == js.TypeString && palette.String() != "" {
+		value := palette.String()
+		settings.Palette = &value
+	}
+	if ipf := options.Get("ipf"); ipf.Type() == js.TypeNumber && ipf.Int() > 0 {
+		value := ipf.Int()
+		settings.IPF = &value
+	}
+	if mute := options.Get("mute"); mute.Type() == js.TypeBoolean {
+		value := mute.Bool()
+		settings.Mute = &value
+	}
+
+	return settings
+}